@@ -42,6 +42,7 @@ watch k8s's resource with multiple handler，like:
 
 - alert，用来对异常events做分析和报警，并将所有events以json格式输出到日志（elk或graylog收集并分析）
 - rabbitmq，发布到rabbitmq,目前graylog会从其消费并做分析报表
+- gelf、syslog，以GELF直接发送到graylog，或以RFC5424发送到syslog服务器
 - influxdb，发布到influxdb,即将停用
 - elasticsearch，发布到elasticsearch，支持更久存储和查询
 - webhook，调用webhook用于后续扩展`,
@@ -122,7 +123,7 @@ func initConfig() {
 			}
 			fmt.Printf("enable env config file:%v success\n", viper.ConfigFileUsed())
 		} else {
-			fmt.Println("trying to load default config\n")
+			fmt.Println("trying to load default config")
 			viper.SetConfigName("default")
 			if err := viper.ReadInConfig(); err != nil {
 				fmt.Printf("load default config file error:%v\n", err)
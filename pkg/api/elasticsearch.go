@@ -1,49 +1,110 @@
 package api
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"go.uber.org/zap"
+
 	"github.com/gok8s/k8swatch/pkg/config"
 	"github.com/gok8s/k8swatch/pkg/event"
 	"github.com/gok8s/k8swatch/pkg/handlers/elasticsearch"
 	"github.com/gok8s/k8swatch/utils/zlog"
-	"go.uber.org/zap"
-	"net/http"
-	"reflect"
-	"strconv"
-	"strings"
-	"time"
 )
 
 type ElasticSearchApi struct {
 	*elasticsearch.ElasticClt
 }
 
-func NewQueryApi(config config.Config) ElasticSearchApi {
+func NewElasticSearchApi(config config.Config) ElasticSearchApi {
 	nea := new(elasticsearch.ElasticClt)
-	if err := nea.Init(config); err != nil {
+	if err := nea.Connect(config.Handlers.Elasticsearch); err != nil {
 		zlog.Error(err.Error())
 	}
 	return ElasticSearchApi{nea}
 }
 
-// GetPodEvt 类似kubectl get event
-func (ea *ElasticSearchApi) GetPodEvt(w http.ResponseWriter, r *http.Request) {
-	InvolvedKind := "Pod"
-	InvolvedNamespace := r.FormValue("namespace")
-	InvolvedName := r.FormValue("kind_name")
-	limit, _ := strconv.Atoi(r.FormValue("limit"))
-	if limit == 0 {
-		limit = 10
-	}
-	//of, _ := strconv.Atoi(r.FormValue("offset"))
+// esSort 按事件时间排序，之后的字段用于区分同一时间的事件，作为search_after的cursor
+var esSort = []struct{ field, unmappedType string }{
+	{"lastTimestamp", "date"},
+	{"namespace", "keyword"},
+	{"name", "keyword"},
+	{"resourceVersion", "keyword"},
+}
 
-	res, err := elasticsearch.Search(ea.Client, ea.Conf.Index, InvolvedNamespace, InvolvedKind, InvolvedName, limit)
+// Events 查询ES中kind为events的文档，字符串字段为keyword，使用term精确匹配；cursor为上一页最后一个文档的sort值
+func (ea ElasticSearchApi) Events(ctx context.Context, q EventQuery) (EventPage, error) {
+	if ea.Client == nil {
+		return EventPage{}, errNoBackend
+	}
+	var searchAfter []interface{}
+	if q.Cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+		if err != nil || json.Unmarshal(b, &searchAfter) != nil || len(searchAfter) != len(esSort) {
+			return EventPage{}, ErrInvalidCursor
+		}
+	}
+	boolQ := elastic.NewBoolQuery().Filter(elastic.NewTermQuery("kind", "events"))
+	for _, f := range [][2]string{
+		{"cluster", q.Cluster},
+		{"namespace", q.Namespace},
+		{"involvedKind", q.InvolvedKind},
+		{"involvedNamespace", q.InvolvedNamespace},
+		{"involvedName", q.InvolvedName},
+		{"reason", q.Reason},
+		{"type", q.Type},
+	} {
+		if f[1] != "" {
+			boolQ.Filter(elastic.NewTermQuery(f[0], f[1]))
+		}
+	}
+	for k, v := range q.Labels {
+		boolQ.Filter(elastic.NewTermQuery("clusterLabels."+k, v))
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		rangeQ := elastic.NewRangeQuery("lastTimestamp")
+		if !q.Since.IsZero() {
+			rangeQ.Gte(q.Since.UTC().Format(time.RFC3339Nano))
+		}
+		if !q.Until.IsZero() {
+			rangeQ.Lte(q.Until.UTC().Format(time.RFC3339Nano))
+		}
+		boolQ.Filter(rangeQ)
+	}
+	search := ea.Client.Search().
+		Index(elasticsearch.SearchIndex(ea.Conf.Index)).
+		Query(boolQ).
+		// 多取一个用于判断是否还有下一页
+		Size(q.Limit + 1)
+	for _, s := range esSort {
+		search = search.SortBy(elastic.NewFieldSort(s.field).Order(q.Ascending).UnmappedType(s.unmappedType))
+	}
+	if searchAfter != nil {
+		search = search.SearchAfter(searchAfter...)
+	}
+	res, err := search.Do(ctx)
 	if err != nil {
-		zlog.Error("es query error", zap.Error(err))
-		json.NewEncoder(w).Encode(err)
-		return
+		return EventPage{}, err
+	}
+	page := EventPage{}
+	for i, hit := range res.Hits.Hits {
+		if i == q.Limit {
+			b, _ := json.Marshal(res.Hits.Hits[i-1].Sort)
+			page.Next = base64.RawURLEncoding.EncodeToString(b)
+			break
+		}
+		var e event.Event
+		if err := json.Unmarshal(hit.Source, &e); err != nil {
+			zlog.Error("解析es文档失败", zap.String("id", hit.Id), zap.Error(err))
+			continue
+		}
+		page.Events = append(page.Events, e)
 	}
-	json.NewEncoder(w).Encode(FmtRes(res))
+	return page, nil
 }
 
 /*
@@ -75,24 +136,19 @@ func FmtRes(res []event.Event) (retRes []map[string]interface{}) {
 	for _, r := range res {
 		resMap := make(map[string]interface{})
 		resMap["count"] = r.Count
-		timeParse := strings.Replace(r.CreationTimestamp, "\u00A0", " ", -1)
-		parse, e := time.Parse("2006-01-02 15:04:05", timeParse)
-		if e != nil {
-			zlog.Errorf("parse time error:%s parseresult:%s ori filed:%s", e, parse, r.CreationTimestamp)
-		}
-		resMap["createTimestamp"] = parse
+		resMap["createTimestamp"] = r.CreationTimestamp
 		resMap["evt_name"] = r.Name
-		resMap["firstTimestamp"] = strings.Replace(r.FirstTimestamp, "\u00A0", " ", -1)
+		resMap["firstTimestamp"] = event.LegacyTime(r.FirstTimestamp)
 		resMap["kind"] = r.Kind
 		resMap["kind_name"] = r.Name
-		resMap["lastTimestamp"] = strings.Replace(r.LastTimestamp, "\u00A0", " ", -1)
+		resMap["lastTimestamp"] = event.LegacyTime(r.LastTimestamp)
 		resMap["message"] = r.Messages
 		resMap["namespace"] = r.Namespace
 		resMap["reason"] = r.Reason
 		resMap["resourceVersion"] = ""
 		resMap["source_component"] = r.Component
 		resMap["source_host"] = r.Host
-		resMap["time"] = strings.Replace(r.LastTimestamp, "\u00A0", " ", -1)
+		resMap["time"] = event.LegacyTime(r.LastTimestamp)
 		resMap["type"] = r.Type
 		retRes = append(retRes, resMap)
 	}
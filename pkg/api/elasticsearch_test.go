@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/gok8s/k8swatch/pkg/event"
 	"testing"
+	"time"
 )
 
 func TestExtract(t *testing.T) {
@@ -15,13 +16,13 @@ func TestExtract(t *testing.T) {
 		Reason:            "5",
 		Status:            "6",
 		Name:              "7",
-		CreationTimestamp: "8",
+		CreationTimestamp: time.Unix(8, 0),
 		Action:            "9",
 		Count:             0,
 		Messages:          "10",
 		Type:              "11",
-		FirstTimestamp:    "12",
-		LastTimestamp:     "13",
+		FirstTimestamp:    time.Unix(12, 0),
+		LastTimestamp:     time.Unix(13, 0),
 		ServiceName:       "14",
 	}
 	b := event.Event{
@@ -32,13 +33,13 @@ func TestExtract(t *testing.T) {
 		Reason:            "5",
 		Status:            "6",
 		Name:              "7",
-		CreationTimestamp: "8",
+		CreationTimestamp: time.Unix(8, 0),
 		Action:            "9",
 		Count:             0,
 		Messages:          "10",
 		Type:              "11",
-		FirstTimestamp:    "12",
-		LastTimestamp:     "13",
+		FirstTimestamp:    time.Unix(12, 0),
+		LastTimestamp:     time.Unix(13, 0),
 		ServiceName:       "14",
 	}
 	c := []event.Event{a, b}
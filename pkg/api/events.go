@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/event"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+/*
+ListEvents 是GET /api/v2/events，返回{"events": [...], "next": "..."}，参数均可选：
+cluster、namespace、kind、name、reason、type精确匹配，kind、name为事件所属的对象(involvedObject)，如kind=Node&name=node-1
+label=key=value可重复，按集群的labels过滤
+since、until为RFC3339时间或相对现在的时长，如since=1h
+order为desc(默认，从新到旧)或asc，limit默认100、最大1000，cursor为上一页返回的next
+参数错误返回400，没有查询后端返回503，后端查询失败返回502，body为{"code": 400, "message": "..."}
+*/
+func (qa *QueryApi) ListEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, &apiError{Code: http.StatusMethodNotAllowed, Message: "method not allowed"})
+		return
+	}
+	if qa.Backend == nil {
+		writeError(w, errNoBackend)
+		return
+	}
+	q, err := parseEventQuery(r, time.Now())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	page, err := qa.Events(r.Context(), q)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if page.Events == nil {
+		page.Events = []event.Event{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func parseEventQuery(r *http.Request, now time.Time) (EventQuery, error) {
+	params := r.URL.Query()
+	q := EventQuery{
+		Cluster:      params.Get("cluster"),
+		Namespace:    params.Get("namespace"),
+		InvolvedKind: params.Get("kind"),
+		InvolvedName: params.Get("name"),
+		Reason:       params.Get("reason"),
+		Type:         params.Get("type"),
+		Cursor:       params.Get("cursor"),
+		Limit:        defaultPageSize,
+	}
+	for _, label := range params["label"] {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return q, invalidParam("label", fmt.Errorf("%q is not key=value", label))
+		}
+		if q.Labels == nil {
+			q.Labels = map[string]string{}
+		}
+		q.Labels[kv[0]] = kv[1]
+	}
+	var err error
+	if q.Since, err = parseTime(params.Get("since"), now); err != nil {
+		return q, invalidParam("since", err)
+	}
+	if q.Until, err = parseTime(params.Get("until"), now); err != nil {
+		return q, invalidParam("until", err)
+	}
+	if !q.Since.IsZero() && !q.Until.IsZero() && q.Until.Before(q.Since) {
+		return q, invalidParam("until", fmt.Errorf("before since"))
+	}
+	switch params.Get("order") {
+	case "", "desc":
+	case "asc":
+		q.Ascending = true
+	default:
+		return q, invalidParam("order", fmt.Errorf("%q is not asc or desc", params.Get("order")))
+	}
+	if limit := params.Get("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit <= 0 {
+			return q, invalidParam("limit", fmt.Errorf("%q is not a positive integer", limit))
+		}
+		if q.Limit > maxPageSize {
+			q.Limit = maxPageSize
+		}
+	}
+	return q, nil
+}
+
+// parseTime 接受RFC3339时间或相对now的时长
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("%q is neither RFC3339 nor a duration", s)
+	}
+	return now.Add(-d), nil
+}
+
+func invalidParam(name string, err error) error {
+	return &apiError{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid %s: %v", name, err)}
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/gok8s/k8swatch/pkg/api/pb"
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/pkg/handlers/stream"
+	"github.com/gok8s/k8swatch/utils"
+)
+
+const defaultGrpcPort = 9090
+
+// GrpcApi 实现pb.EventServiceServer，ListEvents使用QueryApi的后端，WatchEvents订阅stream handler
+type GrpcApi struct {
+	pb.UnimplementedEventServiceServer
+	query  QueryApi
+	stream *stream.Stream
+}
+
+// NewGrpcApi s为nil(未启用stream handler)时WatchEvents返回Unavailable
+func NewGrpcApi(query QueryApi, s *stream.Stream) *GrpcApi {
+	return &GrpcApi{query: query, stream: s}
+}
+
+// NewGrpcServer 按conf.TLS创建gRPC server并注册api，opts如认证使用的interceptor
+func NewGrpcServer(conf config.GRPCConf, api *GrpcApi, opts ...grpc.ServerOption) (*grpc.Server, error) {
+	if conf.TLS.Enable {
+		tlsConfig, err := utils.NewServerTLSConfig(conf.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("gRPC TLS配置错误: %v", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(opts...)
+	pb.RegisterEventServiceServer(server, api)
+	return server, nil
+}
+
+// ListenGrpc 监听conf.Port，默认9090
+func ListenGrpc(conf config.GRPCConf) (net.Listener, error) {
+	port := conf.Port
+	if port <= 0 {
+		port = defaultGrpcPort
+	}
+	return net.Listen("tcp", fmt.Sprintf(":%d", port))
+}
+
+func (g *GrpcApi) ListEvents(ctx context.Context, req *pb.ListEventsRequest) (*pb.ListEventsResponse, error) {
+	if g.query.Backend == nil {
+		return nil, grpcError(errNoBackend)
+	}
+	q := EventQuery{
+		Cluster:      req.Cluster,
+		Namespace:    req.Namespace,
+		InvolvedKind: req.Kind,
+		InvolvedName: req.Name,
+		Reason:       req.Reason,
+		Type:         req.Type,
+		Labels:       req.Labels,
+		Ascending:    req.Ascending,
+		Cursor:       req.PageToken,
+		Limit:        int(req.PageSize),
+	}
+	if req.Since != nil {
+		q.Since = req.Since.AsTime()
+	}
+	if req.Until != nil {
+		q.Until = req.Until.AsTime()
+	}
+	if !q.Since.IsZero() && !q.Until.IsZero() && q.Until.Before(q.Since) {
+		return nil, status.Error(codes.InvalidArgument, "invalid until: before since")
+	}
+	switch {
+	case q.Limit < 0:
+		return nil, status.Error(codes.InvalidArgument, "invalid page_size: negative")
+	case q.Limit == 0:
+		q.Limit = defaultPageSize
+	case q.Limit > maxPageSize:
+		q.Limit = maxPageSize
+	}
+	page, err := g.query.Events(ctx, q)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &pb.ListEventsResponse{NextPageToken: page.Next}
+	for _, e := range page.Events {
+		resp.Events = append(resp.Events, toProto(e))
+	}
+	return resp, nil
+}
+
+func (g *GrpcApi) WatchEvents(req *pb.WatchEventsRequest, srv pb.EventService_WatchEventsServer) error {
+	if g.stream == nil {
+		return grpcError(errNoStream)
+	}
+	sub, err := g.stream.Subscribe(stream.Filter{
+		Cluster:   req.Cluster,
+		Namespace: req.Namespace,
+		Resource:  req.Resource,
+		Kind:      req.Kind,
+		Name:      req.Name,
+		Reason:    req.Reason,
+		Type:      req.Type,
+	}, req.ResumeAfter)
+	if err == stream.ErrTooManySubscribers {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	} else if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer sub.Close()
+	send := func(msg stream.Message) error {
+		return srv.Send(&pb.WatchEventsResponse{Id: msg.ID, Event: toProto(msg.Event)})
+	}
+	for _, msg := range sub.Backlog {
+		if err := send(msg); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				if sub.Slow() {
+					return status.Error(codes.ResourceExhausted, "slow consumer")
+				}
+				return status.Error(codes.Unavailable, "stream closed")
+			}
+			if err := send(msg); err != nil {
+				return err
+			}
+		case <-srv.Context().Done():
+			return srv.Context().Err()
+		}
+	}
+}
+
+// grpcError 将HTTP接口的错误转换为相应的gRPC status
+func grpcError(err error) error {
+	var e *apiError
+	if errors.As(err, &e) {
+		switch e.Code {
+		case http.StatusBadRequest:
+			return status.Error(codes.InvalidArgument, e.Message)
+		case http.StatusServiceUnavailable:
+			return status.Error(codes.Unavailable, e.Message)
+		}
+		return status.Error(codes.Unknown, e.Message)
+	}
+	if errors.Is(err, ErrInvalidCursor) {
+		return status.Error(codes.InvalidArgument, "invalid page_token")
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func toProto(e event.Event) *pb.Event {
+	return &pb.Event{
+		Id:                      e.ID(),
+		Cluster:                 e.Cluster,
+		Environment:             e.Environment,
+		Region:                  e.Region,
+		ClusterLabels:           e.ClusterLabels,
+		Namespace:               e.Namespace,
+		Kind:                    e.Kind,
+		Name:                    e.Name,
+		Uid:                     e.UID,
+		ResourceVersion:         e.ResourceVersion,
+		Action:                  e.Action,
+		Reason:                  e.Reason,
+		Type:                    e.Type,
+		Message:                 e.Messages,
+		Count:                   e.Count,
+		Component:               e.Component,
+		Host:                    e.Host,
+		ServiceName:             e.ServiceName,
+		CreationTimestamp:       timestamp(e.CreationTimestamp),
+		FirstTimestamp:          timestamp(e.FirstTimestamp),
+		LastTimestamp:           timestamp(e.LastTimestamp),
+		UpdateContent:           e.UpdateContent,
+		InvolvedKind:            e.InvolvedKind,
+		InvolvedNamespace:       e.InvolvedNamespace,
+		InvolvedName:            e.InvolvedName,
+		InvolvedResourceVersion: e.InvolvedResourceVersion,
+		OwnerKind:               e.OwnerKind,
+		OwnerName:               e.OwnerName,
+		User:                    e.User,
+		UserAgent:               e.UserAgent,
+		SourceIps:               e.SourceIPs,
+	}
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/gok8s/k8swatch/pkg/api/pb"
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/handlers/stream"
+	"github.com/gok8s/k8swatch/utils/testcert"
+)
+
+// serveGrpc 在bufconn上启动gRPC server，返回连接到它的client
+func serveGrpc(t *testing.T, conf config.GRPCConf, api *GrpcApi, opts ...grpc.DialOption) (pb.EventServiceClient, func()) {
+	server, err := NewGrpcServer(conf, api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	go server.Serve(lis)
+	opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	if !conf.TLS.Enable {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	conn, err := grpc.Dial("bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pb.NewEventServiceClient(conn), func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+func TestGrpcListEvents(t *testing.T) {
+	c := config.Config{}
+	s, cleanup := openStore(t, &c)
+	defer cleanup()
+	labeled := podEvent("web-2", "Killing", 3, ts)
+	labeled.ClusterLabels = map[string]string{"team": "shop"}
+	s.Put(podEvent("web-0", "BackOff", 1, ts.Add(-2*time.Minute)), podEvent("web-1", "BackOff", 2, ts.Add(-time.Minute)), labeled)
+	client, stop := serveGrpc(t, config.GRPCConf{}, NewGrpcApi(NewQueryApi(c, s), nil))
+	defer stop()
+	ctx := context.Background()
+
+	resp, err := client.ListEvents(ctx, &pb.ListEventsRequest{Labels: map[string]string{"team": "shop"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Events) != 1 || resp.NextPageToken != "" {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	if e := resp.Events[0]; e.InvolvedName != "web-2" || e.Id != labeled.ID() || !e.LastTimestamp.AsTime().Equal(ts) ||
+		e.CreationTimestamp != nil || e.ClusterLabels["team"] != "shop" {
+		t.Fatalf("unexpected event: %v", e)
+	}
+
+	var got []string
+	req := &pb.ListEventsRequest{Reason: "BackOff", PageSize: 1, Ascending: true, Since: timestamppb.New(ts.Add(-time.Hour))}
+	for {
+		resp, err := client.ListEvents(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range resp.Events {
+			got = append(got, e.InvolvedName)
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	if len(got) != 2 || got[0] != "web-0" || got[1] != "web-1" {
+		t.Fatalf("got pages %v", got)
+	}
+
+	for _, req := range []*pb.ListEventsRequest{
+		{PageToken: "not-a-cursor"},
+		{PageSize: -1},
+		{Since: timestamppb.New(ts), Until: timestamppb.New(ts.Add(-time.Minute))},
+	} {
+		if _, err := client.ListEvents(ctx, req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%v: got %v, want InvalidArgument", req, err)
+		}
+	}
+}
+
+func TestGrpcWatchEvents(t *testing.T) {
+	s := new(stream.Stream)
+	if err := s.Init(config.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	client, stop := serveGrpc(t, config.GRPCConf{}, NewGrpcApi(QueryApi{}, s))
+	defer stop()
+	ctx := context.Background()
+	if _, err := client.ListEvents(ctx, &pb.ListEventsRequest{}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("got %v without a backend, want Unavailable", err)
+	}
+
+	s.ObjectCreated(podEvent("web-0", "BackOff", 1, ts))
+	s.ObjectCreated(podEvent("web-1", "BackOff", 2, ts))
+	watch, err := client.WatchEvents(ctx, &pb.WatchEventsRequest{Kind: "Pod", Name: "web-1", ResumeAfter: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 补发的事件到达后订阅已完成
+	if msg, err := watch.Recv(); err != nil || msg.Id != 2 || msg.Event.InvolvedName != "web-1" {
+		t.Fatalf("got %v: %v", msg, err)
+	}
+	s.ObjectUpdated(podEvent("web-0", "Killing", 1, ts))
+	s.ObjectUpdated(podEvent("web-1", "Killing", 2, ts))
+	if msg, err := watch.Recv(); err != nil || msg.Id != 4 || msg.Event.Reason != "Killing" {
+		t.Fatalf("got %v: %v", msg, err)
+	}
+	s.Close()
+	if _, err := watch.Recv(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("got %v after the stream is closed, want Unavailable", err)
+	}
+}
+
+func TestGrpcMTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8swatch-grpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca, caKey := testcert.Write(t, dir, "ca", nil, nil, nil)
+	testcert.Write(t, dir, "server", nil, ca, caKey)
+	testcert.Write(t, dir, "client", nil, ca, caKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := config.GRPCConf{TLS: config.TLSConf{Enable: true, CAFile: filepath.Join(dir, "ca.crt"),
+		CertFile: filepath.Join(dir, "server.crt"), KeyFile: filepath.Join(dir, "server.key")}}
+	for _, c := range []struct {
+		certs []tls.Certificate
+		want  codes.Code
+	}{
+		{nil, codes.Unavailable},
+		// 通过认证后因没有查询后端返回Unavailable
+		{[]tls.Certificate{clientCert}, codes.Unavailable},
+	} {
+		creds := credentials.NewTLS(&tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: c.certs})
+		client, stop := serveGrpc(t, conf, NewGrpcApi(QueryApi{}, nil), grpc.WithTransportCredentials(creds))
+		_, err := client.ListEvents(context.Background(), &pb.ListEventsRequest{})
+		stop()
+		st, _ := status.FromError(err)
+		if st.Code() != c.want {
+			t.Fatalf("got %v", err)
+		}
+		if authenticated := st.Message() == errNoBackend.Message; authenticated != (c.certs != nil) {
+			t.Fatalf("got %v with %d client certificates", err, len(c.certs))
+		}
+	}
+
+	conf.TLS.KeyFile = ""
+	if _, err := NewGrpcServer(conf, NewGrpcApi(QueryApi{}, nil)); err == nil {
+		t.Fatal("expected an error without keyFile")
+	}
+}
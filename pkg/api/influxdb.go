@@ -22,7 +22,7 @@ type EventApi struct {
 
 func NewEventApi(config config.Config) EventApi {
 	nea := new(influxdb.InfluxDB)
-	if err := nea.Init(config); err != nil {
+	if err := nea.Connect(config.Handlers.Influxdb); err != nil {
 		zlog.Error(err.Error())
 	}
 	return EventApi{nea}
@@ -0,0 +1,4 @@
+// Package pb 是gRPC API的protobuf定义及生成的代码，修改events.proto后重新生成
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative events.proto
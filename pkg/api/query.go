@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/pkg/handlers/store"
+	"github.com/gok8s/k8swatch/utils/zlog"
+)
+
+const (
+	BackendElasticsearch = "elasticsearch"
+	BackendStore         = "store"
+)
+
+// EventQuery 为空的条件不限制，Namespace为事件所在的namespace，Involved*为事件所属的对象
+type EventQuery struct {
+	Cluster           string
+	Namespace         string
+	InvolvedKind      string
+	InvolvedNamespace string
+	InvolvedName      string
+	Reason            string
+	Type              string
+	// Labels 按集群的labels过滤
+	Labels map[string]string
+	// Since、Until 事件时间(lastTimestamp)的范围，包含两端
+	Since time.Time
+	Until time.Time
+	// Ascending 为true时从旧到新，默认从新到旧
+	Ascending bool
+	// Cursor 为上一页的Next
+	Cursor string
+	Limit  int
+}
+
+// EventPage 是一页事件，Next不为空时以其作为Cursor查询下一页
+type EventPage struct {
+	Events []event.Event `json:"events"`
+	Next   string        `json:"next,omitempty"`
+}
+
+// Backend 是事件查询的存储后端，elasticsearch及内置存储各有实现
+type Backend interface {
+	Events(ctx context.Context, q EventQuery) (EventPage, error)
+}
+
+// ErrInvalidCursor 由Backend在cursor无法解析时返回，接口返回400
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type QueryApi struct {
+	Backend
+}
+
+/*
+NewQueryApi 按Settings.QueryBackend选择查询后端，为空时启用了store且未启用elasticsearch则使用store
+eventStore为store handler打开的存储，未启用或打开失败时为nil，此时查询接口返回503
+*/
+func NewQueryApi(config config.Config, eventStore *store.Store) QueryApi {
+	backend := config.Settings.QueryBackend
+	if backend == "" {
+		backend = BackendElasticsearch
+		if config.Handlers.Store.Enable && !config.Handlers.Elasticsearch.Enable {
+			backend = BackendStore
+		}
+	}
+	switch backend {
+	case BackendStore:
+		if eventStore == nil {
+			zlog.Error("查询后端为store，但store handler未启用")
+			return QueryApi{}
+		}
+		return QueryApi{StoreApi{eventStore}}
+	case BackendElasticsearch:
+		return QueryApi{NewElasticSearchApi(config)}
+	default:
+		zlog.Errorf("未知的查询后端:%s，支持%s、%s", backend, BackendElasticsearch, BackendStore)
+		return QueryApi{}
+	}
+}
+
+// GetPodEvt 类似kubectl get event，保留之前的参数及返回格式
+func (qa *QueryApi) GetPodEvt(w http.ResponseWriter, r *http.Request) {
+	if qa.Backend == nil {
+		writeError(w, errNoBackend)
+		return
+	}
+	limit, _ := strconv.Atoi(r.FormValue("limit"))
+	if limit <= 0 {
+		limit = 10
+	}
+	page, err := qa.Events(r.Context(), EventQuery{
+		InvolvedKind:      "Pod",
+		InvolvedNamespace: r.FormValue("namespace"),
+		InvolvedName:      r.FormValue("kind_name"),
+		Limit:             limit,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(FmtRes(page.Events))
+}
+
+// apiError 是接口返回的错误，也作为错误的body
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+var errNoBackend = &apiError{Code: http.StatusServiceUnavailable, Message: "no query backend"}
+
+// writeError 参数错误为400，后端查询失败为502
+func writeError(w http.ResponseWriter, err error) {
+	e, ok := err.(*apiError)
+	if !ok {
+		e = &apiError{Code: http.StatusBadGateway, Message: err.Error()}
+		if errors.Is(err, ErrInvalidCursor) {
+			e.Code = http.StatusBadRequest
+		} else {
+			zlog.Errorf("查询事件失败: %v", err)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Code)
+	json.NewEncoder(w).Encode(e)
+}
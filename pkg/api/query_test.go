@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/pkg/handlers/store"
+)
+
+var ts = time.Date(2019, 10, 18, 15, 1, 30, 0, time.UTC)
+
+func openStore(t *testing.T, c *config.Config) (*store.Store, func()) {
+	dir, err := ioutil.TempDir("", "k8swatch-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Handlers.Store = config.StoreConf{Enable: true, Path: filepath.Join(dir, "events.db")}
+	s := new(store.Store)
+	if err := s.Open(c.Handlers.Store); err != nil {
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func podEvent(pod, reason string, uid int, ts time.Time) event.Event {
+	return event.Event{Cluster: "prod", Kind: "events", Namespace: "shop", Name: fmt.Sprintf("%s.%x", pod, uid),
+		UID: fmt.Sprint(uid), Reason: reason, Type: "Warning", InvolvedKind: "Pod", InvolvedNamespace: "shop",
+		InvolvedName: pod, LastTimestamp: ts}
+}
+
+func TestStoreBackend(t *testing.T) {
+	c := config.Config{}
+	s, cleanup := openStore(t, &c)
+	defer cleanup()
+	s.Put(podEvent("web-0", "BackOff", 1, ts))
+
+	// 未启用elasticsearch时默认使用store
+	qa := NewQueryApi(c, s)
+	if _, ok := qa.Backend.(StoreApi); !ok {
+		t.Fatalf("got backend %T, want StoreApi", qa.Backend)
+	}
+	w := httptest.NewRecorder()
+	qa.GetPodEvt(w, httptest.NewRequest("GET", "/events?namespace=shop&kind_name=web-0", nil))
+	var res []map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0]["reason"] != "BackOff" || res[0]["lastTimestamp"] != event.LegacyTime(ts) {
+		t.Fatalf("unexpected response: %v", res)
+	}
+
+	c.Settings.QueryBackend = BackendStore
+	qa = NewQueryApi(c, nil)
+	for _, url := range []string{"/events", "/api/v2/events"} {
+		w = httptest.NewRecorder()
+		if url == "/events" {
+			qa.GetPodEvt(w, httptest.NewRequest("GET", url, nil))
+		} else {
+			qa.ListEvents(w, httptest.NewRequest("GET", url, nil))
+		}
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("%s: got %d without a store, want 503", url, w.Code)
+		}
+	}
+}
+
+func listEvents(t *testing.T, qa QueryApi, url string) (EventPage, int) {
+	w := httptest.NewRecorder()
+	qa.ListEvents(w, httptest.NewRequest("GET", url, nil))
+	var page EventPage
+	if w.Code != http.StatusOK {
+		var e apiError
+		if err := json.NewDecoder(w.Body).Decode(&e); err != nil || e.Code != w.Code || e.Message == "" {
+			t.Fatalf("%s: unexpected error body %+v: %v", url, e, err)
+		}
+		return page, w.Code
+	}
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	return page, w.Code
+}
+
+func names(events []event.Event) string {
+	var s []string
+	for _, e := range events {
+		s = append(s, e.InvolvedName+"/"+e.Reason)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestListEvents(t *testing.T) {
+	c := config.Config{}
+	s, cleanup := openStore(t, &c)
+	defer cleanup()
+	labeled := podEvent("web-2", "Killing", 3, ts.Add(-time.Minute))
+	labeled.ClusterLabels = map[string]string{"team": "shop"}
+	node := event.Event{Cluster: "prod", Kind: "events", Namespace: "default", Name: "node-1.1", UID: "4", Reason: "NodeNotReady",
+		Type: "Normal", InvolvedKind: "Node", InvolvedName: "node-1", LastTimestamp: ts.Add(-2 * time.Minute)}
+	s.Put(
+		podEvent("web-0", "BackOff", 1, ts.Add(-3*time.Minute)),
+		podEvent("web-1", "BackOff", 2, ts.Add(-2*time.Minute)),
+		labeled,
+		node,
+	)
+	qa := NewQueryApi(c, s)
+
+	for url, want := range map[string]string{
+		"/api/v2/events": "web-2/Killing,node-1/NodeNotReady,web-1/BackOff,web-0/BackOff",
+		"/api/v2/events?order=asc&namespace=shop":         "web-0/BackOff,web-1/BackOff,web-2/Killing",
+		"/api/v2/events?kind=Node&name=node-1":            "node-1/NodeNotReady",
+		"/api/v2/events?reason=BackOff&limit=1":           "web-1/BackOff",
+		"/api/v2/events?type=Warning&label=team=shop":     "web-2/Killing",
+		"/api/v2/events?since=2019-10-18T14:59:30Z":       "web-2/Killing,node-1/NodeNotReady,web-1/BackOff",
+		"/api/v2/events?until=2019-10-18T14:59:30Z":       "node-1/NodeNotReady,web-1/BackOff,web-0/BackOff",
+		"/api/v2/events?cluster=staging":                  "",
+		"/api/v2/events?namespace=shop&since=1h&limit=10": "",
+	} {
+		page, code := listEvents(t, qa, url)
+		if code != http.StatusOK || names(page.Events) != want {
+			t.Errorf("%s: got %d %s, want %s", url, code, names(page.Events), want)
+		}
+	}
+
+	var pages []string
+	url := "/api/v2/events?namespace=shop&limit=2"
+	for {
+		page, code := listEvents(t, qa, url)
+		if code != http.StatusOK {
+			t.Fatalf("%s: got %d", url, code)
+		}
+		pages = append(pages, names(page.Events))
+		if page.Next == "" {
+			break
+		}
+		url = "/api/v2/events?namespace=shop&limit=2&cursor=" + page.Next
+	}
+	if got := strings.Join(pages, "|"); got != "web-2/Killing,web-1/BackOff|web-0/BackOff" {
+		t.Fatalf("got pages %s", got)
+	}
+
+	for _, url := range []string{
+		"/api/v2/events?since=yesterday",
+		"/api/v2/events?since=1h&until=2h",
+		"/api/v2/events?limit=0",
+		"/api/v2/events?order=random",
+		"/api/v2/events?label=team",
+		"/api/v2/events?cursor=not-a-cursor",
+	} {
+		if _, code := listEvents(t, qa, url); code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", url, code)
+		}
+	}
+}
+
+func TestParseEventQuery(t *testing.T) {
+	now := time.Now()
+	q, err := parseEventQuery(httptest.NewRequest("GET", "/api/v2/events?since=90m&limit=5000", nil), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !q.Since.Equal(now.Add(-90*time.Minute)) || !q.Until.IsZero() || q.Limit != maxPageSize || q.Ascending {
+		t.Fatalf("unexpected query: %+v", q)
+	}
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+
+	"github.com/gok8s/k8swatch/pkg/handlers/store"
+)
+
+// StoreApi 使用内置的事件存储查询
+type StoreApi struct {
+	store *store.Store
+}
+
+// Events 查询内置存储，cursor为store的主键
+func (sa StoreApi) Events(ctx context.Context, q EventQuery) (EventPage, error) {
+	events, next, err := sa.store.Search(store.Query{
+		Cluster:           q.Cluster,
+		Namespace:         q.Namespace,
+		InvolvedKind:      q.InvolvedKind,
+		InvolvedNamespace: q.InvolvedNamespace,
+		InvolvedName:      q.InvolvedName,
+		Reason:            q.Reason,
+		Type:              q.Type,
+		Labels:            q.Labels,
+		Since:             q.Since,
+		Until:             q.Until,
+		Ascending:         q.Ascending,
+		Cursor:            q.Cursor,
+		Limit:             q.Limit,
+	})
+	if err == store.ErrInvalidCursor {
+		err = ErrInvalidCursor
+	}
+	return EventPage{Events: events, Next: next}, err
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/pkg/handlers/stream"
+	"github.com/gok8s/k8swatch/utils/zlog"
+)
+
+// streamWriteTimeout 写入一个事件或心跳的超时
+var streamWriteTimeout = 10 * time.Second
+
+var errNoStream = &apiError{Code: http.StatusServiceUnavailable, Message: "event stream is not enabled"}
+
+var upgrader = websocket.Upgrader{}
+
+type connContextKey struct{}
+
+/*
+ConnContext 用作http.Server的ConnContext，使SSE可以为每次写入设置超时
+推送事件的连接不能使用server的WriteTimeout，否则在WriteTimeout后被断开
+*/
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// wsMessage 是WebSocket上的一个事件，id同SSE的id
+type wsMessage struct {
+	ID    uint64      `json:"id"`
+	Event event.Event `json:"event"`
+}
+
+// StreamApi 通过SSE或WebSocket推送stream handler收到的事件
+type StreamApi struct {
+	stream *stream.Stream
+}
+
+// NewStreamApi s为nil(未启用stream handler)时返回503
+func NewStreamApi(s *stream.Stream) StreamApi {
+	return StreamApi{s}
+}
+
+/*
+ServeHTTP 是GET /api/v2/events/stream，WebSocket升级请求使用WebSocket，否则使用SSE
+参数cluster、namespace、resource、kind、name、reason、type精确匹配，resource为对象类别如events、pods，kind、name为事件所属的对象
+Last-Event-ID header或lastEventId参数为最后收到的事件id，从最近的事件中补发之后的事件
+订阅者跟不上事件速度时被断开，SSE先发送event: close
+*/
+func (sa StreamApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, &apiError{Code: http.StatusMethodNotAllowed, Message: "method not allowed"})
+		return
+	}
+	if sa.stream == nil {
+		writeError(w, errNoStream)
+		return
+	}
+	params := r.URL.Query()
+	filter := stream.Filter{
+		Cluster:   params.Get("cluster"),
+		Namespace: params.Get("namespace"),
+		Resource:  params.Get("resource"),
+		Kind:      params.Get("kind"),
+		Name:      params.Get("name"),
+		Reason:    params.Get("reason"),
+		Type:      params.Get("type"),
+	}
+	var lastID uint64
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = params.Get("lastEventId")
+	}
+	if last != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(last, 10, 64); err != nil {
+			writeError(w, invalidParam("Last-Event-ID", fmt.Errorf("%q is not an event id", last)))
+			return
+		}
+	}
+	_, isFlusher := w.(http.Flusher)
+	ws := websocket.IsWebSocketUpgrade(r)
+	if !ws && !isFlusher {
+		writeError(w, &apiError{Code: http.StatusInternalServerError, Message: "streaming unsupported"})
+		return
+	}
+
+	sub, err := sa.stream.Subscribe(filter, lastID)
+	if err != nil {
+		writeError(w, &apiError{Code: http.StatusServiceUnavailable, Message: err.Error()})
+		return
+	}
+	defer sub.Close()
+	if ws {
+		sa.serveWebSocket(w, r, sub)
+	} else {
+		sa.serveSSE(w, r, sub)
+	}
+}
+
+func (sa StreamApi) serveSSE(w http.ResponseWriter, r *http.Request, sub *stream.Subscription) {
+	flusher := w.(http.Flusher)
+	// HTTP/2的连接由多个请求共用，不设置超时
+	conn, _ := r.Context().Value(connContextKey{}).(net.Conn)
+	if r.ProtoMajor != 1 {
+		conn = nil
+	}
+	setDeadline := func() {
+		if conn != nil {
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		}
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	setDeadline()
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(msg stream.Message) error {
+		data, err := json.Marshal(msg.Event)
+		if err != nil {
+			zlog.Error("将KBEvent解析为json失败", zap.Error(err))
+			return nil
+		}
+		setDeadline()
+		_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", msg.ID, data)
+		return err
+	}
+	for _, msg := range sub.Backlog {
+		if err := send(msg); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(sa.stream.PingInterval())
+	defer ping.Stop()
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				if sub.Slow() {
+					setDeadline()
+					fmt.Fprint(w, "event: close\ndata: slow consumer\n\n")
+					flusher.Flush()
+				}
+				return
+			}
+			if err := send(msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			setDeadline()
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (sa StreamApi) serveWebSocket(w http.ResponseWriter, r *http.Request, sub *stream.Subscription) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade已返回错误响应
+		zlog.Warnf("WebSocket握手失败: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// 读取客户端的close及pong，超过两个心跳间隔没有响应时断开
+	pingInterval := sa.stream.PingInterval()
+	closed := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(msg stream.Message) error {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteJSON(wsMessage{ID: msg.ID, Event: msg.Event})
+	}
+	for _, msg := range sub.Backlog {
+		if err := send(msg); err != nil {
+			return
+		}
+	}
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				reason := websocket.FormatCloseMessage(websocket.CloseGoingAway, "")
+				if sub.Slow() {
+					reason = websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "slow consumer")
+				}
+				conn.WriteControl(websocket.CloseMessage, reason, time.Now().Add(streamWriteTimeout))
+				return
+			}
+			if err := send(msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/handlers/stream"
+)
+
+func newStream(t *testing.T) (*stream.Stream, *httptest.Server) {
+	c := config.Config{}
+	c.Handlers.Stream = config.StreamConf{BufferSize: 4}
+	s := new(stream.Stream)
+	if err := s.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	return s, httptest.NewServer(NewStreamApi(s))
+}
+
+func TestSSE(t *testing.T) {
+	s, server := newStream(t)
+	defer server.Close()
+	defer s.Close()
+	s.ObjectCreated(podEvent("web-0", "BackOff", 1, ts))
+	s.ObjectCreated(podEvent("web-1", "BackOff", 2, ts))
+
+	req, _ := http.NewRequest("GET", server.URL+"?reason=BackOff", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got content type %s", resp.Header.Get("Content-Type"))
+	}
+	s.ObjectCreated(podEvent("web-2", "Killing", 3, ts))
+	s.ObjectCreated(podEvent("web-3", "BackOff", 4, ts))
+
+	r := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 4 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if lines[0] != "id: 2" || !strings.Contains(lines[1], `"involvedName":"web-1"`) ||
+		lines[2] != "id: 4" || !strings.Contains(lines[3], `"involvedName":"web-3"`) {
+		t.Fatalf("unexpected stream: %v", lines)
+	}
+}
+
+func TestSSESlowConsumer(t *testing.T) {
+	s, server := newStream(t)
+	defer server.Close()
+	defer s.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// 不读取响应，直到缓冲满被断开
+	e := podEvent("web-0", "BackOff", 1, ts)
+	e.Messages = strings.Repeat("Back-off restarting failed container ", 1000)
+	deadline := time.Now().Add(10 * time.Second)
+	for s.Subscribers() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("slow consumer is not disconnected")
+		}
+		s.ObjectCreated(e)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(body), "event: close\ndata: slow consumer\n\n") {
+		t.Fatalf("unexpected end of stream: %q", body[len(body)-100:])
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	s, server := newStream(t)
+	defer server.Close()
+	s.ObjectCreated(podEvent("web-0", "BackOff", 1, ts))
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "?kind=Pod&name=web-0&lastEventId=0"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s.ObjectUpdated(podEvent("web-1", "BackOff", 2, ts))
+	s.ObjectUpdated(podEvent("web-0", "Killing", 3, ts))
+
+	var msg wsMessage
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != 3 || msg.Event.Reason != "Killing" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	s.Close()
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("got %v after the stream is closed", err)
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewStreamApi(nil).ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/events/stream", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d without a stream, want 503", w.Code)
+	}
+	s, server := newStream(t)
+	defer server.Close()
+	defer s.Close()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Last-Event-ID", "abc")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got %d for an invalid Last-Event-ID, want 400", resp.StatusCode)
+	}
+}
+
+func TestSSEPastServerTimeouts(t *testing.T) {
+	c := config.Config{}
+	c.Handlers.Stream = config.StreamConf{PingInterval: 50 * time.Millisecond}
+	s := new(stream.Stream)
+	if err := s.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// 每次写入重新设置写超时，推送时间超过server的各项超时后连接仍可用
+	defer func(d time.Duration) { streamWriteTimeout = d }(streamWriteTimeout)
+	streamWriteTimeout = 200 * time.Millisecond
+	server := httptest.NewUnstartedServer(NewStreamApi(s))
+	server.Config.ReadTimeout = 200 * time.Millisecond
+	server.Config.WriteTimeout = 200 * time.Millisecond
+	server.Config.IdleTimeout = 200 * time.Millisecond
+	server.Config.ConnContext = ConnContext
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	time.Sleep(time.Second)
+	s.ObjectCreated(podEvent("web-0", "BackOff", 1, ts))
+	r := bufio.NewReader(resp.Body)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream closed after server timeouts: %v", err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			break
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package audit
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/utils/zlog"
+)
+
+const (
+	defaultCorrelationWait = 2 * time.Second
+	defaultRetention       = 5 * time.Minute
+)
+
+/*
+Event is the subset of audit.k8s.io/v1 Event used for correlation,
+k8s.io/apiserver is not vendored so only the needed fields are declared here
+*/
+type Event struct {
+	AuditID                  string          `json:"auditID"`
+	Stage                    string          `json:"stage"`
+	Verb                     string          `json:"verb"`
+	User                     UserInfo        `json:"user"`
+	ImpersonatedUser         *UserInfo       `json:"impersonatedUser,omitempty"`
+	SourceIPs                []string        `json:"sourceIPs"`
+	UserAgent                string          `json:"userAgent"`
+	ObjectRef                *ObjectRef      `json:"objectRef,omitempty"`
+	ResponseStatus           *ResponseStatus `json:"responseStatus,omitempty"`
+	ResponseObject           json.RawMessage `json:"responseObject,omitempty"`
+	StageTimestamp           time.Time       `json:"stageTimestamp"`
+	RequestReceivedTimestamp time.Time       `json:"requestReceivedTimestamp"`
+}
+
+// EventList is what the apiserver posts to a webhook backend
+type EventList struct {
+	Items []Event `json:"items"`
+}
+
+type UserInfo struct {
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+}
+
+type ObjectRef struct {
+	Resource        string `json:"resource"`
+	Namespace       string `json:"namespace"`
+	Name            string `json:"name"`
+	APIGroup        string `json:"apiGroup"`
+	Subresource     string `json:"subresource"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+type ResponseStatus struct {
+	Code int32 `json:"code"`
+}
+
+// Record is who performed an action on an object
+type Record struct {
+	Action          string
+	ResourceVersion string
+	User            string
+	UserAgent       string
+	SourceIPs       []string
+	received        time.Time
+	used            bool
+	// exact ResourceVersion来自responseObject，是操作后的版本；
+	// 否则来自objectRef，update时为请求中的旧版本，只能按顺序匹配
+	exact bool
+}
+
+/*
+Correlator 保存apiserver的audit事件，供controller在处理informer事件时查找操作者
+informer事件和audit事件的到达顺序不确定，Annotate不等待，未找到记录时由controller在CorrelationWait后再尝试一次
+audit policy为RequestResponse级别时可按responseObject中的resourceVersion精确匹配，
+其他级别objectRef中的resourceVersion是请求中的版本(update时为旧版本)，按资源、名称及动作依次匹配最早未使用的记录
+*/
+type Correlator struct {
+	wait      time.Duration
+	retention time.Duration
+	token     string
+	now       func() time.Time
+
+	mu      sync.Mutex
+	records map[string][]*Record
+}
+
+func NewCorrelator(c config.AuditConf) *Correlator {
+	a := &Correlator{
+		wait:      c.CorrelationWait,
+		retention: c.Retention,
+		token:     c.WebhookToken,
+		now:       time.Now,
+		records:   make(map[string][]*Record),
+	}
+	if a.wait <= 0 {
+		a.wait = defaultCorrelationWait
+	}
+	if a.retention <= 0 {
+		a.retention = defaultRetention
+	}
+	return a
+}
+
+// Wait is how long an event without an audit record may be delayed for a second attempt
+func (a *Correlator) Wait() time.Duration {
+	return a.wait
+}
+
+/*
+Add records a completed, successful mutating request, other audit events are ignored
+subresource请求(如pods/binding及status、scale子资源)不是对对象本身的操作，不记录，否则会被当作父对象的create/update；
+pods/eviction的create会删除pod，记录为pod的delete
+*/
+func (a *Correlator) Add(ae Event) {
+	action, ok := verbAction(ae.Verb)
+	if !ok || ae.Stage != "ResponseComplete" || ae.ObjectRef == nil || ae.ObjectRef.Resource == "" {
+		return
+	}
+	if sub := ae.ObjectRef.Subresource; sub != "" {
+		if ae.ObjectRef.Resource != "pods" || sub != "eviction" || action != event.CreateEvent {
+			return
+		}
+		action = event.DeleteEvent
+	}
+	if ae.ResponseStatus != nil && (ae.ResponseStatus.Code < 200 || ae.ResponseStatus.Code >= 300) {
+		return
+	}
+	name, rv := ae.ObjectRef.Name, ae.ObjectRef.ResourceVersion
+	exact := false
+	if len(ae.ResponseObject) > 0 {
+		var obj struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Name            string `json:"name"`
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"metadata"`
+		}
+		//eviction的responseObject是Status
+		if err := json.Unmarshal(ae.ResponseObject, &obj); err == nil && obj.Kind != "Status" {
+			//create使用generateName时objectRef中没有name
+			if name == "" {
+				name = obj.Metadata.Name
+			}
+			rv = obj.Metadata.ResourceVersion
+			exact = rv != ""
+		}
+	}
+	if name == "" {
+		return
+	}
+	user := ae.User.Username
+	if ae.ImpersonatedUser != nil {
+		user = ae.ImpersonatedUser.Username + " (impersonated by " + user + ")"
+	}
+	key := recordKey(ae.ObjectRef.Resource, ae.ObjectRef.Namespace, name)
+	r := &Record{
+		Action:          action,
+		ResourceVersion: rv,
+		User:            user,
+		UserAgent:       ae.UserAgent,
+		SourceIPs:       ae.SourceIPs,
+		received:        a.now(),
+		exact:           exact,
+	}
+	a.mu.Lock()
+	a.records[key] = append(a.records[key], r)
+	a.mu.Unlock()
+	zlog.Debugf("audit记录 %s %s by %s", action, key, user)
+}
+
+/*
+Annotate 为informer生成的事件填充user,userAgent,sourceIPs，只使用已收到的audit记录，不等待
+resource为配置中的资源名，与audit事件objectRef.resource一致，未找到记录时返回false
+*/
+func (a *Correlator) Annotate(resource string, e *event.Event) bool {
+	key := recordKey(resource, e.Namespace, e.Name)
+	r, ok := a.take(key, e.Action, e.ResourceVersion)
+	if !ok {
+		return false
+	}
+	e.User = r.User
+	e.UserAgent = r.UserAgent
+	e.SourceIPs = r.SourceIPs
+	return true
+}
+
+// take returns the record with the same resourceVersion from responseObject,
+// or else the oldest unused record of the action whose resourceVersion is from objectRef
+func (a *Correlator) take(key, action, resourceVersion string) (Record, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var found *Record
+	for _, r := range a.records[key] {
+		if r.used || r.Action != action {
+			continue
+		}
+		if r.exact && resourceVersion != "" && r.ResourceVersion == resourceVersion {
+			found = r
+			break
+		}
+		if found == nil && (!r.exact || action == event.DeleteEvent) {
+			found = r
+		}
+	}
+	if found == nil {
+		return Record{}, false
+	}
+	found.used = true
+	return *found, true
+}
+
+// Run drops records older than Retention
+func (a *Correlator) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(a.retention / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.prune()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (a *Correlator) prune() {
+	expired := a.now().Add(-a.retention)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, records := range a.records {
+		kept := records[:0]
+		for _, r := range records {
+			if r.received.After(expired) {
+				kept = append(kept, r)
+			}
+		}
+		if len(kept) == 0 {
+			delete(a.records, key)
+		} else {
+			a.records[key] = kept
+		}
+	}
+}
+
+func recordKey(resource, namespace, name string) string {
+	return strings.Join([]string{resource, namespace, name}, "/")
+}
+
+func verbAction(verb string) (string, bool) {
+	switch verb {
+	case "create":
+		return event.CreateEvent, true
+	case "update", "patch":
+		return event.UpdateEvent, true
+	case "delete":
+		return event.DeleteEvent, true
+	}
+	return "", false
+}
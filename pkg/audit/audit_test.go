@@ -0,0 +1,194 @@
+package audit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+)
+
+const auditList = `{"kind":"EventList","apiVersion":"audit.k8s.io/v1","items":[
+{"stage":"ResponseComplete","verb":"create","user":{"username":"alice"},"sourceIPs":["10.0.0.1"],"userAgent":"kubectl/v1.16.0",
+ "objectRef":{"resource":"pods","namespace":"shop","apiVersion":"v1"},"responseStatus":{"code":201},
+ "responseObject":{"kind":"Pod","metadata":{"name":"web-x7k2p","namespace":"shop","resourceVersion":"100"}}},
+{"stage":"ResponseComplete","verb":"patch","user":{"username":"system:serviceaccount:ci:deployer"},"sourceIPs":["10.0.0.2"],"userAgent":"helm/v3",
+ "objectRef":{"resource":"pods","namespace":"shop","name":"web-x7k2p"},"responseStatus":{"code":200}},
+{"stage":"ResponseComplete","verb":"delete","user":{"username":"mallory"},"sourceIPs":["10.0.0.3"],
+ "objectRef":{"resource":"pods","namespace":"shop","name":"web-x7k2p"},"responseStatus":{"code":403}},
+{"stage":"RequestReceived","verb":"delete","user":{"username":"bob"},
+ "objectRef":{"resource":"pods","namespace":"shop","name":"web-x7k2p"}},
+{"stage":"ResponseComplete","verb":"get","user":{"username":"carol"},
+ "objectRef":{"resource":"pods","namespace":"shop","name":"web-x7k2p"},"responseStatus":{"code":200}}
+]}`
+
+func TestCorrelatorWebhook(t *testing.T) {
+	a := NewCorrelator(config.AuditConf{Enable: true, CorrelationWait: 10 * time.Millisecond})
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/audit", strings.NewReader(auditList)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ServeHTTP() status %d", rec.Code)
+	}
+
+	created := event.Event{Namespace: "shop", Name: "web-x7k2p", Action: event.CreateEvent, ResourceVersion: "100"}
+	if !a.Annotate("pods", &created) || created.User != "alice" || created.UserAgent != "kubectl/v1.16.0" || len(created.SourceIPs) != 1 || created.SourceIPs[0] != "10.0.0.1" {
+		t.Fatalf("create not annotated: %+v", created)
+	}
+
+	updated := event.Event{Namespace: "shop", Name: "web-x7k2p", Action: event.UpdateEvent, ResourceVersion: "101"}
+	a.Annotate("pods", &updated)
+	if updated.User != "system:serviceaccount:ci:deployer" {
+		t.Fatalf("update not annotated: %+v", updated)
+	}
+	// 每条audit记录只使用一次
+	again := event.Event{Namespace: "shop", Name: "web-x7k2p", Action: event.UpdateEvent, ResourceVersion: "102"}
+	if a.Annotate("pods", &again) || again.User != "" {
+		t.Fatalf("audit record used twice: %+v", again)
+	}
+
+	// 失败的请求、非ResponseComplete阶段及只读请求都不记录
+	deleted := event.Event{Namespace: "shop", Name: "web-x7k2p", Action: event.DeleteEvent}
+	if a.Annotate("pods", &deleted) || deleted.User != "" {
+		t.Fatalf("unexpected delete annotation: %+v", deleted)
+	}
+
+	a.now = func() time.Time { return time.Now().Add(time.Hour) }
+	a.prune()
+	if len(a.records) != 0 {
+		t.Fatalf("expired records kept: %v", a.records)
+	}
+}
+
+func TestCorrelatorTailFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	if err := ioutil.WriteFile(path, []byte(`{"stage":"ResponseComplete","verb":"delete","user":{"username":"old"},"objectRef":{"resource":"secrets","namespace":"shop","name":"db"}}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewCorrelator(config.AuditConf{Enable: true})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go a.TailFile(path, stopCh)
+	time.Sleep(100 * time.Millisecond)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"stage":"ResponseComplete","verb":"delete","user":{"username":"bob"},"impersonatedUser":{"username":"carol"},`)
+	f.WriteString(`"objectRef":{"resource":"secrets","namespace":"shop","name":"db"},"responseStatus":{"code":200}}` + "\n")
+	f.Close()
+
+	// Annotate不等待，轮询直到读到追加的记录
+	deleted := event.Event{Namespace: "shop", Name: "db", Action: event.DeleteEvent}
+	deadline := time.Now().Add(3 * time.Second)
+	for !a.Annotate("secrets", &deleted) && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if deleted.User != "carol (impersonated by bob)" {
+		t.Fatalf("delete not annotated from tailed file: %+v", deleted)
+	}
+}
+
+const subresourceList = `{"kind":"EventList","apiVersion":"audit.k8s.io/v1","items":[
+{"stage":"ResponseComplete","verb":"create","user":{"username":"system:kube-scheduler"},
+ "objectRef":{"resource":"pods","namespace":"shop","name":"web-0","subresource":"binding"},"responseStatus":{"code":201}},
+{"stage":"ResponseComplete","verb":"patch","user":{"username":"system:node:node-1"},
+ "objectRef":{"resource":"pods","namespace":"shop","name":"web-0","subresource":"status"},"responseStatus":{"code":200}},
+{"stage":"ResponseComplete","verb":"update","user":{"username":"hpa"},
+ "objectRef":{"resource":"deployments","namespace":"shop","name":"web","apiGroup":"apps","subresource":"scale"},"responseStatus":{"code":200}},
+{"stage":"ResponseComplete","verb":"create","user":{"username":"cluster-autoscaler"},
+ "objectRef":{"resource":"pods","namespace":"shop","name":"web-1","subresource":"eviction"},"responseStatus":{"code":201},
+ "responseObject":{"kind":"Status","apiVersion":"v1","status":"Success"}}
+]}`
+
+func TestCorrelatorSubresource(t *testing.T) {
+	a := NewCorrelator(config.AuditConf{Enable: true})
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/audit", strings.NewReader(subresourceList)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ServeHTTP() status %d", rec.Code)
+	}
+
+	// binding、status及scale不是对对象本身的操作
+	for _, e := range []event.Event{
+		{Namespace: "shop", Name: "web-0", Action: event.CreateEvent},
+		{Namespace: "shop", Name: "web-0", Action: event.UpdateEvent},
+	} {
+		if a.Annotate("pods", &e) {
+			t.Fatalf("subresource request annotated %s: %+v", e.Action, e)
+		}
+	}
+	scaled := event.Event{Namespace: "shop", Name: "web", Action: event.UpdateEvent}
+	if a.Annotate("deployments", &scaled) {
+		t.Fatalf("scale request annotated: %+v", scaled)
+	}
+
+	evicted := event.Event{Namespace: "shop", Name: "web-1", Action: event.DeleteEvent, ResourceVersion: "200"}
+	if !a.Annotate("pods", &evicted) || evicted.User != "cluster-autoscaler" {
+		t.Fatalf("eviction not annotated as delete: %+v", evicted)
+	}
+}
+
+func TestWebhookToken(t *testing.T) {
+	a := NewCorrelator(config.AuditConf{Enable: true, Webhook: true, WebhookToken: "audit-token"})
+	for _, c := range []struct {
+		header string
+		want   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer audit-token", http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/audit", strings.NewReader(auditList))
+		if c.header != "" {
+			r.Header.Set("Authorization", c.header)
+		}
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, r)
+		if rec.Code != c.want {
+			t.Errorf("Authorization %q: got %d, want %d", c.header, rec.Code, c.want)
+		}
+	}
+	created := event.Event{Namespace: "shop", Name: "web-x7k2p", Action: event.CreateEvent, ResourceVersion: "100"}
+	if !a.Annotate("pods", &created) || created.User != "alice" {
+		t.Fatalf("create not annotated: %+v", created)
+	}
+}
+
+// update的objectRef.resourceVersion是请求中的旧版本，informer事件为新版本
+const replaceList = `{"kind":"EventList","apiVersion":"audit.k8s.io/v1","items":[
+{"stage":"ResponseComplete","verb":"update","user":{"username":"system:serviceaccount:kube-system:deployment-controller"},"userAgent":"kube-controller-manager/v1.16.0",
+ "objectRef":{"resource":"deployments","namespace":"shop","name":"web","apiGroup":"apps","resourceVersion":"300"},"responseStatus":{"code":200}},
+{"stage":"ResponseComplete","verb":"update","user":{"username":"alice"},"userAgent":"kubectl/v1.16.0",
+ "objectRef":{"resource":"deployments","namespace":"shop","name":"web","apiGroup":"apps","resourceVersion":"301"},"responseStatus":{"code":200}}
+]}`
+
+func TestCorrelatorUpdateWithRequestVersion(t *testing.T) {
+	a := NewCorrelator(config.AuditConf{Enable: true})
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/audit", strings.NewReader(replaceList)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ServeHTTP() status %d", rec.Code)
+	}
+	// 按顺序匹配最早未使用的记录
+	first := event.Event{Namespace: "shop", Name: "web", Action: event.UpdateEvent, ResourceVersion: "301"}
+	if !a.Annotate("deployments", &first) || first.UserAgent != "kube-controller-manager/v1.16.0" {
+		t.Fatalf("first update not annotated: %+v", first)
+	}
+	second := event.Event{Namespace: "shop", Name: "web", Action: event.UpdateEvent, ResourceVersion: "302"}
+	if !a.Annotate("deployments", &second) || second.User != "alice" {
+		t.Fatalf("second update not annotated: %+v", second)
+	}
+}
@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gok8s/k8swatch/utils/zlog"
+)
+
+const tailInterval = time.Second
+
+/*
+ServeHTTP 作为apiserver的audit webhook backend，apiserver以--audit-webhook-config-file指向
+http://k8swatch:<HttpPort>/audit，POST的body为audit.k8s.io/v1 EventList
+配置了WebhookToken时请求需携带Authorization: Bearer <WebhookToken>
+*/
+func (a *Correlator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if a.token != "" {
+		header := r.Header.Get("Authorization")
+		if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimSpace(header[7:])), []byte(a.token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		zlog.Errorf("读取audit webhook请求失败:%v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var list EventList
+	if err := json.Unmarshal(body, &list); err != nil {
+		zlog.Errorf("解析audit EventList失败:%v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, ae := range list.Items {
+		a.Add(ae)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+/*
+TailFile 从文件末尾开始读取apiserver的audit日志(--audit-log-path，json格式，每行一个事件)，
+文件被轮转或截断后重新打开
+*/
+func (a *Correlator) TailFile(path string, stopCh <-chan struct{}) {
+	var f *os.File
+	var reader *bufio.Reader
+	var offset int64
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	open := func(seekEnd bool) bool {
+		if f != nil {
+			f.Close()
+			f = nil
+		}
+		var err error
+		if f, err = os.Open(path); err != nil {
+			zlog.Errorf("打开audit日志%s失败:%v", path, err)
+			return false
+		}
+		whence := io.SeekStart
+		if seekEnd {
+			whence = io.SeekEnd
+		}
+		if offset, err = f.Seek(0, whence); err != nil {
+			zlog.Errorf("audit日志%s seek失败:%v", path, err)
+		}
+		reader = bufio.NewReader(f)
+		return true
+	}
+	opened := open(true)
+	var partial []byte
+
+	ticker := time.NewTicker(tailInterval)
+	defer ticker.Stop()
+	for {
+		if opened {
+			for {
+				line, err := reader.ReadBytes('\n')
+				offset += int64(len(line))
+				if err != nil {
+					//未写完的行留到下次读取
+					partial = append(partial, line...)
+					break
+				}
+				line = append(partial, line...)
+				partial = nil
+				var ae Event
+				if err := json.Unmarshal(line, &ae); err != nil {
+					zlog.Warnf("解析audit日志行失败:%v", err)
+					continue
+				}
+				a.Add(ae)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+		if !opened {
+			opened = open(false)
+			continue
+		}
+		if rotated(f, path, offset) {
+			zlog.Infof("audit日志%s已轮转，重新打开", path)
+			partial = nil
+			opened = open(false)
+		}
+	}
+}
+
+// rotated reports whether path now points to another file or the file was truncated
+func rotated(f *os.File, path string, offset int64) bool {
+	cur, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	old, err := f.Stat()
+	if err != nil {
+		return true
+	}
+	return !os.SameFile(old, cur) || cur.Size() < offset
+}
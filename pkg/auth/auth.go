@@ -0,0 +1,363 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/utils"
+	"github.com/gok8s/k8swatch/utils/zlog"
+)
+
+const (
+	defaultCacheTTL = time.Minute
+	// maxCacheSize 缓存超过该数量时清理过期的结果
+	maxCacheSize = 10000
+)
+
+var (
+	defaultAdminGroups = []string{"system:masters"}
+	// /audit由audit.webhookToken认证，apiserver的webhook kubeconfig不需要auth接受的凭证
+	defaultPublicPaths = []string{"/healthz", "/healthz/clusters", "/audit", "/audit/"}
+	// adminPaths 需要admin角色，以/结尾的为前缀
+	adminPaths = []string{"/stop", "/debug/pprof/"}
+	// eventPaths 查询事件的接口，启用SubjectAccessReview时按namespace参数授权
+	eventPaths = []string{"/events", "/api/v2/events", "/api/v2/events/stream"}
+)
+
+var requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "k8swatch_http_auth_total",
+	Help: "HTTP and gRPC requests checked by authentication and authorization, by result (allowed, public, unauthenticated, forbidden or error).",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(requestsTotal)
+}
+
+// errUnauthenticated 请求没有凭证或凭证无效，返回401
+var errUnauthenticated = errors.New("unauthenticated")
+
+// User 是认证得到的用户
+type User struct {
+	Name   string
+	Groups []string
+}
+
+type cacheEntry struct {
+	user    *User
+	allowed bool
+	err     error
+	expires time.Time
+}
+
+/*
+Auth 认证及授权HTTP API的请求，TokenReview、SubjectAccessReview的结果缓存CacheTTL
+TokenReview使用conf.Cluster指定的集群，SubjectAccessReview在请求查询的集群中进行
+*/
+type Auth struct {
+	conf   config.AuthConf
+	client kubernetes.Interface
+	// clients 各集群SubjectAccessReview使用的client，clusterNames保持配置中的顺序
+	clients      map[string]kubernetes.Interface
+	clusterNames []string
+	tokens       map[string]*User
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+// New clusters为k8swatch监听的集群，TokenReview、SubjectAccessReview均未启用时可为空
+func New(conf config.AuthConf, clusters []*utils.Cluster) (*Auth, error) {
+	a := &Auth{conf: conf, clients: map[string]kubernetes.Interface{}, tokens: map[string]*User{}, cache: map[string]cacheEntry{}}
+	for _, cluster := range clusters {
+		a.clients[cluster.Name] = cluster.KubeClient
+		a.clusterNames = append(a.clusterNames, cluster.Name)
+		if a.client == nil && (conf.Cluster == "" || cluster.Name == conf.Cluster) {
+			a.client = cluster.KubeClient
+		}
+	}
+	if a.conf.CacheTTL <= 0 {
+		a.conf.CacheTTL = defaultCacheTTL
+	}
+	if len(a.conf.AdminUsers) == 0 && len(a.conf.AdminGroups) == 0 {
+		a.conf.AdminGroups = defaultAdminGroups
+	}
+	if a.conf.PublicPaths == nil {
+		a.conf.PublicPaths = defaultPublicPaths
+	}
+	for i, t := range a.conf.Tokens {
+		token := t.Token
+		if t.TokenFile != "" {
+			b, err := ioutil.ReadFile(t.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("读取auth.tokens[%d]的tokenFile失败: %v", i, err)
+			}
+			token = strings.TrimSpace(string(b))
+		}
+		if token == "" || t.User == "" {
+			return nil, fmt.Errorf("auth.tokens[%d]的token及user不能为空", i)
+		}
+		a.tokens[token] = &User{Name: t.User, Groups: t.Groups}
+	}
+	if (a.conf.TokenReview || a.conf.SubjectAccessReview) && a.client == nil {
+		return nil, fmt.Errorf("auth的集群%q不可用，无法使用TokenReview或SubjectAccessReview", a.conf.Cluster)
+	}
+	if len(a.tokens) == 0 && !a.conf.TokenReview && (!a.conf.TLS.Enable || a.conf.TLS.CAFile == "") {
+		return nil, fmt.Errorf("auth未配置tokens、tokenReview或客户端证书，所有请求都会被拒绝")
+	}
+	return a, nil
+}
+
+// TLSConfig 为nil时HTTP server不使用TLS，客户端证书是可选的，没有证书时使用token认证
+func (a *Auth) TLSConfig() (*tls.Config, error) {
+	if !a.conf.TLS.Enable {
+		return nil, nil
+	}
+	tlsConfig, err := utils.NewServerTLSConfig(a.conf.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("auth TLS配置错误: %v", err)
+	}
+	if tlsConfig.ClientCAs != nil {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// Public reports whether path is in PublicPaths and served without authentication
+func (a *Auth) Public(path string) bool {
+	return matchPath(a.conf.PublicPaths, path)
+}
+
+// Handler 在next之前认证及授权，PublicPaths不需要认证
+func (a *Auth) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if a.Public(path) {
+			requestsTotal.WithLabelValues("public").Inc()
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, err := a.Authenticate(r)
+		if err == errUnauthenticated {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="k8swatch"`)
+			deny(w, http.StatusUnauthorized, "unauthenticated", "unauthorized")
+			return
+		} else if err != nil {
+			zlog.Errorf("认证请求失败: %v", err)
+			deny(w, http.StatusServiceUnavailable, "error", "authentication is unavailable")
+			return
+		}
+		switch {
+		case matchPath(adminPaths, path):
+			if !a.isAdmin(user) {
+				deny(w, http.StatusForbidden, "forbidden", fmt.Sprintf("user %q is not an admin", user.Name))
+				return
+			}
+		case matchPath(eventPaths, path) && a.conf.SubjectAccessReview:
+			// 与查询接口一样使用FormValue，POST body中的cluster、namespace不能绕过授权
+			cluster, namespace := r.FormValue("cluster"), r.FormValue("namespace")
+			allowed, err := a.canListEvents(user, cluster, namespace)
+			if err != nil {
+				zlog.Errorf("用户%s的SubjectAccessReview失败: %v", user.Name, err)
+				deny(w, http.StatusServiceUnavailable, "error", "authorization is unavailable")
+				return
+			}
+			if !allowed {
+				deny(w, http.StatusForbidden, "forbidden", forbiddenMessage(user, cluster, namespace))
+				return
+			}
+		}
+		requestsTotal.WithLabelValues("allowed").Inc()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Authenticate 依次使用已验证的客户端证书、静态token、TokenReview
+func (a *Auth) Authenticate(r *http.Request) (*User, error) {
+	return a.authenticate(r.TLS, r.Header.Get("Authorization"))
+}
+
+// authenticate state为连接的TLS状态，header为Authorization header或gRPC的authorization metadata
+func (a *Auth) authenticate(state *tls.ConnectionState, header string) (*User, error) {
+	if state != nil && len(state.VerifiedChains) > 0 {
+		cert := state.VerifiedChains[0][0]
+		return &User{Name: cert.Subject.CommonName, Groups: cert.Subject.Organization}, nil
+	}
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return nil, errUnauthenticated
+	}
+	token := strings.TrimSpace(header[7:])
+	if token == "" {
+		return nil, errUnauthenticated
+	}
+	for t, user := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return user, nil
+		}
+	}
+	if !a.conf.TokenReview {
+		return nil, errUnauthenticated
+	}
+	sum := sha256.Sum256([]byte(token))
+	e := a.cached("token/"+hex.EncodeToString(sum[:]), func() (e cacheEntry) {
+		res, err := a.client.AuthenticationV1().TokenReviews().Create(&authnv1.TokenReview{
+			Spec: authnv1.TokenReviewSpec{Token: token, Audiences: a.conf.Audiences},
+		})
+		if err != nil {
+			e.err = err
+			return
+		}
+		if res.Status.Authenticated {
+			e.user = &User{Name: res.Status.User.Username, Groups: res.Status.User.Groups}
+		}
+		return
+	})
+	if e.err != nil {
+		return nil, e.err
+	}
+	if e.user == nil {
+		return nil, errUnauthenticated
+	}
+	return e.user, nil
+}
+
+func (a *Auth) isAdmin(user *User) bool {
+	for _, name := range a.conf.AdminUsers {
+		if name == user.Name {
+			return true
+		}
+	}
+	for _, group := range a.conf.AdminGroups {
+		for _, g := range user.Groups {
+			if g == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+canListEvents 在cluster中检查能否list namespace的events，cluster为空时需在所有集群中都有权限，
+namespace为空时检查能否list所有namespace的events
+*/
+func (a *Auth) canListEvents(user *User, cluster, namespace string) (bool, error) {
+	names := a.clusterNames
+	if cluster != "" {
+		if _, ok := a.clients[cluster]; !ok {
+			return false, nil
+		}
+		names = []string{cluster}
+	}
+	for _, name := range names {
+		allowed, err := a.review(user, name, namespace)
+		if err != nil || !allowed {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// review 在集群cluster中进行SubjectAccessReview，结果按集群缓存
+func (a *Auth) review(user *User, cluster, namespace string) (bool, error) {
+	client := a.clients[cluster]
+	if client == nil {
+		return false, fmt.Errorf("集群%q的client不可用", cluster)
+	}
+	key := fmt.Sprintf("sar/%s/%s/%s/%s", cluster, user.Name, strings.Join(user.Groups, ","), namespace)
+	e := a.cached(key, func() (e cacheEntry) {
+		res, err := client.AuthorizationV1().SubjectAccessReviews().Create(&authzv1.SubjectAccessReview{
+			Spec: authzv1.SubjectAccessReviewSpec{
+				User:   user.Name,
+				Groups: user.Groups,
+				ResourceAttributes: &authzv1.ResourceAttributes{
+					Namespace: namespace,
+					Verb:      "list",
+					Resource:  "events",
+				},
+			},
+		})
+		if err != nil {
+			e.err = err
+			return
+		}
+		e.allowed = res.Status.Allowed
+		return
+	})
+	return e.allowed, e.err
+}
+
+func forbiddenMessage(user *User, cluster, namespace string) string {
+	where := fmt.Sprintf("namespace %q", namespace)
+	if namespace == "" {
+		where = "all namespaces"
+	}
+	if cluster == "" {
+		where += " of all clusters"
+	} else {
+		where += fmt.Sprintf(" of cluster %q", cluster)
+	}
+	return fmt.Sprintf("user %q cannot list events in %s, specify a cluster and namespace", user.Name, where)
+}
+
+// cached 返回key未过期的结果，否则调用review并缓存，review失败时不缓存
+func (a *Auth) cached(key string, review func() cacheEntry) cacheEntry {
+	now := time.Now()
+	a.mu.Lock()
+	e, ok := a.cache[key]
+	a.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e
+	}
+	e = review()
+	if e.err != nil {
+		return e
+	}
+	e.expires = now.Add(a.conf.CacheTTL)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.cache) >= maxCacheSize {
+		for k, v := range a.cache {
+			if !now.Before(v.expires) {
+				delete(a.cache, k)
+			}
+		}
+	}
+	a.cache[key] = e
+	return e
+}
+
+// matchPath paths中以/结尾的为前缀
+func matchPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if p == path || strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func deny(w http.ResponseWriter, code int, result, message string) {
+	requestsTotal.WithLabelValues(result).Inc()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}{code, message})
+}
@@ -0,0 +1,265 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/utils"
+	"github.com/gok8s/k8swatch/utils/testcert"
+)
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+// fakeClient 认证token "sa-token"为shop:reader，shop:reader只能list namespaces中的events
+func fakeClient(namespaces ...string) (*fake.Clientset, *int) {
+	client := fake.NewSimpleClientset()
+	reviews := 0
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		tr := action.(k8stesting.CreateAction).GetObject().(*authnv1.TokenReview)
+		if tr.Spec.Token == "sa-token" {
+			tr.Status = authnv1.TokenReviewStatus{Authenticated: true,
+				User: authnv1.UserInfo{Username: "system:serviceaccount:shop:reader", Groups: []string{"system:serviceaccounts"}}}
+		}
+		return true, tr, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		sar := action.(k8stesting.CreateAction).GetObject().(*authzv1.SubjectAccessReview)
+		attrs := sar.Spec.ResourceAttributes
+		sar.Status.Allowed = sar.Spec.User == "admin"
+		for _, ns := range namespaces {
+			if sar.Spec.User == "system:serviceaccount:shop:reader" && attrs.Namespace == ns && attrs.Verb == "list" && attrs.Resource == "events" {
+				sar.Status.Allowed = true
+			}
+		}
+		return true, sar, nil
+	})
+	return client, &reviews
+}
+
+func request(h http.Handler, method, url, token string) int {
+	r := httptest.NewRequest(method, url, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestHandler(t *testing.T) {
+	client, reviews := fakeClient("shop")
+	a, err := New(config.AuthConf{
+		Tokens: []config.TokenConf{
+			{Token: "admin-token", User: "admin", Groups: []string{"system:masters"}},
+			{Token: "viewer-token", User: "viewer"},
+		},
+		TokenReview:         true,
+		SubjectAccessReview: true,
+	}, []*utils.Cluster{{Name: "prod", KubeClient: client}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.Handler(ok)
+	for _, c := range []struct {
+		url, token string
+		want       int
+	}{
+		{"/healthz", "", http.StatusOK},
+		{"/healthz/clusters", "", http.StatusOK},
+		// audit webhook由audit.webhookToken认证
+		{"/audit", "", http.StatusOK},
+		{"/audit/prod", "", http.StatusOK},
+		{"/metrics", "", http.StatusUnauthorized},
+		{"/metrics", "wrong-token", http.StatusUnauthorized},
+		{"/metrics", "viewer-token", http.StatusOK},
+		{"/stop", "viewer-token", http.StatusForbidden},
+		{"/stop", "sa-token", http.StatusForbidden},
+		{"/stop", "admin-token", http.StatusOK},
+		{"/debug/pprof/heap", "viewer-token", http.StatusForbidden},
+		{"/debug/pprof/heap", "admin-token", http.StatusOK},
+		{"/api/v2/events?namespace=shop", "sa-token", http.StatusOK},
+		{"/api/v2/events?namespace=kube-system", "sa-token", http.StatusForbidden},
+		{"/api/v2/events", "sa-token", http.StatusForbidden},
+		{"/api/v2/events/stream?namespace=shop", "sa-token", http.StatusOK},
+		{"/events?namespace=kube-system", "sa-token", http.StatusForbidden},
+		{"/api/v2/events", "admin-token", http.StatusOK},
+		{"/api/v2/events?namespace=shop", "viewer-token", http.StatusForbidden},
+	} {
+		if got := request(h, "GET", c.url, c.token); got != c.want {
+			t.Errorf("%s with %q: got %d, want %d", c.url, c.token, got, c.want)
+		}
+	}
+
+	// body中的namespace与查询接口的FormValue一致
+	r := httptest.NewRequest("POST", "/events?namespace=shop", nil)
+	r.Body = ioutil.NopCloser(strings.NewReader("namespace=kube-system"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Authorization", "Bearer sa-token")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("got %d for a namespace in the body, want 403", w.Code)
+	}
+
+	// 结果被缓存
+	before := *reviews
+	request(h, "GET", "/api/v2/events?namespace=shop", "sa-token")
+	if *reviews != before {
+		t.Fatalf("got %d reviews for a cached request", *reviews-before)
+	}
+}
+
+func TestHandlerClusters(t *testing.T) {
+	prod, prodReviews := fakeClient("shop")
+	staging, stagingReviews := fakeClient("shop", "dev")
+	a, err := New(config.AuthConf{
+		Tokens:              []config.TokenConf{{Token: "admin-token", User: "admin"}},
+		TokenReview:         true,
+		SubjectAccessReview: true,
+		Cluster:             "staging",
+	}, []*utils.Cluster{{Name: "prod", KubeClient: prod}, {Name: "staging", KubeClient: staging}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.Handler(ok)
+	for _, c := range []struct {
+		url, token string
+		want       int
+	}{
+		// 未指定cluster时需在所有集群中都有权限
+		{"/api/v2/events?namespace=shop", "sa-token", http.StatusOK},
+		{"/api/v2/events?namespace=dev", "sa-token", http.StatusForbidden},
+		{"/api/v2/events?cluster=staging&namespace=dev", "sa-token", http.StatusOK},
+		{"/api/v2/events?cluster=prod&namespace=dev", "sa-token", http.StatusForbidden},
+		{"/api/v2/events?cluster=test&namespace=shop", "sa-token", http.StatusForbidden},
+		{"/api/v2/events", "admin-token", http.StatusOK},
+	} {
+		if got := request(h, "GET", c.url, c.token); got != c.want {
+			t.Errorf("%s with %q: got %d, want %d", c.url, c.token, got, c.want)
+		}
+	}
+	// TokenReview只在auth.cluster中进行，各集群的SubjectAccessReview分别缓存
+	prodBefore, stagingBefore := *prodReviews, *stagingReviews
+	request(h, "GET", "/api/v2/events?cluster=staging&namespace=shop", "sa-token")
+	request(h, "GET", "/api/v2/events?cluster=prod&namespace=shop", "sa-token")
+	if *prodReviews != prodBefore || *stagingReviews != stagingBefore {
+		t.Fatalf("got %d prod and %d staging reviews for cached requests", *prodReviews-prodBefore, *stagingReviews-stagingBefore)
+	}
+	if *prodReviews != 3 {
+		t.Fatalf("got %d prod reviews, want 3 SubjectAccessReviews", *prodReviews)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, conf := range []config.AuthConf{
+		{},
+		{Tokens: []config.TokenConf{{Token: "t"}}},
+		{TokenReview: true},
+		{Tokens: []config.TokenConf{{TokenFile: "/nonexistent", User: "u"}}},
+	} {
+		if _, err := New(conf, nil); err == nil {
+			t.Errorf("%+v: expected an error", conf)
+		}
+	}
+	dir, err := ioutil.TempDir("", "k8swatch-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "token"), []byte("file-token\n"), 0600)
+	a, err := New(config.AuthConf{Tokens: []config.TokenConf{{TokenFile: filepath.Join(dir, "token"), User: "u"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := request(a.Handler(ok), "GET", "/metrics", "file-token"); got != http.StatusOK {
+		t.Fatalf("got %d with a token from tokenFile", got)
+	}
+	// 默认/audit不需要认证，main据此要求audit.webhookToken
+	if !a.Public("/audit") || !a.Public("/audit/") || a.Public("/metrics") {
+		t.Fatal("unexpected default public paths")
+	}
+	a, err = New(config.AuthConf{Tokens: []config.TokenConf{{Token: "t", User: "u"}}, PublicPaths: []string{"/healthz"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Public("/audit") || a.Public("/audit/") {
+		t.Fatal("/audit is public with custom publicPaths")
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8swatch-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca, caKey := testcert.Write(t, dir, "ca", nil, nil, nil)
+	testcert.Write(t, dir, "localhost", nil, ca, caKey)
+	testcert.Write(t, dir, "ops", []string{"system:masters"}, ca, caKey)
+	testcert.Write(t, dir, "dev", nil, ca, caKey)
+
+	a, err := New(config.AuthConf{TLS: config.TLSConf{Enable: true, CAFile: filepath.Join(dir, "ca.crt"),
+		CertFile: filepath.Join(dir, "localhost.crt"), KeyFile: filepath.Join(dir, "localhost.key")}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := a.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(a.Handler(ok))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	for _, c := range []struct {
+		cert string
+		path string
+		want int
+	}{
+		// 没有客户端证书时仍可访问public的路径
+		{"", "/healthz", http.StatusOK},
+		{"", "/metrics", http.StatusUnauthorized},
+		{"dev", "/metrics", http.StatusOK},
+		{"dev", "/stop", http.StatusForbidden},
+		{"ops", "/stop", http.StatusOK},
+	} {
+		clientTLS := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+		if c.cert != "" {
+			cert, err := tls.LoadX509KeyPair(filepath.Join(dir, c.cert+".crt"), filepath.Join(dir, c.cert+".key"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientTLS.Certificates = []tls.Certificate{cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+		resp, err := client.Get(server.URL + c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.want {
+			t.Errorf("%s with %q: got %d, want %d", c.path, c.cert, resp.StatusCode, c.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/gok8s/k8swatch/utils/zlog"
+)
+
+// eventsRequest 是查询事件的gRPC请求(ListEvents、WatchEvents)，按其cluster、namespace授权
+type eventsRequest interface {
+	GetCluster() string
+	GetNamespace() string
+}
+
+// UnaryInterceptor 与Handler一样认证gRPC请求，启用SubjectAccessReview时按请求的cluster、namespace授权
+func (a *Auth) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		user, err := a.authenticateGrpc(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.authorizeGrpc(user, req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor 在调用handler前认证，在收到请求消息时授权
+func (a *Auth) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		user, err := a.authenticateGrpc(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authorizedStream{ServerStream: ss, auth: a, user: user})
+	}
+}
+
+type authorizedStream struct {
+	grpc.ServerStream
+	auth *Auth
+	user *User
+}
+
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.auth.authorizeGrpc(s.user, m)
+}
+
+// authenticateGrpc 使用已验证的客户端证书或authorization metadata中的bearer token
+func (a *Auth) authenticateGrpc(ctx context.Context) (*User, error) {
+	var state *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			header = values[0]
+		}
+	}
+	user, err := a.authenticate(state, header)
+	if err == errUnauthenticated {
+		requestsTotal.WithLabelValues("unauthenticated").Inc()
+		return nil, status.Error(codes.Unauthenticated, "unauthenticated")
+	} else if err != nil {
+		requestsTotal.WithLabelValues("error").Inc()
+		zlog.Errorf("认证gRPC请求失败: %v", err)
+		return nil, status.Error(codes.Unavailable, "authentication is unavailable")
+	}
+	return user, nil
+}
+
+func (a *Auth) authorizeGrpc(user *User, req interface{}) error {
+	r, ok := req.(eventsRequest)
+	if !ok || !a.conf.SubjectAccessReview {
+		requestsTotal.WithLabelValues("allowed").Inc()
+		return nil
+	}
+	allowed, err := a.canListEvents(user, r.GetCluster(), r.GetNamespace())
+	if err != nil {
+		requestsTotal.WithLabelValues("error").Inc()
+		zlog.Errorf("用户%s的SubjectAccessReview失败: %v", user.Name, err)
+		return status.Error(codes.Unavailable, "authorization is unavailable")
+	}
+	if !allowed {
+		requestsTotal.WithLabelValues("forbidden").Inc()
+		return status.Error(codes.PermissionDenied, forbiddenMessage(user, r.GetCluster(), r.GetNamespace()))
+	}
+	requestsTotal.WithLabelValues("allowed").Inc()
+	return nil
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/gok8s/k8swatch/pkg/api/pb"
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/utils"
+	"github.com/gok8s/k8swatch/utils/testcert"
+)
+
+// eventService 返回一个空的事件列表，WatchEvents发送一个事件后结束
+type eventService struct {
+	pb.UnimplementedEventServiceServer
+}
+
+func (eventService) ListEvents(context.Context, *pb.ListEventsRequest) (*pb.ListEventsResponse, error) {
+	return &pb.ListEventsResponse{}, nil
+}
+
+func (eventService) WatchEvents(req *pb.WatchEventsRequest, srv pb.EventService_WatchEventsServer) error {
+	return srv.Send(&pb.WatchEventsResponse{Id: 1})
+}
+
+func serveGrpc(t *testing.T, a *Auth, serverOpt grpc.ServerOption, dialOpt grpc.DialOption) (pb.EventServiceClient, func()) {
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(a.UnaryInterceptor()), grpc.StreamInterceptor(a.StreamInterceptor())}
+	if serverOpt != nil {
+		opts = append(opts, serverOpt)
+	}
+	server := grpc.NewServer(opts...)
+	pb.RegisterEventServiceServer(server, eventService{})
+	lis := bufconn.Listen(1 << 20)
+	go server.Serve(lis)
+	conn, err := grpc.Dial("bufnet", dialOpt, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pb.NewEventServiceClient(conn), func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+// watch 返回WatchEvents第一个响应的错误
+func watch(ctx context.Context, client pb.EventServiceClient, req *pb.WatchEventsRequest) error {
+	stream, err := client.WatchEvents(ctx, req)
+	if err != nil {
+		return err
+	}
+	_, err = stream.Recv()
+	return err
+}
+
+func TestGrpcInterceptors(t *testing.T) {
+	prod, _ := fakeClient("shop")
+	staging, _ := fakeClient("shop", "dev")
+	a, err := New(config.AuthConf{
+		Tokens:              []config.TokenConf{{Token: "admin-token", User: "admin"}},
+		TokenReview:         true,
+		SubjectAccessReview: true,
+	}, []*utils.Cluster{{Name: "prod", KubeClient: prod}, {Name: "staging", KubeClient: staging}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, stop := serveGrpc(t, a, nil, grpc.WithInsecure())
+	defer stop()
+
+	for _, c := range []struct {
+		token, cluster, namespace string
+		want                      codes.Code
+	}{
+		{"", "", "shop", codes.Unauthenticated},
+		{"wrong-token", "", "shop", codes.Unauthenticated},
+		{"sa-token", "", "shop", codes.OK},
+		{"sa-token", "", "dev", codes.PermissionDenied},
+		{"sa-token", "staging", "dev", codes.OK},
+		{"sa-token", "prod", "", codes.PermissionDenied},
+		{"admin-token", "", "", codes.OK},
+	} {
+		ctx := context.Background()
+		if c.token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+c.token)
+		}
+		_, err := client.ListEvents(ctx, &pb.ListEventsRequest{Cluster: c.cluster, Namespace: c.namespace})
+		if got := status.Code(err); got != c.want {
+			t.Errorf("ListEvents %s/%s with %q: got %v, want %v", c.cluster, c.namespace, c.token, got, c.want)
+		}
+		err = watch(ctx, client, &pb.WatchEventsRequest{Cluster: c.cluster, Namespace: c.namespace})
+		if got := status.Code(err); got != c.want {
+			t.Errorf("WatchEvents %s/%s with %q: got %v, want %v", c.cluster, c.namespace, c.token, got, c.want)
+		}
+	}
+}
+
+func TestGrpcClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8swatch-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca, caKey := testcert.Write(t, dir, "ca", nil, nil, nil)
+	testcert.Write(t, dir, "localhost", nil, ca, caKey)
+	testcert.Write(t, dir, "system:serviceaccount:shop:reader", nil, ca, caKey)
+
+	prod, _ := fakeClient("shop")
+	a, err := New(config.AuthConf{SubjectAccessReview: true, TLS: config.TLSConf{Enable: true, CAFile: filepath.Join(dir, "ca.crt")}},
+		[]*utils.Cluster{{Name: "prod", KubeClient: prod}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverTLS, err := utils.NewServerTLSConfig(config.TLSConf{Enable: true, CAFile: filepath.Join(dir, "ca.crt"),
+		CertFile: filepath.Join(dir, "localhost.crt"), KeyFile: filepath.Join(dir, "localhost.key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "system:serviceaccount:shop:reader.crt"), filepath.Join(dir, "system:serviceaccount:shop:reader.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientTLS := &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: []tls.Certificate{cert}}
+	client, stop := serveGrpc(t, a, grpc.Creds(credentials.NewTLS(serverTLS)), grpc.WithTransportCredentials(credentials.NewTLS(clientTLS)))
+	defer stop()
+
+	ctx := context.Background()
+	if _, err := client.ListEvents(ctx, &pb.ListEventsRequest{Namespace: "shop"}); err != nil {
+		t.Fatalf("ListEvents with a client certificate: %v", err)
+	}
+	if err := watch(ctx, client, &pb.WatchEventsRequest{Namespace: "kube-system"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("WatchEvents in kube-system: got %v, want PermissionDenied", err)
+	}
+}
@@ -0,0 +1,197 @@
+package cloudevents
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/nats-io/nats.go"
+	"github.com/streadway/amqp"
+)
+
+const (
+	SpecVersion = "1.0"
+	// ModeStructured puts the whole CloudEvent in the message body
+	ModeStructured = "structured"
+	// ModeBinary puts the attributes in headers and the event in the message body
+	ModeBinary = "binary"
+	// StructuredContentType is the content type of structured mode messages
+	StructuredContentType = "application/cloudevents+json"
+	dataContentType       = "application/json"
+	typePrefix            = "io.k8swatch."
+	// defaultSource is used when the event has no cluster
+	defaultSource = "k8swatch"
+	// httpHeaderPrefix, amqpPropertyPrefix and kafkaHeaderPrefix are defined by the protocol bindings,
+	// the NATS binding uses the HTTP prefix
+	httpHeaderPrefix   = "ce-"
+	amqpPropertyPrefix = "cloudEvents:"
+	kafkaHeaderPrefix  = "ce_"
+)
+
+/*
+CloudEvent 是CloudEvents 1.0的JSON格式，data为按当前事件格式(event.SetSchema)编码的事件
+type为io.k8swatch.<kind>.<action>，source为集群名称，subject为namespace/name，id为event.ID
+*/
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// ValidMode reports an error for modes other than structured and binary, empty means structured
+func ValidMode(mode string) error {
+	switch mode {
+	case "", ModeStructured, ModeBinary:
+		return nil
+	}
+	return fmt.Errorf("unknown CloudEvents mode %q, supported: %s, %s", mode, ModeStructured, ModeBinary)
+}
+
+// New maps e to a CloudEvent
+func New(e event.Event) (CloudEvent, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return CloudEvent{}, err
+	}
+	ce := CloudEvent{
+		SpecVersion:     SpecVersion,
+		ID:              e.ID(),
+		Source:          e.Cluster,
+		Type:            typePrefix + strings.ToLower(e.Kind+"."+e.Action),
+		Subject:         e.Name,
+		DataContentType: dataContentType,
+		Data:            data,
+	}
+	if ce.Source == "" {
+		ce.Source = defaultSource
+	}
+	if e.Namespace != "" {
+		ce.Subject = e.Namespace + "/" + e.Name
+	}
+	if t := e.Time(); !t.IsZero() {
+		ce.Time = t.UTC().Format(time.RFC3339)
+	}
+	return ce, nil
+}
+
+// attributes returns the context attributes used as headers in binary mode
+func (ce CloudEvent) attributes() map[string]string {
+	attrs := map[string]string{
+		"specversion": ce.SpecVersion,
+		"id":          ce.ID,
+		"source":      ce.Source,
+		"type":        ce.Type,
+	}
+	if ce.Subject != "" {
+		attrs["subject"] = ce.Subject
+	}
+	if ce.Time != "" {
+		attrs["time"] = ce.Time
+	}
+	return attrs
+}
+
+// NewHTTPRequest encodes e with the HTTP protocol binding
+func NewHTTPRequest(url string, e event.Event, mode string) (*http.Request, error) {
+	ce, err := New(e)
+	if err != nil {
+		return nil, err
+	}
+	body, contentType := []byte(ce.Data), ce.DataContentType
+	if mode != ModeBinary {
+		if body, err = json.Marshal(ce); err != nil {
+			return nil, err
+		}
+		contentType = StructuredContentType
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if mode == ModeBinary {
+		for k, v := range ce.attributes() {
+			req.Header.Set(httpHeaderPrefix+k, v)
+		}
+	}
+	return req, nil
+}
+
+// NewAMQPPublishing encodes e with the AMQP protocol binding
+func NewAMQPPublishing(e event.Event, mode string) (amqp.Publishing, error) {
+	ce, err := New(e)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+	p := amqp.Publishing{MessageId: ce.ID}
+	if mode != ModeBinary {
+		p.ContentType = StructuredContentType
+		p.Body, err = json.Marshal(ce)
+		return p, err
+	}
+	p.ContentType = ce.DataContentType
+	p.Body = ce.Data
+	p.Headers = amqp.Table{}
+	for k, v := range ce.attributes() {
+		p.Headers[amqpPropertyPrefix+k] = v
+	}
+	return p, nil
+}
+
+// NewKafkaMessage encodes e with the Kafka protocol binding, topic and key are left to the caller
+func NewKafkaMessage(e event.Event, mode string) (*sarama.ProducerMessage, error) {
+	ce, err := New(e)
+	if err != nil {
+		return nil, err
+	}
+	if mode != ModeBinary {
+		body, err := json.Marshal(ce)
+		if err != nil {
+			return nil, err
+		}
+		return &sarama.ProducerMessage{
+			Value:   sarama.ByteEncoder(body),
+			Headers: []sarama.RecordHeader{{Key: []byte("content-type"), Value: []byte(StructuredContentType)}},
+		}, nil
+	}
+	msg := &sarama.ProducerMessage{
+		Value:   sarama.ByteEncoder(ce.Data),
+		Headers: []sarama.RecordHeader{{Key: []byte("content-type"), Value: []byte(ce.DataContentType)}},
+	}
+	for k, v := range ce.attributes() {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(kafkaHeaderPrefix + k), Value: []byte(v)})
+	}
+	return msg, nil
+}
+
+// NewNATSMsg encodes e with the NATS protocol binding, binary mode needs nats-server 2.2 or later for headers
+func NewNATSMsg(subject string, e event.Event, mode string) (*nats.Msg, error) {
+	ce, err := New(e)
+	if err != nil {
+		return nil, err
+	}
+	msg := nats.NewMsg(subject)
+	if mode != ModeBinary {
+		if msg.Data, err = json.Marshal(ce); err != nil {
+			return nil, err
+		}
+		msg.Header["content-type"] = []string{StructuredContentType}
+		return msg, nil
+	}
+	msg.Data = ce.Data
+	msg.Header["content-type"] = []string{ce.DataContentType}
+	for k, v := range ce.attributes() {
+		msg.Header[httpHeaderPrefix+k] = []string{v}
+	}
+	return msg, nil
+}
@@ -0,0 +1,97 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/event"
+)
+
+func testEvent() event.Event {
+	return event.Event{
+		Cluster:           "cluster-a",
+		Namespace:         "shop",
+		Kind:              "pods",
+		Name:              "web-x7k2p",
+		Action:            event.CreateEvent,
+		UID:               "6f1d2c1e-8f6a-4c7e-9f4b-1f2e3d4c5b6a",
+		ResourceVersion:   "100",
+		CreationTimestamp: time.Date(2019, 10, 18, 7, 1, 30, 0, time.UTC),
+	}
+}
+
+func TestNew(t *testing.T) {
+	ce, err := New(testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ce.SpecVersion != "1.0" || ce.Type != "io.k8swatch.pods.create" || ce.Source != "cluster-a" ||
+		ce.Subject != "shop/web-x7k2p" || ce.ID != "6f1d2c1e-8f6a-4c7e-9f4b-1f2e3d4c5b6a-100" || ce.Time != "2019-10-18T07:01:30Z" {
+		t.Fatalf("unexpected CloudEvent: %+v", ce)
+	}
+	var data event.Event
+	if err := json.Unmarshal(ce.Data, &data); err != nil || data.Name != "web-x7k2p" {
+		t.Fatalf("data is not the event: %s %v", ce.Data, err)
+	}
+
+	// 已删除的对象没有uid，id由事件字段得出且保持稳定
+	deleted := event.Event{Cluster: "cluster-a", Kind: "nodes", Name: "node-1", Action: event.DeleteEvent}
+	if deleted.ID() != deleted.ID() || deleted.ID() == testEvent().ID() {
+		t.Fatal("id of deleted objects should be deterministic and distinct")
+	}
+	if ce, _ := New(deleted); ce.Subject != "node-1" || ce.Time != "" {
+		t.Fatalf("unexpected CloudEvent for cluster scoped delete: %+v", ce)
+	}
+}
+
+func TestHTTPBinding(t *testing.T) {
+	req, err := NewHTTPRequest("http://example.com/hook", testEvent(), ModeStructured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	var ce CloudEvent
+	if req.Header.Get("Content-Type") != StructuredContentType || json.Unmarshal(body, &ce) != nil || ce.Type != "io.k8swatch.pods.create" {
+		t.Fatalf("unexpected structured request: %v %s", req.Header, body)
+	}
+
+	req, err = NewHTTPRequest("http://example.com/hook", testEvent(), ModeBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(req.Body)
+	if req.Header.Get("Content-Type") != "application/json" || req.Header.Get("ce-specversion") != "1.0" ||
+		req.Header.Get("ce-id") != ce.ID || req.Header.Get("ce-subject") != "shop/web-x7k2p" {
+		t.Fatalf("unexpected binary headers: %v", req.Header)
+	}
+	var data event.Event
+	if err := json.Unmarshal(body, &data); err != nil || data.UID != testEvent().UID {
+		t.Fatalf("binary body is not the event: %s", body)
+	}
+}
+
+func TestAMQPBinding(t *testing.T) {
+	p, err := NewAMQPPublishing(testEvent(), ModeBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ContentType != "application/json" || p.Headers["cloudEvents:type"] != "io.k8swatch.pods.create" ||
+		p.Headers["cloudEvents:source"] != "cluster-a" || p.MessageId != testEvent().ID() {
+		t.Fatalf("unexpected binary publishing: %+v", p)
+	}
+
+	p, err = NewAMQPPublishing(testEvent(), ModeStructured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ce CloudEvent
+	if p.ContentType != StructuredContentType || len(p.Headers) != 0 || json.Unmarshal(p.Body, &ce) != nil || ce.Source != "cluster-a" {
+		t.Fatalf("unexpected structured publishing: %+v", p)
+	}
+
+	if ValidMode("batch") == nil {
+		t.Fatal("unknown mode should be rejected")
+	}
+}
@@ -1,10 +1,16 @@
 package config
 
+import "time"
+
 type Config struct {
 	Handlers  Handlers   `yaml:"handlers"`
 	Resources []Resource `yaml:"resources"`
 	Settings  Settings   `yaml:"settings"`
 	K8s       K8s        `yaml:"k8s"`
+	Trackers  Trackers   `yaml:"trackers"`
+	Audit     AuditConf  `yaml:"audit"`
+	GRPC      GRPCConf   `yaml:"grpc"`
+	Auth      AuthConf   `yaml:"auth"`
 	//ResyncPeriod  time.Duration
 	//SyncRateLimit float64
 }
@@ -18,6 +24,54 @@ type K8s struct {
 	APIServerHost  string
 	KubeConfigFile string
 	ClusterName    string
+	// Metadata 为所有集群的默认静态信息，各集群可单独设置
+	Metadata ClusterMeta `yaml:"metadata"`
+	// Clusters 为空时只监听上面配置的集群
+	Clusters []Cluster `yaml:"clusters"`
+}
+
+// Cluster 是一个被监听的集群，InCluster、KubeConfigSecret、KubeConfigFile按顺序选用其一
+type Cluster struct {
+	Name string `yaml:"name"`
+	// InCluster 使用k8swatch所在集群的serviceaccount
+	InCluster bool `yaml:"inCluster"`
+	// KubeConfigSecret 以namespace/name引用k8swatch所在集群中保存kubeconfig的secret，key为kubeconfig
+	KubeConfigSecret string `yaml:"kubeConfigSecret"`
+	KubeConfigFile   string `yaml:"kubeConfigFile"`
+	// Context kubeconfig中的context，为空时使用current-context
+	Context string `yaml:"context"`
+	// Resources 为空时使用全局的resources
+	Resources []Resource `yaml:"resources"`
+	// Metadata 覆盖k8s.metadata中的同名字段，labels合并
+	Metadata ClusterMeta `yaml:"metadata"`
+}
+
+// ClusterMeta 是集群的静态信息，会写入该集群的每个事件，供所有handler使用
+type ClusterMeta struct {
+	Environment string            `yaml:"environment"`
+	Region      string            `yaml:"region"`
+	Labels      map[string]string `yaml:"labels"`
+}
+
+// Merge returns m with the fields set in override replacing its own, labels are merged
+func (m ClusterMeta) Merge(override ClusterMeta) ClusterMeta {
+	merged := m
+	if override.Environment != "" {
+		merged.Environment = override.Environment
+	}
+	if override.Region != "" {
+		merged.Region = override.Region
+	}
+	if len(override.Labels) > 0 {
+		merged.Labels = make(map[string]string, len(m.Labels)+len(override.Labels))
+		for k, v := range m.Labels {
+			merged.Labels[k] = v
+		}
+		for k, v := range override.Labels {
+			merged.Labels[k] = v
+		}
+	}
+	return merged
 }
 
 type Settings struct {
@@ -27,6 +81,10 @@ type Settings struct {
 	LogFile         string
 	LogStdout       bool
 	Threadiness     int
+	// EventSchema 事件的输出格式，默认v2(RFC3339 UTC时间，带schemaVersion)，v1为兼容之前的格式
+	EventSchema string
+	// QueryBackend /events的查询后端，为elasticsearch或store；为空时启用了store且未启用elasticsearch则为store，否则为elasticsearch
+	QueryBackend string
 }
 
 type Handlers struct {
@@ -35,17 +93,55 @@ type Handlers struct {
 	Alert         AlertConf         `yaml:"alert"`
 	Webhook       Webhook           `yaml:"webhook"`
 	Elasticsearch ElasticsearchConf `yaml:"elasticsearch"`
+	Kafka         KafkaConf         `yaml:"kafka"`
+	NATS          NATSConf          `yaml:"nats"`
+	// OpenSearch 与Elasticsearch配置相同，ILMPolicy为ISM策略的id
+	OpenSearch ElasticsearchConf `yaml:"opensearch"`
+	Loki       LokiConf          `yaml:"loki"`
+	// Prometheus 在/metrics上暴露由事件得出的指标
+	Prometheus PrometheusConf `yaml:"prometheus"`
+	OTLP       OTLPConf       `yaml:"otlp"`
+	// Stdout 将事件以NDJSON输出到标准输出
+	Stdout StdoutConf `yaml:"stdout"`
+	File   FileConf   `yaml:"file"`
+	Syslog SyslogConf `yaml:"syslog"`
+	GELF   GELFConf   `yaml:"gelf"`
+	// Store 内置的事件存储，未启用elasticsearch时作为/events的查询后端
+	Store StoreConf `yaml:"store"`
+	// Stream 通过/api/v2/events/stream以SSE或WebSocket实时推送事件
+	Stream StreamConf `yaml:"stream"`
 }
 
 type ElasticsearchConf struct {
 	Enable  bool
 	Servers []string
-	Index   string
-	//Type   string
+	// Index 可使用%Y、%m、%d、%H按事件时间(UTC)生成按天或按小时的索引，如k8s-events-%Y.%m.%d，查询时使用k8s-events-*
+	Index    string
+	UserName string `yaml:"username"`
+	Password string `yaml:"password"`
+	// 累积FlushSize条文档或经过FlushInterval后批量写入，默认1000条、5s
+	FlushSize     int           `yaml:"flushSize"`
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	// Workers 并发写入的bulk请求数，默认1
+	Workers int `yaml:"workers"`
+	// Template 启动时安装的index template名称，匹配Index的所有索引，为空时不安装
+	Template string `yaml:"template"`
+	// ILMPolicy 不为空时由index template为新索引关联该ILM策略，策略需事先创建
+	ILMPolicy string  `yaml:"ilmPolicy"`
+	TLS       TLSConf `yaml:"tls"`
 }
 
 type Webhook struct {
-	Url string `json:"url"`
+	Enable      bool            `yaml:"enable"`
+	Url         string          `json:"url"`
+	CloudEvents CloudEventsConf `yaml:"cloudEvents"`
+}
+
+// CloudEventsConf 以CloudEvents 1.0格式输出事件
+type CloudEventsConf struct {
+	Enable bool `yaml:"enable"`
+	// Mode 为structured(默认，整个CloudEvent作为消息体)或binary(属性放在header中，消息体为事件)
+	Mode string `yaml:"mode"`
 }
 
 type RabbitMqConf struct {
@@ -54,23 +150,362 @@ type RabbitMqConf struct {
 	TopicName    string
 	ExchangeType string
 	Durable      bool
-	RouteKey     string
-	UserName     string
-	Password     string
-	Vhost        string
+	// RouteKey 为text/template模板，可使用事件的字段，如events.{{.Namespace}}.{{.Reason}}，不含模板时同时声明同名的queue
+	RouteKey    string
+	UserName    string
+	Password    string
+	Vhost       string
+	CloudEvents CloudEventsConf `yaml:"cloudEvents"`
+	// ConfirmTimeout 等待broker确认消息(publisher confirms)的最长时间，默认5s
+	ConfirmTimeout time.Duration `yaml:"confirmTimeout"`
+	// ReconnectWait 连接断开后依次尝试Servers中的各个地址，每轮之间等待的时间，默认5s
+	ReconnectWait time.Duration `yaml:"reconnectWait"`
+	// TLS 启用后使用amqps连接
+	TLS TLSConf `yaml:"tls"`
+}
+
+// KafkaConf 发布到kafka
+type KafkaConf struct {
+	Enable  bool     `yaml:"enable"`
+	Brokers []string `yaml:"brokers"`
+	// Topic 为text/template模板，可使用事件的字段，如k8s-events、k8s-{{.Kind}}、k8s-{{.Namespace}}
+	Topic string `yaml:"topic"`
+	// Key 消息的key，相同key的消息在同一分区内有序：namespace/name(默认)、kind/namespace/name、uid、none
+	Key string `yaml:"key"`
+	// Version kafka版本，默认2.1.0，idempotent要求0.11.0以上
+	Version string `yaml:"version"`
+	// Idempotent 启用幂等producer，重试时不会产生重复消息
+	Idempotent bool `yaml:"idempotent"`
+	// Compression 为none(默认)、gzip、snappy、lz4、zstd
+	Compression string `yaml:"compression"`
+	// 累积BatchSize条消息或经过FlushInterval后发送一批
+	BatchSize     int             `yaml:"batchSize"`
+	FlushInterval time.Duration   `yaml:"flushInterval"`
+	SASL          SASLConf        `yaml:"sasl"`
+	TLS           TLSConf         `yaml:"tls"`
+	CloudEvents   CloudEventsConf `yaml:"cloudEvents"`
+}
+
+// NATSConf 发布到nats，可选使用JetStream
+type NATSConf struct {
+	Enable  bool     `yaml:"enable"`
+	Servers []string `yaml:"servers"`
+	// Subject 为text/template模板，默认k8s.{{.Cluster}}.{{.Kind}}.{{.Namespace}}，字段中的.、*、>及空白替换为_，空字段为_
+	Subject  string `yaml:"subject"`
+	UserName string `yaml:"username"`
+	Password string `yaml:"password"`
+	Token    string `yaml:"token"`
+	// CredsFile NATS 2.0的用户凭证文件(JWT及nkey seed)
+	CredsFile string `yaml:"credsFile"`
+	// ReconnectWait 断开后重连的间隔，MaxReconnects 最大重连次数，0为一直重连
+	ReconnectWait time.Duration `yaml:"reconnectWait"`
+	MaxReconnects int           `yaml:"maxReconnects"`
+	// ReconnectBufSize 重连期间最多缓存的消息字节数，超过后发送失败
+	ReconnectBufSize int             `yaml:"reconnectBufSize"`
+	TLS              TLSConf         `yaml:"tls"`
+	JetStream        JetStreamConf   `yaml:"jetStream"`
+	CloudEvents      CloudEventsConf `yaml:"cloudEvents"`
+}
+
+// JetStreamConf 使用JetStream发布，等待服务端确认，并以事件id作为Nats-Msg-Id去重
+type JetStreamConf struct {
+	Enable bool `yaml:"enable"`
+	// Stream 不为空时启动时检查该stream存在，并要求消息由该stream接收
+	Stream string `yaml:"stream"`
+}
+
+type SASLConf struct {
+	Enable bool `yaml:"enable"`
+	// Mechanism 为PLAIN(默认)、SCRAM-SHA-256、SCRAM-SHA-512
+	Mechanism string `yaml:"mechanism"`
+	UserName  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+// TLSConf 连接handler服务端的TLS配置，CAFile为空时使用系统CA，CertFile和KeyFile用于客户端证书认证
+type TLSConf struct {
+	Enable             bool   `yaml:"enable"`
+	CAFile             string `yaml:"caFile"`
+	CertFile           string `yaml:"certFile"`
+	KeyFile            string `yaml:"keyFile"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+// LokiConf 以Loki push API发送事件，每个事件为一行JSON
+type LokiConf struct {
+	Enable bool `yaml:"enable"`
+	// Url Loki的地址，如http://loki:3100，推送到/loki/api/v1/push
+	Url string `yaml:"url"`
+	// TenantID 多租户时的X-Scope-OrgID
+	TenantID string `yaml:"tenantID"`
+	UserName string `yaml:"username"`
+	Password string `yaml:"password"`
+	// 累积BatchSize个事件或经过FlushInterval后推送一批，默认500个、5s
+	BatchSize     int           `yaml:"batchSize"`
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	// Timeout 每次推送的超时时间，默认10s
+	Timeout time.Duration `yaml:"timeout"`
+	TLS     TLSConf       `yaml:"tls"`
+}
+
+// PrometheusConf 由事件得出的指标，Labels为允许使用的label，MaxSeries限制每个指标的series数量
+type PrometheusConf struct {
+	Enable bool `yaml:"enable"`
+	// Labels 为空时使用cluster、namespace、reason、type、involved_kind、container、node、condition
+	Labels []string `yaml:"labels"`
+	// MaxSeries 每个指标最多的series数量，超出后新的label组合不再记录，默认10000
+	MaxSeries int `yaml:"maxSeries"`
+}
+
+// OTLPConf 以OTLP(gRPC或HTTP/protobuf)将事件作为OpenTelemetry日志发送
+type OTLPConf struct {
+	Enable bool `yaml:"enable"`
+	// Endpoint gRPC时为host:port，如otel-collector:4317；HTTP时为url，如http://otel-collector:4318，发送到/v1/logs及/v1/traces
+	Endpoint string `yaml:"endpoint"`
+	// Protocol 为grpc(默认)或http
+	Protocol string `yaml:"protocol"`
+	// Headers 每个请求附带的header(gRPC时为metadata)，如认证信息
+	Headers map[string]string `yaml:"headers"`
+	// 累积BatchSize条日志或经过FlushInterval后发送一批，默认500条、5s
+	BatchSize     int           `yaml:"batchSize"`
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	// Timeout 每次发送的超时时间，默认10s
+	Timeout time.Duration `yaml:"timeout"`
+	// Traces 为true时将k8swatch处理每个对象的过程(receive、enrich、各handler的dispatch)作为span发送
+	Traces bool `yaml:"traces"`
+	// TLS 未启用时使用明文连接
+	TLS TLSConf `yaml:"tls"`
+}
+
+type StdoutConf struct {
+	Enable bool `yaml:"enable"`
+}
+
+// FileConf 将事件以NDJSON写入本地文件，按大小及时间轮转，供日志采集器读取
+type FileConf struct {
+	Enable bool `yaml:"enable"`
+	// Path 当前写入的文件，轮转后的文件在同一目录，文件名带有轮转时间
+	Path string `yaml:"path"`
+	// MaxSize 单个文件的最大MB数，默认100
+	MaxSize int `yaml:"maxSize"`
+	// RotateInterval 不为0时每隔该时间轮转一次，如24h为每天0点(UTC)轮转
+	RotateInterval time.Duration `yaml:"rotateInterval"`
+	// MaxBackups 保留的轮转文件数，MaxAge 保留的天数，均为0时不删除
+	MaxBackups int `yaml:"maxBackups"`
+	MaxAge     int `yaml:"maxAge"`
+	// Compress 是否用gzip压缩轮转后的文件
+	Compress bool `yaml:"compress"`
+	// LocalTime 轮转文件名使用本地时间，默认UTC
+	LocalTime bool `yaml:"localTime"`
 }
 
+// SyslogConf 以RFC5424格式发送事件，事件的字段放在structured data中
+type SyslogConf struct {
+	Enable bool `yaml:"enable"`
+	// Network 为udp(默认)、tcp或tls，tcp及tls使用octet counting分帧(RFC6587、RFC5425)
+	Network string `yaml:"network"`
+	// Address syslog服务器的host:port，如rsyslog:514
+	Address string `yaml:"address"`
+	// Facility 为kern、user、daemon、local0至local7等，默认local0
+	Facility string `yaml:"facility"`
+	// AppName 默认k8swatch
+	AppName string `yaml:"appName"`
+	// Hostname 为空时使用k8swatch所在的主机名，事件的host在structured data中
+	Hostname string `yaml:"hostname"`
+	// SDID structured data的SD-ID，需为name@<企业编号>的形式，默认k8s@32473
+	SDID string `yaml:"sdID"`
+	// QueueSize 等待发送的事件数，队列满时丢弃事件，默认1000
+	QueueSize int `yaml:"queueSize"`
+	// Timeout 连接及每次写入的超时时间，默认10s
+	Timeout time.Duration `yaml:"timeout"`
+	// TLS 仅用于tls
+	TLS TLSConf `yaml:"tls"`
+}
+
+// GELFConf 以GELF格式发送事件到Graylog，事件的字段作为additional fields
+type GELFConf struct {
+	Enable bool `yaml:"enable"`
+	// Protocol 为udp(默认)、tcp或http
+	Protocol string `yaml:"protocol"`
+	// Address udp、tcp时为host:port，如graylog:12201；http时为url，如http://graylog:12201/gelf
+	Address string `yaml:"address"`
+	// Compression udp及http使用，为gzip(默认)、zlib或none；tcp不支持压缩
+	Compression string `yaml:"compression"`
+	// ChunkSize udp每个chunk的最大字节数，超出时分块发送，默认1420
+	ChunkSize int `yaml:"chunkSize"`
+	// Host GELF的host字段，为空时使用k8swatch所在的主机名
+	Host string `yaml:"host"`
+	// QueueSize 等待发送的事件数，队列满时丢弃事件，默认1000
+	QueueSize int `yaml:"queueSize"`
+	// Timeout 连接及每次发送的超时时间，默认10s
+	Timeout time.Duration `yaml:"timeout"`
+	// TLS 用于tcp及https
+	TLS TLSConf `yaml:"tls"`
+}
+
+// StoreConf 将k8s events保存在本地的bbolt文件中，按时间和大小清理
+type StoreConf struct {
+	Enable bool `yaml:"enable"`
+	// Path 数据文件，默认/var/lib/k8swatch/events.db，应使用持久化的volume
+	Path string `yaml:"path"`
+	// MaxAge 事件保留的时间，默认168h
+	MaxAge time.Duration `yaml:"maxAge"`
+	// MaxSize 事件及索引最多使用的MB数，超出时删除最早的事件，默认1024
+	MaxSize int `yaml:"maxSize"`
+	// 累积BatchSize个事件或经过FlushInterval后写入一次，默认500个、1s
+	BatchSize     int           `yaml:"batchSize"`
+	FlushInterval time.Duration `yaml:"flushInterval"`
+}
+
+// StreamConf 实时推送事件，每个订阅者有各自的缓冲，缓冲满时断开该订阅者
+type StreamConf struct {
+	Enable bool `yaml:"enable"`
+	// History 保留最近的事件数，客户端以Last-Event-ID重连时从中补发，默认1000
+	History int `yaml:"history"`
+	// BufferSize 每个订阅者缓冲的事件数，默认256
+	BufferSize int `yaml:"bufferSize"`
+	// MaxSubscribers 同时订阅的客户端数上限，默认100
+	MaxSubscribers int `yaml:"maxSubscribers"`
+	// PingInterval 没有事件时发送心跳的间隔，默认30s
+	PingInterval time.Duration `yaml:"pingInterval"`
+}
+
+// InfluxdbConf 以line protocol批量写入InfluxDB 1.x(DBName)或2.x(Org、Bucket、Token)
 type InfluxdbConf struct {
-	Enable   bool
-	Server   string
-	UserName string
-	Password string
-	DBName   string
+	Enable bool   `yaml:"enable"`
+	Server string `yaml:"server"`
+	// Version 为1(默认)或2
+	Version int `yaml:"version"`
+	// 1.x使用UserName、Password、DBName及可选的RetentionPolicy
+	UserName        string `yaml:"username"`
+	Password        string `yaml:"password"`
+	DBName          string `yaml:"dbName"`
+	RetentionPolicy string `yaml:"retentionPolicy"`
+	// 2.x使用Org、Bucket及Token
+	Org    string `yaml:"org"`
+	Bucket string `yaml:"bucket"`
+	Token  string `yaml:"token"`
+	// Measurement 默认为k8sevents
+	Measurement string `yaml:"measurement"`
+	// Tags 作为tag写入的事件字段，只能为取值有限的字段，默认为cluster、environment、region、namespace、kind、reason、type
+	Tags []string `yaml:"tags"`
+	// 累积BatchSize个point或经过FlushInterval后写入一批，默认1000个、5s
+	BatchSize     int           `yaml:"batchSize"`
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	// Timeout 每次写入的超时时间，默认10s
+	Timeout time.Duration `yaml:"timeout"`
+	TLS     TLSConf       `yaml:"tls"`
 }
 
 type AlertConf struct {
 	Enable              bool   `yaml:"enable"`
 	EnableAdminAlert    bool   `yaml:"enableAdminAlert"`
 	EnableAppOwnerAlert bool   `yaml:"enableAppOwnerAlert"`
+	EnableBatchAlert    bool   `yaml:"enableBatchAlert"`
 	Server              string `yaml:"server"`
 }
+
+// Trackers 基于informer对资源状态做跟踪，生成比UPDATE更有意义的事件
+type Trackers struct {
+	Node    NodeTrackerConf    `yaml:"node"`
+	Rollout RolloutTrackerConf `yaml:"rollout"`
+	Job     JobTrackerConf     `yaml:"job"`
+	Data    DataTrackerConf    `yaml:"data"`
+	RBAC    RBACTrackerConf    `yaml:"rbac"`
+}
+
+type NodeTrackerConf struct {
+	Enable bool `yaml:"enable"`
+	// HoldDuration 异常状态持续多久才报警
+	HoldDuration time.Duration `yaml:"holdDuration"`
+	// FlapWindow 内状态切换达到FlapThreshold次则视为抖动，抖动期间不再报警
+	FlapWindow    time.Duration `yaml:"flapWindow"`
+	FlapThreshold int           `yaml:"flapThreshold"`
+}
+
+type RolloutTrackerConf struct {
+	Enable bool `yaml:"enable"`
+	// StuckTimeout 发布过程中超过该时长没有进展且存在不可用副本，视为发布卡住
+	StuckTimeout time.Duration `yaml:"stuckTimeout"`
+}
+
+type JobTrackerConf struct {
+	Enable bool `yaml:"enable"`
+	// MissedScheduleGrace CronJob未设置startingDeadlineSeconds时，计划时间过后多久仍未运行视为错过
+	MissedScheduleGrace time.Duration `yaml:"missedScheduleGrace"`
+	// LastRunMaxAge CronJob最后一次运行距今超过该时长则报警，0为不检查，可用annotation k8swatch/last-run-max-age单独设置
+	LastRunMaxAge time.Duration `yaml:"lastRunMaxAge"`
+}
+
+type DataTrackerConf struct {
+	Enable bool `yaml:"enable"`
+	// CertExpiryDays TLS secret中的证书在该天数内过期则报警
+	CertExpiryDays int `yaml:"certExpiryDays"`
+}
+
+type RBACTrackerConf struct {
+	Enable bool `yaml:"enable"`
+	// PrivilegedRoles 绑定到这些ClusterRole视为提权，默认为cluster-admin
+	PrivilegedRoles []string `yaml:"privilegedRoles"`
+}
+
+// GRPCConf 在单独的端口上提供gRPC API，查询后端同HTTP查询，订阅需启用stream handler
+type GRPCConf struct {
+	Enable bool `yaml:"enable"`
+	// Port 默认9090
+	Port int `yaml:"port"`
+	// TLS 启用时certFile、keyFile为服务端证书，caFile不为空时要求并验证客户端证书(mTLS)
+	TLS TLSConf `yaml:"tls"`
+}
+
+/*
+AuthConf HTTP API的认证及授权，启用后除PublicPaths外的请求都需要认证
+依次使用客户端证书、静态token、TokenReview认证；/stop及pprof需要admin角色
+*/
+type AuthConf struct {
+	Enable bool `yaml:"enable"`
+	// Tokens 静态的bearer token
+	Tokens []TokenConf `yaml:"tokens"`
+	// TokenReview 不在Tokens中的bearer token由k8s TokenReview认证
+	TokenReview bool `yaml:"tokenReview"`
+	// Audiences TokenReview的audiences，为空时使用apiserver的默认值
+	Audiences []string `yaml:"audiences"`
+	// TLS 启用时HTTP server使用HTTPS，caFile不为空时以其签发的客户端证书认证，CN为用户名，O为组
+	TLS TLSConf `yaml:"tls"`
+	// SubjectAccessReview 查询事件时在所查询的集群中检查用户能否list该namespace的events，
+	// 未指定namespace时需能list所有namespace的events，未指定cluster时需在所有集群中都有权限
+	SubjectAccessReview bool `yaml:"subjectAccessReview"`
+	// AdminUsers、AdminGroups 可访问/stop及pprof，均为空时为组system:masters
+	AdminUsers  []string `yaml:"adminUsers"`
+	AdminGroups []string `yaml:"adminGroups"`
+	// PublicPaths 不需要认证的路径，以/结尾的为前缀，默认/healthz、/healthz/clusters及由audit.webhookToken认证的/audit、/audit/
+	PublicPaths []string `yaml:"publicPaths"`
+	// Cluster TokenReview使用的集群，默认第一个集群
+	Cluster string `yaml:"cluster"`
+	// CacheTTL TokenReview、SubjectAccessReview结果的缓存时间，默认1m
+	CacheTTL time.Duration `yaml:"cacheTTL"`
+}
+
+// TokenConf 是一个静态token及其用户，Token、TokenFile二选一，TokenFile便于从secret挂载
+type TokenConf struct {
+	Token     string   `yaml:"token"`
+	TokenFile string   `yaml:"tokenFile"`
+	User      string   `yaml:"user"`
+	Groups    []string `yaml:"groups"`
+}
+
+// AuditConf 接收apiserver的audit事件，为资源的CREATE/UPDATE/DELETE事件补充操作者信息
+type AuditConf struct {
+	Enable bool `yaml:"enable"`
+	// Webhook 启用后在HttpPort上提供/audit作为apiserver的audit webhook backend
+	Webhook bool `yaml:"webhook"`
+	// WebhookToken 不为空时webhook请求需携带该bearer token，即apiserver的webhook kubeconfig中user的token；
+	// /audit默认在auth的publicPaths中，启用auth且/audit不需要认证时必须设置
+	WebhookToken string `yaml:"webhookToken"`
+	// LogFile 不为空时tail该audit日志文件(json格式)
+	LogFile string `yaml:"logFile"`
+	// CorrelationWait 未找到对应audit事件时延迟该时长再尝试关联一次，之后无论是否找到都发送事件
+	CorrelationWait time.Duration `yaml:"correlationWait"`
+	// Retention audit事件保留时长，超过后不再用于匹配
+	Retention time.Duration `yaml:"retention"`
+}
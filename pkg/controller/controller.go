@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/gok8s/k8swatch/pkg/audit"
 	"github.com/gok8s/k8swatch/pkg/event"
 	"github.com/gok8s/k8swatch/pkg/handlers"
+	"github.com/gok8s/k8swatch/pkg/trace"
 
 	"github.com/gok8s/k8swatch/utils"
 
@@ -23,11 +25,15 @@ const maxRetries = 5
 var serverStartTime time.Time
 
 type Controller struct {
+	cluster       *utils.Cluster
 	resourceType  string
 	queue         workqueue.RateLimitingInterface
 	informer      cache.SharedIndexInformer
 	config        config.Config
 	eventHandlers []handlers.Handler
+	updateFilters []func(old, new interface{}) bool
+	auditor       *audit.Correlator
+	tracer        trace.Recorder
 }
 
 type CacheMeta struct {
@@ -35,10 +41,13 @@ type CacheMeta struct {
 	Kind            string
 	Action          string
 	ResourceVersion string
+	//删除时对象已不在indexer中，uid由informer传入的对象或tombstone得出
+	UID string
 }
 
-func NewResourceController(eventHandlers []handlers.Handler, informer cache.SharedIndexInformer, config config.Config, resourceType string) *Controller {
+func NewResourceController(eventHandlers []handlers.Handler, informer cache.SharedIndexInformer, config config.Config, cluster *utils.Cluster, resourceType string) *Controller {
 	c := &Controller{
+		cluster:       cluster,
 		resourceType:  resourceType,
 		informer:      informer,
 		config:        config,
@@ -57,6 +66,7 @@ func NewResourceController(eventHandlers []handlers.Handler, informer cache.Shar
 			}
 			cacheMeta.Action = event.CreateEvent
 			cacheMeta.Kind = resourceType
+			cacheMeta.UID = ""
 			c.queue.Add(cacheMeta)
 			zlog.Debugf("AddFunc queue.add item: %+v c.queue.Len():%d", cacheMeta, c.queue.Len())
 		},
@@ -67,6 +77,12 @@ func NewResourceController(eventHandlers []handlers.Handler, informer cache.Shar
 				zlog.Warnf("ResourceVersion not change in UpdateEvent:%s/%s", newEvent.Namespace, newEvent.Name)
 				return
 			}
+			for _, significant := range c.updateFilters {
+				if !significant(old, new) {
+					zlog.Debugf("UpdateEvent:%s/%s 无实质变化，忽略", newEvent.Namespace, newEvent.Name)
+					return
+				}
+			}
 			/*		if diff := cmp.Diff(old, new); diff != "" {
 					zlog.Debugf("%s:%s/%s has been Updated:%s", oldEvent.Kind, oldEvent.Namespace, oldEvent.Name, diff)
 					newEvent.UpdateContent = diff
@@ -80,11 +96,12 @@ func NewResourceController(eventHandlers []handlers.Handler, informer cache.Shar
 			cacheMeta.Action = event.UpdateEvent
 			cacheMeta.Kind = resourceType
 			cacheMeta.ResourceVersion = newEvent.ResourceVersion
+			cacheMeta.UID = ""
 			c.queue.Add(cacheMeta)
 			if resourceType == "nodes" {
 				zlog.Debug("UpdateFunc nodes",
 					zap.String("nodeName", newEvent.Name),
-					zap.String("lastHbTime", newEvent.LastTimestamp),
+					zap.Time("lastHbTime", newEvent.LastTimestamp),
 					zap.String("cacheMeta.Key", cacheMeta.Key))
 			}
 			zlog.Debugf("UpdateFunc queue.add item :%+v c.queue.Len():%d", cacheMeta, c.queue.Len())
@@ -105,6 +122,13 @@ func NewResourceController(eventHandlers []handlers.Handler, informer cache.Shar
 			}
 			cacheMeta.Action = event.DeleteEvent
 			cacheMeta.Kind = resourceType
+			//watch断开期间被删除的对象以tombstone形式传入，其中是最后一次看到的对象
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			objectMeta := utils.GetObjectMetaData(obj)
+			cacheMeta.UID = string(objectMeta.UID)
+			cacheMeta.ResourceVersion = objectMeta.ResourceVersion
 			c.queue.Add(cacheMeta)
 			zlog.Debugf("DeleteFunc queue.add item :%+v c.queue.Len():%d", cacheMeta, c.queue.Len())
 		},
@@ -112,6 +136,62 @@ func NewResourceController(eventHandlers []handlers.Handler, informer cache.Shar
 	return c
 }
 
+// AddUpdateFilter adds a filter which reports whether an update is worth forwarding to handlers,
+// updates rejected by any filter are dropped
+func (c *Controller) AddUpdateFilter(significant func(old, new interface{}) bool) {
+	c.updateFilters = append(c.updateFilters, significant)
+}
+
+// SetAuditor enables filling in who performed CREATE/UPDATE/DELETE actions from apiserver audit events
+func (c *Controller) SetAuditor(auditor *audit.Correlator) {
+	c.auditor = auditor
+}
+
+// SetTracer enables recording how long receiving, enriching and dispatching to each handler take
+func (c *Controller) SetTracer(tracer trace.Recorder) {
+	c.tracer = tracer
+}
+
+// annotate fills in the cluster metadata, and user, userAgent and sourceIPs when audit is enabled,
+// events are created by components and not worth correlating; it reports false when the audit record has not arrived yet
+func (c *Controller) annotate(e *event.Event) bool {
+	e.SetCluster(c.cluster.Name, c.cluster.Meta)
+	if c.auditor != nil && c.resourceType != "events" {
+		return c.auditor.Annotate(c.resourceType, e)
+	}
+	return true
+}
+
+/*
+dispatch 填充事件信息后交给各handler
+audit记录尚未到达时不阻塞worker，在CorrelationWait后再尝试关联一次，之后无论是否找到都发送，此时该事件可能晚于同一对象之后的事件
+*/
+func (c *Controller) dispatch(p *trace.Process, e event.Event, send func(handlers.Handler, event.Event)) {
+	if c.annotate(&e) {
+		c.send(p, e, send)
+		return
+	}
+	time.AfterFunc(c.auditor.Wait(), func() {
+		if !c.auditor.Annotate(c.resourceType, &e) {
+			zlog.Debugf("未找到%s %s/%s对应的audit记录", e.Action, e.Namespace, e.Name)
+		}
+		c.send(p, e, send)
+	})
+}
+
+func (c *Controller) send(p *trace.Process, e event.Event, send func(handlers.Handler, event.Event)) {
+	p.Step("enrich")
+	for _, eventHandler := range c.eventHandlers {
+		send(eventHandler, e)
+		p.Step("dispatch " + trace.HandlerName(eventHandler))
+	}
+	p.Finish(e, "process "+c.resourceType)
+}
+
+func created(h handlers.Handler, e event.Event) { h.ObjectCreated(e) }
+func updated(h handlers.Handler, e event.Event) { h.ObjectUpdated(e) }
+func deleted(h handlers.Handler, e event.Event) { h.ObjectDeleted(e) }
+
 // Run starts the k8swatch controller
 func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) {
 	defer utilruntime.HandleCrash()
@@ -198,6 +278,7 @@ func (c *Controller) handleErr(err error, key interface{}) {
 }
 
 func (c *Controller) process(cacheMeta CacheMeta) error {
+	p := trace.Start(c.tracer)
 	obj, exists, err := c.informer.GetIndexer().GetByKey(cacheMeta.Key)
 	if err != nil {
 		zlog.Errorf("获取对象:%+v失败", cacheMeta.Key, zap.Error(err))
@@ -211,11 +292,12 @@ func (c *Controller) process(cacheMeta CacheMeta) error {
 		tmpEvent.Name = cacheMeta.Key
 		tmpEvent.Kind = cacheMeta.Kind
 		tmpEvent.Action = cacheMeta.Action
+		tmpEvent.UID = cacheMeta.UID
+		tmpEvent.ResourceVersion = cacheMeta.ResourceVersion
 
 		handerObj := event.New(tmpEvent, cacheMeta.Action)
-		for _, eventHandler := range c.eventHandlers {
-			eventHandler.ObjectDeleted(handerObj)
-		}
+		p.Step("receive")
+		c.dispatch(p, handerObj, deleted)
 	} else {
 		switch cacheMeta.Action {
 		case event.CreateEvent:
@@ -227,9 +309,8 @@ func (c *Controller) process(cacheMeta CacheMeta) error {
 			zlog.Debugf(" objectMeta.CreationTimestamp:%s timeDuration:%f", objectMeta.CreationTimestamp, timeDuration)
 			if timeDuration > 0 {
 				handlerObj = event.New(obj, cacheMeta.Action)
-				for _, eventHandler := range c.eventHandlers {
-					eventHandler.ObjectCreated(handlerObj)
-				}
+				p.Step("receive")
+				c.dispatch(p, handlerObj, created)
 			} else {
 				handlerObj = event.New(obj, cacheMeta.Action) //tmp add for test
 				zlog.Debugf("old resource info,ignoring...%+v timeDuration:%f objectMeta.CreationTimestamp:%s  serverStartTime:%s",
@@ -237,10 +318,9 @@ func (c *Controller) process(cacheMeta CacheMeta) error {
 			}
 		case event.UpdateEvent:
 			handerObj := event.New(obj, cacheMeta.Action)
-			zlog.Debug("Process Update nodes", zap.String("nodeName", handerObj.Name), zap.String("lastHbTime", handerObj.LastTimestamp))
-			for _, eventHandler := range c.eventHandlers {
-				eventHandler.ObjectUpdated(handerObj)
-			}
+			p.Step("receive")
+			zlog.Debug("Process Update nodes", zap.String("nodeName", handerObj.Name), zap.Time("lastHbTime", handerObj.LastTimestamp))
+			c.dispatch(p, handerObj, updated)
 
 		case event.DeleteEvent:
 			//理论上!exists触发代表对象DeleteEvent，应该不会进入到此处
@@ -256,6 +336,7 @@ func (c *Controller) process(cacheMeta CacheMeta) error {
 			tmpEvent.Name = cacheMeta.Key
 			tmpEvent.Kind = cacheMeta.Kind
 			tmpEvent.Action = cacheMeta.Action
+			tmpEvent.SetCluster(c.cluster.Name, c.cluster.Meta)
 			for _, eventHandler := range c.eventHandlers {
 				eventHandler.ObjectDeleted(tmpEvent)
 			}
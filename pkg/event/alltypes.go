@@ -12,14 +12,29 @@ var WarnAlertReasonType = map[string]string{
 	"NodeAllocatableEnforced": "NodeAllocatableEnforced", //调整节点的资源配额
 	"ProbeWarning":            "ProbeWarning 健康检查有异常",    //k8s1.14 新增不支持跨主机跳转的healthCheck，会统一认为healthy并发送该警告事件。
 	"UnfinishedPreStopHook":   "容器在销毁前执行用户自定义脚本超过用户设置的TimeOut，容器会被强行删除，请配置正确的Shell脚本",
+	"NodeConditionFlapping":   "Node状态反复切换", //k8swatch nodeTracker
+	"NodeTaintAdded":          "Node新增了污点",  //k8swatch nodeTracker
+	"NodeTaintRemoved":        "Node移除了污点",  //k8swatch nodeTracker
 }
 
 var UserAlertReasonType = map[string]string{
-	"HostPortConflict": "Host节点端口冲突，请配置正确的端口",
-	"BackOff":          "BackOff",
-	"CrashLoopBackOff": "容器启动失败",
-	"Killing":          "容器被删除",
-	"UnhealthKilling":  "容器因健康检查失败被删除", //diy
+	"HostPortConflict":         "Host节点端口冲突，请配置正确的端口",
+	"BackOff":                  "BackOff",
+	"CrashLoopBackOff":         "容器启动失败",
+	"Killing":                  "容器被删除",
+	"UnhealthKilling":          "容器因健康检查失败被删除",                    //diy
+	"ProgressDeadlineExceeded": "发布超过progressDeadlineSeconds仍未完成", //k8swatch rolloutTracker
+	"RolloutStuck":             "发布长时间没有进展且存在不可用副本",               //k8swatch rolloutTracker
+	"CertificateExpiring":      "TLS证书即将过期",                       //k8swatch dataTracker
+	"CertificateExpired":       "TLS证书已过期",                        //k8swatch dataTracker
+}
+
+// BatchAlertReasonType Job/CronJob相关报警，单独发给负责批处理的接收方
+var BatchAlertReasonType = map[string]string{
+	"JobFailed":             "Job执行失败",
+	"JobDeadlineExceeded":   "Job运行超过activeDeadlineSeconds",
+	"CronJobMissedSchedule": "CronJob错过了计划运行时间",
+	"CronJobLastRunTooOld":  "CronJob最后一次运行距今过久",
 }
 var AdminAlertReasonType = map[string]string{
 	"SystemOOM":                    "节点系统发生oom",
@@ -58,6 +73,14 @@ var AdminAlertReasonType = map[string]string{
 	"RemovingNode":                 "节点被执行下线",
 	"FailedMount":                  "FailedMount",
 	"FailedScheduling":             "调度失败",
+	"NodeHasInsufficientPID":       "Node没有足够的可用PID",
+	"NodeNetworkUnavailable":       "Node网络不可用",
+	"RBACRoleCreated":              "RBAC角色创建",
+	"RBACRoleDeleted":              "RBAC角色删除",
+	"RBACRulesChanged":             "RBAC角色权限变更",
+	"RBACSubjectsBound":            "RBAC授权绑定",
+	"RBACSubjectsUnbound":          "RBAC授权解绑",
+	"RBACEscalation":               "RBAC提权操作",
 }
 var NormalReasonType = map[string]string{
 	"NodeSchedulable":         "NodeSchedulable",
@@ -84,10 +107,23 @@ var NormalReasonType = map[string]string{
 	"SandboxChanged":          "SandboxChanged",
 	"FailedCreatePodSandBox":  "FailedCreatePodSandBox",
 	"FailedPodSandBoxStatus":  "FailedPodSandBoxStatus",
+	"NodeHasSufficientPID":    "Node节点有足够的可用PID",
+	"NodeNetworkAvailable":    "Node节点网络恢复",
+	"RolloutStarted":          "开始发布",
+	"RolloutProgressing":      "发布进行中",
+	"RolloutCompleted":        "发布完成",
+	"JobCompleted":            "Job执行完成",
+	"SecretDataChanged":       "Secret的key发生变化",
+	"ConfigMapDataChanged":    "ConfigMap的key发生变化",
 }
 
 var RecoverReasonType = map[string]string{
-	"NodeReady": "NodeReady",
+	"NodeReady":               "NodeReady",
+	"NodeHasSufficientMemory": "NodeHasSufficientMemory",
+	"NodeHasNoDiskPressure":   "NodeHasNoDiskPressure",
+	"NodeHasSufficientPID":    "NodeHasSufficientPID",
+	"NodeNetworkAvailable":    "NodeNetworkAvailable",
+	"NodeSchedulable":         "NodeSchedulable",
 }
 
 //TODO 改为可配置的
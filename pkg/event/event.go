@@ -7,11 +7,14 @@ import (
 	"strings"
 	"time"
 
+	"github.com/gok8s/k8swatch/pkg/config"
 	"github.com/gok8s/k8swatch/utils"
 
 	"github.com/gok8s/k8swatch/utils/zlog"
 
+	apps_v1 "k8s.io/api/apps/v1"
 	batch_v1 "k8s.io/api/batch/v1"
+	batch_v1beta1 "k8s.io/api/batch/v1beta1"
 	api_v1 "k8s.io/api/core/v1"
 	ext_v1beta1 "k8s.io/api/extensions/v1beta1"
 	rbacV1 "k8s.io/api/rbac/v1"
@@ -25,27 +28,37 @@ before being able to be handled by handler
 kind 和cache.NewListWatchFromClient使用的resource要一致
 */
 type Event struct {
-	Namespace               string `json:"namespace"`
-	Kind                    string `json:"kind"`
-	Component               string `json:"component"`
-	Host                    string `json:"host"`
-	Reason                  string `json:"reason"`
-	Status                  string `json:"status"` //暂未用到
-	Name                    string `json:"name"`
-	CreationTimestamp       string `json:"creationTimestamp"`
-	Action                  string `json:"action"`
-	Count                   int32  `json:"count"`
-	Messages                string `json:"short_message"`
-	Type                    string `json:"type"`
-	FirstTimestamp          string `json:"firstTimestamp"`
-	LastTimestamp           string `json:"lastTimestamp"`
-	ServiceName             string `json:"serviceName"`
-	ResourceVersion         string `json:"resourceVersion"`
-	UpdateContent           string
-	InvolvedName            string `json:"involvedName"` //add for event's InvolvedObject
-	InvolvedNamespace       string `json:"involvedNamespace"`
-	InvolvedKind            string `json:"involvedKind"`
-	InvolvedResourceVersion string `json:"involvedResourceVersion"`
+	Cluster                 string            `json:"cluster"`
+	Environment             string            `json:"environment"`
+	Region                  string            `json:"region"`
+	ClusterLabels           map[string]string `json:"clusterLabels,omitempty"`
+	Namespace               string            `json:"namespace"`
+	Kind                    string            `json:"kind"`
+	Component               string            `json:"component"`
+	Host                    string            `json:"host"`
+	Reason                  string            `json:"reason"`
+	Status                  string            `json:"status"` //暂未用到
+	Name                    string            `json:"name"`
+	CreationTimestamp       time.Time         `json:"creationTimestamp"`
+	Action                  string            `json:"action"`
+	Count                   int32             `json:"count"`
+	Messages                string            `json:"short_message"`
+	Type                    string            `json:"type"`
+	FirstTimestamp          time.Time         `json:"firstTimestamp"`
+	LastTimestamp           time.Time         `json:"lastTimestamp"`
+	ServiceName             string            `json:"serviceName"`
+	ResourceVersion         string            `json:"resourceVersion"`
+	UID                     string            `json:"uid"`
+	UpdateContent           string            `json:"updateContent,omitempty"`
+	InvolvedName            string            `json:"involvedName"` //add for event's InvolvedObject
+	InvolvedNamespace       string            `json:"involvedNamespace"`
+	InvolvedKind            string            `json:"involvedKind"`
+	InvolvedResourceVersion string            `json:"involvedResourceVersion"`
+	OwnerKind               string            `json:"ownerKind"` //如job所属的CronJob
+	OwnerName               string            `json:"ownerName"`
+	User                    string            `json:"user"` //来自audit事件的操作者
+	UserAgent               string            `json:"userAgent"`
+	SourceIPs               []string          `json:"sourceIPs"`
 }
 
 const (
@@ -59,6 +72,14 @@ const (
 	//ConfigurationEvent = "CONFIGURATION"
 )
 
+const (
+	// DerivedComponent is the source component of events generated by k8swatch itself
+	DerivedComponent = "k8swatch"
+	// NormalType and WarningType mirror the types of k8s events
+	NormalType  = "Normal"
+	WarningType = "Warning"
+)
+
 var m = map[string]string{
 	"created": "Normal",
 	"deleted": "Danger",
@@ -81,8 +102,9 @@ func New(obj interface{}, action string) Event {
 	kbEvent.Name = objectMeta.Name
 	kbEvent.Action = action
 	kbEvent.ResourceVersion = objectMeta.ResourceVersion
+	kbEvent.UID = string(objectMeta.UID)
 	//zlog.Debugf("objectMeta:%+v", objectMeta)
-	kbEvent.CreationTimestamp = time.Unix(objectMeta.CreationTimestamp.Unix(), 0).Format("2006-01-02 15:04:05")
+	kbEvent.CreationTimestamp = objectMeta.CreationTimestamp.Time
 
 	switch object := obj.(type) {
 	case *ext_v1beta1.DaemonSet:
@@ -96,7 +118,7 @@ func New(obj interface{}, action string) Event {
 		if action == UpdateEvent {
 			condLen := len(object.Status.Conditions)
 			if condLen != 0 {
-				kbEvent.LastTimestamp = time.Unix(object.Status.Conditions[condLen-1].LastTransitionTime.Time.Unix(), 0).Format("2006-01-02 15:04:05")
+				kbEvent.LastTimestamp = object.Status.Conditions[condLen-1].LastTransitionTime.Time
 			}
 		}
 	case *batch_v1.Job:
@@ -104,9 +126,11 @@ func New(obj interface{}, action string) Event {
 		if action == UpdateEvent {
 			condLen := len(object.Status.Conditions)
 			if condLen != 0 {
-				kbEvent.LastTimestamp = time.Unix(object.Status.Conditions[condLen-1].LastTransitionTime.Time.Unix(), 0).Format("2006-01-02 15:04:05")
+				kbEvent.LastTimestamp = object.Status.Conditions[condLen-1].LastTransitionTime.Time
 			}
 		}
+	case *batch_v1beta1.CronJob:
+		kind = "cronjobs"
 	case *api_v1.Namespace:
 		kind = "namespaces"
 	case *ext_v1beta1.Ingress:
@@ -118,7 +142,7 @@ func New(obj interface{}, action string) Event {
 		if action == UpdateEvent {
 			condLen := len(object.Status.Conditions)
 			if condLen != 0 {
-				kbEvent.LastTimestamp = time.Unix(object.Status.Conditions[condLen-1].LastTransitionTime.Time.Unix(), 0).Format("2006-01-02 15:04:05")
+				kbEvent.LastTimestamp = object.Status.Conditions[condLen-1].LastTransitionTime.Time
 			}
 		}
 	case *api_v1.Pod:
@@ -127,7 +151,7 @@ func New(obj interface{}, action string) Event {
 		if action == UpdateEvent {
 			condLen := len(object.Status.Conditions)
 			if condLen != 0 {
-				kbEvent.LastTimestamp = time.Unix(object.Status.Conditions[condLen-1].LastTransitionTime.Time.Unix(), 0).Format("2006-01-02 15:04:05")
+				kbEvent.LastTimestamp = object.Status.Conditions[condLen-1].LastTransitionTime.Time
 			}
 		}
 	case *api_v1.Node:
@@ -136,7 +160,7 @@ func New(obj interface{}, action string) Event {
 		if action == UpdateEvent {
 			condLen := len(object.Status.Conditions)
 			if condLen != 0 {
-				kbEvent.LastTimestamp = time.Unix(object.Status.Conditions[condLen-1].LastHeartbeatTime.Time.Unix(), 0).Format("2006-01-02 15:04:05")
+				kbEvent.LastTimestamp = object.Status.Conditions[condLen-1].LastHeartbeatTime.Time
 			}
 		}
 	case *api_v1.ReplicationController:
@@ -144,7 +168,7 @@ func New(obj interface{}, action string) Event {
 		if action == UpdateEvent {
 			condLen := len(object.Status.Conditions)
 			if condLen != 0 {
-				kbEvent.LastTimestamp = time.Unix(object.Status.Conditions[condLen-1].LastTransitionTime.Time.Unix(), 0).Format("2006-01-02 15:04:05")
+				kbEvent.LastTimestamp = object.Status.Conditions[condLen-1].LastTransitionTime.Time
 			}
 		}
 	case *ext_v1beta1.ReplicaSet:
@@ -152,9 +176,11 @@ func New(obj interface{}, action string) Event {
 		if action == UpdateEvent {
 			condLen := len(object.Status.Conditions)
 			if condLen != 0 {
-				kbEvent.LastTimestamp = time.Unix(object.Status.Conditions[condLen-1].LastTransitionTime.Time.Unix(), 0).Format("2006-01-02 15:04:05")
+				kbEvent.LastTimestamp = object.Status.Conditions[condLen-1].LastTransitionTime.Time
 			}
 		}
+	case *apps_v1.StatefulSet:
+		kind = "statefulsets"
 	case *api_v1.Service:
 		kind = "services"
 		kbEvent.Component = string(object.Spec.Type)
@@ -172,8 +198,8 @@ func New(obj interface{}, action string) Event {
 		kbEvent.InvolvedResourceVersion = object.InvolvedObject.ResourceVersion
 		//zlog.Debugf("object:%+v", object)
 		kbEvent.Type = object.Type
-		kbEvent.FirstTimestamp = time.Unix(object.FirstTimestamp.Unix(), 0).Format("2006-01-02 15:04:05")
-		kbEvent.LastTimestamp = time.Unix(object.LastTimestamp.Unix(), 0).Format("2006-01-02 15:04:05")
+		kbEvent.FirstTimestamp = object.FirstTimestamp.Time
+		kbEvent.LastTimestamp = object.LastTimestamp.Time
 
 		kbEvent.Reason = object.Reason
 		//kbEvent.Kind = object.Kind
@@ -215,6 +241,9 @@ func New(obj interface{}, action string) Event {
 		} else {
 			kbEvent.Name = object.Name
 		}
+		//uid及resourceVersion为删除前最后一次看到的对象的，用于生成ID
+		kbEvent.UID = object.UID
+		kbEvent.ResourceVersion = object.ResourceVersion
 		//kbEvent.Namespace = object.Namespace
 
 	default:
@@ -229,6 +258,40 @@ func New(obj interface{}, action string) Event {
 	return kbEvent
 }
 
+/*
+NewDerived 生成由k8swatch自身分析得出的事件(如node状态持续异常)
+字段与k8s的events保持一致，handler可以像处理events一样处理它
+*/
+func NewDerived(involvedKind, namespace, name, reason, eventType, message string) Event {
+	now := time.Now()
+	return Event{
+		Namespace:         namespace,
+		Kind:              "events",
+		Component:         DerivedComponent,
+		Host:              "Empty",
+		Reason:            reason,
+		Name:              name,
+		CreationTimestamp: now,
+		Action:            CreateEvent,
+		Count:             1,
+		Messages:          message,
+		Type:              eventType,
+		FirstTimestamp:    now,
+		LastTimestamp:     now,
+		InvolvedName:      name,
+		InvolvedNamespace: namespace,
+		InvolvedKind:      involvedKind,
+	}
+}
+
+// SetCluster fills in the identity and static metadata of the cluster the event comes from
+func (e *Event) SetCluster(name string, meta config.ClusterMeta) {
+	e.Cluster = name
+	e.Environment = meta.Environment
+	e.Region = meta.Region
+	e.ClusterLabels = meta.Labels
+}
+
 /*
 Message returns event message in standard format.
 included as a part of event packege to enhance code resuablity across handlers.
@@ -0,0 +1,40 @@
+package event
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+/*
+ID 由uid和resourceVersion得出，同一对象版本重复发送时id不变，handler及消费方可据此去重
+删除事件的resourceVersion可能与最后一次UPDATE相同(如tombstone)，因此加上delete后缀
+k8swatch生成的事件没有uid，使用事件关键字段的哈希
+*/
+func (e Event) ID() string {
+	if e.UID != "" && e.ResourceVersion != "" {
+		if e.Action == DeleteEvent {
+			return e.UID + "-" + e.ResourceVersion + "-delete"
+		}
+		return e.UID + "-" + e.ResourceVersion
+	}
+	h := sha1.New()
+	for _, f := range []string{e.Cluster, e.Kind, e.Namespace, e.Name, e.Action, e.Reason, e.ResourceVersion,
+		e.LastTimestamp.UTC().Format(time.RFC3339), fmt.Sprint(e.Count)} {
+		h.Write([]byte(f))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Time is when the event happened, zero for updates and deletes of objects without conditions
+func (e Event) Time() time.Time {
+	if !e.LastTimestamp.IsZero() {
+		return e.LastTimestamp
+	}
+	if e.Action == CreateEvent {
+		return e.CreationTimestamp
+	}
+	return time.Time{}
+}
@@ -0,0 +1,126 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// SchemaVersion is the version of the wire layout described by event.schema.json
+	SchemaVersion = "v2"
+	// LegacySchema is the layout before versioning: local "2006-01-02 15:04:05" times,
+	// no schemaVersion and UpdateContent without a json tag
+	LegacySchema = "v1"
+	// LegacyTimeLayout is how LegacySchema formats times
+	LegacyTimeLayout = "2006-01-02 15:04:05"
+)
+
+// schema is the wire layout used by MarshalJSON, set once at startup by SetSchema
+var schema = SchemaVersion
+
+// SetSchema selects the wire layout of all handlers, LegacySchema keeps existing consumers working
+func SetSchema(version string) error {
+	switch version {
+	case "", SchemaVersion:
+		schema = SchemaVersion
+	case LegacySchema:
+		schema = LegacySchema
+	default:
+		return fmt.Errorf("unknown event schema %q, supported: %s, %s", version, SchemaVersion, LegacySchema)
+	}
+	return nil
+}
+
+// LegacyTime formats t the way LegacySchema does, zero times are empty
+func LegacyTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(LegacyTimeLayout)
+}
+
+// rfc3339 formats t in UTC, zero times are empty and omitted
+func rfc3339(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// parseTime accepts RFC3339 and LegacyTimeLayout, older documents may contain a no-break space
+func parseTime(s string) (time.Time, error) {
+	s = strings.Replace(s, "\u00A0", " ", -1)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(LegacyTimeLayout, s, time.Local)
+}
+
+// wireEvent has the fields of Event without its methods
+type wireEvent Event
+
+type v2Event struct {
+	wireEvent
+	SchemaVersion     string `json:"schemaVersion"`
+	CreationTimestamp string `json:"creationTimestamp,omitempty"`
+	FirstTimestamp    string `json:"firstTimestamp,omitempty"`
+	LastTimestamp     string `json:"lastTimestamp,omitempty"`
+}
+
+type v1Event struct {
+	wireEvent
+	CreationTimestamp string `json:"creationTimestamp"`
+	FirstTimestamp    string `json:"firstTimestamp"`
+	LastTimestamp     string `json:"lastTimestamp"`
+	UpdateContent     string
+}
+
+// MarshalJSON encodes the event in the layout selected by SetSchema
+func (e Event) MarshalJSON() ([]byte, error) {
+	if schema == LegacySchema {
+		w := v1Event{
+			wireEvent:         wireEvent(e),
+			CreationTimestamp: LegacyTime(e.CreationTimestamp),
+			FirstTimestamp:    LegacyTime(e.FirstTimestamp),
+			LastTimestamp:     LegacyTime(e.LastTimestamp),
+			UpdateContent:     e.UpdateContent,
+		}
+		w.wireEvent.UpdateContent = ""
+		return json.Marshal(w)
+	}
+	return json.Marshal(v2Event{
+		wireEvent:         wireEvent(e),
+		SchemaVersion:     SchemaVersion,
+		CreationTimestamp: rfc3339(e.CreationTimestamp),
+		FirstTimestamp:    rfc3339(e.FirstTimestamp),
+		LastTimestamp:     rfc3339(e.LastTimestamp),
+	})
+}
+
+// UnmarshalJSON decodes both layouts, so events stored before versioning can still be read
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var w v1Event
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	*e = Event(w.wireEvent)
+	if e.UpdateContent == "" {
+		e.UpdateContent = w.UpdateContent
+	}
+	var err error
+	if e.CreationTimestamp, err = parseTime(w.CreationTimestamp); err != nil {
+		return fmt.Errorf("creationTimestamp: %v", err)
+	}
+	if e.FirstTimestamp, err = parseTime(w.FirstTimestamp); err != nil {
+		return fmt.Errorf("firstTimestamp: %v", err)
+	}
+	if e.LastTimestamp, err = parseTime(w.LastTimestamp); err != nil {
+		return fmt.Errorf("lastTimestamp: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package event
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func testEvent() Event {
+	return Event{
+		Cluster:           "cluster-a",
+		Namespace:         "shop",
+		Kind:              "events",
+		Name:              "web.15ceabafa804c94f",
+		Action:            CreateEvent,
+		Reason:            "BackOff",
+		CreationTimestamp: time.Date(2019, 10, 18, 7, 1, 30, 0, time.UTC),
+		FirstTimestamp:    time.Date(2019, 10, 18, 7, 1, 30, 0, time.UTC),
+		LastTimestamp:     time.Date(2019, 10, 18, 7, 5, 0, 0, time.UTC),
+		UpdateContent:     "diff",
+	}
+}
+
+func TestEventSchemaV2(t *testing.T) {
+	b, err := json.Marshal(testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["schemaVersion"] != SchemaVersion || doc["lastTimestamp"] != "2019-10-18T07:05:00Z" || doc["updateContent"] != "diff" {
+		t.Fatalf("unexpected v2 layout: %s", b)
+	}
+
+	// event.schema.json要与Event的字段保持一致
+	raw, err := ioutil.ReadFile("event.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schemaDoc struct {
+		Required   []string               `json:"required"`
+		Properties map[string]interface{} `json:"properties"`
+	}
+	if err := json.Unmarshal(raw, &schemaDoc); err != nil {
+		t.Fatalf("invalid event.schema.json: %v", err)
+	}
+	for k := range doc {
+		if _, ok := schemaDoc.Properties[k]; !ok {
+			t.Errorf("field %q missing in event.schema.json", k)
+		}
+	}
+	for _, k := range schemaDoc.Required {
+		if _, ok := doc[k]; !ok {
+			t.Errorf("required field %q not encoded", k)
+		}
+	}
+
+	var decoded Event
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.LastTimestamp.Equal(testEvent().LastTimestamp) || decoded.UpdateContent != "diff" {
+		t.Fatalf("round trip changed the event: %+v", decoded)
+	}
+}
+
+func TestEventSchemaLegacy(t *testing.T) {
+	if err := SetSchema(LegacySchema); err != nil {
+		t.Fatal(err)
+	}
+	defer SetSchema(SchemaVersion)
+
+	e := testEvent()
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc["schemaVersion"]; ok {
+		t.Fatalf("legacy layout should not have schemaVersion: %s", b)
+	}
+	if doc["lastTimestamp"] != LegacyTime(e.LastTimestamp) || doc["UpdateContent"] != "diff" {
+		t.Fatalf("unexpected legacy layout: %s", b)
+	}
+	if _, ok := doc["updateContent"]; ok {
+		t.Fatalf("legacy layout should only have UpdateContent: %s", b)
+	}
+
+	// 之前存储的文档中可能带有不间断空格
+	var decoded Event
+	old := `{"kind":"events","lastTimestamp":"2019-10-18\u00a015:01:30","firstTimestamp":"","UpdateContent":"diff"}`
+	if err := json.Unmarshal([]byte(old), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2019, 10, 18, 15, 1, 30, 0, time.Local)
+	if !decoded.LastTimestamp.Equal(want) || !decoded.FirstTimestamp.IsZero() || decoded.UpdateContent != "diff" {
+		t.Fatalf("legacy document decoded as %+v", decoded)
+	}
+
+	if err := SetSchema("v3"); err == nil {
+		t.Fatal("unknown schema should be rejected")
+	}
+}
+
+func TestDeleteID(t *testing.T) {
+	deleted := func(uid, rv string) Event {
+		return New(Event{Kind: "pods", Name: "shop/web-0", Action: DeleteEvent, UID: uid, ResourceVersion: rv}, DeleteEvent)
+	}
+	first := deleted("6f1c0a4e-1d2b-4f4e-9a0b-3c5d7e9f1a2b", "100")
+	if first.Namespace != "shop" || first.Name != "web-0" || first.UID == "" || first.ResourceVersion != "100" {
+		t.Fatalf("unexpected delete event: %+v", first)
+	}
+	// 同名对象重建后再次删除
+	if recreated := deleted("0b9e8d7c-6a5f-4e3d-8c2b-1a0f9e8d7c6b", "200"); recreated.ID() == first.ID() {
+		t.Fatalf("deletes of recreated object share id %s", first.ID())
+	}
+	// tombstone中的resourceVersion与最后一次update相同
+	updated := first
+	updated.Action = UpdateEvent
+	if updated.ID() == first.ID() {
+		t.Fatalf("delete shares id with last update %s", first.ID())
+	}
+	if first.ID() != deleted(first.UID, "100").ID() {
+		t.Fatal("id of redelivered delete changed")
+	}
+}
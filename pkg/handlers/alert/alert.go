@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
@@ -30,6 +29,7 @@ import (
 type Alert struct {
 	EnableAdminAlert    bool
 	EnableAppOwnerAlert bool
+	EnableBatchAlert    bool
 	AlertSpeaker        string
 }
 
@@ -38,9 +38,25 @@ type AlertMsg struct {
 	Subject string `json:"subject"`
 }
 
+// MarshalJSON adds the subject to the event, otherwise the promoted Event.MarshalJSON would drop it
+func (m AlertMsg) MarshalJSON() ([]byte, error) {
+	evt, err := json.Marshal(m.Event)
+	if err != nil {
+		return nil, err
+	}
+	subject, err := json.Marshal(m.Subject)
+	if err != nil {
+		return nil, err
+	}
+	msg := append(evt[:len(evt)-1:len(evt)-1], `,"subject":`...)
+	msg = append(msg, subject...)
+	return append(msg, '}'), nil
+}
+
 func (a *Alert) Init(c config.Config) error {
 	a.EnableAdminAlert = c.Handlers.Alert.EnableAdminAlert
 	a.EnableAppOwnerAlert = c.Handlers.Alert.EnableAppOwnerAlert
+	a.EnableBatchAlert = c.Handlers.Alert.EnableBatchAlert
 	a.AlertSpeaker = c.Handlers.Alert.Server
 	return nil
 }
@@ -65,26 +81,22 @@ const (
 	AppOwner = "appowner"
 	Normal   = "normal"
 	Admin    = "admin"
+	Batch    = "batch"
 	Warning  = "warning"
 	Unknown  = "unknown"
 )
 
 func (a *Alert) AlertWorker(msg event.Event) error {
-	parse, e := time.Parse("2006-01-02 15:04:05", msg.LastTimestamp)
-	if e != nil {
-		zlog.Error("alertworker 解析LastTimestamp失败", zap.Error(e))
-	} else if time.Now().Unix()-parse.Unix() > 1800 {
+	if msg.LastTimestamp.IsZero() {
+		zlog.Errorf("alertworker 事件缺少LastTimestamp:%s/%s", msg.Namespace, msg.Name)
+	} else if time.Since(msg.LastTimestamp) > 30*time.Minute {
 		zlog.Infof("事件时间在30分钟以前不做报警，详情为:%v", msg)
 		return nil
 	}
 	var subject string
 	describe, receiverType := ClassifyEvent(msg)
 
-	clusterName := os.Getenv("clusterName")
-	if clusterName == "" {
-		zlog.Error("cannot get env clusterName")
-	}
-	subject = fmt.Sprintf("%s %s %s/%s", clusterName, describe, msg.Namespace, msg.ServiceName)
+	subject = fmt.Sprintf("%s %s %s/%s", msg.Cluster, describe, msg.Namespace, msg.ServiceName)
 
 	//日志记录
 	zlog.Info("事件告警记录 "+msg.Messages,
@@ -99,8 +111,8 @@ func (a *Alert) AlertWorker(msg event.Event) error {
 		zap.Int32("count", msg.Count),
 		zap.String("eventType", msg.Type), //normal,warning
 		zap.String("eventServiceName", msg.ServiceName),
-		zap.String("firstTimestamp", msg.FirstTimestamp),
-		zap.String("lastTimestamp", msg.LastTimestamp),
+		zap.Time("firstTimestamp", msg.FirstTimestamp),
+		zap.Time("lastTimestamp", msg.LastTimestamp),
 		zap.String("eventSourceHost", msg.Host), //因为应用日志是被filebeat收集,会覆盖host字段，因此这里用eventSourceHost来表示
 	)
 
@@ -115,8 +127,13 @@ func (a *Alert) AlertWorker(msg event.Event) error {
 			zlog.Infof("未启用appowner报警，不调用alert-speaker")
 			return nil
 		}
+	} else if receiverType == Batch {
+		if !a.EnableBatchAlert {
+			zlog.Infof("未启用batch报警，不调用alert-speaker")
+			return nil
+		}
 	} else {
-		zlog.Infof("非admin,appowner类别，不做报警,subject为:%s", subject)
+		zlog.Infof("非admin,appowner,batch类别，不做报警,subject为:%s", subject)
 		return nil
 	}
 	status, respBytes, err := callAlertSpeaker(alertMsg, receiverType, a.AlertSpeaker)
@@ -151,9 +168,14 @@ func ClassifyEvent(msg event.Event) (describe, receiverType string) {
 		} else {
 			receiverType = AppOwner
 		}
+	} else if describe, ok = event.BatchAlertReasonType[msg.Reason]; ok {
+		receiverType = Batch
 	} else if describe, ok = event.AdminAlertReasonType[msg.Reason]; ok {
 		receiverType = Admin
 		zlog.Infof("Admin类型报警触发，Reason: %v, Message: %s", msg.Reason, msg.Messages)
+	} else if describe, ok = event.RecoverReasonType[msg.Reason]; ok && msg.Component == event.DerivedComponent {
+		//tracker生成的恢复事件需要通知到之前收到报警的管理员
+		receiverType = Admin
 	} else if describe, ok = event.NormalReasonType[msg.Reason]; ok {
 		receiverType = Normal
 	} else if describe, ok = event.WarnAlertReasonType[msg.Reason]; ok {
@@ -175,6 +197,38 @@ func ClassifyEvent(msg event.Event) (describe, receiverType string) {
 	return describe, receiverType
 }
 
+// syslog(RFC5424)的severity，GELF的level使用相同的取值
+const (
+	SeverityCritical = 2
+	SeverityError    = 3
+	SeverityWarning  = 4
+	SeverityNotice   = 5
+	SeverityInfo     = 6
+)
+
+// Severity 按ClassifyEvent的分级得出事件的severity：admin报警为critical，appowner为error，batch及warning为warning，
+// 恢复事件及未知类别为notice，normal事件及pod、node等对象本身的变化为info
+func Severity(msg event.Event) int {
+	if msg.Kind != "events" {
+		return SeverityInfo
+	}
+	if _, ok := event.RecoverReasonType[msg.Reason]; ok {
+		return SeverityNotice
+	}
+	switch _, receiverType := ClassifyEvent(msg); receiverType {
+	case Admin:
+		return SeverityCritical
+	case AppOwner:
+		return SeverityError
+	case Batch, Warning:
+		return SeverityWarning
+	case Normal:
+		return SeverityInfo
+	default:
+		return SeverityNotice
+	}
+}
+
 func callAlertSpeaker(msg AlertMsg, receiverType, url string) (int, []byte, error) {
 	defer func() {
 		if r := recover(); r != nil {
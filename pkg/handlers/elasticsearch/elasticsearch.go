@@ -2,58 +2,150 @@ package elasticsearch
 
 import (
 	"context"
-	"github.com/olivere/elastic" //current v6
-	"github.com/gok8s/k8swatch/utils"
+	"github.com/olivere/elastic/v7"
+	"net/http"
+	"time"
 
-	//	"github.com/olivere/elastic/v7"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/gok8s/k8swatch/pkg/config"
 	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/utils"
 	"github.com/gok8s/k8swatch/utils/zlog"
 	"go.uber.org/zap"
 	"reflect"
 	"strings"
-	//"gopkg.in/olivere/elastic.v5"
 )
 
+const (
+	defaultFlushSize     = 1000
+	defaultFlushInterval = 5 * time.Second
+)
+
+var documentsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "k8swatch_elasticsearch_documents_total",
+	Help: "Documents written to elasticsearch or opensearch by the bulk processor, by result (success or error).",
+}, []string{"sink", "result"})
+
+func init() {
+	prometheus.MustRegister(documentsTotal)
+}
+
+// Flavor 区分ES及兼容ES 7接口的OpenSearch
+type Flavor struct {
+	// Name 用于日志及指标
+	Name string
+	// PolicySetting 是index template中为索引关联生命周期策略的setting
+	PolicySetting string
+}
+
+var (
+	Elasticsearch = Flavor{Name: "elasticsearch", PolicySetting: "index.lifecycle.name"}
+	OpenSearch    = Flavor{Name: "opensearch", PolicySetting: "plugins.index_state_management.policy_id"}
+)
+
+/*
+ElasticClt 通过BulkProcessor批量写入ES 7/8或OpenSearch，文档id为event.ID，重试不会产生重复文档
+索引按Index中的%Y、%m、%d、%H及事件时间生成
+*/
 type ElasticClt struct {
-	Client *elastic.Client
-	Conf   config.ElasticsearchConf
+	Client    *elastic.Client
+	Conf      config.ElasticsearchConf
+	flavor    Flavor
+	processor *elastic.BulkProcessor
 }
 
-func (e *ElasticClt) Init(c config.Config) (err error) {
-	e.Conf = c.Handlers.Elasticsearch
-	e.Client, err = elastic.NewClient(
+// Connect 只创建client，查询接口使用
+func (e *ElasticClt) Connect(conf config.ElasticsearchConf) (err error) {
+	e.Conf = conf
+	options := []elastic.ClientOptionFunc{
 		elastic.SetURL(e.Conf.Servers...),
 		// Must turn off sniff in docker
 		elastic.SetSniff(false),
-	)
+	}
+	if e.Conf.UserName != "" {
+		options = append(options, elastic.SetBasicAuth(e.Conf.UserName, e.Conf.Password))
+	}
+	if e.Conf.TLS.Enable {
+		tlsConfig, err := utils.NewTLSConfig(e.Conf.TLS)
+		if err != nil {
+			return err
+		}
+		options = append(options, elastic.SetHttpClient(&http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}))
+	}
+	e.Client, err = elastic.NewClient(options...)
 	if err != nil {
 		zlog.Error("es Client init error", zap.Error(err))
 		return err
 	}
+	return nil
+}
 
-	//TODO 通常索引自动创建，此处不需要手动做mapping并确认、创建，
-	/*exists, err := e.Client.IndexExists(e.Conf.Index).Do(context.Background())
-	if err != nil {
-		zlog.Error("es index exists error", zap.Error(err))
+func (e *ElasticClt) Init(c config.Config) (err error) {
+	return e.Start(Elasticsearch, c.Handlers.Elasticsearch)
+}
+
+// Start 连接后安装index template并启动BulkProcessor
+func (e *ElasticClt) Start(flavor Flavor, conf config.ElasticsearchConf) (err error) {
+	e.flavor = flavor
+	if err = e.Connect(conf); err != nil {
 		return err
 	}
-	if !exists {
-		_, err := e.Client.CreateIndex(e.Conf.Index).BodyString().Do(context.Background())
-		if err != nil {
-			panic(err)
+	if e.Conf.Template != "" {
+		if err = e.putTemplate(); err != nil {
+			zlog.Error("安装index template失败", zap.String("sink", e.flavor.Name), zap.String("template", e.Conf.Template), zap.Error(err))
+			return err
 		}
-	}*/
+	}
 
+	flushSize, flushInterval := e.Conf.FlushSize, e.Conf.FlushInterval
+	if flushSize <= 0 {
+		flushSize = defaultFlushSize
+	}
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	workers := e.Conf.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	e.processor, err = e.Client.BulkProcessor().
+		Name("k8swatch-" + e.flavor.Name).
+		Workers(workers).
+		BulkActions(flushSize).
+		FlushInterval(flushInterval).
+		After(e.afterBulk).
+		Do(context.Background())
+	if err != nil {
+		zlog.Error("bulk processor init error", zap.String("sink", e.flavor.Name), zap.Error(err))
+		return err
+	}
 	return nil
 }
 
+// afterBulk 记录bulk请求中写入失败的文档，可重试的错误已由BulkProcessor按backoff重试
+func (e *ElasticClt) afterBulk(executionID int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
+	if err != nil {
+		documentsTotal.WithLabelValues(e.flavor.Name, "error").Add(float64(len(requests)))
+		zlog.Error("bulk写入失败", zap.String("sink", e.flavor.Name), zap.Int("documents", len(requests)), zap.Error(err))
+		return
+	}
+	failed := response.Failed()
+	for _, item := range failed {
+		reason := ""
+		if item.Error != nil {
+			reason = item.Error.Type + ": " + item.Error.Reason
+		}
+		zlog.Error("写入文档失败", zap.String("sink", e.flavor.Name), zap.String("index", item.Index), zap.String("id", item.Id),
+			zap.Int("status", item.Status), zap.String("error", reason))
+	}
+	documentsTotal.WithLabelValues(e.flavor.Name, "error").Add(float64(len(failed)))
+	documentsTotal.WithLabelValues(e.flavor.Name, "success").Add(float64(len(response.Succeeded())))
+	zlog.Debugf("%s bulk写入%d个文档，失败%d个", e.flavor.Name, len(requests), len(failed))
+}
+
 func (e *ElasticClt) ObjectCreated(obj event.Event) {
-	utils.Retry(func() error {
-		err := Save(e.Client, e.Conf.Index, obj)
-		return err
-	}, "Created 写入ES数据", 5, 10)
+	e.add(obj)
 }
 
 func (e *ElasticClt) ObjectDeleted(obj event.Event) {
@@ -61,29 +153,62 @@ func (e *ElasticClt) ObjectDeleted(obj event.Event) {
 }
 
 func (e *ElasticClt) ObjectUpdated(obj event.Event) {
-	utils.Retry(func() error {
-		err := Save(e.Client, e.Conf.Index, obj)
-		return err
-	}, "Updated 写入ES数据", 5, 10)
+	e.add(obj)
 }
 
+func (e *ElasticClt) add(obj event.Event) {
+	e.processor.Add(elastic.NewBulkIndexRequest().
+		Index(IndexName(e.Conf.Index, obj)).
+		Id(obj.ID()).
+		Doc(obj))
+}
+
+// Close flushes the pending documents
+func (e *ElasticClt) Close() {
+	if e.processor != nil {
+		if err := e.processor.Close(); err != nil {
+			zlog.Error("es bulk processor close error", zap.Error(err))
+		}
+	}
+}
+
+// Save 同步写入一个文档，handler使用BulkProcessor批量写入
 func Save(client *elastic.Client, index string, item event.Event) (err error) {
 	indexService, err := client.Index().
-		Index(index).
-		Type("k8s2").
-		//Id("1").
+		Index(IndexName(index, item)).
+		Id(item.ID()).
 		BodyJson(item).
-		Refresh("wait_for").
 		Do(context.Background())
 	if err != nil {
 		zlog.Error("写入es失败", zap.Error(err))
 		return err
 	}
-	zlog.Infof("Indexed with id=%v, type=%s\n", indexService.Id, indexService.Type)
+	zlog.Infof("Indexed with id=%v, index=%s\n", indexService.Id, indexService.Index)
 
 	return nil
 }
 
+// IndexName 按事件时间(UTC)替换pattern中的%Y、%m、%d、%H，时间未知时使用当前时间
+func IndexName(pattern string, item event.Event) string {
+	if !strings.Contains(pattern, "%") {
+		return pattern
+	}
+	t := item.Time()
+	if t.IsZero() {
+		t = time.Now()
+	}
+	t = t.UTC()
+	return strings.NewReplacer("%Y", t.Format("2006"), "%m", t.Format("01"), "%d", t.Format("02"), "%H", t.Format("15")).Replace(pattern)
+}
+
+// SearchIndex 返回匹配pattern生成的所有索引的通配符，如k8s-events-%Y.%m.%d为k8s-events-*
+func SearchIndex(pattern string) string {
+	if i := strings.Index(pattern, "%"); i >= 0 {
+		return pattern[:i] + "*"
+	}
+	return pattern
+}
+
 func (e *ElasticClt) GetEvents(namespace, kind, kindName string) {
 
 }
@@ -119,7 +244,7 @@ func Search(client *elastic.Client, index, InvolvedNamespace, InvolvedKind, Invo
 	boolQ.Filter(rangeQuery).Boost(1.0)*/
 
 	searchResult, err := client.Search().
-		Index(index).
+		Index(SearchIndex(index)).
 		Query(boolQ).
 		//Query(rangeQuery).
 		//Sort("id", true).
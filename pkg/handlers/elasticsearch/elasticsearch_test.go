@@ -1,32 +1,38 @@
 package elasticsearch
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
-	"github.com/olivere/elastic"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+	"github.com/olivere/elastic/v7"
 	"github.com/gok8s/k8swatch/utils/zlog"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/gok8s/k8swatch/pkg/config"
 	"github.com/gok8s/k8swatch/pkg/event"
 )
 
 func TestSaver(t *testing.T) {
 	item1 := event.Event{
-		Namespace:         "cre-abc-def-hig",
-		Kind:              "pod",
-		Component:         "kubelet",
-		Host:              "1",
-		Reason:            "",
-		Status:            "",
-		Name:              "crename-1-haha-2.33",
-		CreationTimestamp: "",
-		Action:            "",
-		Count:             0,
-		Messages:          "",
-		Type:              "",
-		FirstTimestamp:    "",
-		LastTimestamp:     "",
-		ServiceName:       "",
+		Namespace:   "cre-abc-def-hig",
+		Kind:        "pod",
+		Component:   "kubelet",
+		Host:        "1",
+		Reason:      "",
+		Status:      "",
+		Name:        "crename-1-haha-2.33",
+		Action:      "",
+		Count:       0,
+		Messages:    "",
+		Type:        "",
+		ServiceName: "",
 	}
 
 	client, err := elastic.NewClient(
@@ -77,3 +83,111 @@ func TestSearch(t *testing.T) {
 		fmt.Printf("item:%+v\n", item)
 	}
 }
+
+// fakeES 实现bulk写入及index template所需的接口，id为rejected的文档返回400
+type fakeES struct {
+	mu       sync.Mutex
+	template map[string]interface{}
+	// docs 为各索引中的文档id
+	docs     map[string][]string
+	rejected string
+}
+
+func (f *fakeES) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.URL.Path == "/":
+		fmt.Fprint(w, `{"version":{"number":"8.6.0"},"tagline":"You Know, for Search"}`)
+	case r.Method == "PUT" && r.URL.Path == "/_template/k8swatch":
+		json.NewDecoder(r.Body).Decode(&f.template)
+		fmt.Fprint(w, `{"acknowledged":true}`)
+	case r.Method == "POST" && r.URL.Path == "/_bulk":
+		var items []map[string]interface{}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]struct {
+				Index string `json:"_index"`
+				ID    string `json:"_id"`
+				Type  string `json:"_type"`
+			}
+			json.Unmarshal(scanner.Bytes(), &action)
+			scanner.Scan() // document
+			a, ok := action["index"]
+			if !ok || a.Type != "" {
+				http.Error(w, "unexpected bulk action "+scanner.Text(), http.StatusBadRequest)
+				return
+			}
+			status := http.StatusCreated
+			item := map[string]interface{}{"_index": a.Index, "_id": a.ID, "status": status}
+			if a.ID == f.rejected {
+				item["status"] = http.StatusBadRequest
+				item["error"] = map[string]interface{}{"type": "mapper_parsing_exception", "reason": "failed to parse"}
+			} else {
+				f.docs[a.Index] = append(f.docs[a.Index], a.ID)
+			}
+			items = append(items, map[string]interface{}{"index": item})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"took": 1, "errors": f.rejected != "", "items": items})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestBulk(t *testing.T) {
+	es := &fakeES{docs: map[string][]string{}}
+	server := httptest.NewServer(es)
+	defer server.Close()
+
+	c := config.Config{}
+	c.Handlers.Elasticsearch = config.ElasticsearchConf{
+		Servers:       []string{server.URL},
+		Index:         "k8s-events-%Y.%m.%d",
+		FlushSize:     2,
+		FlushInterval: time.Minute,
+		Template:      "k8swatch",
+		ILMPolicy:     "k8s-events-30d",
+	}
+	e := new(ElasticClt)
+	if err := e.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	if es.template["index_patterns"].([]interface{})[0] != "k8s-events-*" ||
+		es.template["settings"].(map[string]interface{})["index.lifecycle.name"] != "k8s-events-30d" {
+		t.Fatalf("unexpected index template: %v", es.template)
+	}
+
+	backOff := event.Event{Kind: "events", Namespace: "shop", Name: "web.15ceabafa804c94f", Action: event.UpdateEvent,
+		UID: "6f1d2c1e", ResourceVersion: "100", LastTimestamp: time.Date(2019, 10, 18, 23, 1, 30, 0, time.UTC)}
+	rejected := backOff
+	rejected.ResourceVersion = "101"
+	es.rejected = rejected.ID()
+	errors := testutil.ToFloat64(documentsTotal.WithLabelValues("elasticsearch", "error"))
+
+	e.ObjectUpdated(backOff)
+	// 重试时id不变，不会产生重复文档
+	e.ObjectUpdated(backOff)
+	e.ObjectUpdated(rejected)
+	e.Close()
+
+	docs := es.docs["k8s-events-2019.10.18"]
+	if len(docs) != 2 || docs[0] != "6f1d2c1e-100" || docs[1] != docs[0] {
+		t.Fatalf("unexpected documents: %v", es.docs)
+	}
+	if got := testutil.ToFloat64(documentsTotal.WithLabelValues("elasticsearch", "error")) - errors; got != 1 {
+		t.Fatalf("%v failed documents reported, want 1", got)
+	}
+}
+
+func TestIndexName(t *testing.T) {
+	e := event.Event{Kind: "pods", Action: event.CreateEvent, CreationTimestamp: time.Date(2019, 10, 18, 7, 1, 30, 0, time.FixedZone("CST", 8*3600))}
+	if got := IndexName("k8s-events-%Y.%m.%d.%H", e); got != "k8s-events-2019.10.17.23" {
+		t.Errorf("IndexName = %s", got)
+	}
+	if got := IndexName("k8swatch", e); got != "k8swatch" {
+		t.Errorf("IndexName without pattern = %s", got)
+	}
+	if got := SearchIndex("k8s-events-%Y.%m.%d"); got != "k8s-events-*" {
+		t.Errorf("SearchIndex = %s", got)
+	}
+}
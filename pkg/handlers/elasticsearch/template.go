@@ -0,0 +1,54 @@
+package elasticsearch
+
+import (
+	"context"
+)
+
+// timeMapping 同时接受v2的RFC3339及v1的"2006-01-02 15:04:05"，v1中未知的时间为空字符串
+var timeMapping = map[string]interface{}{
+	"type":             "date",
+	"format":           "strict_date_optional_time||yyyy-MM-dd HH:mm:ss||epoch_millis",
+	"ignore_malformed": true,
+}
+
+/*
+templateBody 是不带type的legacy index template，ES 7、8及OpenSearch均支持
+字符串默认为keyword，可按namespace、name等精确查询；事件消息及更新内容为text
+*/
+func templateBody(indexPattern, policySetting, policy string) map[string]interface{} {
+	settings := map[string]interface{}{}
+	if policy != "" {
+		settings[policySetting] = policy
+	}
+	return map[string]interface{}{
+		"index_patterns": []string{indexPattern},
+		"settings":       settings,
+		"mappings": map[string]interface{}{
+			"dynamic_templates": []interface{}{
+				map[string]interface{}{
+					"strings_as_keywords": map[string]interface{}{
+						"match_mapping_type": "string",
+						"mapping":            map[string]interface{}{"type": "keyword", "ignore_above": 1024},
+					},
+				},
+			},
+			"properties": map[string]interface{}{
+				"creationTimestamp": timeMapping,
+				"firstTimestamp":    timeMapping,
+				"lastTimestamp":     timeMapping,
+				"count":             map[string]interface{}{"type": "long"},
+				"short_message":     map[string]interface{}{"type": "text"},
+				"updateContent":     map[string]interface{}{"type": "text", "index": false},
+				"UpdateContent":     map[string]interface{}{"type": "text", "index": false},
+			},
+		},
+	}
+}
+
+// putTemplate 安装或更新index template，只对之后新建的索引生效
+func (e *ElasticClt) putTemplate() error {
+	_, err := e.Client.IndexPutTemplate(e.Conf.Template).
+		BodyJson(templateBody(SearchIndex(e.Conf.Index), e.flavor.PolicySetting, e.Conf.ILMPolicy)).
+		Do(context.Background())
+	return err
+}
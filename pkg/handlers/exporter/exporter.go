@@ -0,0 +1,253 @@
+package exporter
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/utils/zlog"
+)
+
+const defaultMaxSeries = 10000
+
+// DefaultLabels 是未配置Labels时使用的label
+var DefaultLabels = []string{"cluster", "namespace", "reason", "type", "involved_kind", "container", "node", "condition"}
+
+// labelValues 是各label的取值方式，name、uid等取值无限的字段不能作为label
+var labelValues = map[string]func(e event.Event) string{
+	"cluster":       func(e event.Event) string { return e.Cluster },
+	"environment":   func(e event.Event) string { return e.Environment },
+	"region":        func(e event.Event) string { return e.Region },
+	"namespace":     func(e event.Event) string { return e.InvolvedNamespace },
+	"reason":        func(e event.Event) string { return e.Reason },
+	"type":          func(e event.Event) string { return e.Type },
+	"involved_kind": func(e event.Event) string { return e.InvolvedKind },
+	"component":     func(e event.Event) string { return e.Component },
+	"container":     func(e event.Event) string { return e.ServiceName },
+	"node":          func(e event.Event) string { return e.InvolvedName },
+	"condition":     func(e event.Event) string { return nodeReasons[e.Reason].condition },
+}
+
+// nodeCondition 是node事件reason对应的condition及是否异常，来自kubelet及node tracker
+type nodeCondition struct {
+	condition string
+	bad       bool
+}
+
+var nodeReasons = map[string]nodeCondition{
+	"NodeNotReady":              {"Ready", true},
+	"NodeReady":                 {"Ready", false},
+	"NodeHasInsufficientMemory": {"MemoryPressure", true},
+	"NodeHasSufficientMemory":   {"MemoryPressure", false},
+	"NodeHasDiskPressure":       {"DiskPressure", true},
+	"NodeHasNoDiskPressure":     {"DiskPressure", false},
+	"NodeHasInsufficientPID":    {"PIDPressure", true},
+	"NodeHasSufficientPID":      {"PIDPressure", false},
+	"NodeNetworkUnavailable":    {"NetworkUnavailable", true},
+	"NodeNetworkAvailable":      {"NetworkUnavailable", false},
+	"NodeNotSchedulable":        {"Unschedulable", true},
+	"NodeSchedulable":           {"Unschedulable", false},
+}
+
+// metric 是一个指标及其已有的series，series数量达到上限后不再增加
+type metric struct {
+	name   string
+	labels []string
+	series map[string][]string
+	// full 在第一次达到上限时设置，只提示一次
+	full bool
+}
+
+func newMetric(name string, candidates, allowed []string) *metric {
+	m := &metric{name: name, series: map[string][]string{}}
+	for _, l := range candidates {
+		for _, a := range allowed {
+			if l == a {
+				m.labels = append(m.labels, l)
+			}
+		}
+	}
+	return m
+}
+
+func (m *metric) values(e event.Event) []string {
+	values := make([]string, len(m.labels))
+	for i, l := range m.labels {
+		values[i] = labelValues[l](e)
+	}
+	return values
+}
+
+/*
+Exporter 将事件转换为prometheus指标，由/metrics暴露
+k8swatch_events_total为k8s events及tracker事件的次数，events对象每次新建或更新(count增加)计一次
+k8swatch_pod_restarts_total为由容器再次Started的事件得出的重启次数
+k8swatch_node_condition为node的condition是否异常(1异常，0正常)，来自kubelet及node tracker的事件
+启动前已存在的事件不计入，避免重启时计数跳变
+*/
+type Exporter struct {
+	conf  config.PrometheusConf
+	start time.Time
+
+	mu           sync.Mutex
+	events       *metric
+	restarts     *metric
+	nodes        *metric
+	eventsTotal  *prometheus.CounterVec
+	restartTotal *prometheus.CounterVec
+	nodeGauge    *prometheus.GaugeVec
+	dropped      *prometheus.CounterVec
+}
+
+func (x *Exporter) Init(c config.Config) error {
+	return x.init(c.Handlers.Prometheus, prometheus.DefaultRegisterer)
+}
+
+func (x *Exporter) init(conf config.PrometheusConf, reg prometheus.Registerer) error {
+	x.conf = conf
+	x.start = time.Now()
+	if x.conf.MaxSeries <= 0 {
+		x.conf.MaxSeries = defaultMaxSeries
+	}
+	labels := x.conf.Labels
+	if len(labels) == 0 {
+		labels = DefaultLabels
+	}
+	for _, l := range labels {
+		if _, ok := labelValues[l]; !ok {
+			return fmt.Errorf("prometheus label %q is not allowed, labels must be one of cluster, environment, region, namespace, reason, type, involved_kind, component, container, node, condition", l)
+		}
+	}
+
+	x.events = newMetric("k8swatch_events_total",
+		[]string{"cluster", "environment", "region", "namespace", "reason", "type", "involved_kind", "component"}, labels)
+	x.restarts = newMetric("k8swatch_pod_restarts_total",
+		[]string{"cluster", "environment", "region", "namespace", "container"}, labels)
+	x.nodes = newMetric("k8swatch_node_condition",
+		[]string{"cluster", "environment", "region", "node", "condition"}, labels)
+	if !hasLabels(x.nodes, "node", "condition") {
+		return fmt.Errorf("prometheus labels must include node and condition")
+	}
+
+	x.eventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: x.events.name,
+		Help: "Kubernetes events observed since k8swatch started, including the events derived by trackers.",
+	}, x.events.labels)
+	x.restartTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: x.restarts.name,
+		Help: "Container restarts observed from the Started events of pods.",
+	}, x.restarts.labels)
+	x.nodeGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: x.nodes.name,
+		Help: "Whether a node condition is unhealthy (1) or healthy (0), as reported by node events.",
+	}, x.nodes.labels)
+	x.dropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8swatch_exporter_series_dropped_total",
+		Help: "Observations not recorded because the metric reached maxSeries.",
+	}, []string{"metric"})
+	for _, c := range []prometheus.Collector{x.eventsTotal, x.restartTotal, x.nodeGauge, x.dropped} {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasLabels(m *metric, labels ...string) bool {
+	for _, l := range labels {
+		found := false
+		for _, ml := range m.labels {
+			found = found || ml == l
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (x *Exporter) ObjectCreated(obj event.Event) {
+	x.observe(obj)
+}
+
+func (x *Exporter) ObjectUpdated(obj event.Event) {
+	x.observe(obj)
+}
+
+// ObjectDeleted 删除已不存在的node的series
+func (x *Exporter) ObjectDeleted(obj event.Event) {
+	if obj.Kind != "nodes" {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	i := indexOf(x.nodes.labels, "node")
+	for key, values := range x.nodes.series {
+		if values[i] == obj.Name {
+			x.nodeGauge.DeleteLabelValues(values...)
+			delete(x.nodes.series, key)
+		}
+	}
+}
+
+func indexOf(labels []string, label string) int {
+	for i, l := range labels {
+		if l == label {
+			return i
+		}
+	}
+	return -1
+}
+
+func (x *Exporter) observe(e event.Event) {
+	if e.Kind != "events" {
+		return
+	}
+	if !e.LastTimestamp.IsZero() && e.LastTimestamp.Before(x.start.Truncate(time.Second)) {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if values, ok := x.track(x.events, e); ok {
+		x.eventsTotal.WithLabelValues(values...).Inc()
+	}
+	// 同一容器的Started事件在重启时count增加，count为1时是第一次启动
+	if e.InvolvedKind == "Pod" && e.Reason == "Started" && e.Count > 1 {
+		if values, ok := x.track(x.restarts, e); ok {
+			x.restartTotal.WithLabelValues(values...).Inc()
+		}
+	}
+	if nc, ok := nodeReasons[e.Reason]; ok && e.InvolvedKind == "Node" {
+		if values, ok := x.track(x.nodes, e); ok {
+			v := 0.0
+			if nc.bad {
+				v = 1
+			}
+			x.nodeGauge.WithLabelValues(values...).Set(v)
+		}
+	}
+}
+
+// track 返回e在m中的label取值，m的series数量已达上限且e属于新的series时返回false
+func (x *Exporter) track(m *metric, e event.Event) ([]string, bool) {
+	values := m.values(e)
+	key := strings.Join(values, "\xff")
+	if _, ok := m.series[key]; ok {
+		return values, true
+	}
+	if len(m.series) >= x.conf.MaxSeries {
+		x.dropped.WithLabelValues(m.name).Inc()
+		if !m.full {
+			m.full = true
+			zlog.Warnf("指标%s的series数量已达上限%d，新的label组合不再记录", m.name, x.conf.MaxSeries)
+		}
+		return nil, false
+	}
+	m.series[key] = values
+	return values, true
+}
@@ -0,0 +1,109 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+)
+
+func newExporter(t *testing.T, conf config.PrometheusConf) *Exporter {
+	x := new(Exporter)
+	if err := x.init(conf, prometheus.NewRegistry()); err != nil {
+		t.Fatal(err)
+	}
+	return x
+}
+
+// count 返回c中的series数量
+func count(c prometheus.Collector) int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	n := 0
+	for range ch {
+		n++
+	}
+	return n
+}
+
+func podEvent(reason string, n int32) event.Event {
+	return event.Event{Cluster: "prod", Kind: "events", Namespace: "shop", Name: "web-0.15ceabafa804c94f", Reason: reason,
+		Type: "Normal", Count: n, InvolvedKind: "Pod", InvolvedNamespace: "shop", InvolvedName: "web-0",
+		ServiceName: "web", Component: "kubelet", LastTimestamp: time.Now()}
+}
+
+func TestEvents(t *testing.T) {
+	x := newExporter(t, config.PrometheusConf{})
+	started := podEvent("Started", 1)
+	x.ObjectCreated(started)
+	started.Count = 2
+	x.ObjectUpdated(started)
+	started.Count = 3
+	x.ObjectUpdated(started)
+	// 启动前的事件不计入
+	old := podEvent("BackOff", 5)
+	old.LastTimestamp = time.Now().Add(-time.Hour)
+	x.ObjectUpdated(old)
+	// 非events对象不计入
+	x.ObjectCreated(event.Event{Kind: "pods", Namespace: "shop", Name: "web-0"})
+
+	if got := testutil.ToFloat64(x.eventsTotal.WithLabelValues("prod", "shop", "Started", "Normal", "Pod")); got != 3 {
+		t.Errorf("k8swatch_events_total = %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(x.restartTotal.WithLabelValues("prod", "shop", "web")); got != 2 {
+		t.Errorf("k8swatch_pod_restarts_total = %v, want 2", got)
+	}
+	if n := count(x.eventsTotal); n != 1 {
+		t.Errorf("got %d series of k8swatch_events_total, want 1", n)
+	}
+}
+
+func TestNodeCondition(t *testing.T) {
+	x := newExporter(t, config.PrometheusConf{})
+	notReady := event.NewDerived("Node", "", "node-1", "NodeNotReady", event.WarningType, "Node node-1 is NotReady")
+	notReady.Cluster = "prod"
+	x.ObjectCreated(notReady)
+	gauge := x.nodeGauge.WithLabelValues("prod", "node-1", "Ready")
+	if got := testutil.ToFloat64(gauge); got != 1 {
+		t.Errorf("k8swatch_node_condition = %v after NodeNotReady, want 1", got)
+	}
+	ready := notReady
+	ready.Reason = "NodeReady"
+	x.ObjectCreated(ready)
+	if got := testutil.ToFloat64(gauge); got != 0 {
+		t.Errorf("k8swatch_node_condition = %v after NodeReady, want 0", got)
+	}
+	x.ObjectDeleted(event.Event{Kind: "nodes", Name: "node-1"})
+	if n := count(x.nodeGauge); n != 0 {
+		t.Errorf("got %d series after the node was deleted, want 0", n)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	x := newExporter(t, config.PrometheusConf{Labels: []string{"namespace", "reason", "node", "condition"}, MaxSeries: 2})
+	for _, reason := range []string{"BackOff", "Pulled", "Killing", "Killing"} {
+		x.ObjectCreated(podEvent(reason, 1))
+	}
+	if n := count(x.eventsTotal); n != 2 {
+		t.Errorf("got %d series, want 2", n)
+	}
+	if got := testutil.ToFloat64(x.dropped.WithLabelValues("k8swatch_events_total")); got != 2 {
+		t.Errorf("k8swatch_exporter_series_dropped_total = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(x.eventsTotal.WithLabelValues("shop", "BackOff")); got != 1 {
+		t.Errorf("k8swatch_events_total{namespace=shop,reason=BackOff} = %v", got)
+	}
+
+	for _, labels := range [][]string{{"namespace", "name"}, {"namespace", "reason"}} {
+		if err := new(Exporter).init(config.PrometheusConf{Labels: labels}, prometheus.NewRegistry()); err == nil {
+			t.Errorf("labels %v accepted", labels)
+		}
+	}
+}
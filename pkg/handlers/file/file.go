@@ -0,0 +1,135 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/utils/zlog"
+)
+
+const defaultMaxSize = 100
+
+/*
+File 将每个事件作为一行JSON(NDJSON)写入本地文件，适用于由日志采集器收集文件的隔离环境
+文件超过MaxSize或每经过RotateInterval时轮转，轮转后的文件按MaxBackups、MaxAge清理，可gzip压缩
+*/
+type File struct {
+	conf config.FileConf
+
+	mu     sync.Mutex
+	logger *lumberjack.Logger
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func (f *File) Init(c config.Config) error {
+	f.conf = c.Handlers.File
+	if f.conf.Path == "" {
+		return fmt.Errorf("file path is empty")
+	}
+	if f.conf.MaxSize <= 0 {
+		f.conf.MaxSize = defaultMaxSize
+	}
+	if err := os.MkdirAll(filepath.Dir(f.conf.Path), 0755); err != nil {
+		return err
+	}
+	f.logger = &lumberjack.Logger{
+		Filename:   f.conf.Path,
+		MaxSize:    f.conf.MaxSize,
+		MaxBackups: f.conf.MaxBackups,
+		MaxAge:     f.conf.MaxAge,
+		Compress:   f.conf.Compress,
+		LocalTime:  f.conf.LocalTime,
+	}
+	f.stopCh = make(chan struct{})
+	if f.conf.RotateInterval > 0 {
+		f.wg.Add(1)
+		go f.rotateEvery(f.conf.RotateInterval, f.stopCh)
+	}
+	return nil
+}
+
+func (f *File) ObjectCreated(obj event.Event) {
+	f.write(obj)
+}
+
+func (f *File) ObjectUpdated(obj event.Event) {
+	f.write(obj)
+}
+
+func (f *File) ObjectDeleted(obj event.Event) {
+	f.write(obj)
+}
+
+func (f *File) write(obj event.Event) {
+	line, err := json.Marshal(obj)
+	if err != nil {
+		zlog.Error("将KBEvent解析为json失败", zap.Error(err))
+		return
+	}
+	line = append(line, '\n')
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.logger == nil {
+		zlog.Error("file handler已关闭，丢弃事件", zap.String("name", obj.Name))
+		return
+	}
+	if _, err := f.logger.Write(line); err != nil {
+		zlog.Error("写入事件文件失败", zap.String("path", f.conf.Path), zap.Error(err))
+	}
+}
+
+// rotateEvery 在interval的整数倍时刻轮转，如1h为每个整点
+func (f *File) rotateEvery(interval time.Duration, stopCh <-chan struct{}) {
+	defer f.wg.Done()
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(interval).Add(interval).Sub(now))
+		select {
+		case <-timer.C:
+			f.rotate()
+		case <-stopCh:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (f *File) rotate() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.logger == nil {
+		return
+	}
+	if err := f.logger.Rotate(); err != nil {
+		zlog.Error("轮转事件文件失败", zap.String("path", f.conf.Path), zap.Error(err))
+	}
+}
+
+// Close stops the rotation and closes the file, it can be called more than once
+func (f *File) Close() {
+	f.mu.Lock()
+	stopCh := f.stopCh
+	f.stopCh = nil
+	f.mu.Unlock()
+	if stopCh == nil {
+		return
+	}
+	close(stopCh)
+	f.wg.Wait()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.logger.Close(); err != nil {
+		zlog.Error("关闭事件文件失败", zap.String("path", f.conf.Path), zap.Error(err))
+	}
+	f.logger = nil
+}
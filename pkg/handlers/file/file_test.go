@@ -0,0 +1,95 @@
+package file
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+)
+
+func newFile(t *testing.T, conf config.FileConf) *File {
+	c := config.Config{}
+	c.Handlers.File = conf
+	f := new(File)
+	if err := f.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func readEvents(t *testing.T, path string) (events []event.Event) {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e event.Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid line %s: %v", scanner.Text(), err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8swatch-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events", "k8swatch.ndjson")
+	f := newFile(t, config.FileConf{Path: path})
+	f.ObjectCreated(event.Event{Kind: "pods", Namespace: "shop", Name: "web-0", Action: event.CreateEvent})
+	f.ObjectDeleted(event.Event{Kind: "pods", Namespace: "shop", Name: "web-0", Action: event.DeleteEvent})
+	f.Close()
+	// 关闭后的事件被丢弃
+	f.ObjectUpdated(event.Event{Kind: "pods", Namespace: "shop", Name: "web-0", Action: event.UpdateEvent})
+	// 可重复关闭
+	f.Close()
+
+	events := readEvents(t, path)
+	if len(events) != 2 || events[0].Name != "web-0" || events[1].Action != event.DeleteEvent {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8swatch-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "k8swatch.ndjson")
+	f := newFile(t, config.FileConf{Path: path, RotateInterval: 200 * time.Millisecond, Compress: true})
+	defer f.Close()
+
+	f.ObjectCreated(event.Event{Kind: "pods", Name: "web-0"})
+	// 等待轮转及后台的压缩
+	deadline := time.Now().Add(5 * time.Second)
+	var backups []string
+	for time.Now().Before(deadline) {
+		backups, _ = filepath.Glob(filepath.Join(dir, "k8swatch-*.ndjson.gz"))
+		if len(backups) > 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(backups) == 0 {
+		files, _ := ioutil.ReadDir(dir)
+		t.Fatalf("no compressed backup in %v", files)
+	}
+	f.ObjectCreated(event.Event{Kind: "pods", Name: "web-1"})
+	if events := readEvents(t, path); len(events) != 1 || !strings.HasPrefix(events[0].Name, "web-1") {
+		t.Fatalf("unexpected events after rotation: %+v", events)
+	}
+}
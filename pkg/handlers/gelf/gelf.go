@@ -0,0 +1,398 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/pkg/handlers/alert"
+	"github.com/gok8s/k8swatch/utils"
+	"github.com/gok8s/k8swatch/utils/zlog"
+)
+
+const (
+	ProtocolUDP  = "udp"
+	ProtocolTCP  = "tcp"
+	ProtocolHTTP = "http"
+
+	CompressionGzip = "gzip"
+	CompressionZlib = "zlib"
+	CompressionNone = "none"
+
+	defaultChunkSize = 1420
+	defaultQueueSize = 1000
+	defaultTimeout   = 10 * time.Second
+
+	// chunk的header为magic(2字节)、message id(8字节)、序号及总数(各1字节)
+	chunkHeaderSize = 12
+	maxChunks       = 128
+)
+
+var chunkMagic = []byte{0x1e, 0x0f}
+
+// invalidField 替换additional field名中GELF不允许的字符
+var invalidField = regexp.MustCompile(`[^\w.\-]`)
+
+var messagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "k8swatch_gelf_messages_total",
+	Help: "Events sent to graylog as GELF, by result (success, error or dropped).",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(messagesTotal)
+}
+
+/*
+GELF 以GELF 1.1格式将事件直接发送到Graylog，level由alert.Severity按报警分级得出
+udp时压缩后超过ChunkSize的消息分块发送，tcp时以\0分隔且不压缩，http时每个事件一个请求
+*/
+type GELF struct {
+	conf      config.GELFConf
+	host      string
+	tlsConfig *tls.Config
+	client    *http.Client
+	// conn 只由run所在的goroutine使用
+	conn net.Conn
+
+	mu       sync.RWMutex
+	messages chan []byte
+	wg       sync.WaitGroup
+}
+
+func (g *GELF) Init(c config.Config) error {
+	if err := g.init(c.Handlers.GELF); err != nil {
+		return err
+	}
+	g.messages = make(chan []byte, g.conf.QueueSize)
+	g.wg.Add(1)
+	go g.run(g.messages)
+	return nil
+}
+
+// init checks the config and fills in the defaults
+func (g *GELF) init(conf config.GELFConf) error {
+	g.conf = conf
+	if g.conf.Address == "" {
+		return fmt.Errorf("gelf address is empty")
+	}
+	switch g.conf.Protocol {
+	case "":
+		g.conf.Protocol = ProtocolUDP
+	case ProtocolUDP, ProtocolTCP, ProtocolHTTP:
+	default:
+		return fmt.Errorf("unknown gelf protocol %q, supported: udp, tcp, http", g.conf.Protocol)
+	}
+	switch g.conf.Compression {
+	case "":
+		g.conf.Compression = CompressionGzip
+	case CompressionGzip, CompressionZlib, CompressionNone:
+	default:
+		return fmt.Errorf("unknown gelf compression %q, supported: gzip, zlib, none", g.conf.Compression)
+	}
+	if g.conf.ChunkSize <= 0 {
+		g.conf.ChunkSize = defaultChunkSize
+	}
+	if g.conf.ChunkSize <= chunkHeaderSize {
+		return fmt.Errorf("gelf chunkSize %d is too small", g.conf.ChunkSize)
+	}
+	g.host = g.conf.Host
+	if g.host == "" {
+		g.host, _ = os.Hostname()
+	}
+	if g.conf.QueueSize <= 0 {
+		g.conf.QueueSize = defaultQueueSize
+	}
+	if g.conf.Timeout <= 0 {
+		g.conf.Timeout = defaultTimeout
+	}
+	if g.conf.TLS.Enable {
+		tlsConfig, err := utils.NewTLSConfig(g.conf.TLS)
+		if err != nil {
+			return err
+		}
+		g.tlsConfig = tlsConfig
+	}
+	g.client = &http.Client{Timeout: g.conf.Timeout}
+	if g.tlsConfig != nil {
+		g.client.Transport = &http.Transport{TLSClientConfig: g.tlsConfig}
+	}
+	return nil
+}
+
+func (g *GELF) ObjectCreated(obj event.Event) {
+	g.add(obj)
+}
+
+func (g *GELF) ObjectUpdated(obj event.Event) {
+	g.add(obj)
+}
+
+func (g *GELF) ObjectDeleted(obj event.Event) {
+	g.add(obj)
+}
+
+func (g *GELF) add(obj event.Event) {
+	msg, err := json.Marshal(g.Message(obj))
+	if err != nil {
+		zlog.Error("将KBEvent解析为gelf消息失败", zap.Error(err))
+		return
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.messages == nil {
+		zlog.Error("gelf handler已关闭，丢弃事件", zap.String("name", obj.Name))
+		return
+	}
+	select {
+	case g.messages <- msg:
+	default:
+		// 连接不可用时队列很快会满，丢弃事件而不是阻塞controller
+		messagesTotal.WithLabelValues("dropped").Inc()
+		zlog.Error("gelf队列已满，丢弃事件", zap.String("name", obj.Name))
+	}
+}
+
+/*
+Message 是事件对应的GELF消息，事件的其他字段以_为前缀作为additional fields
+事件的host(所在node)为_eventSourceHost，updateContent为full_message，clusterLabels展开为_clusterLabels_<key>
+*/
+func (g *GELF) Message(e event.Event) map[string]interface{} {
+	ts := e.Time()
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	shortMessage := e.Messages
+	if shortMessage == "" {
+		shortMessage = fmt.Sprintf("%s %s %s", e.Action, e.Kind, e.Name)
+		if e.Namespace != "" {
+			shortMessage = fmt.Sprintf("%s %s %s/%s", e.Action, e.Kind, e.Namespace, e.Name)
+		}
+	}
+	msg := map[string]interface{}{
+		"version":       "1.1",
+		"host":          g.host,
+		"short_message": shortMessage,
+		"timestamp":     float64(ts.UnixNano()/int64(time.Millisecond)) / 1000,
+		"level":         alert.Severity(e),
+		"_eventId":      e.ID(),
+	}
+	if e.UpdateContent != "" {
+		msg["full_message"] = e.UpdateContent
+	}
+	var fields map[string]interface{}
+	if b, err := json.Marshal(e); err == nil {
+		json.Unmarshal(b, &fields)
+	}
+	for k, v := range fields {
+		switch k {
+		case "short_message", "updateContent", "UpdateContent":
+		case "host":
+			addField(msg, "eventSourceHost", v)
+		case "clusterLabels":
+			labels, _ := v.(map[string]interface{})
+			for lk, lv := range labels {
+				addField(msg, "clusterLabels_"+lk, lv)
+			}
+		default:
+			addField(msg, k, v)
+		}
+	}
+	return msg
+}
+
+// addField 只添加不为空的字符串及数字，GELF的additional field不支持其他类型
+func addField(msg map[string]interface{}, name string, v interface{}) {
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return
+		}
+	case float64:
+	case nil:
+		return
+	default:
+		b, _ := json.Marshal(v)
+		v = string(b)
+	}
+	msg["_"+invalidField.ReplaceAllString(name, "_")] = v
+}
+
+// run 依次发送消息，messages关闭后发送剩余的消息并关闭连接
+func (g *GELF) run(messages <-chan []byte) {
+	defer g.wg.Done()
+	for msg := range messages {
+		err := utils.Retry(func() error {
+			return g.send(msg)
+		}, "发送gelf消息", 3, 1)
+		if err != nil {
+			messagesTotal.WithLabelValues("error").Inc()
+			zlog.Error("发送gelf消息失败", zap.String("address", g.conf.Address), zap.Error(err))
+			continue
+		}
+		messagesTotal.WithLabelValues("success").Inc()
+	}
+	if g.conn != nil {
+		g.conn.Close()
+	}
+}
+
+func (g *GELF) send(msg []byte) error {
+	switch g.conf.Protocol {
+	case ProtocolHTTP:
+		return g.post(msg)
+	case ProtocolTCP:
+		return g.write(append(msg, 0))
+	}
+	payload, err := g.compress(msg)
+	if err != nil {
+		return utils.Stop(err)
+	}
+	if len(payload) <= g.conf.ChunkSize {
+		return g.write(payload)
+	}
+	chunks, err := g.chunks(payload)
+	if err != nil {
+		return utils.Stop(err)
+	}
+	for _, chunk := range chunks {
+		if err := g.write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunks 将udp消息分为最多128块，同一消息的各块使用相同的随机message id
+func (g *GELF) chunks(payload []byte) ([][]byte, error) {
+	size := g.conf.ChunkSize - chunkHeaderSize
+	count := (len(payload) + size - 1) / size
+	if count > maxChunks {
+		return nil, fmt.Errorf("gelf消息压缩后为%d字节，超过%d个chunk", len(payload), maxChunks)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunk := make([]byte, 0, chunkHeaderSize+end-i*size)
+		chunk = append(chunk, chunkMagic...)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunks = append(chunks, append(chunk, payload[i*size:end]...))
+	}
+	return chunks, nil
+}
+
+func (g *GELF) compress(msg []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch g.conf.Compression {
+	case CompressionGzip:
+		w = gzip.NewWriter(&buf)
+	case CompressionZlib:
+		w = zlib.NewWriter(&buf)
+	default:
+		return msg, nil
+	}
+	if _, err := w.Write(msg); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// write 在没有连接时先连接，写入失败时关闭连接，下次写入时重新连接
+func (g *GELF) write(b []byte) error {
+	if g.conn == nil {
+		conn, err := g.dial()
+		if err != nil {
+			return err
+		}
+		g.conn = conn
+	}
+	g.conn.SetWriteDeadline(time.Now().Add(g.conf.Timeout))
+	if _, err := g.conn.Write(b); err != nil {
+		g.conn.Close()
+		g.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (g *GELF) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: g.conf.Timeout}
+	if g.conf.Protocol == ProtocolTCP && g.tlsConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", g.conf.Address, g.tlsConfig)
+	}
+	return dialer.Dial(g.conf.Protocol, g.conf.Address)
+}
+
+// post 发送一个事件，429及5xx时重试，其他错误不重试
+func (g *GELF) post(msg []byte) error {
+	body, err := g.compress(msg)
+	if err != nil {
+		return utils.Stop(err)
+	}
+	req, err := http.NewRequest("POST", g.conf.Address, bytes.NewReader(body))
+	if err != nil {
+		return utils.Stop(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	switch g.conf.Compression {
+	case CompressionGzip:
+		req.Header.Set("Content-Encoding", "gzip")
+	case CompressionZlib:
+		req.Header.Set("Content-Encoding", "deflate")
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	err = fmt.Errorf("graylog返回%d: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return err
+	}
+	return utils.Stop(err)
+}
+
+// Close sends the queued events and closes the connection
+func (g *GELF) Close() {
+	g.mu.Lock()
+	messages := g.messages
+	g.messages = nil
+	g.mu.Unlock()
+	if messages == nil {
+		return
+	}
+	close(messages)
+	g.wg.Wait()
+}
@@ -0,0 +1,227 @@
+package gelf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+)
+
+var backOff = event.Event{Cluster: "prod", Kind: "events", Namespace: "shop", Name: "web-0.15ceabafa804c94f",
+	Reason: "BackOff", Type: "Warning", Action: event.UpdateEvent, Count: 3, Messages: "Back-off restarting failed container",
+	InvolvedKind: "Pod", InvolvedNamespace: "shop", InvolvedName: "web-0", Host: "node-1", Component: "kubelet",
+	ClusterLabels: map[string]string{"team/owner": "infra"}, LastTimestamp: time.Date(2019, 10, 18, 23, 1, 30, 0, time.UTC)}
+
+func newGELF(t *testing.T, conf config.GELFConf) *GELF {
+	c := config.Config{}
+	conf.Host = "k8swatch-0"
+	c.Handlers.GELF = conf
+	g := new(GELF)
+	if err := g.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func gunzip(t *testing.T, b []byte) map[string]interface{} {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestMessage(t *testing.T) {
+	g := &GELF{}
+	if err := g.init(config.GELFConf{Address: "graylog:12201", Host: "k8swatch-0"}); err != nil {
+		t.Fatal(err)
+	}
+	msg := g.Message(backOff)
+	for k, v := range map[string]interface{}{
+		"version":                   "1.1",
+		"host":                      "k8swatch-0",
+		"short_message":             backOff.Messages,
+		"timestamp":                 float64(backOff.LastTimestamp.Unix()),
+		"level":                     3,
+		"_eventId":                  backOff.ID(),
+		"_eventSourceHost":          "node-1",
+		"_reason":                   "BackOff",
+		"_count":                    float64(3),
+		"_clusterLabels_team_owner": "infra",
+	} {
+		if msg[k] != v {
+			t.Errorf("%s = %v, want %v", k, msg[k], v)
+		}
+	}
+	for _, k := range []string{"_short_message", "_host", "_id", "_updateContent", "_clusterLabels"} {
+		if _, ok := msg[k]; ok {
+			t.Errorf("unexpected field %s", k)
+		}
+	}
+}
+
+func TestUDPChunks(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	g := newGELF(t, config.GELFConf{Address: conn.LocalAddr().String(), ChunkSize: 100})
+	updated := backOff
+	// 随机内容压缩后仍然超过一个chunk
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 300)
+	for i := range random {
+		random[i] = byte('a' + r.Intn(26))
+	}
+	updated.UpdateContent = string(random)
+	g.ObjectUpdated(updated)
+	g.Close()
+
+	var chunks [][]byte
+	buf := make([]byte, 65536)
+	for {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		chunks = append(chunks, append([]byte(nil), buf[:n]...))
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want more than 1", len(chunks))
+	}
+	var payload []byte
+	for i, chunk := range chunks {
+		if len(chunk) > 100 || !bytes.Equal(chunk[:2], chunkMagic) || !bytes.Equal(chunk[2:10], chunks[0][2:10]) ||
+			int(chunk[10]) != i || int(chunk[11]) != len(chunks) {
+			t.Fatalf("invalid chunk %d: %x", i, chunk[:chunkHeaderSize])
+		}
+		payload = append(payload, chunk[chunkHeaderSize:]...)
+	}
+	if msg := gunzip(t, payload); msg["full_message"] != updated.UpdateContent {
+		t.Fatalf("unexpected message: %v", msg)
+	}
+}
+
+func TestTCP(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var msgs []string
+		for {
+			msg, err := r.ReadString(0)
+			if err != nil {
+				break
+			}
+			msgs = append(msgs, strings.TrimSuffix(msg, "\x00"))
+		}
+		received <- msgs
+	}()
+
+	g := newGELF(t, config.GELFConf{Address: lis.Addr().String(), Protocol: ProtocolTCP})
+	g.ObjectUpdated(backOff)
+	g.ObjectCreated(event.Event{Kind: "pods", Namespace: "shop", Name: "web-0", Action: event.CreateEvent})
+	g.Close()
+
+	select {
+	case msgs := <-received:
+		if len(msgs) != 2 {
+			t.Fatalf("got %d messages, want 2", len(msgs))
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal([]byte(msgs[1]), &msg); err != nil || msg["short_message"] != "CREATE pods shop/web-0" {
+			t.Fatalf("unexpected message %s: %v", msgs[1], err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	var bodies [][]byte
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/gelf" || r.Header.Get("Content-Encoding") != "gzip" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	g := newGELF(t, config.GELFConf{Address: server.URL + "/gelf", Protocol: ProtocolHTTP})
+	g.ObjectUpdated(backOff)
+	g.Close()
+	// 503时重试
+	if calls != 2 || len(bodies) != 1 {
+		t.Fatalf("got %d calls and %d messages", calls, len(bodies))
+	}
+	if msg := gunzip(t, bodies[0]); msg["_involvedName"] != "web-0" {
+		t.Fatalf("unexpected message: %v", msg)
+	}
+}
+
+// TestUnreachable 地址不可用时队列满后丢弃事件，add不阻塞
+func TestUnreachable(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	dropped := testutil.ToFloat64(messagesTotal.WithLabelValues("dropped"))
+	g := newGELF(t, config.GELFConf{Address: addr, Protocol: "tcp", QueueSize: 1})
+	defer g.Close()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			g.ObjectUpdated(backOff)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("add blocks while the address is unreachable")
+	}
+	// 最多一个事件在发送中，一个在队列中
+	if got := testutil.ToFloat64(messagesTotal.WithLabelValues("dropped")) - dropped; got < 8 {
+		t.Fatalf("dropped %v events, want at least 8", got)
+	}
+}
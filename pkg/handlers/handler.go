@@ -1,12 +1,31 @@
 package handlers
 
 import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"go.uber.org/zap"
+
 	"github.com/gok8s/k8swatch/pkg/config"
 	"github.com/gok8s/k8swatch/pkg/event"
 	"github.com/gok8s/k8swatch/pkg/handlers/alert"
+	"github.com/gok8s/k8swatch/pkg/handlers/exporter"
+	"github.com/gok8s/k8swatch/pkg/handlers/file"
+	"github.com/gok8s/k8swatch/pkg/handlers/gelf"
 	"github.com/gok8s/k8swatch/pkg/handlers/influxdb"
+	"github.com/gok8s/k8swatch/pkg/handlers/kafka"
+	"github.com/gok8s/k8swatch/pkg/handlers/loki"
+	"github.com/gok8s/k8swatch/pkg/handlers/nats"
+	"github.com/gok8s/k8swatch/pkg/handlers/opensearch"
+	"github.com/gok8s/k8swatch/pkg/handlers/otlp"
 	"github.com/gok8s/k8swatch/pkg/handlers/rabbitmq"
+	"github.com/gok8s/k8swatch/pkg/handlers/store"
+	"github.com/gok8s/k8swatch/pkg/handlers/stream"
+	"github.com/gok8s/k8swatch/pkg/handlers/syslog"
 	"github.com/gok8s/k8swatch/pkg/handlers/webhook"
+	"github.com/gok8s/k8swatch/utils/zlog"
 )
 
 // Handlers is implemented by any handler.
@@ -20,16 +39,30 @@ type Handler interface {
 
 // Map maps each event handler function to a name for easily lookup
 var Map = map[string]interface{}{
-	"default":  &Default{},
-	"rabbitmq": &rabbitmq.RabbitMq{},
-	"influxdb": &influxdb.InfluxDB{},
-	"alert":    &alert.Alert{},
-	"webhook":  &webhook.Webhook{},
+	"default":    &Default{},
+	"rabbitmq":   &rabbitmq.RabbitMq{},
+	"influxdb":   &influxdb.InfluxDB{},
+	"alert":      &alert.Alert{},
+	"webhook":    &webhook.Webhook{},
+	"kafka":      &kafka.Kafka{},
+	"nats":       &nats.NATS{},
+	"opensearch": &opensearch.OpenSearch{},
+	"loki":       &loki.Loki{},
+	"prometheus": &exporter.Exporter{},
+	"otlp":       &otlp.OTLP{},
+	"file":       &file.File{},
+	"syslog":     &syslog.Syslog{},
+	"gelf":       &gelf.GELF{},
+	"store":      &store.Store{},
+	"stream":     &stream.Stream{},
 }
 
 // Default handler implements Handlers interface,
 // print each event with JSON format
 type Default struct {
+	mu sync.Mutex
+	// out 为nil时输出到标准输出
+	out io.Writer
 }
 
 // Init initializes handler configuration
@@ -39,13 +72,31 @@ func (d *Default) Init(c config.Config) error {
 }
 
 func (d *Default) ObjectCreated(obj event.Event) {
-
+	d.print(obj)
 }
 
 func (d *Default) ObjectDeleted(obj event.Event) {
-
+	d.print(obj)
 }
 
-func (d *Default) ObjectUpdated(event.Event) {
+func (d *Default) ObjectUpdated(obj event.Event) {
+	d.print(obj)
+}
 
+// print 将事件作为一行JSON输出
+func (d *Default) print(obj event.Event) {
+	line, err := json.Marshal(obj)
+	if err != nil {
+		zlog.Error("将KBEvent解析为json失败", zap.Error(err))
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := d.out
+	if out == nil {
+		out = os.Stdout
+	}
+	if _, err := out.Write(append(line, '\n')); err != nil {
+		zlog.Error("输出事件失败", zap.Error(err))
+	}
 }
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gok8s/k8swatch/pkg/event"
+)
+
+func TestDefault(t *testing.T) {
+	var out bytes.Buffer
+	d := &Default{out: &out}
+	d.ObjectCreated(event.Event{Kind: "pods", Namespace: "shop", Name: "web-0", Action: event.CreateEvent})
+	d.ObjectUpdated(event.Event{Kind: "pods", Namespace: "shop", Name: "web-0", Action: event.UpdateEvent})
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %s", len(lines), out.String())
+	}
+	var e event.Event
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil || e.Action != event.UpdateEvent {
+		t.Fatalf("unexpected line %s: %v", lines[1], err)
+	}
+}
@@ -1,49 +1,172 @@
 package influxdb
 
 import (
+	"bytes"
 	"fmt"
-	"github.com/gok8s/k8swatch/pkg/event"
-	"strconv"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 
-	"github.com/influxdata/influxdb/client/v2"
 	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/utils"
 	"github.com/gok8s/k8swatch/utils/zlog"
-	api_v1 "k8s.io/api/core/v1"
 )
 
+const (
+	defaultMeasurement   = "k8sevents"
+	defaultBatchSize     = 1000
+	defaultFlushInterval = 5 * time.Second
+	defaultTimeout       = 10 * time.Second
+)
+
+// DefaultTags 是未配置Tags时作为tag写入的字段
+var DefaultTags = []string{"cluster", "environment", "region", "namespace", "kind", "reason", "type"}
+
+// tagFields 是可以作为tag的字段，uid、name等取值无限的字段只作为field写入，避免series数量无限增长
+var tagFields = map[string]func(e event.Event) string{
+	"cluster":            func(e event.Event) string { return e.Cluster },
+	"environment":        func(e event.Event) string { return e.Environment },
+	"region":             func(e event.Event) string { return e.Region },
+	"namespace":          func(e event.Event) string { return e.Namespace },
+	"kind":               func(e event.Event) string { return e.Kind },
+	"reason":             func(e event.Event) string { return e.Reason },
+	"type":               func(e event.Event) string { return e.Type },
+	"action":             func(e event.Event) string { return e.Action },
+	"component":          func(e event.Event) string { return e.Component },
+	"involved_kind":      func(e event.Event) string { return e.InvolvedKind },
+	"involved_namespace": func(e event.Event) string { return e.InvolvedNamespace },
+}
+
+var pointsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "k8swatch_influxdb_points_total",
+	Help: "Points written to influxdb, by result (success or error).",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(pointsTotal)
+}
+
+/*
+InfluxDB 将k8s event按批写入InfluxDB 1.x或2.x
+point的时间为事件的lastTimestamp，同一秒内的不同事件以由事件id得出的纳秒偏移区分，重复写入同一事件不会产生新的point
+*/
 type InfluxDB struct {
-	addr     string
-	username string
-	password string
-	dbname   string
-	cli      client.Client
-	bp       client.BatchPoints
+	conf     config.InfluxdbConf
+	writeURL string
+	client   *http.Client
+	// cli 只用于查询接口
+	cli     client.Client
+	batcher *utils.Batcher
+}
+
+// Connect 只创建查询使用的client，查询使用InfluxQL，仅支持1.x
+func (idc *InfluxDB) Connect(conf config.InfluxdbConf) (err error) {
+	idc.conf = conf
+	httpConf := client.HTTPConfig{
+		Addr:     conf.Server,
+		Username: conf.UserName,
+		Password: conf.Password,
+		Timeout:  conf.Timeout,
+	}
+	if conf.TLS.Enable {
+		if httpConf.TLSConfig, err = utils.NewTLSConfig(conf.TLS); err != nil {
+			return err
+		}
+	}
+	if idc.cli, err = client.NewHTTPClient(httpConf); err != nil {
+		zlog.Errorf("Create influxdb client error:  %v", err)
+		return err
+	}
+	return nil
 }
 
 func (idc *InfluxDB) Init(c config.Config) error {
-	idc.addr = c.Handlers.Influxdb.Server
-	idc.username = c.Handlers.Influxdb.UserName
-	idc.password = c.Handlers.Influxdb.Password
-	idc.dbname = c.Handlers.Influxdb.DBName
-	idc.cli, idc.bp = idc.getClient()
-
-	if idc.cli == nil || idc.bp == nil {
-		err := "InfluxDBClientlog:Create DB Client failed due to client or bp is null!!!!"
-		zlog.Errorf(err)
-		idc.cli, idc.bp = idc.getClient()
-		if idc.cli == nil || idc.bp == nil {
-			zlog.Errorf("retry failed..." + err)
-			return fmt.Errorf(err)
+	if err := idc.init(c.Handlers.Influxdb); err != nil {
+		return err
+	}
+	idc.client = &http.Client{Timeout: idc.conf.Timeout}
+	if idc.conf.TLS.Enable {
+		tlsConfig, err := utils.NewTLSConfig(idc.conf.TLS)
+		if err != nil {
+			return err
+		}
+		idc.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	idc.batcher = utils.NewBatcher(idc.conf.BatchSize, idc.conf.FlushInterval, func(batch []interface{}) {
+		points := make([]string, len(batch))
+		for i, p := range batch {
+			points[i] = p.(string)
+		}
+		idc.write(points)
+	})
+	return nil
+}
+
+// init checks the config and builds the write url
+func (idc *InfluxDB) init(conf config.InfluxdbConf) error {
+	idc.conf = conf
+	if idc.conf.Server == "" {
+		return fmt.Errorf("influxdb server is empty")
+	}
+	if idc.conf.Measurement == "" {
+		idc.conf.Measurement = defaultMeasurement
+	}
+	if len(idc.conf.Tags) == 0 {
+		idc.conf.Tags = DefaultTags
+	}
+	for _, tag := range idc.conf.Tags {
+		if _, ok := tagFields[tag]; !ok {
+			return fmt.Errorf("influxdb tag %q is not allowed, tags must be one of cluster, environment, region, namespace, kind, reason, type, action, component, involved_kind, involved_namespace", tag)
 		}
 	}
+	if idc.conf.BatchSize <= 0 {
+		idc.conf.BatchSize = defaultBatchSize
+	}
+	if idc.conf.FlushInterval <= 0 {
+		idc.conf.FlushInterval = defaultFlushInterval
+	}
+	if idc.conf.Timeout <= 0 {
+		idc.conf.Timeout = defaultTimeout
+	}
+
+	params := url.Values{"precision": {"ns"}}
+	server := strings.TrimRight(idc.conf.Server, "/")
+	switch idc.conf.Version {
+	case 0, 1:
+		if idc.conf.DBName == "" {
+			return fmt.Errorf("influxdb dbName is empty")
+		}
+		params.Set("db", idc.conf.DBName)
+		if idc.conf.RetentionPolicy != "" {
+			params.Set("rp", idc.conf.RetentionPolicy)
+		}
+		idc.writeURL = server + "/write?" + params.Encode()
+	case 2:
+		if idc.conf.Org == "" || idc.conf.Bucket == "" {
+			return fmt.Errorf("influxdb org and bucket are required for version 2")
+		}
+		params.Set("org", idc.conf.Org)
+		params.Set("bucket", idc.conf.Bucket)
+		idc.writeURL = server + "/api/v2/write?" + params.Encode()
+	default:
+		return fmt.Errorf("unsupported influxdb version %d", idc.conf.Version)
+	}
 	return nil
 }
 
 func (idc *InfluxDB) Close() {
-	idc.cli.Close()
+	idc.batcher.Close()
+	if idc.cli != nil {
+		idc.cli.Close()
+	}
 }
 
 func (idc *InfluxDB) ObjectCreated(obj event.Event) {
@@ -62,80 +185,131 @@ func (idc *InfluxDB) ObjectUpdated(obj event.Event) {
 	}
 }
 
-func (idc *InfluxDB) getClient() (client.Client, client.BatchPoints) {
-	insecureSkipVerify := false
-	if idc.username == "" || idc.password == "" {
-		insecureSkipVerify = true
-	}
-
-	c, err := client.NewHTTPClient(client.HTTPConfig{
-		Addr:               idc.addr,
-		Username:           idc.username,
-		Password:           idc.password,
-		UserAgent:          "",
-		Timeout:            0,
-		InsecureSkipVerify: insecureSkipVerify,
-		TLSConfig:          nil,
-		Proxy:              nil,
-	})
-
+/*
+RecordEventToInflux 将事件object转换为tags和fields并加入待写入的批次
+*/
+func (idc *InfluxDB) RecordEventToInflux(e event.Event) {
+	line, err := idc.Point(e)
 	if err != nil {
-		zlog.Errorf("Create influxdb client error:  %v", err)
-		return nil, nil
+		pointsTotal.WithLabelValues("error").Inc()
+		zlog.Error("InfluxdbWrite NewPoint failed", zap.Error(err), zap.String("name", e.Name))
+		return
 	}
+	if !idc.batcher.Add(line) {
+		zlog.Error("influxdb handler已关闭，丢弃事件", zap.String("name", e.Name))
+	}
+}
 
-	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
-		Database:  idc.dbname,
-		Precision: "s"})
-
+// Point 返回事件的line protocol
+func (idc *InfluxDB) Point(e event.Event) (string, error) {
+	tags := make(map[string]string)
+	for _, tag := range idc.conf.Tags {
+		// 空字符串的tag不会写入
+		if v := tagFields[tag](e); v != "" {
+			tags[tag] = v
+		}
+	}
+	fields := map[string]interface{}{
+		"message":          e.Messages,
+		"count":            e.Count,
+		"source_component": e.Component,
+		"source_host":      e.Host,
+		"kind_name":        e.Name,
+		"involved_name":    e.InvolvedName,
+		"uid":              e.UID,
+		"resourceVersion":  e.ResourceVersion,
+	}
+	// 时间为unix秒，未知时不写入
+	for name, t := range map[string]time.Time{
+		"firstTimestamp":  e.FirstTimestamp,
+		"lastTimestamp":   e.LastTimestamp,
+		"createTimestamp": e.CreationTimestamp,
+	} {
+		if !t.IsZero() {
+			fields[name] = t.Unix()
+		}
+	}
+	pt, err := client.NewPoint(idc.conf.Measurement, tags, fields, Timestamp(e))
 	if err != nil {
-		zlog.Errorf("Batch Point return err:  %v", err)
-		return nil, nil
+		return "", err
 	}
-	return c, bp
+	return pt.String(), nil
 }
 
 /*
-Write 执行写入动作 会先判断连接状态
+Timestamp 是point的时间，为事件的lastTimestamp，未知时为创建时间或当前时间
+k8s event的时间精度为秒，tag相同的不同事件在同一秒内会相互覆盖，因此加上由事件id得出的纳秒偏移
 */
-func (idc *InfluxDB) Write(measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) {
-	if idc.cli == nil || idc.bp == nil {
-		zlog.Errorf("InfluxDBClientlog:Create DB Client failed due to client or bp is null!!!!")
-		idc.cli, idc.bp = idc.getClient()
-		if idc.cli == nil || idc.bp == nil {
-			zlog.Errorf("InfluxDBClientlog:Create DB Client failed due to client or bp is null!!!!  retry failed")
-			return
-		}
+func Timestamp(e event.Event) time.Time {
+	t := e.LastTimestamp
+	if t.IsZero() {
+		t = e.Time()
 	}
-	pt, err := client.NewPoint(measurement, tags, fields, t)
-	if err != nil {
-		zlog.Error("InfluxdbWrite NewPoint failed", zap.Error(err))
+	if t.IsZero() {
+		t = time.Now()
+	}
+	h := fnv.New32a()
+	h.Write([]byte(e.ID()))
+	return t.Truncate(time.Second).Add(time.Duration(h.Sum32() % uint32(time.Second)))
+}
+
+// write 写入一批point，429及5xx时重试，其他错误不重试
+func (idc *InfluxDB) write(batch []string) {
+	if len(batch) == 0 {
 		return
 	}
-	idc.bp.AddPoint(pt)
-	if err := idc.cli.Write(idc.bp); err != nil {
-		zlog.Error("InfluxdbWrite failed", zap.Error(err))
+	body := []byte(strings.Join(batch, "\n"))
+	err := utils.Retry(func() error {
+		return idc.post(body)
+	}, "写入influxdb", 3, 1)
+	if err != nil {
+		pointsTotal.WithLabelValues("error").Add(float64(len(batch)))
+		zlog.Error("InfluxdbWrite failed", zap.Int("points", len(batch)), zap.Error(err))
 		return
 	}
-	zlog.Debugf("InfluxdbWrite Successed: %+v", tags)
+	pointsTotal.WithLabelValues("success").Add(float64(len(batch)))
+	zlog.Debugf("InfluxdbWrite Successed: %d points", len(batch))
+}
+
+func (idc *InfluxDB) post(body []byte) error {
+	req, err := http.NewRequest("POST", idc.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return utils.Stop(err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if idc.conf.Version == 2 {
+		req.Header.Set("Authorization", "Token "+idc.conf.Token)
+	} else if idc.conf.UserName != "" {
+		req.SetBasicAuth(idc.conf.UserName, idc.conf.Password)
+	}
+	resp, err := idc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(resp.Body)
+	err = fmt.Errorf("influxdb返回%d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return err
+	}
+	return utils.Stop(err)
 }
 
 /*
 Get 对资源进行查询
 */
 func (idc *InfluxDB) Get(cmd string) (res []client.Result, err error) {
-	if idc.cli == nil || idc.bp == nil {
-		zlog.Errorf("InfluxDBClientlog:Create DB Client failed due to client or bp is null!!!!")
-		idc.cli, idc.bp = idc.getClient()
-		if idc.cli == nil || idc.bp == nil {
-			zlog.Errorf("InfluxDBClientlog:Create DB Client failed due to client or bp is null!!!!  retry failed")
-			return
-		}
+	if idc.cli == nil {
+		return nil, fmt.Errorf("influxdb client is not connected")
 	}
 	q := client.Query{
-		Command:   cmd,
-		Database:  idc.dbname,
-		Precision: "s",
+		Command:         cmd,
+		Database:        idc.conf.DBName,
+		RetentionPolicy: idc.conf.RetentionPolicy,
+		Precision:       "s",
 	}
 
 	if response, err := idc.cli.Query(q); err == nil {
@@ -150,44 +324,3 @@ func (idc *InfluxDB) Get(cmd string) (res []client.Result, err error) {
 	}
 	return res, nil
 }
-
-/*
-RecordEventToInflux 将事件object转换为tags和fields并写入influxdb
-*/
-var x api_v1.Event
-
-func (idc *InfluxDB) RecordEventToInflux(e event.Event) {
-	tags := make(map[string]string)
-	fields := make(map[string]interface{})
-	measurement := "k8sevents" //todo 可配置
-	tags["namespace"] = e.Namespace
-	tags["kind"] = e.Kind
-	tags["kind_name"] = e.Name //todo k8sevent的involvedObject有name,但event.Event未包含
-	tags["reason"] = e.Reason
-	tags["type"] = e.Type
-	fields["message"] = e.Message
-	fields["count"] = e.Count
-	fields["source_component"] = e.Component
-	fields["source_host"] = e.Host
-	fields["firstTimestamp"] = e.FirstTimestamp
-	fields["lastTimestamp"] = e.LastTimestamp
-	fields["createTimestamp"] = e.CreationTimestamp //todo .UnixNano()
-	fields["evt_name"] = e.Name
-	rv, _ := strconv.Atoi(e.ResourceVersion)
-
-	fields["resourceVersion"] = e.ResourceVersion
-	parse, err := time.Parse("2006-01-02 15:04:05", e.CreationTimestamp)
-
-	if err != nil {
-		zlog.Error("RecordEventToInflux parse time error", zap.Error(err))
-	}
-	strcreated := parse.Unix() + int64(rv)
-	t := time.Unix(strcreated, 0)
-
-	zlog.Debugf("InfluxDBClientlog:measurement: %v  ns:  %v; kind:   %v ; Name:   %v; Reason:    %v ;Type:   %v ;"+
-		"Message:  %v; Count:    %v;  Component:    %v;  Host:   %v ; FirstTimestamp:   %v, Action: %v ",
-		measurement, e.Namespace, e.Kind, e.Name, e.Reason, e.Type,
-		e.Message, e.Count, e.Component, e.Host, e.FirstTimestamp, e.Action)
-
-	idc.Write(measurement, tags, fields, t)
-}
@@ -0,0 +1,123 @@
+package influxdb
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+)
+
+type write struct {
+	path  string
+	query string
+	auth  string
+	lines []string
+}
+
+// fakeInflux 记录收到的写入请求
+type fakeInflux struct {
+	mu     sync.Mutex
+	writes []write
+}
+
+func (f *fakeInflux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	f.writes = append(f.writes, write{
+		path:  r.URL.Path,
+		query: r.URL.RawQuery,
+		auth:  r.Header.Get("Authorization"),
+		lines: strings.Split(string(body), "\n"),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var backOff = event.Event{Cluster: "prod", Kind: "events", Namespace: "shop", Name: "web.15ceabafa804c94f",
+	Reason: "BackOff", Type: "Warning", Action: event.UpdateEvent, Count: 3, Messages: "Back-off restarting failed container",
+	UID: "6f1d2c1e", ResourceVersion: "100", LastTimestamp: time.Date(2019, 10, 18, 23, 1, 30, 0, time.UTC)}
+
+func newInfluxDB(t *testing.T, conf config.InfluxdbConf) *InfluxDB {
+	c := config.Config{}
+	c.Handlers.Influxdb = conf
+	idc := new(InfluxDB)
+	if err := idc.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	return idc
+}
+
+func TestWrite(t *testing.T) {
+	influx := &fakeInflux{}
+	server := httptest.NewServer(influx)
+	defer server.Close()
+	idc := newInfluxDB(t, config.InfluxdbConf{Server: server.URL, DBName: "events", UserName: "events", Password: "events",
+		BatchSize: 2, FlushInterval: time.Minute})
+
+	other := backOff
+	other.UID, other.Name = "0b7d9e3a", "web.15ceabafa804c950"
+	idc.ObjectUpdated(backOff)
+	idc.ObjectUpdated(other)
+	idc.ObjectCreated(event.Event{Kind: "pods", Name: "web-0"})
+	idc.ObjectUpdated(backOff)
+	idc.Close()
+
+	// 每批只包含新的point
+	if len(influx.writes) != 2 || len(influx.writes[0].lines) != 2 || len(influx.writes[1].lines) != 1 {
+		t.Fatalf("unexpected writes: %+v", influx.writes)
+	}
+	w := influx.writes[0]
+	if w.path != "/write" || w.query != "db=events&precision=ns" || !strings.HasPrefix(w.auth, "Basic ") {
+		t.Fatalf("unexpected request %s?%s %s", w.path, w.query, w.auth)
+	}
+	line := w.lines[0]
+	if !strings.HasPrefix(line, "k8sevents,cluster=prod,kind=events,namespace=shop,reason=BackOff,type=Warning ") ||
+		!strings.Contains(line, `message="Back-off restarting failed container"`) || !strings.Contains(line, `uid="6f1d2c1e"`) {
+		t.Fatalf("unexpected line: %s", line)
+	}
+	// 同一秒内的两个事件时间不同，同一事件重复写入时间相同
+	ts := func(line string) string { return line[strings.LastIndex(line, " ")+1:] }
+	if ts(w.lines[0]) == ts(w.lines[1]) || ts(w.lines[0]) != ts(influx.writes[1].lines[0]) {
+		t.Fatalf("unexpected timestamps: %v %v", w.lines, influx.writes[1].lines)
+	}
+	if got := Timestamp(backOff).Truncate(time.Second); !got.Equal(backOff.LastTimestamp) {
+		t.Fatalf("Timestamp = %v", got)
+	}
+}
+
+func TestWriteV2(t *testing.T) {
+	influx := &fakeInflux{}
+	server := httptest.NewServer(influx)
+	defer server.Close()
+	idc := newInfluxDB(t, config.InfluxdbConf{Server: server.URL, Version: 2, Org: "ops", Bucket: "k8s", Token: "secret",
+		Measurement: "events", Tags: []string{"namespace", "reason"}})
+	idc.ObjectUpdated(backOff)
+	idc.Close()
+
+	if len(influx.writes) != 1 {
+		t.Fatalf("got %d writes", len(influx.writes))
+	}
+	w := influx.writes[0]
+	if w.path != "/api/v2/write" || w.query != "bucket=k8s&org=ops&precision=ns" || w.auth != "Token secret" {
+		t.Fatalf("unexpected request %s?%s %s", w.path, w.query, w.auth)
+	}
+	if !strings.HasPrefix(w.lines[0], "events,namespace=shop,reason=BackOff ") {
+		t.Fatalf("unexpected line: %s", w.lines[0])
+	}
+}
+
+func TestTags(t *testing.T) {
+	idc := new(InfluxDB)
+	if err := idc.init(config.InfluxdbConf{Server: "http://influxdb:8086", DBName: "events", Tags: []string{"namespace", "uid"}}); err == nil {
+		t.Fatal("uid accepted as a tag")
+	}
+	if err := idc.init(config.InfluxdbConf{Server: "http://influxdb:8086", Version: 2, Bucket: "k8s"}); err == nil {
+		t.Fatal("version 2 without org accepted")
+	}
+}
@@ -0,0 +1,294 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/gok8s/k8swatch/pkg/cloudevents"
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/utils"
+	"github.com/gok8s/k8swatch/utils/zlog"
+)
+
+const (
+	// KeyNamespaceName 同一对象的消息进入同一分区，保证顺序
+	KeyNamespaceName = "namespace/name"
+	// KeyKindNamespaceName 区分不同类别的同名对象
+	KeyKindNamespaceName = "kind/namespace/name"
+	// KeyUID 按对象uid，已删除的对象和k8swatch生成的事件没有uid，使用namespace/name
+	KeyUID = "uid"
+	// KeyNone 不设置key，消息随机分配到各分区
+	KeyNone = "none"
+
+	defaultTopic   = "k8s-events"
+	defaultVersion = "2.1.0"
+	clientID       = "k8swatch"
+)
+
+var messagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "k8swatch_kafka_messages_total",
+	Help: "Messages produced to kafka, by result (success or error).",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(messagesTotal)
+}
+
+var compressionCodecs = map[string]sarama.CompressionCodec{
+	"":       sarama.CompressionNone,
+	"none":   sarama.CompressionNone,
+	"gzip":   sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy,
+	"lz4":    sarama.CompressionLZ4,
+	"zstd":   sarama.CompressionZSTD,
+}
+
+/*
+Kafka 将事件异步发布到kafka，topic由模板按事件生成，key决定分区
+发送结果在后台读取，失败的消息会记录日志并计入k8swatch_kafka_messages_total{result="error"}
+*/
+type Kafka struct {
+	conf     config.KafkaConf
+	topic    *template.Template
+	mu       sync.RWMutex
+	producer sarama.AsyncProducer
+	wg       sync.WaitGroup
+}
+
+// Init validates the config and connects to the brokers
+func (k *Kafka) Init(c config.Config) error {
+	if err := k.init(c.Handlers.Kafka); err != nil {
+		return err
+	}
+	saramaConfig, err := newSaramaConfig(k.conf)
+	if err != nil {
+		return err
+	}
+	producer, err := sarama.NewAsyncProducer(k.conf.Brokers, saramaConfig)
+	if err != nil {
+		zlog.Errorf("连接kafka失败，brokers: %v, error: %v", k.conf.Brokers, err)
+		return err
+	}
+	k.producer = producer
+	k.wg.Add(2)
+	go k.reportSuccesses(producer.Successes())
+	go k.reportErrors(producer.Errors())
+	return nil
+}
+
+// init checks the options that don't need a connection
+func (k *Kafka) init(conf config.KafkaConf) error {
+	k.conf = conf
+	if len(k.conf.Brokers) == 0 {
+		return fmt.Errorf("kafka brokers is empty")
+	}
+	switch k.conf.Key {
+	case "", KeyNamespaceName, KeyKindNamespaceName, KeyUID, KeyNone:
+	default:
+		return fmt.Errorf("unknown kafka key %q, supported: %s, %s, %s, %s", k.conf.Key, KeyNamespaceName, KeyKindNamespaceName, KeyUID, KeyNone)
+	}
+	if k.conf.CloudEvents.Enable {
+		if err := cloudevents.ValidMode(k.conf.CloudEvents.Mode); err != nil {
+			return err
+		}
+	}
+	topic := k.conf.Topic
+	if topic == "" {
+		topic = defaultTopic
+	}
+	var err error
+	if k.topic, err = template.New("topic").Option("missingkey=error").Parse(topic); err != nil {
+		return fmt.Errorf("invalid kafka topic template %q: %v", topic, err)
+	}
+	return nil
+}
+
+func newSaramaConfig(conf config.KafkaConf) (*sarama.Config, error) {
+	c := sarama.NewConfig()
+	c.ClientID = clientID
+	version := conf.Version
+	if version == "" {
+		version = defaultVersion
+	}
+	var err error
+	if c.Version, err = sarama.ParseKafkaVersion(version); err != nil {
+		return nil, err
+	}
+	codec, ok := compressionCodecs[strings.ToLower(conf.Compression)]
+	if !ok {
+		return nil, fmt.Errorf("unknown kafka compression %q, supported: none, gzip, snappy, lz4, zstd", conf.Compression)
+	}
+	c.Producer.Compression = codec
+	c.Producer.RequiredAcks = sarama.WaitForAll
+	c.Producer.Return.Successes = true
+	c.Producer.Return.Errors = true
+	c.Producer.Flush.Messages = conf.BatchSize
+	c.Producer.Flush.Frequency = conf.FlushInterval
+	if conf.Idempotent {
+		c.Producer.Idempotent = true
+		c.Net.MaxOpenRequests = 1
+	}
+
+	if conf.SASL.Enable {
+		c.Net.SASL.Enable = true
+		c.Net.SASL.User = conf.SASL.UserName
+		c.Net.SASL.Password = conf.SASL.Password
+		switch strings.ToUpper(conf.SASL.Mechanism) {
+		case "", sarama.SASLTypePlaintext:
+			c.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case sarama.SASLTypeSCRAMSHA256:
+			c.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			c.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{HashGeneratorFcn: sha256Hash} }
+		case sarama.SASLTypeSCRAMSHA512:
+			c.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			c.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{HashGeneratorFcn: sha512Hash} }
+		default:
+			return nil, fmt.Errorf("unknown kafka sasl mechanism %q, supported: PLAIN, SCRAM-SHA-256, SCRAM-SHA-512", conf.SASL.Mechanism)
+		}
+	}
+	if conf.TLS.Enable {
+		c.Net.TLS.Enable = true
+		if c.Net.TLS.Config, err = utils.NewTLSConfig(conf.TLS); err != nil {
+			return nil, err
+		}
+	}
+	return c, c.Validate()
+}
+
+func (k *Kafka) ObjectCreated(obj event.Event) {
+	k.send(obj)
+}
+
+func (k *Kafka) ObjectUpdated(obj event.Event) {
+	k.send(obj)
+}
+
+func (k *Kafka) ObjectDeleted(obj event.Event) {
+	k.send(obj)
+}
+
+func (k *Kafka) send(obj event.Event) {
+	msg, err := k.message(obj)
+	if err != nil {
+		zlog.Error("生成kafka消息失败", zap.Error(err),
+			zap.String("namespace", obj.Namespace),
+			zap.String("name", obj.Name),
+			zap.String("kind", obj.Kind),
+		)
+		messagesTotal.WithLabelValues("error").Inc()
+		return
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.producer == nil {
+		zlog.Error("kafka producer已关闭，丢弃消息", zap.String("topic", msg.Topic), zap.String("name", obj.Name))
+		return
+	}
+	k.producer.Input() <- msg
+}
+
+// message encodes obj as JSON, or as a CloudEvent with the Kafka binding, and sets its topic and key
+func (k *Kafka) message(obj event.Event) (*sarama.ProducerMessage, error) {
+	var msg *sarama.ProducerMessage
+	if k.conf.CloudEvents.Enable {
+		var err error
+		if msg, err = cloudevents.NewKafkaMessage(obj, k.conf.CloudEvents.Mode); err != nil {
+			return nil, err
+		}
+	} else {
+		body, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		msg = &sarama.ProducerMessage{Value: sarama.ByteEncoder(body)}
+	}
+	var buf bytes.Buffer
+	if err := k.topic.Execute(&buf, obj); err != nil {
+		return nil, err
+	}
+	msg.Topic = topicName(buf.String())
+	if msg.Topic == "" {
+		return nil, fmt.Errorf("kafka topic is empty")
+	}
+	msg.Key = k.key(obj)
+	msg.Metadata = obj
+	return msg, nil
+}
+
+func (k *Kafka) key(obj event.Event) sarama.Encoder {
+	switch k.conf.Key {
+	case KeyNone:
+		return nil
+	case KeyKindNamespaceName:
+		return sarama.StringEncoder(obj.Kind + "/" + obj.Namespace + "/" + obj.Name)
+	case KeyUID:
+		if obj.UID != "" {
+			return sarama.StringEncoder(obj.UID)
+		}
+	}
+	return sarama.StringEncoder(obj.Namespace + "/" + obj.Name)
+}
+
+// topicName replaces the characters kafka doesn't allow in topic names, e.g. from cluster labels
+func topicName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(s))
+}
+
+func (k *Kafka) reportSuccesses(successes <-chan *sarama.ProducerMessage) {
+	defer k.wg.Done()
+	for msg := range successes {
+		messagesTotal.WithLabelValues("success").Inc()
+		obj, _ := msg.Metadata.(event.Event)
+		zlog.Info("发送kafka消息成功 "+obj.Messages,
+			zap.String("topic", msg.Topic),
+			zap.Int32("partition", msg.Partition),
+			zap.Int64("offset", msg.Offset),
+			zap.String("namespace", obj.Namespace),
+			zap.String("name", obj.Name),
+			zap.String("action", obj.Action),
+			zap.String("kind", obj.Kind),
+		)
+	}
+}
+
+func (k *Kafka) reportErrors(errors <-chan *sarama.ProducerError) {
+	defer k.wg.Done()
+	for perr := range errors {
+		messagesTotal.WithLabelValues("error").Inc()
+		obj, _ := perr.Msg.Metadata.(event.Event)
+		zlog.Error("发送kafka消息失败", zap.Error(perr.Err),
+			zap.String("topic", perr.Msg.Topic),
+			zap.String("namespace", obj.Namespace),
+			zap.String("name", obj.Name),
+			zap.String("action", obj.Action),
+			zap.String("kind", obj.Kind),
+		)
+	}
+}
+
+// Close flushes the buffered messages and waits for their results
+func (k *Kafka) Close() {
+	k.mu.Lock()
+	producer := k.producer
+	k.producer = nil
+	k.mu.Unlock()
+	if producer == nil {
+		return
+	}
+	producer.AsyncClose()
+	k.wg.Wait()
+}
@@ -0,0 +1,126 @@
+package kafka
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+)
+
+func testEvent(kind, namespace, name string) event.Event {
+	return event.Event{
+		Cluster:         "cluster-a",
+		Kind:            kind,
+		Namespace:       namespace,
+		Name:            name,
+		Action:          event.UpdateEvent,
+		UID:             "6f1d2c1e-8f6a-4c7e-9f4b-1f2e3d4c5b6a",
+		ResourceVersion: "100",
+	}
+}
+
+func TestMessage(t *testing.T) {
+	k := new(Kafka)
+	if err := k.init(config.KafkaConf{Brokers: []string{"localhost:9092"}, Topic: "k8s-{{.Namespace}}"}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := k.message(testEvent("pods", "shop", "web-x7k2p"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := msg.Key.Encode()
+	value, _ := msg.Value.Encode()
+	var decoded event.Event
+	if msg.Topic != "k8s-shop" || string(key) != "shop/web-x7k2p" || json.Unmarshal(value, &decoded) != nil || decoded.Name != "web-x7k2p" {
+		t.Fatalf("unexpected message: %s %s %s", msg.Topic, key, value)
+	}
+	// 集群级别的对象没有namespace
+	if msg, _ := k.message(testEvent("nodes", "", "node-1")); msg.Topic != "k8s-" {
+		t.Fatalf("unexpected topic %q", msg.Topic)
+	}
+
+	k.conf.Key = KeyUID
+	if key, _ := k.key(testEvent("pods", "shop", "web-x7k2p")).Encode(); string(key) != "6f1d2c1e-8f6a-4c7e-9f4b-1f2e3d4c5b6a" {
+		t.Fatalf("unexpected uid key %s", key)
+	}
+	k.conf.Key = KeyNone
+	if k.key(testEvent("pods", "shop", "web-x7k2p")) != nil {
+		t.Fatal("key should not be set")
+	}
+
+	k.conf.CloudEvents = config.CloudEventsConf{Enable: true, Mode: "binary"}
+	msg, _ = k.message(testEvent("pods", "shop", "web-x7k2p"))
+	headers := map[string]string{}
+	for _, h := range msg.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	if headers["ce_type"] != "io.k8swatch.pods.update" || headers["ce_source"] != "cluster-a" || headers["content-type"] != "application/json" {
+		t.Fatalf("unexpected CloudEvents headers: %v", headers)
+	}
+
+	for _, conf := range []config.KafkaConf{
+		{},
+		{Brokers: []string{"localhost:9092"}, Key: "name"},
+		{Brokers: []string{"localhost:9092"}, Topic: "k8s-{{.Kind"},
+	} {
+		if err := new(Kafka).init(conf); err == nil {
+			t.Errorf("config %+v should be rejected", conf)
+		}
+	}
+	if _, err := newSaramaConfig(config.KafkaConf{Compression: "brotli"}); err == nil {
+		t.Error("unknown compression should be rejected")
+	}
+	if _, err := newSaramaConfig(config.KafkaConf{Idempotent: true, Version: "0.10.2.0"}); err == nil {
+		t.Error("idempotent producer requires kafka 0.11")
+	}
+}
+
+func TestProduce(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("k8s-pods", 0, broker.BrokerID()).
+			SetLeader("k8s-nodes", 0, broker.BrokerID()),
+		"InitProducerIDRequest": sarama.NewMockWrapper(&sarama.InitProducerIDResponse{ProducerID: 1}),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).
+			SetVersion(3).
+			SetError("k8s-nodes", 0, sarama.ErrMessageSizeTooLarge),
+	})
+
+	c := config.Config{}
+	c.Handlers.Kafka = config.KafkaConf{
+		Brokers:       []string{broker.Addr()},
+		Topic:         "k8s-{{.Kind}}",
+		Idempotent:    true,
+		Compression:   "gzip",
+		BatchSize:     2,
+		FlushInterval: 50 * time.Millisecond,
+	}
+	k := new(Kafka)
+	if err := k.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	successes := testutil.ToFloat64(messagesTotal.WithLabelValues("success"))
+	errors := testutil.ToFloat64(messagesTotal.WithLabelValues("error"))
+
+	k.ObjectCreated(testEvent("pods", "shop", "web-x7k2p"))
+	k.ObjectUpdated(testEvent("pods", "shop", "web-x7k2p"))
+	k.ObjectDeleted(testEvent("nodes", "", "node-1"))
+	k.Close()
+
+	if got := testutil.ToFloat64(messagesTotal.WithLabelValues("success")) - successes; got != 2 {
+		t.Errorf("%v messages delivered, want 2", got)
+	}
+	if got := testutil.ToFloat64(messagesTotal.WithLabelValues("error")) - errors; got != 1 {
+		t.Errorf("%v delivery errors reported, want 1", got)
+	}
+	// 关闭后的消息被丢弃
+	k.ObjectCreated(testEvent("pods", "shop", "web-x7k2p"))
+}
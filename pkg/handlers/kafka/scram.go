@@ -0,0 +1,36 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+
+	"github.com/xdg/scram"
+)
+
+var (
+	sha256Hash scram.HashGeneratorFcn = sha256.New
+	sha512Hash scram.HashGeneratorFcn = sha512.New
+)
+
+// scramClient implements sarama.SCRAMClient, sarama leaves the SCRAM conversation to the caller
+type scramClient struct {
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+func (s *scramClient) Begin(userName, password, authzID string) error {
+	client, err := s.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	s.ClientConversation = client.NewConversation()
+	return nil
+}
+
+func (s *scramClient) Step(challenge string) (string, error) {
+	return s.ClientConversation.Step(challenge)
+}
+
+func (s *scramClient) Done() bool {
+	return s.ClientConversation.Done()
+}
@@ -0,0 +1,230 @@
+package loki
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/utils"
+	"github.com/gok8s/k8swatch/utils/zlog"
+)
+
+const (
+	pushPath             = "/loki/api/v1/push"
+	defaultBatchSize     = 500
+	defaultFlushInterval = 5 * time.Second
+	defaultTimeout       = 10 * time.Second
+)
+
+var entriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "k8swatch_loki_entries_total",
+	Help: "Events pushed to loki, by result (success or error).",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(entriesTotal)
+}
+
+// entry 是一行日志，ts为k8swatch处理事件的时间，事件本身的时间在JSON中
+type entry struct {
+	labels map[string]string
+	ts     time.Time
+	line   string
+}
+
+/*
+Loki 按批通过push API发送事件，每个事件为一行JSON
+label只使用cluster、namespace、kind、reason、type，避免name等字段导致stream数量无限增长
+*/
+type Loki struct {
+	conf    config.LokiConf
+	client  *http.Client
+	batcher *utils.Batcher
+}
+
+func (l *Loki) Init(c config.Config) error {
+	l.conf = c.Handlers.Loki
+	if l.conf.Url == "" {
+		return fmt.Errorf("loki url is empty")
+	}
+	if l.conf.BatchSize <= 0 {
+		l.conf.BatchSize = defaultBatchSize
+	}
+	if l.conf.FlushInterval <= 0 {
+		l.conf.FlushInterval = defaultFlushInterval
+	}
+	if l.conf.Timeout <= 0 {
+		l.conf.Timeout = defaultTimeout
+	}
+	l.client = &http.Client{Timeout: l.conf.Timeout}
+	if l.conf.TLS.Enable {
+		tlsConfig, err := utils.NewTLSConfig(l.conf.TLS)
+		if err != nil {
+			return err
+		}
+		l.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	l.batcher = utils.NewBatcher(l.conf.BatchSize, l.conf.FlushInterval, func(batch []interface{}) {
+		entries := make([]entry, len(batch))
+		for i, e := range batch {
+			entries[i] = e.(entry)
+		}
+		l.push(entries)
+	})
+	return nil
+}
+
+func (l *Loki) ObjectCreated(obj event.Event) {
+	l.add(obj)
+}
+
+func (l *Loki) ObjectUpdated(obj event.Event) {
+	l.add(obj)
+}
+
+func (l *Loki) ObjectDeleted(obj event.Event) {
+	l.add(obj)
+}
+
+func (l *Loki) add(obj event.Event) {
+	line, err := json.Marshal(obj)
+	if err != nil {
+		zlog.Error("将KBEvent解析为json失败", zap.Error(err))
+		return
+	}
+	if !l.batcher.Add(entry{labels: Labels(obj), ts: time.Now(), line: string(line)}) {
+		zlog.Error("loki handler已关闭，丢弃事件", zap.String("name", obj.Name))
+	}
+}
+
+// Labels 是事件所属stream的label，为空的label不发送
+func Labels(obj event.Event) map[string]string {
+	labels := map[string]string{}
+	for k, v := range map[string]string{
+		"cluster":   obj.Cluster,
+		"namespace": obj.Namespace,
+		"kind":      obj.Kind,
+		"reason":    obj.Reason,
+		"type":      obj.Type,
+	} {
+		if v != "" {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+type stream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type pushRequest struct {
+	Streams []stream `json:"streams"`
+}
+
+// newPushRequest 按label分组，每个stream中的日志按时间排序
+func newPushRequest(batch []entry) pushRequest {
+	streams := map[string]*stream{}
+	var keys []string
+	for _, e := range batch {
+		key := streamKey(e.labels)
+		s, ok := streams[key]
+		if !ok {
+			s = &stream{Stream: e.labels}
+			streams[key] = s
+			keys = append(keys, key)
+		}
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(e.ts.UnixNano(), 10), e.line})
+	}
+	req := pushRequest{}
+	for _, key := range keys {
+		s := streams[key]
+		sort.SliceStable(s.Values, func(i, j int) bool {
+			ti, _ := strconv.ParseInt(s.Values[i][0], 10, 64)
+			tj, _ := strconv.ParseInt(s.Values[j][0], 10, 64)
+			return ti < tj
+		})
+		req.Streams = append(req.Streams, *s)
+	}
+	return req
+}
+
+func streamKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%q,", k, labels[k])
+	}
+	return b.String()
+}
+
+// push 发送一批事件，429及5xx时重试，其他错误不重试
+func (l *Loki) push(batch []entry) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(newPushRequest(batch))
+	if err != nil {
+		zlog.Error("loki push请求编码失败", zap.Error(err))
+		return
+	}
+	err = utils.Retry(func() error {
+		return l.post(body)
+	}, "推送loki日志", 3, 1)
+	if err != nil {
+		entriesTotal.WithLabelValues("error").Add(float64(len(batch)))
+		zlog.Error("推送loki日志失败", zap.Int("entries", len(batch)), zap.Error(err))
+		return
+	}
+	entriesTotal.WithLabelValues("success").Add(float64(len(batch)))
+	zlog.Debugf("推送loki日志成功，共%d条", len(batch))
+}
+
+func (l *Loki) post(body []byte) error {
+	req, err := http.NewRequest("POST", strings.TrimRight(l.conf.Url, "/")+pushPath, bytes.NewReader(body))
+	if err != nil {
+		return utils.Stop(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.conf.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", l.conf.TenantID)
+	}
+	if l.conf.UserName != "" {
+		req.SetBasicAuth(l.conf.UserName, l.conf.Password)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(resp.Body)
+	err = fmt.Errorf("loki返回%d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return err
+	}
+	return utils.Stop(err)
+}
+
+// Close pushes the pending events
+func (l *Loki) Close() {
+	l.batcher.Close()
+}
@@ -0,0 +1,144 @@
+package loki
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+)
+
+// fakeLoki 记录收到的push请求，statuses中的状态码依次返回，之后返回204
+type fakeLoki struct {
+	mu       sync.Mutex
+	tenants  []string
+	pushes   []pushRequest
+	statuses []int
+	calls    int
+}
+
+func (f *fakeLoki) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Method != "POST" || r.URL.Path != pushPath {
+		http.NotFound(w, r)
+		return
+	}
+	f.calls++
+	if len(f.statuses) > 0 {
+		status := f.statuses[0]
+		f.statuses = f.statuses[1:]
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	var req pushRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.tenants = append(f.tenants, r.Header.Get("X-Scope-OrgID"))
+	f.pushes = append(f.pushes, req)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newLoki(t *testing.T, url string, batchSize int) *Loki {
+	c := config.Config{}
+	c.Handlers.Loki = config.LokiConf{
+		Url:           url,
+		TenantID:      "k8s",
+		BatchSize:     batchSize,
+		FlushInterval: time.Minute,
+	}
+	l := new(Loki)
+	if err := l.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestPush(t *testing.T) {
+	loki := &fakeLoki{}
+	server := httptest.NewServer(loki)
+	defer server.Close()
+	l := newLoki(t, server.URL+"/", 3)
+
+	backOff := event.Event{Cluster: "prod", Kind: "events", Namespace: "shop", Name: "web.15ceabafa804c94f",
+		Reason: "BackOff", Type: "Warning", Action: event.UpdateEvent}
+	created := event.Event{Cluster: "prod", Kind: "pods", Name: "node-1", Action: event.CreateEvent}
+	l.ObjectUpdated(backOff)
+	l.ObjectCreated(created)
+	l.ObjectUpdated(backOff)
+	// 第4个事件在Close时推送
+	l.ObjectDeleted(created)
+	l.Close()
+
+	if len(loki.pushes) != 2 {
+		t.Fatalf("got %d pushes, want 2", len(loki.pushes))
+	}
+	for _, tenant := range loki.tenants {
+		if tenant != "k8s" {
+			t.Errorf("X-Scope-OrgID = %q", tenant)
+		}
+	}
+	streams := loki.pushes[0].Streams
+	if len(streams) != 2 {
+		t.Fatalf("got %d streams, want 2: %+v", len(streams), streams)
+	}
+	want := map[string]string{"cluster": "prod", "namespace": "shop", "kind": "events", "reason": "BackOff", "type": "Warning"}
+	if len(streams[0].Stream) != len(want) {
+		t.Fatalf("stream labels = %v", streams[0].Stream)
+	}
+	for k, v := range want {
+		if streams[0].Stream[k] != v {
+			t.Errorf("label %s = %q, want %q", k, streams[0].Stream[k], v)
+		}
+	}
+	if _, ok := streams[1].Stream["namespace"]; ok {
+		t.Errorf("empty namespace label sent: %v", streams[1].Stream)
+	}
+	values := streams[0].Values
+	if len(values) != 2 || values[0][0] > values[1][0] {
+		t.Fatalf("unexpected values: %v", values)
+	}
+	var e event.Event
+	if err := json.Unmarshal([]byte(values[0][1]), &e); err != nil || e.Name != backOff.Name {
+		t.Fatalf("unexpected line %s: %v", values[0][1], err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	loki := &fakeLoki{statuses: []int{http.StatusTooManyRequests, http.StatusInternalServerError}}
+	server := httptest.NewServer(loki)
+	defer server.Close()
+	l := newLoki(t, server.URL, 1)
+	success := testutil.ToFloat64(entriesTotal.WithLabelValues("success"))
+	l.ObjectCreated(event.Event{Kind: "pods", Name: "web-0"})
+	l.Close()
+	if loki.calls != 3 || len(loki.pushes) != 1 {
+		t.Fatalf("got %d calls and %d pushes, want 3 and 1", loki.calls, len(loki.pushes))
+	}
+	if got := testutil.ToFloat64(entriesTotal.WithLabelValues("success")) - success; got != 1 {
+		t.Fatalf("%v entries reported as success, want 1", got)
+	}
+
+	// 400不重试
+	loki = &fakeLoki{statuses: []int{http.StatusBadRequest}}
+	server = httptest.NewServer(loki)
+	defer server.Close()
+	l = newLoki(t, server.URL, 1)
+	errors := testutil.ToFloat64(entriesTotal.WithLabelValues("error"))
+	l.ObjectCreated(event.Event{Kind: "pods", Name: "web-0"})
+	l.Close()
+	if loki.calls != 1 {
+		t.Fatalf("got %d calls for 400, want 1", loki.calls)
+	}
+	if got := testutil.ToFloat64(entriesTotal.WithLabelValues("error")) - errors; got != 1 {
+		t.Fatalf("%v entries reported as error, want 1", got)
+	}
+}
@@ -0,0 +1,258 @@
+package nats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/gok8s/k8swatch/pkg/cloudevents"
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/utils"
+	"github.com/gok8s/k8swatch/utils/zlog"
+)
+
+const (
+	defaultSubject = "k8s.{{.Cluster}}.{{.Kind}}.{{.Namespace}}"
+	clientName     = "k8swatch"
+	// emptyToken 替代subject中为空的字段，如集群级别对象的namespace
+	emptyToken = "_"
+)
+
+var messagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "k8swatch_nats_messages_total",
+	Help: "Messages published to nats, by result (success or error).",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(messagesTotal)
+}
+
+/*
+NATS 将事件发布到nats，subject由模板按事件生成
+断线后由nats client按ReconnectWait自动重连，重连期间的消息缓存在ReconnectBufSize内
+启用JetStream时同步等待服务端确认，并以事件id作为Nats-Msg-Id，重试不会产生重复消息
+*/
+type NATS struct {
+	conf    config.NATSConf
+	subject *template.Template
+	conn    *nats.Conn
+	js      nats.JetStreamContext
+}
+
+// Init validates the config and connects to the servers
+func (n *NATS) Init(c config.Config) error {
+	if err := n.init(c.Handlers.NATS); err != nil {
+		return err
+	}
+	opts, err := n.options()
+	if err != nil {
+		return err
+	}
+	n.conn, err = nats.Connect(strings.Join(n.conf.Servers, ","), opts...)
+	if err != nil {
+		zlog.Errorf("连接nats失败，servers: %v, error: %v", n.conf.Servers, err)
+		return err
+	}
+	if !n.conf.JetStream.Enable {
+		return nil
+	}
+	if n.js, err = n.conn.JetStream(); err != nil {
+		n.conn.Close()
+		return err
+	}
+	if n.conf.JetStream.Stream != "" {
+		if _, err = n.js.StreamInfo(n.conf.JetStream.Stream); err != nil {
+			n.conn.Close()
+			return fmt.Errorf("jetstream stream %s: %v", n.conf.JetStream.Stream, err)
+		}
+	}
+	return nil
+}
+
+// init checks the options that don't need a connection
+func (n *NATS) init(conf config.NATSConf) error {
+	n.conf = conf
+	if len(n.conf.Servers) == 0 {
+		return fmt.Errorf("nats servers is empty")
+	}
+	if n.conf.CloudEvents.Enable {
+		if err := cloudevents.ValidMode(n.conf.CloudEvents.Mode); err != nil {
+			return err
+		}
+	}
+	subject := n.conf.Subject
+	if subject == "" {
+		subject = defaultSubject
+	}
+	var err error
+	if n.subject, err = template.New("subject").Option("missingkey=error").Parse(subject); err != nil {
+		return fmt.Errorf("invalid nats subject template %q: %v", subject, err)
+	}
+	return nil
+}
+
+func (n *NATS) options() ([]nats.Option, error) {
+	maxReconnects := n.conf.MaxReconnects
+	if maxReconnects == 0 {
+		maxReconnects = -1
+	}
+	opts := []nats.Option{
+		nats.Name(clientName),
+		nats.MaxReconnects(maxReconnects),
+		nats.DisconnectErrHandler(func(conn *nats.Conn, err error) {
+			zlog.Errorf("与nats的连接已断开，将自动重连，error: %v", err)
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			zlog.Infof("已重新连接到nats: %s", conn.ConnectedUrl())
+		}),
+		nats.ClosedHandler(func(conn *nats.Conn) {
+			if err := conn.LastError(); err != nil {
+				zlog.Errorf("nats连接已关闭，error: %v", err)
+			}
+		}),
+	}
+	if n.conf.ReconnectWait > 0 {
+		opts = append(opts, nats.ReconnectWait(n.conf.ReconnectWait))
+	}
+	if n.conf.ReconnectBufSize > 0 {
+		opts = append(opts, nats.ReconnectBufSize(n.conf.ReconnectBufSize))
+	}
+	if n.conf.UserName != "" {
+		opts = append(opts, nats.UserInfo(n.conf.UserName, n.conf.Password))
+	}
+	if n.conf.Token != "" {
+		opts = append(opts, nats.Token(n.conf.Token))
+	}
+	if n.conf.CredsFile != "" {
+		opts = append(opts, nats.UserCredentials(n.conf.CredsFile))
+	}
+	if n.conf.TLS.Enable {
+		tlsConfig, err := utils.NewTLSConfig(n.conf.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, nats.Secure(tlsConfig))
+	}
+	return opts, nil
+}
+
+func (n *NATS) ObjectCreated(obj event.Event) {
+	n.send(obj)
+}
+
+func (n *NATS) ObjectUpdated(obj event.Event) {
+	n.send(obj)
+}
+
+func (n *NATS) ObjectDeleted(obj event.Event) {
+	n.send(obj)
+}
+
+func (n *NATS) send(obj event.Event) {
+	msg, err := n.message(obj)
+	if err == nil {
+		err = n.publish(msg, obj)
+	}
+	if err != nil {
+		messagesTotal.WithLabelValues("error").Inc()
+		zlog.Error("发送nats消息失败", zap.Error(err),
+			zap.String("namespace", obj.Namespace),
+			zap.String("name", obj.Name),
+			zap.String("action", obj.Action),
+			zap.String("kind", obj.Kind),
+		)
+		return
+	}
+	messagesTotal.WithLabelValues("success").Inc()
+	zlog.Info("发送nats消息成功 "+obj.Messages,
+		zap.String("subject", msg.Subject),
+		zap.String("namespace", obj.Namespace),
+		zap.String("name", obj.Name),
+		zap.String("action", obj.Action),
+		zap.String("kind", obj.Kind),
+	)
+}
+
+func (n *NATS) publish(msg *nats.Msg, obj event.Event) error {
+	if n.js == nil {
+		return n.conn.PublishMsg(msg)
+	}
+	opts := []nats.PubOpt{nats.MsgId(obj.ID())}
+	if n.conf.JetStream.Stream != "" {
+		opts = append(opts, nats.ExpectStream(n.conf.JetStream.Stream))
+	}
+	return utils.Retry(func() error {
+		_, err := n.js.PublishMsg(msg, opts...)
+		return err
+	}, "发送JetStream消息", 3, 1)
+}
+
+// message encodes obj as JSON, or as a CloudEvent with the NATS binding, on the subject rendered from the template
+func (n *NATS) message(obj event.Event) (*nats.Msg, error) {
+	subject, err := n.subjectFor(obj)
+	if err != nil {
+		return nil, err
+	}
+	if n.conf.CloudEvents.Enable {
+		return cloudevents.NewNATSMsg(subject, obj, n.conf.CloudEvents.Mode)
+	}
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &nats.Msg{Subject: subject, Data: body}, nil
+}
+
+func (n *NATS) subjectFor(obj event.Event) (string, error) {
+	var buf bytes.Buffer
+	if err := n.subject.Execute(&buf, subjectData(obj)); err != nil {
+		return "", err
+	}
+	subject := buf.String()
+	for _, t := range strings.Split(subject, ".") {
+		if t == "" {
+			return "", fmt.Errorf("invalid nats subject %q", subject)
+		}
+	}
+	return subject, nil
+}
+
+// subjectData 替换事件字段中subject不允许的字符，字段的值不会改变subject的层级
+func subjectData(e event.Event) event.Event {
+	for _, f := range []*string{&e.Cluster, &e.Environment, &e.Region, &e.Namespace, &e.Kind, &e.Component,
+		&e.Reason, &e.Name, &e.Action, &e.Type, &e.InvolvedKind, &e.InvolvedNamespace, &e.InvolvedName, &e.OwnerKind, &e.OwnerName} {
+		*f = token(*f)
+	}
+	return e
+}
+
+func token(s string) string {
+	if s == "" {
+		return emptyToken
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// Close flushes the buffered messages
+func (n *NATS) Close() {
+	if n.conn == nil {
+		return
+	}
+	if err := n.conn.Flush(); err != nil {
+		zlog.Errorf("nats flush失败，error: %v", err)
+	}
+	n.conn.Close()
+}
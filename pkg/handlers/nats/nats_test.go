@@ -0,0 +1,204 @@
+package nats
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+)
+
+// runServer starts an embedded nats-server with JetStream, port -1 picks a free port
+func runServer(t *testing.T, port int, storeDir string) *server.Server {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: port, JetStream: true, StoreDir: storeDir, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats-server not ready")
+	}
+	return s
+}
+
+func testEvent(kind, namespace, name string) event.Event {
+	return event.Event{
+		Cluster:         "cluster-a",
+		Kind:            kind,
+		Namespace:       namespace,
+		Name:            name,
+		Action:          event.UpdateEvent,
+		UID:             "6f1d2c1e-8f6a-4c7e-9f4b-1f2e3d4c5b6a",
+		ResourceVersion: "100",
+	}
+}
+
+func TestSubject(t *testing.T) {
+	n := new(NATS)
+	if err := n.init(config.NATSConf{Servers: []string{"nats://localhost:4222"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		e    event.Event
+		want string
+	}{
+		{testEvent("pods", "shop", "web-x7k2p"), "k8s.cluster-a.pods.shop"},
+		{testEvent("nodes", "", "node-1"), "k8s.cluster-a.nodes._"},
+		{event.Event{Cluster: "prod.bj", Kind: "events", Namespace: "shop"}, "k8s.prod_bj.events.shop"},
+	} {
+		if got, err := n.subjectFor(tt.e); err != nil || got != tt.want {
+			t.Errorf("subject of %s/%s = %q, %v, want %q", tt.e.Kind, tt.e.Name, got, err, tt.want)
+		}
+	}
+
+	n.init(config.NATSConf{Servers: []string{"nats://localhost:4222"}, Subject: "k8s..{{.Kind}}"})
+	if _, err := n.subjectFor(testEvent("pods", "shop", "web-x7k2p")); err == nil {
+		t.Error("empty subject token should be rejected")
+	}
+	if err := new(NATS).init(config.NATSConf{}); err == nil {
+		t.Error("empty servers should be rejected")
+	}
+}
+
+func TestPublish(t *testing.T) {
+	s := runServer(t, -1, "")
+	defer s.Shutdown()
+
+	sub, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	msgs := make(chan *nats.Msg, 10)
+	if _, err := sub.ChanSubscribe("k8s.cluster-a.pods.>", msgs); err != nil {
+		t.Fatal(err)
+	}
+	sub.Flush()
+
+	c := config.Config{}
+	c.Handlers.NATS = config.NATSConf{Servers: []string{s.ClientURL()}}
+	n := new(NATS)
+	if err := n.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	n.ObjectCreated(testEvent("pods", "shop", "web-x7k2p"))
+
+	select {
+	case msg := <-msgs:
+		var e event.Event
+		if msg.Subject != "k8s.cluster-a.pods.shop" || json.Unmarshal(msg.Data, &e) != nil || e.Name != "web-x7k2p" {
+			t.Fatalf("unexpected message %s: %s", msg.Subject, msg.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+
+	n.conf.CloudEvents = config.CloudEventsConf{Enable: true, Mode: "binary"}
+	n.ObjectCreated(testEvent("pods", "shop", "web-x7k2p"))
+	select {
+	case msg := <-msgs:
+		if msg.Header.Get("ce-type") != "io.k8swatch.pods.update" || msg.Header.Get("ce-source") != "cluster-a" {
+			t.Fatalf("unexpected CloudEvents headers: %v", msg.Header)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("CloudEvent not received")
+	}
+}
+
+func TestJetStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8swatch-nats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := runServer(t, -1, dir)
+	defer s.Shutdown()
+
+	c := config.Config{}
+	c.Handlers.NATS = config.NATSConf{
+		Servers:   []string{s.ClientURL()},
+		JetStream: config.JetStreamConf{Enable: true, Stream: "K8S"},
+	}
+	if err := new(NATS).Init(c); err == nil {
+		t.Fatal("missing stream should be rejected")
+	}
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	js, _ := nc.JetStream()
+	if _, err := js.AddStream(&nats.StreamConfig{Name: "K8S", Subjects: []string{"k8s.>"}, Duplicates: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+
+	n := new(NATS)
+	if err := n.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	// 同一对象版本重复发送时按Nats-Msg-Id去重
+	n.ObjectUpdated(testEvent("pods", "shop", "web-x7k2p"))
+	n.ObjectUpdated(testEvent("pods", "shop", "web-x7k2p"))
+	deleted := testEvent("nodes", "", "node-1")
+	deleted.UID = ""
+	n.ObjectDeleted(deleted)
+
+	info, err := js.StreamInfo("K8S")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State.Msgs != 2 {
+		t.Fatalf("stream has %d messages, want 2", info.State.Msgs)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	s := runServer(t, -1, "")
+	port := s.Addr().(*net.TCPAddr).Port
+
+	c := config.Config{}
+	c.Handlers.NATS = config.NATSConf{Servers: []string{s.ClientURL()}, ReconnectWait: 500 * time.Millisecond}
+	n := new(NATS)
+	if err := n.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	s.Shutdown()
+	s.WaitForShutdown()
+	for deadline := time.Now().Add(5 * time.Second); n.conn.IsConnected() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// 断开期间的消息缓存在client中，重连后发送
+	n.ObjectCreated(testEvent("pods", "shop", "web-x7k2p"))
+
+	s = runServer(t, port, "")
+	defer s.Shutdown()
+	sub, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	msgs := make(chan *nats.Msg, 10)
+	sub.ChanSubscribe("k8s.>", msgs)
+	sub.Flush()
+
+	select {
+	case msg := <-msgs:
+		if msg.Subject != "k8s.cluster-a.pods.shop" {
+			t.Fatalf("unexpected subject %s", msg.Subject)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("buffered message not delivered after reconnect")
+	}
+}
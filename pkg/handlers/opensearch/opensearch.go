@@ -0,0 +1,18 @@
+package opensearch
+
+import (
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/handlers/elasticsearch"
+)
+
+/*
+OpenSearch 写入OpenSearch 1.x/2.x，其bulk及legacy index template接口与ES 7兼容，与elasticsearch handler共用实现
+ILMPolicy为ISM策略的id，由index template为新索引关联
+*/
+type OpenSearch struct {
+	elasticsearch.ElasticClt
+}
+
+func (o *OpenSearch) Init(c config.Config) error {
+	return o.Start(elasticsearch.OpenSearch, c.Handlers.OpenSearch)
+}
@@ -0,0 +1,44 @@
+package opensearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+)
+
+func TestTemplate(t *testing.T) {
+	var template map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/":
+			fmt.Fprint(w, `{"version":{"distribution":"opensearch","number":"2.11.0"}}`)
+		case r.Method == "PUT" && r.URL.Path == "/_template/k8swatch":
+			json.NewDecoder(r.Body).Decode(&template)
+			fmt.Fprint(w, `{"acknowledged":true}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	c := config.Config{}
+	c.Handlers.OpenSearch = config.ElasticsearchConf{
+		Servers:   []string{server.URL},
+		Index:     "k8s-events-%Y.%m.%d",
+		Template:  "k8swatch",
+		ILMPolicy: "k8s-events-30d",
+	}
+	o := new(OpenSearch)
+	if err := o.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+	settings := template["settings"].(map[string]interface{})
+	if settings["plugins.index_state_management.policy_id"] != "k8s-events-30d" || settings["index.lifecycle.name"] != nil {
+		t.Fatalf("unexpected template settings: %v", settings)
+	}
+}
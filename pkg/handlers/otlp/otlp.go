@@ -0,0 +1,439 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/pkg/trace"
+	"github.com/gok8s/k8swatch/utils"
+	"github.com/gok8s/k8swatch/utils/zlog"
+)
+
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http"
+
+	scopeName            = "github.com/gok8s/k8swatch"
+	serviceName          = "k8swatch"
+	defaultBatchSize     = 500
+	defaultFlushInterval = 5 * time.Second
+	defaultTimeout       = 10 * time.Second
+)
+
+// objectNameKeys 是各类对象名称的resource属性，events为involvedObject的kind，其他为资源名
+var objectNameKeys = map[string]string{
+	"Pod":          "k8s.pod.name",
+	"pods":         "k8s.pod.name",
+	"Node":         "k8s.node.name",
+	"nodes":        "k8s.node.name",
+	"Deployment":   "k8s.deployment.name",
+	"deployments":  "k8s.deployment.name",
+	"ReplicaSet":   "k8s.replicaset.name",
+	"replicasets":  "k8s.replicaset.name",
+	"StatefulSet":  "k8s.statefulset.name",
+	"statefulsets": "k8s.statefulset.name",
+	"DaemonSet":    "k8s.daemonset.name",
+	"daemonsets":   "k8s.daemonset.name",
+	"Job":          "k8s.job.name",
+	"jobs":         "k8s.job.name",
+	"CronJob":      "k8s.cronjob.name",
+	"cronjobs":     "k8s.cronjob.name",
+}
+
+var recordsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "k8swatch_otlp_records_total",
+	Help: "Log records and spans exported over OTLP, by signal (logs or traces) and result (success or error).",
+}, []string{"signal", "result"})
+
+func init() {
+	prometheus.MustRegister(recordsTotal)
+}
+
+// item 是待发送的一条日志或一次处理过程的span
+type item struct {
+	resource []*commonpb.KeyValue
+	log      *logspb.LogRecord
+	spans    []*tracepb.Span
+}
+
+/*
+OTLP 将每个事件作为OpenTelemetry LogRecord批量发送，resource属性遵循k8s语义约定
+启用Traces时同时实现trace.Recorder，将k8swatch处理每个对象的过程作为span发送
+*/
+type OTLP struct {
+	conf config.OTLPConf
+	// instance 为spans的service.instance.id，即pod名称
+	instance string
+	client   *http.Client
+	conn     *grpc.ClientConn
+	logs     collogspb.LogsServiceClient
+	traces   coltracepb.TraceServiceClient
+	batcher  *utils.Batcher
+}
+
+func (o *OTLP) Init(c config.Config) error {
+	o.conf = c.Handlers.OTLP
+	if o.conf.Endpoint == "" {
+		return fmt.Errorf("otlp endpoint is empty")
+	}
+	if o.conf.Protocol == "" {
+		o.conf.Protocol = ProtocolGRPC
+	}
+	if o.conf.BatchSize <= 0 {
+		o.conf.BatchSize = defaultBatchSize
+	}
+	if o.conf.FlushInterval <= 0 {
+		o.conf.FlushInterval = defaultFlushInterval
+	}
+	if o.conf.Timeout <= 0 {
+		o.conf.Timeout = defaultTimeout
+	}
+	o.instance, _ = os.Hostname()
+	var err error
+	switch o.conf.Protocol {
+	case ProtocolGRPC:
+		creds := grpc.WithInsecure()
+		if o.conf.TLS.Enable {
+			tlsConfig, err := utils.NewTLSConfig(o.conf.TLS)
+			if err != nil {
+				return err
+			}
+			creds = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+		}
+		// 不阻塞等待连接，collector不可用时由发送时的重试处理
+		if o.conn, err = grpc.Dial(o.conf.Endpoint, creds); err != nil {
+			return err
+		}
+		o.logs = collogspb.NewLogsServiceClient(o.conn)
+		o.traces = coltracepb.NewTraceServiceClient(o.conn)
+	case ProtocolHTTP:
+		o.client = &http.Client{Timeout: o.conf.Timeout}
+		if o.conf.TLS.Enable {
+			tlsConfig, err := utils.NewTLSConfig(o.conf.TLS)
+			if err != nil {
+				return err
+			}
+			o.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+		}
+	default:
+		return fmt.Errorf("unsupported otlp protocol %q, must be grpc or http", o.conf.Protocol)
+	}
+	o.batcher = utils.NewBatcher(o.conf.BatchSize, o.conf.FlushInterval, func(batch []interface{}) {
+		items := make([]item, len(batch))
+		for i, it := range batch {
+			items[i] = it.(item)
+		}
+		o.export(items)
+	})
+	return nil
+}
+
+// Tracing reports whether the processing of objects should be recorded
+func (o *OTLP) Tracing() bool {
+	return o.conf.Traces
+}
+
+func (o *OTLP) ObjectCreated(obj event.Event) {
+	o.add(item{resource: Resource(obj), log: LogRecord(obj)})
+}
+
+func (o *OTLP) ObjectUpdated(obj event.Event) {
+	o.add(item{resource: Resource(obj), log: LogRecord(obj)})
+}
+
+func (o *OTLP) ObjectDeleted(obj event.Event) {
+	o.add(item{resource: Resource(obj), log: LogRecord(obj)})
+}
+
+// Record 将一次处理过程转换为一个trace，root为父span
+func (o *OTLP) Record(e event.Event, root trace.Span, steps []trace.Span) {
+	if !o.conf.Traces {
+		return
+	}
+	traceID, rootID := make([]byte, 16), make([]byte, 8)
+	rand.Read(traceID)
+	rand.Read(rootID)
+	spans := []*tracepb.Span{newSpan(traceID, rootID, nil, root, objectAttributes(e))}
+	for _, step := range steps {
+		spanID := make([]byte, 8)
+		rand.Read(spanID)
+		spans = append(spans, newSpan(traceID, spanID, rootID, step, nil))
+	}
+	resource := attributes(map[string]interface{}{
+		"service.name":        serviceName,
+		"service.instance.id": o.instance,
+		"k8s.cluster.name":    e.Cluster,
+	})
+	o.add(item{resource: resource, spans: spans})
+}
+
+func newSpan(traceID, spanID, parentID []byte, s trace.Span, attrs []*commonpb.KeyValue) *tracepb.Span {
+	return &tracepb.Span{
+		TraceId:           traceID,
+		SpanId:            spanID,
+		ParentSpanId:      parentID,
+		Name:              s.Name,
+		Kind:              tracepb.Span_SPAN_KIND_INTERNAL,
+		StartTimeUnixNano: uint64(s.Start.UnixNano()),
+		EndTimeUnixNano:   uint64(s.End.UnixNano()),
+		Attributes:        attrs,
+	}
+}
+
+func (o *OTLP) add(it item) {
+	if !o.batcher.Add(it) {
+		zlog.Error("otlp handler已关闭，丢弃事件")
+	}
+}
+
+// Resource 返回事件所属对象的resource属性，events为其involvedObject
+func Resource(e event.Event) []*commonpb.KeyValue {
+	kind, namespace, name := objectOf(e)
+	attrs := map[string]interface{}{
+		"k8s.cluster.name":       e.Cluster,
+		"k8s.namespace.name":     namespace,
+		"deployment.environment": e.Environment,
+		"cloud.region":           e.Region,
+	}
+	if key, ok := objectNameKeys[kind]; ok {
+		attrs[key] = name
+	}
+	// events的host为上报事件的节点，pod的host为其所在节点
+	if _, ok := attrs["k8s.node.name"]; !ok && e.Host != "Empty" {
+		attrs["k8s.node.name"] = e.Host
+	}
+	return attributes(attrs)
+}
+
+func objectOf(e event.Event) (kind, namespace, name string) {
+	if e.Kind == "events" {
+		return e.InvolvedKind, e.InvolvedNamespace, e.InvolvedName
+	}
+	return e.Kind, e.Namespace, e.Name
+}
+
+func objectAttributes(e event.Event) []*commonpb.KeyValue {
+	kind, namespace, name := objectOf(e)
+	return attributes(map[string]interface{}{
+		"k8s.object.kind":      kind,
+		"k8s.namespace.name":   namespace,
+		"k8s.object.name":      name,
+		"k8swatch.action":      e.Action,
+		"k8swatch.kind":        e.Kind,
+		"k8s.object.uid":       e.UID,
+		"k8s.resource_version": e.ResourceVersion,
+	})
+}
+
+// LogRecord 将事件转换为LogRecord，时间为事件时间，Warning事件的级别为WARN
+func LogRecord(e event.Event) *logspb.LogRecord {
+	record := &logspb.LogRecord{
+		ObservedTimeUnixNano: uint64(time.Now().UnixNano()),
+		SeverityNumber:       logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+		SeverityText:         e.Type,
+		Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: e.Messages}},
+	}
+	if t := e.Time(); !t.IsZero() {
+		record.TimeUnixNano = uint64(t.UnixNano())
+	}
+	if e.Type == "Warning" {
+		record.SeverityNumber = logspb.SeverityNumber_SEVERITY_NUMBER_WARN
+	}
+	attrs := map[string]interface{}{
+		"k8s.event.reason":    e.Reason,
+		"k8s.event.name":      e.Name,
+		"k8s.event.component": e.Component,
+		"k8swatch.kind":       e.Kind,
+		"k8swatch.action":     e.Action,
+		"enduser.id":          e.User,
+	}
+	if e.Kind == "events" {
+		attrs["k8s.event.count"] = int64(e.Count)
+	}
+	kind, _, name := objectOf(e)
+	attrs["k8s.object.kind"] = kind
+	attrs["k8s.object.name"] = name
+	if e.UpdateContent != "" {
+		attrs["k8swatch.update_content"] = e.UpdateContent
+	}
+	record.Attributes = attributes(attrs)
+	return record
+}
+
+// attributes 按key排序，忽略为空字符串的值
+func attributes(m map[string]interface{}) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var attrs []*commonpb.KeyValue
+	for _, k := range keys {
+		var v *commonpb.AnyValue
+		switch value := m[k].(type) {
+		case string:
+			if value == "" {
+				continue
+			}
+			v = &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}
+		case int64:
+			v = &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: value}}
+		}
+		attrs = append(attrs, &commonpb.KeyValue{Key: k, Value: v})
+	}
+	return attrs
+}
+
+// export 按resource分组，分别发送日志及span
+func (o *OTLP) export(batch []item) {
+	var logs []*logspb.ResourceLogs
+	var spans []*tracepb.ResourceSpans
+	logsIndex, spansIndex := map[string]int{}, map[string]int{}
+	scope := &commonpb.InstrumentationScope{Name: scopeName}
+	var nLogs, nSpans int
+	for _, it := range batch {
+		key := resourceKey(it.resource)
+		if it.log != nil {
+			i, ok := logsIndex[key]
+			if !ok {
+				i = len(logs)
+				logsIndex[key] = i
+				logs = append(logs, &logspb.ResourceLogs{
+					Resource:  &resourcepb.Resource{Attributes: it.resource},
+					ScopeLogs: []*logspb.ScopeLogs{{Scope: scope}},
+				})
+			}
+			logs[i].ScopeLogs[0].LogRecords = append(logs[i].ScopeLogs[0].LogRecords, it.log)
+			nLogs++
+		}
+		if len(it.spans) > 0 {
+			i, ok := spansIndex[key]
+			if !ok {
+				i = len(spans)
+				spansIndex[key] = i
+				spans = append(spans, &tracepb.ResourceSpans{
+					Resource:   &resourcepb.Resource{Attributes: it.resource},
+					ScopeSpans: []*tracepb.ScopeSpans{{Scope: scope}},
+				})
+			}
+			spans[i].ScopeSpans[0].Spans = append(spans[i].ScopeSpans[0].Spans, it.spans...)
+			nSpans += len(it.spans)
+		}
+	}
+	if len(logs) > 0 {
+		o.send("logs", nLogs, &collogspb.ExportLogsServiceRequest{ResourceLogs: logs})
+	}
+	if len(spans) > 0 {
+		o.send("traces", nSpans, &coltracepb.ExportTraceServiceRequest{ResourceSpans: spans})
+	}
+}
+
+func resourceKey(attrs []*commonpb.KeyValue) string {
+	var b strings.Builder
+	for _, kv := range attrs {
+		fmt.Fprintf(&b, "%s=%v,", kv.Key, kv.Value.GetValue())
+	}
+	return b.String()
+}
+
+// send 发送一个export请求，可重试的错误重试3次
+func (o *OTLP) send(signal string, n int, req proto.Message) {
+	err := utils.Retry(func() error {
+		if o.conf.Protocol == ProtocolHTTP {
+			return o.post(signal, req)
+		}
+		return o.call(req)
+	}, "发送otlp "+signal, 3, 1)
+	if err != nil {
+		recordsTotal.WithLabelValues(signal, "error").Add(float64(n))
+		zlog.Error("发送otlp数据失败", zap.String("signal", signal), zap.Int("records", n), zap.Error(err))
+		return
+	}
+	recordsTotal.WithLabelValues(signal, "success").Add(float64(n))
+	zlog.Debugf("发送otlp %s成功，共%d条", signal, n)
+}
+
+func (o *OTLP) call(req proto.Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), o.conf.Timeout)
+	defer cancel()
+	if len(o.conf.Headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(o.conf.Headers))
+	}
+	var err error
+	switch r := req.(type) {
+	case *collogspb.ExportLogsServiceRequest:
+		_, err = o.logs.Export(ctx, r)
+	case *coltracepb.ExportTraceServiceRequest:
+		_, err = o.traces.Export(ctx, r)
+	}
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.Canceled, codes.DeadlineExceeded, codes.Aborted, codes.OutOfRange, codes.Unavailable, codes.DataLoss, codes.ResourceExhausted:
+		return err
+	}
+	return utils.Stop(err)
+}
+
+func (o *OTLP) post(signal string, req proto.Message) error {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return utils.Stop(err)
+	}
+	r, err := http.NewRequest("POST", strings.TrimRight(o.conf.Endpoint, "/")+"/v1/"+signal, bytes.NewReader(body))
+	if err != nil {
+		return utils.Stop(err)
+	}
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range o.conf.Headers {
+		r.Header.Set(k, v)
+	}
+	resp, err := o.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(resp.Body)
+	err = fmt.Errorf("otlp collector返回%d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return err
+	}
+	return utils.Stop(err)
+}
+
+// Close sends the pending records and closes the connection
+func (o *OTLP) Close() {
+	o.batcher.Close()
+	if o.conn != nil {
+		o.conn.Close()
+	}
+}
@@ -0,0 +1,222 @@
+package otlp
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/pkg/trace"
+)
+
+// collector 记录收到的日志及span，errs中的错误依次返回
+type collector struct {
+	collogspb.UnimplementedLogsServiceServer
+
+	mu     sync.Mutex
+	errs   []error
+	calls  int
+	tokens []string
+	logs   []*logspb.ResourceLogs
+	traces []*coltracepb.ExportTraceServiceRequest
+}
+
+func (c *collector) Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return nil, err
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	c.tokens = append(c.tokens, md.Get("authorization")...)
+	c.logs = append(c.logs, req.ResourceLogs...)
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+type traceService struct {
+	coltracepb.UnimplementedTraceServiceServer
+	c *collector
+}
+
+func (t traceService) Export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	t.c.traces = append(t.c.traces, req)
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+func runCollector(t *testing.T) (*collector, string, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &collector{}
+	s := grpc.NewServer()
+	collogspb.RegisterLogsServiceServer(s, c)
+	coltracepb.RegisterTraceServiceServer(s, traceService{c: c})
+	go s.Serve(lis)
+	return c, lis.Addr().String(), s.Stop
+}
+
+func newOTLP(t *testing.T, conf config.OTLPConf) *OTLP {
+	c := config.Config{}
+	conf.FlushInterval = time.Minute
+	c.Handlers.OTLP = conf
+	o := new(OTLP)
+	if err := o.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+var backOff = event.Event{Cluster: "prod", Kind: "events", Namespace: "shop", Name: "web-0.15ceabafa804c94f",
+	Reason: "BackOff", Type: "Warning", Action: event.UpdateEvent, Count: 3, Messages: "Back-off restarting failed container",
+	InvolvedKind: "Pod", InvolvedNamespace: "shop", InvolvedName: "web-0", Host: "node-1", Component: "kubelet",
+	LastTimestamp: time.Date(2019, 10, 18, 23, 1, 30, 0, time.UTC)}
+
+func attrs(kvs []*commonpb.KeyValue) map[string]interface{} {
+	m := map[string]interface{}{}
+	for _, kv := range kvs {
+		switch v := kv.Value.Value.(type) {
+		case *commonpb.AnyValue_StringValue:
+			m[kv.Key] = v.StringValue
+		case *commonpb.AnyValue_IntValue:
+			m[kv.Key] = v.IntValue
+		}
+	}
+	return m
+}
+
+func TestGRPC(t *testing.T) {
+	c, addr, stop := runCollector(t)
+	defer stop()
+	c.errs = []error{status.Error(codes.Unavailable, "starting")}
+	o := newOTLP(t, config.OTLPConf{Endpoint: addr, Headers: map[string]string{"authorization": "Bearer secret"}})
+
+	pod := event.Event{Cluster: "prod", Kind: "pods", Namespace: "shop", Name: "web-0", Host: "node-1", Action: event.CreateEvent}
+	o.ObjectUpdated(backOff)
+	o.ObjectCreated(pod)
+	o.ObjectUpdated(backOff)
+	o.Close()
+
+	// Unavailable时重试
+	if c.calls != 2 || len(c.tokens) != 1 || c.tokens[0] != "Bearer secret" {
+		t.Fatalf("got %d calls with tokens %v", c.calls, c.tokens)
+	}
+	// 同一pod的事件及pod本身属于同一resource
+	if len(c.logs) != 1 {
+		t.Fatalf("got %d resources, want 1", len(c.logs))
+	}
+	resource := attrs(c.logs[0].Resource.Attributes)
+	for k, v := range map[string]string{"k8s.cluster.name": "prod", "k8s.namespace.name": "shop", "k8s.pod.name": "web-0", "k8s.node.name": "node-1"} {
+		if resource[k] != v {
+			t.Errorf("resource %s = %v, want %s", k, resource[k], v)
+		}
+	}
+	records := c.logs[0].ScopeLogs[0].LogRecords
+	if len(records) != 3 {
+		t.Fatalf("got %d log records, want 3", len(records))
+	}
+	r := records[0]
+	if r.SeverityNumber != logspb.SeverityNumber_SEVERITY_NUMBER_WARN || r.Body.GetStringValue() != backOff.Messages ||
+		r.TimeUnixNano != uint64(backOff.LastTimestamp.UnixNano()) {
+		t.Fatalf("unexpected log record: %v", r)
+	}
+	if a := attrs(r.Attributes); a["k8s.event.reason"] != "BackOff" || a["k8s.event.count"] != int64(3) {
+		t.Fatalf("unexpected attributes: %v", a)
+	}
+}
+
+func TestGRPCError(t *testing.T) {
+	c, addr, stop := runCollector(t)
+	defer stop()
+	c.errs = []error{status.Error(codes.InvalidArgument, "bad request")}
+	o := newOTLP(t, config.OTLPConf{Endpoint: addr})
+	o.ObjectUpdated(backOff)
+	o.Close()
+	if c.calls != 1 || len(c.logs) != 0 {
+		t.Fatalf("got %d calls for InvalidArgument, want 1", c.calls)
+	}
+}
+
+func TestTraces(t *testing.T) {
+	c, addr, stop := runCollector(t)
+	defer stop()
+	o := newOTLP(t, config.OTLPConf{Endpoint: addr, Traces: true})
+	if !o.Tracing() {
+		t.Fatal("tracing disabled")
+	}
+	p := trace.Start(o)
+	p.Step("receive")
+	p.Step("enrich")
+	p.Step("dispatch " + trace.HandlerName(o))
+	p.Finish(backOff, "process events")
+	o.Close()
+
+	if len(c.traces) != 1 {
+		t.Fatalf("got %d trace requests, want 1", len(c.traces))
+	}
+	spans := c.traces[0].ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 4 || spans[0].Name != "process events" || spans[3].Name != "dispatch otlp" {
+		t.Fatalf("unexpected spans: %v", spans)
+	}
+	for _, s := range spans[1:] {
+		if string(s.TraceId) != string(spans[0].TraceId) || string(s.ParentSpanId) != string(spans[0].SpanId) {
+			t.Fatalf("span %s is not a child of the root span", s.Name)
+		}
+		if s.StartTimeUnixNano < spans[0].StartTimeUnixNano || s.EndTimeUnixNano > spans[0].EndTimeUnixNano {
+			t.Fatalf("span %s is outside of the root span", s.Name)
+		}
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	var mu sync.Mutex
+	var reqs []*collogspb.ExportLogsServiceRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/logs" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		req := &collogspb.ExportLogsServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		reqs = append(reqs, req)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	o := newOTLP(t, config.OTLPConf{Endpoint: server.URL, Protocol: ProtocolHTTP, BatchSize: 1})
+	o.ObjectUpdated(backOff)
+	o.ObjectDeleted(event.Event{Cluster: "prod", Kind: "nodes", Name: "node-2", Action: event.DeleteEvent})
+	o.Close()
+	if len(reqs) != 2 {
+		t.Fatalf("got %d requests, want 2", len(reqs))
+	}
+	if a := attrs(reqs[1].ResourceLogs[0].Resource.Attributes); a["k8s.node.name"] != "node-2" {
+		t.Fatalf("unexpected resource: %v", a)
+	}
+}
@@ -1,8 +1,15 @@
 package rabbitmq
 
 import (
+	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
 	"github.com/gok8s/k8swatch/utils"
 
 	"go.uber.org/zap"
@@ -11,14 +18,36 @@ import (
 	"github.com/gok8s/k8swatch/utils/zlog"
 	"k8s.io/apimachinery/pkg/util/rand"
 
+	"github.com/gok8s/k8swatch/pkg/cloudevents"
 	"github.com/gok8s/k8swatch/pkg/config"
 	"github.com/gok8s/k8swatch/pkg/event"
 )
 
+const (
+	defaultConfirmTimeout = 5 * time.Second
+	defaultReconnectWait  = 5 * time.Second
+)
+
+/*
+RabbitMq 将事件发布到exchange，routing key由模板按事件生成
+channel处于confirm模式，broker确认后才视为发送成功
+连接或channel关闭时由watch依次尝试Servers中的各个地址重连
+*/
 type RabbitMq struct {
-	conf config.RabbitMqConf
-	conn *amqp.Connection
-	ch   *amqp.Channel
+	conf      config.RabbitMqConf
+	routeKey  *template.Template
+	tlsConfig *tls.Config
+
+	// mu 保护以下字段，发送和重连互斥，confirm按发送顺序返回
+	mu       sync.Mutex
+	conn     *amqp.Connection
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+	// seq 是当前channel上最后一条消息的delivery tag
+	seq uint64
+	// next 是下次连接时首先尝试的server
+	next   int
+	closed bool
 }
 
 type RabbitMqMsg struct {
@@ -27,8 +56,23 @@ type RabbitMqMsg struct {
 }
 
 func (r *RabbitMq) Init(c config.Config) (err error) {
-	r.conf = c.Handlers.RabbitMq
-	err = r.getConnection()
+	if err = r.init(c.Handlers.RabbitMq); err != nil {
+		return err
+	}
+	if r.conf.TLS.Enable {
+		if r.tlsConfig, err = utils.NewTLSConfig(r.conf.TLS); err != nil {
+			return err
+		}
+	}
+	r.next = rand.IntnRange(0, len(r.conf.Servers))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 0; i < 3; i++ {
+		if err = r.connect(); err == nil {
+			break
+		}
+		zlog.Errorf("初始化Connection失败，会重试3遍，现在是重试第%d遍!! 错误:  %v", i, err)
+	}
 	if err != nil {
 		zlog.Errorf("初始化Connection失败，error: %v", err)
 		return err
@@ -37,60 +81,87 @@ func (r *RabbitMq) Init(c config.Config) (err error) {
 	return nil
 }
 
-func (r *RabbitMq) ObjectCreated(obj event.Event) {
-	msgbytes, err := json.Marshal(obj)
-	if err != nil {
-		zlog.Error("将KBEvent解析为json失败", zap.Error(err))
+// init checks the options that don't need a connection
+func (r *RabbitMq) init(conf config.RabbitMqConf) (err error) {
+	r.conf = conf
+	if len(r.conf.Servers) == 0 {
+		zlog.Error("rmc.conf.RabbitMQHosts is empty")
+		return fmt.Errorf("rmc.conf.RabbitMQHosts is empty")
+	}
+	if r.conf.CloudEvents.Enable {
+		if err = cloudevents.ValidMode(r.conf.CloudEvents.Mode); err != nil {
+			return err
+		}
+	}
+	if r.conf.ConfirmTimeout <= 0 {
+		r.conf.ConfirmTimeout = defaultConfirmTimeout
 	}
-	r.Publish(msgbytes)
+	if r.conf.ReconnectWait <= 0 {
+		r.conf.ReconnectWait = defaultReconnectWait
+	}
+	if r.routeKey, err = template.New("routeKey").Option("missingkey=error").Parse(r.conf.RouteKey); err != nil {
+		return fmt.Errorf("invalid rabbitmq routeKey template %q: %v", r.conf.RouteKey, err)
+	}
+	return nil
+}
+
+func (r *RabbitMq) ObjectCreated(obj event.Event) {
+	r.send(obj)
 }
 
 func (r *RabbitMq) ObjectUpdated(obj event.Event) {
-	msgbytes, err := json.Marshal(obj)
-	if err != nil {
-		zlog.Error("将KBEvent解析为json失败", zap.Error(err))
-	}
-	r.Publish(msgbytes)
+	r.send(obj)
 }
 
 func (r *RabbitMq) ObjectDeleted(obj event.Event) {
-	msgbytes, err := json.Marshal(obj)
+	r.send(obj)
+}
+
+// send encodes obj as JSON, or as a CloudEvent with the AMQP binding, and publishes it
+func (r *RabbitMq) send(obj event.Event) {
+	msg, routeKey, err := r.message(obj)
 	if err != nil {
 		zlog.Error("将KBEvent解析为json失败", zap.Error(err))
+		return
 	}
-	r.Publish(msgbytes)
+	r.Publish(msg, routeKey, obj)
 }
 
-func (rmc *RabbitMq) Publish(msgBody []byte) {
-	if rmc.ch == nil {
-		zlog.Info("channel已关闭，将重连...")
-		err := rmc.getChannel()
-		if err != nil {
-			zlog.Errorf("重连Channel失败，无法发送消息,error is: %v", err)
-			return
-		}
+// message builds the publishing of obj with kind, action and cluster headers, and its routing key
+func (r *RabbitMq) message(obj event.Event) (msg amqp.Publishing, routeKey string, err error) {
+	if r.routeKey == nil {
+		return msg, "", fmt.Errorf("rabbitmq handler未初始化")
 	}
-	var msg event.Event
-	json.Unmarshal(msgBody, &msg)
-
-	var err error
-	utils.Retry(func() error {
-		err = rmc.ch.Publish(
-			rmc.conf.TopicName, // exchange
-			rmc.conf.RouteKey,  // routing key
-			false,              // mandatory
-			false,              // immediate
-			amqp.Publishing{
-				ContentType:  "text/plain",
-				Body:         msgBody,
-				DeliveryMode: 2,
-			})
-		return err
-	}, "发送MQ消息", 5, 10)
+	if r.conf.CloudEvents.Enable {
+		msg, err = cloudevents.NewAMQPPublishing(obj, r.conf.CloudEvents.Mode)
+	} else {
+		msg.ContentType = "text/plain"
+		msg.Body, err = json.Marshal(obj)
+	}
+	if err != nil {
+		return msg, "", err
+	}
+	msg.DeliveryMode = amqp.Persistent
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
+	msg.Headers["kind"] = obj.Kind
+	msg.Headers["action"] = obj.Action
+	msg.Headers["cluster"] = obj.Cluster
 
-	//TODO client重连
+	var buf bytes.Buffer
+	if err = r.routeKey.Execute(&buf, obj); err != nil {
+		return msg, "", err
+	}
+	return msg, buf.String(), nil
+}
+
+func (rmc *RabbitMq) Publish(publishing amqp.Publishing, routeKey string, msg event.Event) {
+	err := utils.Retry(func() error {
+		return rmc.publish(publishing, routeKey)
+	}, "发送MQ消息", 5, 10)
 	if err != nil {
-		zlog.Errorf("发送消息失败,消息为:%s ,错误为： %v", msgBody, err)
+		zlog.Errorf("发送消息失败,消息为:%s ,错误为： %v", publishing.Body, err)
 		return
 	}
 	zlog.Info("发送mq消息成功 "+msg.Messages,
@@ -98,107 +169,216 @@ func (rmc *RabbitMq) Publish(msgBody []byte) {
 		zap.String("name", msg.Name),
 		zap.String("action", msg.Action),
 		zap.String("kind", msg.Kind),
+		zap.String("routeKey", routeKey),
 	)
 }
 
-func (rmc *RabbitMq) getConnection() (err error) {
-	var mqhost string
-	if len(rmc.conf.Servers) > 1 {
-		mqhost = rmc.conf.Servers[rand.IntnRange(0, len(rmc.conf.Servers))]
-	} else if len(rmc.conf.Servers) <= 0 {
-		zlog.Error("rmc.conf.RabbitMQHosts is empty")
-		return fmt.Errorf("rmc.conf.RabbitMQHosts is empty")
-	} else {
-		mqhost = rmc.conf.Servers[0]
+// publish sends one message and waits for the broker to confirm it
+func (rmc *RabbitMq) publish(publishing amqp.Publishing, routeKey string) error {
+	rmc.mu.Lock()
+	defer rmc.mu.Unlock()
+	if rmc.ch == nil {
+		return fmt.Errorf("MQ未连接，正在重连")
 	}
-	connstr := fmt.Sprintf("amqp://%s:%s@%s/%s", rmc.conf.UserName, rmc.conf.Password, mqhost, rmc.conf.Vhost)
-	for i := 0; i < 3; i++ {
-		rmc.conn, err = amqp.Dial(connstr)
-		if err != nil {
-			zlog.Errorf("不能连接到MQ: %s,会重试3遍，现在是重试第%d遍!! 错误:  %v", connstr, i, err)
-			if i == 2 {
-				return err
+	err := rmc.ch.Publish(
+		rmc.conf.TopicName, // exchange
+		routeKey,           // routing key
+		false,              // mandatory
+		false,              // immediate
+		publishing)
+	if err != nil {
+		return err
+	}
+	rmc.seq++
+	timeout := time.After(rmc.conf.ConfirmTimeout)
+	for {
+		select {
+		case c, ok := <-rmc.confirms:
+			if !ok {
+				return fmt.Errorf("channel已关闭，未收到MQ的确认")
 			}
-			continue
+			// 之前超时的消息的确认
+			if c.DeliveryTag < rmc.seq {
+				continue
+			}
+			if !c.Ack {
+				return fmt.Errorf("MQ拒绝了消息(nack)")
+			}
+			return nil
+		case <-timeout:
+			return fmt.Errorf("等待MQ确认超时(%v)", rmc.conf.ConfirmTimeout)
 		}
-		break
 	}
-	return nil
 }
 
-func (rmc *RabbitMq) getChannel() (err error) {
-
-	for i := 0; i < 3; i++ {
-		rmc.ch, err = rmc.conn.Channel()
-		if err != nil {
-			zlog.Errorf("初始化channel失败，会重试3遍，现在是重试第%d遍!! 错误:  %v", i, err)
-			rmc.getConnection()
-			if i == 2 {
-				return err
-			}
+// connect 从next开始依次尝试各个server，连接成功后声明exchange及queue并开启confirm模式，调用方需持有mu
+func (rmc *RabbitMq) connect() (err error) {
+	for i := 0; i < len(rmc.conf.Servers); i++ {
+		mqhost := rmc.conf.Servers[rmc.next]
+		rmc.next = (rmc.next + 1) % len(rmc.conf.Servers)
+		if err = rmc.dial(mqhost); err != nil {
+			zlog.Errorf("不能连接到MQ: %s, 错误:  %v", mqhost, err)
 			continue
 		}
+		if err = rmc.getChannel(); err != nil {
+			zlog.Errorf("初始化channel失败，MQ: %s, 错误:  %v", mqhost, err)
+			rmc.conn.Close()
+			rmc.conn, rmc.ch = nil, nil
+			continue
+		}
+		zlog.Infof("已连接到MQ: %s", mqhost)
+		go rmc.watch(rmc.conn.NotifyClose(make(chan *amqp.Error, 1)), rmc.ch.NotifyClose(make(chan *amqp.Error, 1)))
+		return nil
+	}
+	return err
+}
 
-		_, err = rmc.ch.QueueDeclarePassive(rmc.conf.RouteKey,
-			true,
-			false,
-			false,
-			false,
-			nil,
-		)
-		if err != nil {
-			_, err = rmc.ch.QueueDeclare(
-				rmc.conf.RouteKey,
-				true,
-				false,
-				false,
-				false,
-				nil,
-			)
-			if err != nil {
-				zlog.Errorf("声明queue:%s 失败，会重试3遍，现在是重试第%d遍,错误:%v", rmc.conf.RouteKey, i, err)
-				if i == 2 {
-					return err
-				}
-				continue
-			} else {
-				zlog.Debugf("声明queue:%s成功", rmc.conf.RouteKey)
-			}
+func (rmc *RabbitMq) dial(mqhost string) (err error) {
+	scheme := "amqp"
+	if rmc.tlsConfig != nil {
+		scheme = "amqps"
+	}
+	connstr := fmt.Sprintf("%s://%s:%s@%s/%s", scheme, rmc.conf.UserName, rmc.conf.Password, mqhost, rmc.conf.Vhost)
+	if rmc.tlsConfig != nil {
+		rmc.conn, err = amqp.DialTLS(connstr, rmc.tlsConfig)
+	} else {
+		rmc.conn, err = amqp.Dial(connstr)
+	}
+	return err
+}
+
+/*
+watch 等待连接或channel关闭，关闭后按ReconnectWait依次尝试各个server直到重连成功
+channel被broker关闭(如exchange不存在)时同样重建连接
+*/
+func (rmc *RabbitMq) watch(connClosed, chClosed chan *amqp.Error) {
+	var reason *amqp.Error
+	select {
+	case reason = <-connClosed:
+	case reason = <-chClosed:
+	}
+	rmc.mu.Lock()
+	if rmc.closed {
+		rmc.mu.Unlock()
+		return
+	}
+	zlog.Errorf("MQ连接已断开，将重连，原因: %v", reason)
+	if rmc.conn != nil {
+		rmc.conn.Close()
+	}
+	rmc.conn, rmc.ch = nil, nil
+	rmc.mu.Unlock()
+
+	for {
+		rmc.mu.Lock()
+		if rmc.closed {
+			rmc.mu.Unlock()
+			return
+		}
+		err := rmc.connect()
+		rmc.mu.Unlock()
+		if err == nil {
+			return
 		}
+		zlog.Errorf("MQ重连失败，将在%v后重试，错误: %v", rmc.conf.ReconnectWait, err)
+		time.Sleep(rmc.conf.ReconnectWait)
+	}
+}
 
-		err = rmc.ch.ExchangeDeclarePassive(
-			rmc.conf.TopicName,    // name
-			rmc.conf.ExchangeType, // type
-			rmc.conf.Durable,      // durable
-			false,                 // auto-deleted
-			false,                 // internal
-			false,                 // no-wait
-			nil,                   // arguments
-		)
-		if err != nil {
-			err = rmc.ch.ExchangeDeclare(
-				rmc.conf.TopicName,    // name
-				rmc.conf.ExchangeType, // type
-				rmc.conf.Durable,      // durable
-				false,                 // auto-deleted
-				false,                 // internal
-				false,                 // no-wait
-				nil,                   // arguments
-			)
-			if err != nil {
-				zlog.Errorf("声明Exchange失败:,会重试3遍，现在是重试第%d遍!! 错误:  %v", i, err)
-				if i == 2 {
-					return err
-				}
-				continue
-			}
+// getChannel 在当前连接上打开confirm模式的channel并声明exchange，routeKey不是模板时声明同名queue
+func (rmc *RabbitMq) getChannel() (err error) {
+	if !strings.Contains(rmc.conf.RouteKey, "{{") {
+		if err = rmc.declareQueue(); err != nil {
+			zlog.Errorf("声明queue:%s 失败,错误:%v", rmc.conf.RouteKey, err)
+			return err
 		}
-		break
 	}
+	if err = rmc.declareExchange(); err != nil {
+		zlog.Errorf("声明Exchange失败:, 错误:  %v", err)
+		return err
+	}
+
+	if rmc.ch, err = rmc.conn.Channel(); err != nil {
+		return err
+	}
+	if err = rmc.ch.Confirm(false); err != nil {
+		return err
+	}
+	rmc.confirms = rmc.ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	rmc.seq = 0
 	return nil
 }
 
+// declareQueue 声明失败的passive会关闭channel，因此每次声明使用新的channel
+func (rmc *RabbitMq) declareQueue() error {
+	ch, err := rmc.conn.Channel()
+	if err != nil {
+		return err
+	}
+	_, err = ch.QueueDeclarePassive(rmc.conf.RouteKey,
+		true,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err == nil {
+		return ch.Close()
+	}
+	if ch, err = rmc.conn.Channel(); err != nil {
+		return err
+	}
+	defer ch.Close()
+	_, err = ch.QueueDeclare(
+		rmc.conf.RouteKey,
+		true,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err == nil {
+		zlog.Debugf("声明queue:%s成功", rmc.conf.RouteKey)
+	}
+	return err
+}
+
+func (rmc *RabbitMq) declareExchange() error {
+	ch, err := rmc.conn.Channel()
+	if err != nil {
+		return err
+	}
+	err = ch.ExchangeDeclarePassive(
+		rmc.conf.TopicName,    // name
+		rmc.conf.ExchangeType, // type
+		rmc.conf.Durable,      // durable
+		false,                 // auto-deleted
+		false,                 // internal
+		false,                 // no-wait
+		nil,                   // arguments
+	)
+	if err == nil {
+		return ch.Close()
+	}
+	if ch, err = rmc.conn.Channel(); err != nil {
+		return err
+	}
+	defer ch.Close()
+	return ch.ExchangeDeclare(
+		rmc.conf.TopicName,    // name
+		rmc.conf.ExchangeType, // type
+		rmc.conf.Durable,      // durable
+		false,                 // auto-deleted
+		false,                 // internal
+		false,                 // no-wait
+		nil,                   // arguments
+	)
+}
+
 func (rmc *RabbitMq) Close() {
+	rmc.mu.Lock()
+	defer rmc.mu.Unlock()
+	rmc.closed = true
 	if rmc.ch != nil {
 		rmc.ch.Close()
 	}
@@ -25,3 +25,31 @@ func TestObjectCreated(t *testing.T) {
 	r.ObjectCreated(obj)
 	r.Close()
 }
+
+func TestMessage(t *testing.T) {
+	r := new(RabbitMq)
+	if err := r.init(config.RabbitMqConf{Servers: []string{"localhost:5672"}, RouteKey: "events.{{.Namespace}}.{{.Reason}}"}); err != nil {
+		t.Fatal(err)
+	}
+	obj := event.Event{Cluster: "cluster-a", Namespace: "shop", Kind: "events", Reason: "BackOff", Action: event.CreateEvent}
+	msg, routeKey, err := r.message(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routeKey != "events.shop.BackOff" || msg.DeliveryMode != 2 {
+		t.Fatalf("unexpected routing key %q or delivery mode %d", routeKey, msg.DeliveryMode)
+	}
+	if msg.Headers["kind"] != "events" || msg.Headers["action"] != event.CreateEvent || msg.Headers["cluster"] != "cluster-a" {
+		t.Fatalf("unexpected headers: %v", msg.Headers)
+	}
+
+	r.conf.CloudEvents = config.CloudEventsConf{Enable: true, Mode: "binary"}
+	msg, _, _ = r.message(obj)
+	if msg.Headers["cloudEvents:type"] != "io.k8swatch.events.create" || msg.Headers["kind"] != "events" {
+		t.Fatalf("unexpected CloudEvents headers: %v", msg.Headers)
+	}
+
+	if err := new(RabbitMq).init(config.RabbitMqConf{Servers: []string{"localhost:5672"}, RouteKey: "events.{{.Namespace"}); err == nil {
+		t.Fatal("invalid routeKey template should be rejected")
+	}
+}
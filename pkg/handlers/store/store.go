@@ -0,0 +1,559 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/utils"
+	"github.com/gok8s/k8swatch/utils/zlog"
+)
+
+const (
+	defaultPath          = "/var/lib/k8swatch/events.db"
+	defaultMaxAge        = 7 * 24 * time.Hour
+	defaultMaxSize       = 1024
+	defaultBatchSize     = 500
+	defaultFlushInterval = time.Second
+	defaultLimit         = 100
+
+	pruneInterval = time.Minute
+	// pruneBatch 每个事务最多删除的事件数，避免长时间阻塞写入
+	pruneBatch = 1000
+	// 超出MaxSize时删除到MaxSize的90%，避免每次清理只删除少量事件
+	pruneTarget = 0.9
+)
+
+var (
+	eventsBucket  = []byte("events")
+	objectsBucket = []byte("objects")
+)
+
+// index 以value+0x00+主键为key，同一value下的事件按时间排序
+type index struct {
+	bucket []byte
+	value  func(e event.Event) string
+}
+
+var (
+	namespaceIndex = index{[]byte("namespace"), func(e event.Event) string { return e.Namespace }}
+	involvedIndex  = index{[]byte("involved"), involvedValue}
+	reasonIndex    = index{[]byte("reason"), func(e event.Event) string { return e.Reason }}
+	indexes        = []index{namespaceIndex, involvedIndex, reasonIndex}
+)
+
+// involvedValue 不包含namespace，查询时可以不指定，不同namespace的同名对象由Query.match过滤
+func involvedValue(e event.Event) string {
+	if e.InvolvedKind == "" && e.InvolvedName == "" {
+		return ""
+	}
+	return e.InvolvedKind + "/" + e.InvolvedName
+}
+
+var (
+	eventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8swatch_store_events_total",
+		Help: "Events written to the embedded store, by result (success or error).",
+	}, []string{"result"})
+	prunedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8swatch_store_pruned_total",
+		Help: "Events removed from the embedded store, by reason (age or size).",
+	}, []string{"reason"})
+	sizeBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8swatch_store_size_bytes",
+		Help: "Bytes used by events and indexes in the embedded store.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(eventsTotal, prunedTotal, sizeBytes)
+}
+
+/*
+Store 将k8s events保存在本地的bbolt文件中，供没有elasticsearch的小集群通过/events查询
+events以事件时间+序号为key，同一k8s event(uid)的新版本替换旧版本，namespace、involved object、reason各有索引
+事件按BatchSize、FlushInterval批量写入，每分钟删除超过MaxAge的事件，数据超过MaxSize时删除最早的事件
+*/
+type Store struct {
+	conf config.StoreConf
+	db   *bolt.DB
+
+	batcher *utils.Batcher
+
+	mu     sync.Mutex
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Query 为空的条件不限制，结果默认按事件时间从新到旧排列
+type Query struct {
+	Cluster           string
+	Namespace         string
+	InvolvedKind      string
+	InvolvedNamespace string
+	InvolvedName      string
+	Reason            string
+	Type              string
+	// Labels 按集群的labels过滤
+	Labels map[string]string
+	// Since、Until 事件时间的范围，包含两端
+	Since time.Time
+	Until time.Time
+	// Ascending 为true时从旧到新排列
+	Ascending bool
+	// Cursor 为上一页返回的next，从上一页的最后一个事件之后继续
+	Cursor string
+	// Limit 默认100
+	Limit int
+}
+
+func (s *Store) Init(c config.Config) error {
+	if err := s.Open(c.Handlers.Store); err != nil {
+		return err
+	}
+	s.batcher = utils.NewBatcher(s.conf.BatchSize, s.conf.FlushInterval, func(batch []interface{}) {
+		events := make([]event.Event, len(batch))
+		for i, e := range batch {
+			events[i] = e.(event.Event)
+		}
+		s.save(events)
+	})
+	s.stopCh = make(chan struct{})
+	s.wg.Add(1)
+	go s.pruneEvery(pruneInterval, s.stopCh)
+	return nil
+}
+
+// Open opens the data file and creates the buckets, without starting the writer
+func (s *Store) Open(conf config.StoreConf) error {
+	s.conf = conf
+	if s.conf.Path == "" {
+		s.conf.Path = defaultPath
+	}
+	if s.conf.MaxAge <= 0 {
+		s.conf.MaxAge = defaultMaxAge
+	}
+	if s.conf.MaxSize <= 0 {
+		s.conf.MaxSize = defaultMaxSize
+	}
+	if s.conf.BatchSize <= 0 {
+		s.conf.BatchSize = defaultBatchSize
+	}
+	if s.conf.FlushInterval <= 0 {
+		s.conf.FlushInterval = defaultFlushInterval
+	}
+	if err := os.MkdirAll(filepath.Dir(s.conf.Path), 0755); err != nil {
+		return err
+	}
+	db, err := bolt.Open(s.conf.Path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("打开事件存储%s失败: %v", s.conf.Path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{eventsBucket, objectsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		for _, idx := range indexes {
+			if _, err := tx.CreateBucketIfNotExists(idx.bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+	s.db = db
+	return nil
+}
+
+func (s *Store) ObjectCreated(obj event.Event) {
+	s.add(obj)
+}
+
+func (s *Store) ObjectUpdated(obj event.Event) {
+	s.add(obj)
+}
+
+// ObjectDeleted 忽略k8s events过期后的删除，保留其历史
+func (s *Store) ObjectDeleted(obj event.Event) {
+}
+
+// add 只保存k8s events及tracker生成的事件
+func (s *Store) add(obj event.Event) {
+	if obj.Kind != "events" {
+		return
+	}
+	if !s.batcher.Add(obj) {
+		zlog.Error("store handler已关闭，丢弃事件", zap.String("name", obj.Name))
+	}
+}
+
+func (s *Store) save(batch []event.Event) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := s.Put(batch...); err != nil {
+		eventsTotal.WithLabelValues("error").Add(float64(len(batch)))
+		zlog.Error("写入事件存储失败", zap.Int("events", len(batch)), zap.Error(err))
+		return
+	}
+	eventsTotal.WithLabelValues("success").Add(float64(len(batch)))
+}
+
+// Put writes the events in one transaction, replacing the previous versions of the same k8s events
+func (s *Store) Put(events ...event.Event) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, e := range events {
+			if err := put(tx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func put(tx *bolt.Tx, e event.Event) error {
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	objects := tx.Bucket(objectsBucket)
+	object := objectKey(e)
+	if old := objects.Get(object); old != nil {
+		if err := remove(tx, append([]byte(nil), old...)); err != nil {
+			return err
+		}
+	}
+	events := tx.Bucket(eventsBucket)
+	seq, err := events.NextSequence()
+	if err != nil {
+		return err
+	}
+	t := e.Time()
+	if t.IsZero() {
+		t = time.Now()
+	}
+	k := key(t, seq)
+	if err := events.Put(k, value); err != nil {
+		return err
+	}
+	if err := objects.Put(object, k); err != nil {
+		return err
+	}
+	for _, idx := range indexes {
+		if v := idx.value(e); v != "" {
+			if err := tx.Bucket(idx.bucket).Put(indexKey(v, k), []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// remove 删除主键为k的事件及其索引
+func remove(tx *bolt.Tx, k []byte) error {
+	events := tx.Bucket(eventsBucket)
+	value := events.Get(k)
+	if value == nil {
+		return nil
+	}
+	var e event.Event
+	if err := json.Unmarshal(value, &e); err != nil {
+		return events.Delete(k)
+	}
+	for _, idx := range indexes {
+		if v := idx.value(e); v != "" {
+			if err := tx.Bucket(idx.bucket).Delete(indexKey(v, k)); err != nil {
+				return err
+			}
+		}
+	}
+	objects := tx.Bucket(objectsBucket)
+	object := objectKey(e)
+	if bytes.Equal(objects.Get(object), k) {
+		if err := objects.Delete(object); err != nil {
+			return err
+		}
+	}
+	return events.Delete(k)
+}
+
+// objectKey 标识同一个k8s event，k8swatch生成的事件没有uid，使用事件id
+func objectKey(e event.Event) []byte {
+	if e.UID != "" {
+		return []byte(e.Cluster + "/" + e.UID)
+	}
+	return []byte(e.Cluster + "/" + e.ID())
+}
+
+// key 为事件时间(纳秒，大端)加序号，按时间排序且不会重复
+func key(t time.Time, seq uint64) []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(k[8:], seq)
+	return k
+}
+
+func keyTime(k []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(k[:8])))
+}
+
+func indexKey(value string, k []byte) []byte {
+	return append(append([]byte(value), 0), k...)
+}
+
+// ErrInvalidCursor 是Query.Cursor无法解析时的错误
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+/*
+Search 返回符合条件的事件，最多Limit个，还有更多事件时next为下一页的cursor
+按involved object、reason、namespace的顺序选用一个索引，都未指定时按时间遍历所有事件，其他条件逐个事件过滤
+*/
+func (s *Store) Search(q Query) (items []event.Event, next string, err error) {
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+	var after []byte
+	if q.Cursor != "" {
+		if after, err = base64.RawURLEncoding.DecodeString(q.Cursor); err != nil || len(after) != 16 {
+			return nil, "", ErrInvalidCursor
+		}
+	}
+	bucket, prefix := eventsBucket, []byte(nil)
+	switch {
+	case q.InvolvedKind != "" && q.InvolvedName != "":
+		bucket = involvedIndex.bucket
+		prefix = indexKey(involvedValue(event.Event{InvolvedKind: q.InvolvedKind, InvolvedName: q.InvolvedName}), nil)
+	case q.Reason != "":
+		bucket, prefix = reasonIndex.bucket, indexKey(q.Reason, nil)
+	case q.Namespace != "":
+		bucket, prefix = namespaceIndex.bucket, indexKey(q.Namespace, nil)
+	}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		events := tx.Bucket(eventsBucket)
+		var err error
+		each(tx.Bucket(bucket), prefix, q, after, func(k []byte) bool {
+			value := events.Get(k)
+			if value == nil {
+				return true
+			}
+			var e event.Event
+			if err = json.Unmarshal(value, &e); err != nil {
+				return false
+			}
+			if !q.match(e) {
+				return true
+			}
+			// 多取一个用于判断是否还有下一页
+			if len(items) == q.Limit {
+				next = base64.RawURLEncoding.EncodeToString(after)
+				return false
+			}
+			items = append(items, e)
+			after = append(after[:0:0], k...)
+			return true
+		})
+		return err
+	})
+	return items, next, err
+}
+
+func (q Query) match(e event.Event) bool {
+	for _, f := range [][2]string{
+		{q.Cluster, e.Cluster},
+		{q.Namespace, e.Namespace},
+		{q.InvolvedKind, e.InvolvedKind},
+		{q.InvolvedNamespace, e.InvolvedNamespace},
+		{q.InvolvedName, e.InvolvedName},
+		{q.Reason, e.Reason},
+		{q.Type, e.Type},
+	} {
+		if f[0] != "" && f[0] != f[1] {
+			return false
+		}
+	}
+	for k, v := range q.Labels {
+		if e.ClusterLabels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// each 按时间遍历prefix下[Since, Until]内的主键，after不为空时从其后开始，fn返回false时停止
+func each(b *bolt.Bucket, prefix []byte, q Query, after []byte, fn func(k []byte) bool) {
+	c := b.Cursor()
+	if q.Ascending {
+		lower := make([]byte, 16)
+		if !q.Since.IsZero() {
+			lower = key(q.Since, 0)
+		}
+		if after != nil && bytes.Compare(after, lower) > 0 {
+			lower = after
+		}
+		for k, _ := c.Seek(append(append([]byte(nil), prefix...), lower...)); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			pk := k[len(prefix):]
+			if after != nil && bytes.Compare(pk, after) <= 0 {
+				continue
+			}
+			if !q.Until.IsZero() && keyTime(pk).After(q.Until) {
+				return
+			}
+			if !fn(pk) {
+				return
+			}
+		}
+		return
+	}
+	upper := bytes.Repeat([]byte{0xff}, 16)
+	if !q.Until.IsZero() {
+		upper = key(q.Until.Add(time.Nanosecond), 0)
+	}
+	if after != nil && bytes.Compare(after, upper) < 0 {
+		upper = after
+	}
+	k, _ := c.Seek(append(append([]byte(nil), prefix...), upper...))
+	if k == nil {
+		k, _ = c.Last()
+	} else {
+		k, _ = c.Prev()
+	}
+	for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Prev() {
+		pk := k[len(prefix):]
+		if !q.Since.IsZero() && keyTime(pk).Before(q.Since) {
+			return
+		}
+		if !fn(pk) {
+			return
+		}
+	}
+}
+
+// pruneEvery 启动时及每个interval清理一次
+func (s *Store) pruneEvery(interval time.Duration, stopCh <-chan struct{}) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Prune(); err != nil {
+			zlog.Error("清理事件存储失败", zap.Error(err))
+		}
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// Prune removes the events older than MaxAge, then the oldest events while the data is larger than MaxSize
+func (s *Store) Prune() error {
+	n, err := s.deleteOldest(-1, time.Now().Add(-s.conf.MaxAge))
+	prunedTotal.WithLabelValues("age").Add(float64(n))
+	if err != nil {
+		return err
+	}
+	size, count, err := s.usage()
+	if err != nil {
+		return err
+	}
+	maxSize := s.conf.MaxSize << 20
+	if size > maxSize && count > 0 {
+		// 按事件的平均大小估算需要删除的数量
+		target := int(float64(maxSize) * pruneTarget)
+		n, err = s.deleteOldest((size-target)*count/size+1, time.Time{})
+		prunedTotal.WithLabelValues("size").Add(float64(n))
+		zlog.Infof("事件存储使用%dMB，超过%dMB，删除最早的%d个事件", size>>20, s.conf.MaxSize, n)
+		if err != nil {
+			return err
+		}
+		size, _, err = s.usage()
+	}
+	sizeBytes.Set(float64(size))
+	return err
+}
+
+// usage 为事件及索引实际使用的字节数和事件数，文件本身不会因删除而变小
+func (s *Store) usage() (size, count int, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			stats := b.Stats()
+			size += stats.BranchInuse + stats.LeafInuse + stats.InlineBucketInuse
+			if bytes.Equal(name, eventsBucket) {
+				count = stats.KeyN
+			}
+			return nil
+		})
+	})
+	return size, count, err
+}
+
+// deleteOldest 删除最早的limit个事件(小于0时不限)，before不为0时只删除之前的事件
+func (s *Store) deleteOldest(limit int, before time.Time) (int, error) {
+	deleted := 0
+	for limit < 0 || deleted < limit {
+		var keys [][]byte
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			c := tx.Bucket(eventsBucket).Cursor()
+			for k, _ := c.First(); k != nil && len(keys) < pruneBatch; k, _ = c.Next() {
+				if limit >= 0 && deleted+len(keys) >= limit {
+					break
+				}
+				if !before.IsZero() && !keyTime(k).Before(before) {
+					break
+				}
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			for _, k := range keys {
+				if err := remove(tx, k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += len(keys)
+		if len(keys) < pruneBatch {
+			break
+		}
+	}
+	return deleted, nil
+}
+
+// Close writes the pending events and closes the data file
+func (s *Store) Close() {
+	s.batcher.Close()
+	s.mu.Lock()
+	stopCh := s.stopCh
+	s.stopCh = nil
+	s.mu.Unlock()
+	if stopCh != nil {
+		close(stopCh)
+		s.wg.Wait()
+	}
+	if s.db != nil {
+		if err := s.db.Close(); err != nil {
+			zlog.Error("关闭事件存储失败", zap.Error(err))
+		}
+		s.db = nil
+	}
+}
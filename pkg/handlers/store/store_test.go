@@ -0,0 +1,197 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+)
+
+var now = time.Now().Truncate(time.Second)
+
+func openStore(t *testing.T, conf config.StoreConf) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "k8swatch-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Path = filepath.Join(dir, "events.db")
+	s := new(Store)
+	if err := s.Open(conf); err != nil {
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func podEvent(pod, reason string, uid int, ts time.Time) event.Event {
+	return event.Event{Cluster: "prod", Kind: "events", Namespace: "shop", Name: fmt.Sprintf("%s.%x", pod, uid),
+		UID: fmt.Sprint(uid), ResourceVersion: "1", Reason: reason, Type: "Warning", Action: event.CreateEvent,
+		InvolvedKind: "Pod", InvolvedNamespace: "shop", InvolvedName: pod, LastTimestamp: ts}
+}
+
+func names(events []event.Event) string {
+	var s []string
+	for _, e := range events {
+		s = append(s, e.InvolvedName+"/"+e.Reason)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestSearch(t *testing.T) {
+	s, cleanup := openStore(t, config.StoreConf{})
+	defer cleanup()
+	labeled := podEvent("web-10", "Killing", 4, now)
+	labeled.ClusterLabels = map[string]string{"team": "shop"}
+	err := s.Put(
+		podEvent("web-0", "Scheduled", 1, now.Add(-3*time.Minute)),
+		podEvent("web-0", "BackOff", 2, now.Add(-2*time.Minute)),
+		podEvent("web-1", "BackOff", 3, now.Add(-time.Minute)),
+		labeled,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 同一k8s event的新版本替换旧版本
+	updated := podEvent("web-0", "BackOff", 2, now.Add(30*time.Second))
+	updated.ResourceVersion, updated.Count = "2", 5
+	if err := s.Put(updated); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		q    Query
+		want string
+	}{
+		{Query{InvolvedKind: "Pod", InvolvedNamespace: "shop", InvolvedName: "web-0"}, "web-0/BackOff,web-0/Scheduled"},
+		{Query{Reason: "BackOff"}, "web-0/BackOff,web-1/BackOff"},
+		{Query{Namespace: "shop", Limit: 2}, "web-0/BackOff,web-10/Killing"},
+		{Query{Namespace: "shop", Reason: "Killing"}, "web-10/Killing"},
+		{Query{Since: now.Add(-2 * time.Minute), Until: now}, "web-10/Killing,web-1/BackOff"},
+		{Query{InvolvedKind: "Pod", InvolvedName: "web-0", Until: now.Add(-3 * time.Minute)}, "web-0/Scheduled"},
+		{Query{Namespace: "kube-system"}, ""},
+		{Query{Cluster: "staging"}, ""},
+		{Query{Type: "Warning", Labels: map[string]string{"team": "shop"}}, "web-10/Killing"},
+		{Query{Reason: "BackOff", Ascending: true}, "web-1/BackOff,web-0/BackOff"},
+		{Query{Since: now.Add(-3 * time.Minute), Until: now.Add(-time.Minute), Ascending: true}, "web-0/Scheduled,web-1/BackOff"},
+	} {
+		res, _, err := s.Search(c.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(res); got != c.want {
+			t.Errorf("%+v: got %s, want %s", c.q, got, c.want)
+		}
+	}
+	res, _, _ := s.Search(Query{Reason: "BackOff", Limit: 1})
+	if len(res) != 1 || res[0].Count != 5 {
+		t.Fatalf("unexpected events: %+v", res)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	s, cleanup := openStore(t, config.StoreConf{})
+	defer cleanup()
+	var events []event.Event
+	for i := 0; i < 5; i++ {
+		// 相同时间的事件按写入顺序排列
+		events = append(events, podEvent(fmt.Sprintf("web-%d", i), "BackOff", i, now.Add(time.Duration(i/2)*time.Second)))
+	}
+	if err := s.Put(events...); err != nil {
+		t.Fatal(err)
+	}
+	for _, asc := range []bool{false, true} {
+		var pages []string
+		q := Query{Reason: "BackOff", Limit: 2, Ascending: asc}
+		for {
+			res, next, err := s.Search(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages = append(pages, names(res))
+			if next == "" {
+				break
+			}
+			q.Cursor = next
+		}
+		want := "web-4/BackOff,web-3/BackOff|web-2/BackOff,web-1/BackOff|web-0/BackOff"
+		if asc {
+			want = "web-0/BackOff,web-1/BackOff|web-2/BackOff,web-3/BackOff|web-4/BackOff"
+		}
+		if got := strings.Join(pages, "|"); got != want {
+			t.Errorf("ascending %v: got pages %s, want %s", asc, got, want)
+		}
+	}
+	if _, _, err := s.Search(Query{Cursor: "not-a-cursor"}); err != ErrInvalidCursor {
+		t.Fatalf("got %v for an invalid cursor", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	s, cleanup := openStore(t, config.StoreConf{MaxAge: time.Hour, MaxSize: 1})
+	defer cleanup()
+	var events []event.Event
+	for i := 0; i < 3000; i++ {
+		e := podEvent(fmt.Sprintf("web-%d", i), "BackOff", i, now.Add(time.Duration(i-4000)*time.Second))
+		e.Messages = strings.Repeat("Back-off restarting failed container ", 10)
+		events = append(events, e)
+	}
+	if err := s.Put(events...); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	size, count, err := s.usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 最早的400个事件超过1小时，之后按大小删除最早的事件
+	if count == 0 || count >= 2600 || size > 1<<20 {
+		t.Fatalf("%d events use %d bytes after pruning", count, size)
+	}
+	res, _, _ := s.Search(Query{Limit: 10000})
+	if len(res) != count || res[0].InvolvedName != "web-2999" || res[len(res)-1].LastTimestamp.Before(now.Add(-time.Hour)) {
+		t.Fatalf("got %d events, oldest %v", len(res), res[len(res)-1].LastTimestamp)
+	}
+	// 索引随事件一起删除
+	if res, _, _ := s.Search(Query{InvolvedKind: "Pod", InvolvedNamespace: "shop", InvolvedName: "web-0"}); len(res) != 0 {
+		t.Fatalf("pruned event is still indexed: %+v", res)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8swatch-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := config.Config{}
+	c.Handlers.Store = config.StoreConf{Path: filepath.Join(dir, "events.db"), FlushInterval: time.Minute}
+	s := new(Store)
+	if err := s.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	s.ObjectCreated(podEvent("web-0", "Scheduled", 1, now))
+	s.ObjectCreated(event.Event{Kind: "pods", Namespace: "shop", Name: "web-0", Action: event.CreateEvent})
+	s.ObjectDeleted(podEvent("web-0", "Scheduled", 1, now))
+	// Close时写入剩余的事件
+	s.Close()
+
+	s = new(Store)
+	if err := s.Open(c.Handlers.Store); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	res, _, err := s.Search(Query{})
+	if err != nil || names(res) != "web-0/Scheduled" {
+		t.Fatalf("got %s: %v", names(res), err)
+	}
+}
@@ -0,0 +1,235 @@
+package stream
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/utils/zlog"
+)
+
+const (
+	defaultHistory        = 1000
+	defaultBufferSize     = 256
+	defaultMaxSubscribers = 100
+	defaultPingInterval   = 30 * time.Second
+)
+
+var (
+	// ErrTooManySubscribers 订阅者达到MaxSubscribers
+	ErrTooManySubscribers = errors.New("too many subscribers")
+	// ErrClosed stream已关闭
+	ErrClosed = errors.New("stream closed")
+)
+
+var (
+	subscribers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8swatch_stream_subscribers",
+		Help: "Clients currently subscribed to the event stream.",
+	})
+	disconnectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8swatch_stream_disconnected_total",
+		Help: "Subscribers removed from the event stream, by reason (client, slow or shutdown).",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(subscribers, disconnectedTotal)
+}
+
+// Message 是推送给订阅者的事件，ID在进程内递增，作为SSE的id
+type Message struct {
+	ID    uint64
+	Event event.Event
+}
+
+// Filter 为空的条件不限制，Kind、Name为事件所属的对象(involvedObject)，Resource为对象类别如events、pods
+type Filter struct {
+	Cluster   string
+	Namespace string
+	Resource  string
+	Kind      string
+	Name      string
+	Reason    string
+	Type      string
+}
+
+func (f Filter) match(e event.Event) bool {
+	return (f.Cluster == "" || f.Cluster == e.Cluster) &&
+		(f.Namespace == "" || f.Namespace == e.Namespace) &&
+		(f.Resource == "" || f.Resource == e.Kind) &&
+		(f.Kind == "" || f.Kind == e.InvolvedKind) &&
+		(f.Name == "" || f.Name == e.InvolvedName) &&
+		(f.Reason == "" || f.Reason == e.Reason) &&
+		(f.Type == "" || f.Type == e.Type)
+}
+
+/*
+Stream 将controller交给handler的事件分发给各订阅者，不阻塞controller
+每个订阅者有BufferSize的缓冲，缓冲满时关闭该订阅者，由客户端以Last-Event-ID重连
+最近History个事件保存在环形缓冲中，用于重连时补发
+*/
+type Stream struct {
+	conf config.StreamConf
+
+	mu      sync.Mutex
+	seq     uint64
+	history []Message
+	// next 为history中下一个写入的位置
+	next   int
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// Subscription 是一个订阅者，从C读取事件，C被关闭时检查Slow()
+type Subscription struct {
+	C <-chan Message
+	// Backlog 为订阅时从history补发的事件，应在C之前发送
+	Backlog []Message
+
+	s      *Stream
+	ch     chan Message
+	filter Filter
+	slow   bool
+}
+
+func (s *Stream) Init(c config.Config) error {
+	s.conf = c.Handlers.Stream
+	if s.conf.History <= 0 {
+		s.conf.History = defaultHistory
+	}
+	if s.conf.BufferSize <= 0 {
+		s.conf.BufferSize = defaultBufferSize
+	}
+	if s.conf.MaxSubscribers <= 0 {
+		s.conf.MaxSubscribers = defaultMaxSubscribers
+	}
+	if s.conf.PingInterval <= 0 {
+		s.conf.PingInterval = defaultPingInterval
+	}
+	s.history = make([]Message, 0, s.conf.History)
+	s.subs = map[*Subscription]struct{}{}
+	return nil
+}
+
+// PingInterval 没有事件时发送心跳的间隔
+func (s *Stream) PingInterval() time.Duration {
+	return s.conf.PingInterval
+}
+
+func (s *Stream) ObjectCreated(obj event.Event) {
+	s.publish(obj)
+}
+
+func (s *Stream) ObjectUpdated(obj event.Event) {
+	s.publish(obj)
+}
+
+func (s *Stream) ObjectDeleted(obj event.Event) {
+	s.publish(obj)
+}
+
+func (s *Stream) publish(obj event.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.seq++
+	msg := Message{ID: s.seq, Event: obj}
+	if len(s.history) < cap(s.history) {
+		s.history = append(s.history, msg)
+	} else {
+		s.history[s.next] = msg
+		s.next = (s.next + 1) % len(s.history)
+	}
+	for sub := range s.subs {
+		if !sub.filter.match(obj) {
+			continue
+		}
+		select {
+		case sub.ch <- msg:
+		default:
+			zlog.Warnf("事件订阅者的缓冲已满(%d)，断开该订阅者", cap(sub.ch))
+			sub.slow = true
+			s.remove(sub, "slow")
+		}
+	}
+}
+
+/*
+Subscribe 订阅符合filter的事件，lastID不为0时从history补发ID大于lastID的事件
+lastID已不在history中(超出History或k8swatch重启过)时补发history中的全部事件
+*/
+func (s *Stream) Subscribe(filter Filter, lastID uint64) (*Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	if len(s.subs) >= s.conf.MaxSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+	sub := &Subscription{s: s, ch: make(chan Message, s.conf.BufferSize), filter: filter}
+	sub.C = sub.ch
+	if lastID > 0 {
+		if lastID > s.seq {
+			lastID = 0
+		}
+		for i := range s.history {
+			msg := s.history[(s.next+i)%len(s.history)]
+			if msg.ID > lastID && filter.match(msg.Event) {
+				sub.Backlog = append(sub.Backlog, msg)
+			}
+		}
+	}
+	s.subs[sub] = struct{}{}
+	subscribers.Inc()
+	return sub, nil
+}
+
+// remove 需持有s.mu
+func (s *Stream) remove(sub *Subscription, reason string) {
+	if _, ok := s.subs[sub]; !ok {
+		return
+	}
+	delete(s.subs, sub)
+	close(sub.ch)
+	subscribers.Dec()
+	disconnectedTotal.WithLabelValues(reason).Inc()
+}
+
+// Subscribers 返回当前的订阅者数
+func (s *Stream) Subscribers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs)
+}
+
+// Close 取消订阅，可重复调用
+func (sub *Subscription) Close() {
+	sub.s.mu.Lock()
+	defer sub.s.mu.Unlock()
+	sub.s.remove(sub, "client")
+}
+
+// Slow 为true时订阅者因缓冲已满被断开
+func (sub *Subscription) Slow() bool {
+	sub.s.mu.Lock()
+	defer sub.s.mu.Unlock()
+	return sub.slow
+}
+
+// Close 关闭所有订阅者，之后的事件被丢弃
+func (s *Stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for sub := range s.subs {
+		s.remove(sub, "shutdown")
+	}
+}
@@ -0,0 +1,105 @@
+package stream
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+)
+
+func newStream(t *testing.T, conf config.StreamConf) *Stream {
+	c := config.Config{}
+	c.Handlers.Stream = conf
+	s := new(Stream)
+	if err := s.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func podEvent(pod, reason string) event.Event {
+	return event.Event{Kind: "events", Namespace: "shop", Name: pod + ".1", Reason: reason,
+		InvolvedKind: "Pod", InvolvedNamespace: "shop", InvolvedName: pod}
+}
+
+func ids(msgs []Message) string {
+	var s string
+	for _, m := range msgs {
+		s += fmt.Sprintf("%d/%s,", m.ID, m.Event.InvolvedName)
+	}
+	return s
+}
+
+func TestSubscribe(t *testing.T) {
+	s := newStream(t, config.StreamConf{History: 3})
+	defer s.Close()
+	for i := 0; i < 5; i++ {
+		s.ObjectCreated(podEvent(fmt.Sprintf("web-%d", i), "BackOff"))
+	}
+	// history只保留最近3个事件
+	for _, c := range []struct {
+		lastID uint64
+		want   string
+	}{
+		{0, ""},
+		{3, "4/web-3,5/web-4,"},
+		{1, "3/web-2,4/web-3,5/web-4,"},
+		{5, ""},
+		// 重启前的id
+		{100, "3/web-2,4/web-3,5/web-4,"},
+	} {
+		sub, err := s.Subscribe(Filter{}, c.lastID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(sub.Backlog); got != c.want {
+			t.Errorf("lastID %d: got backlog %s, want %s", c.lastID, got, c.want)
+		}
+		sub.Close()
+	}
+
+	sub, _ := s.Subscribe(Filter{Kind: "Pod", Name: "web-9"}, 1)
+	defer sub.Close()
+	s.ObjectUpdated(podEvent("web-8", "BackOff"))
+	s.ObjectUpdated(podEvent("web-9", "Killing"))
+	s.ObjectDeleted(event.Event{Kind: "pods", Namespace: "shop", Name: "web-9"})
+	if msg := <-sub.C; msg.ID != 7 || msg.Event.Reason != "Killing" || len(sub.C) != 0 {
+		t.Fatalf("unexpected message %+v, %d buffered", msg, len(sub.C))
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	s := newStream(t, config.StreamConf{BufferSize: 2, MaxSubscribers: 2})
+	slow, _ := s.Subscribe(Filter{}, 0)
+	other, _ := s.Subscribe(Filter{Reason: "Killing"}, 0)
+	if _, err := s.Subscribe(Filter{}, 0); err != ErrTooManySubscribers {
+		t.Fatalf("got %v, want ErrTooManySubscribers", err)
+	}
+	for i := 0; i < 3; i++ {
+		s.ObjectCreated(podEvent(fmt.Sprintf("web-%d", i), "BackOff"))
+	}
+	// 缓冲中的事件仍可读取，之后C被关闭
+	var got []Message
+	for msg := range slow.C {
+		got = append(got, msg)
+	}
+	if ids(got) != "1/web-0,2/web-1," || !slow.Slow() || other.Slow() {
+		t.Fatalf("got %s, slow %v", ids(got), slow.Slow())
+	}
+	slow.Close()
+	// 断开的订阅者不占用名额
+	sub, err := s.Subscribe(Filter{}, 2)
+	if err != nil || ids(sub.Backlog) != "3/web-2," {
+		t.Fatalf("got %v: %v", sub, err)
+	}
+
+	s.Close()
+	if _, ok := <-other.C; ok || other.Slow() {
+		t.Fatal("subscriber is not closed")
+	}
+	s.ObjectCreated(podEvent("web-3", "Killing"))
+	if _, err := s.Subscribe(Filter{}, 0); err != ErrClosed {
+		t.Fatalf("got %v after close, want ErrClosed", err)
+	}
+}
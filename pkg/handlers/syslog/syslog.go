@@ -0,0 +1,311 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/pkg/handlers/alert"
+	"github.com/gok8s/k8swatch/utils"
+	"github.com/gok8s/k8swatch/utils/zlog"
+)
+
+const (
+	defaultAppName   = "k8swatch"
+	defaultFacility  = "local0"
+	defaultSDID      = "k8s@32473"
+	defaultQueueSize = 1000
+	defaultTimeout   = 10 * time.Second
+	// nilValue 为RFC5424中空的header字段
+	nilValue = "-"
+)
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var messagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "k8swatch_syslog_messages_total",
+	Help: "Events sent to syslog, by result (success, error or dropped).",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(messagesTotal)
+}
+
+/*
+Syslog 以RFC5424格式发送事件，severity由alert.Severity按报警分级得出
+事件的字段放在SDID对应的structured data中，MSG为事件的message
+udp每个消息一个数据包，tcp及tls使用octet counting分帧，写入失败时重新连接
+*/
+type Syslog struct {
+	conf      config.SyslogConf
+	facility  int
+	hostname  string
+	tlsConfig *tls.Config
+	// conn 只由run所在的goroutine使用
+	conn net.Conn
+
+	mu       sync.RWMutex
+	messages chan []byte
+	wg       sync.WaitGroup
+}
+
+func (s *Syslog) Init(c config.Config) error {
+	if err := s.init(c.Handlers.Syslog); err != nil {
+		return err
+	}
+	s.messages = make(chan []byte, s.conf.QueueSize)
+	s.wg.Add(1)
+	go s.run(s.messages)
+	return nil
+}
+
+// init checks the config and fills in the defaults
+func (s *Syslog) init(conf config.SyslogConf) error {
+	s.conf = conf
+	if s.conf.Address == "" {
+		return fmt.Errorf("syslog address is empty")
+	}
+	switch s.conf.Network {
+	case "":
+		s.conf.Network = "udp"
+	case "udp", "tcp":
+	case "tls":
+		tlsConfig, err := utils.NewTLSConfig(s.conf.TLS)
+		if err != nil {
+			return err
+		}
+		s.tlsConfig = tlsConfig
+	default:
+		return fmt.Errorf("unknown syslog network %q, supported: udp, tcp, tls", s.conf.Network)
+	}
+	if s.conf.Facility == "" {
+		s.conf.Facility = defaultFacility
+	}
+	facility, ok := facilities[s.conf.Facility]
+	if !ok {
+		return fmt.Errorf("unknown syslog facility %q", s.conf.Facility)
+	}
+	s.facility = facility
+	if s.conf.AppName == "" {
+		s.conf.AppName = defaultAppName
+	}
+	if s.conf.SDID == "" {
+		s.conf.SDID = defaultSDID
+	}
+	if !strings.Contains(s.conf.SDID, "@") || len(s.conf.SDID) > 32 || sdName(s.conf.SDID) != s.conf.SDID {
+		return fmt.Errorf("invalid syslog sdID %q, must be name@<enterprise number>", s.conf.SDID)
+	}
+	s.hostname = s.conf.Hostname
+	if s.hostname == "" {
+		s.hostname, _ = os.Hostname()
+	}
+	if s.conf.QueueSize <= 0 {
+		s.conf.QueueSize = defaultQueueSize
+	}
+	if s.conf.Timeout <= 0 {
+		s.conf.Timeout = defaultTimeout
+	}
+	return nil
+}
+
+func (s *Syslog) ObjectCreated(obj event.Event) {
+	s.add(obj)
+}
+
+func (s *Syslog) ObjectUpdated(obj event.Event) {
+	s.add(obj)
+}
+
+func (s *Syslog) ObjectDeleted(obj event.Event) {
+	s.add(obj)
+}
+
+func (s *Syslog) add(obj event.Event) {
+	msg := s.Message(obj)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.messages == nil {
+		zlog.Error("syslog handler已关闭，丢弃事件", zap.String("name", obj.Name))
+		return
+	}
+	select {
+	case s.messages <- msg:
+	default:
+		// 连接不可用时队列很快会满，丢弃事件而不是阻塞controller
+		messagesTotal.WithLabelValues("dropped").Inc()
+		zlog.Error("syslog队列已满，丢弃事件", zap.String("name", obj.Name))
+	}
+}
+
+// Message 将事件格式化为RFC5424消息：<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] MSG
+// MSGID为事件的reason，没有reason时为action
+func (s *Syslog) Message(e event.Event) []byte {
+	ts := e.Time()
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	msgID := e.Reason
+	if msgID == "" {
+		msgID = e.Action
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s %s ", s.facility*8+alert.Severity(e),
+		ts.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		header(s.hostname, 255), header(s.conf.AppName, 48), nilValue, header(msgID, 32))
+	b.WriteString(s.structuredData(e))
+	msg := e.Messages
+	if msg == "" {
+		msg = fmt.Sprintf("%s %s %s", e.Action, e.Kind, strings.TrimPrefix(e.Namespace+"/"+e.Name, "/"))
+	}
+	b.WriteString(" ")
+	b.WriteString(msg)
+	return []byte(b.String())
+}
+
+// structuredData 只包含不为空的字段，host为事件所在的node
+func (s *Syslog) structuredData(e event.Event) string {
+	var b strings.Builder
+	b.WriteString("[" + s.conf.SDID)
+	params := []param{
+		{"id", e.ID()},
+		{"cluster", e.Cluster},
+		{"environment", e.Environment},
+		{"region", e.Region},
+		{"namespace", e.Namespace},
+		{"kind", e.Kind},
+		{"name", e.Name},
+		{"action", e.Action},
+		{"reason", e.Reason},
+		{"type", e.Type},
+		{"host", e.Host},
+		{"component", e.Component},
+		{"serviceName", e.ServiceName},
+		{"uid", e.UID},
+		{"involvedKind", e.InvolvedKind},
+		{"involvedNamespace", e.InvolvedNamespace},
+		{"involvedName", e.InvolvedName},
+		{"ownerKind", e.OwnerKind},
+		{"ownerName", e.OwnerName},
+		{"user", e.User},
+	}
+	if e.Count > 0 {
+		params = append(params, param{"count", strconv.Itoa(int(e.Count))})
+	}
+	for _, p := range params {
+		if p.value != "" {
+			fmt.Fprintf(&b, " %s=\"%s\"", p.name, paramEscaper.Replace(p.value))
+		}
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+type param struct {
+	name, value string
+}
+
+// paramEscaper 转义PARAM-VALUE中的"、\及]
+var paramEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// sdName 去掉SD-NAME中不允许的字符
+func sdName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// header 将header字段限制为不含空格的可打印ASCII及最大长度，为空时使用nilValue
+func header(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(s) > max {
+		s = s[:max]
+	}
+	if s == "" {
+		return nilValue
+	}
+	return s
+}
+
+// run 依次发送消息，messages关闭后发送剩余的消息并关闭连接
+func (s *Syslog) run(messages <-chan []byte) {
+	defer s.wg.Done()
+	for msg := range messages {
+		err := utils.Retry(func() error {
+			return s.write(msg)
+		}, "发送syslog消息", 3, 1)
+		if err != nil {
+			messagesTotal.WithLabelValues("error").Inc()
+			zlog.Error("发送syslog消息失败", zap.String("address", s.conf.Address), zap.Error(err))
+			continue
+		}
+		messagesTotal.WithLabelValues("success").Inc()
+	}
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// write 在没有连接时先连接，写入失败时关闭连接，下次写入时重新连接
+func (s *Syslog) write(msg []byte) error {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	frame := msg
+	if s.conf.Network != "udp" {
+		frame = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(s.conf.Timeout))
+	if _, err := s.conn.Write(frame); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *Syslog) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: s.conf.Timeout}
+	if s.conf.Network == "tls" {
+		return tls.DialWithDialer(dialer, "tcp", s.conf.Address, s.tlsConfig)
+	}
+	return dialer.Dial(s.conf.Network, s.conf.Address)
+}
+
+// Close sends the queued events and closes the connection
+func (s *Syslog) Close() {
+	s.mu.Lock()
+	messages := s.messages
+	s.messages = nil
+	s.mu.Unlock()
+	if messages == nil {
+		return
+	}
+	close(messages)
+	s.wg.Wait()
+}
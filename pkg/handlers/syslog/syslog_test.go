@@ -0,0 +1,160 @@
+package syslog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+)
+
+var backOff = event.Event{Cluster: "prod", Kind: "events", Namespace: "shop", Name: "web-0.15ceabafa804c94f",
+	Reason: "BackOff", Type: "Warning", Action: event.UpdateEvent, Count: 3, Messages: `Back-off restarting failed container "app" [x]`,
+	InvolvedKind: "Pod", InvolvedNamespace: "shop", InvolvedName: "web-0", Host: "node-1", Component: "kubelet",
+	LastTimestamp: time.Date(2019, 10, 18, 23, 1, 30, 0, time.UTC)}
+
+func newSyslog(t *testing.T, conf config.SyslogConf) *Syslog {
+	c := config.Config{}
+	conf.Hostname = "k8swatch-0"
+	c.Handlers.Syslog = conf
+	s := new(Syslog)
+	if err := s.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestMessage(t *testing.T) {
+	s := &Syslog{}
+	if err := s.init(config.SyslogConf{Address: "rsyslog:514", Hostname: "k8swatch-0"}); err != nil {
+		t.Fatal(err)
+	}
+	msg := string(s.Message(backOff))
+	// local0(16)*8 + error(3)，BackOff为appowner报警
+	want := `<131>1 2019-10-18T23:01:30.000000Z k8swatch-0 k8swatch - BackOff [k8s@32473 id="` + backOff.ID() + `" cluster="prod"`
+	if !strings.HasPrefix(msg, want) {
+		t.Fatalf("got %s\nwant prefix %s", msg, want)
+	}
+	if !strings.HasSuffix(msg, ` count="3"] Back-off restarting failed container "app" [x]`) {
+		t.Fatalf("unexpected structured data or message: %s", msg)
+	}
+
+	pod := event.Event{Kind: "pods", Namespace: "shop", Name: "web-0", Action: event.DeleteEvent}
+	msg = string(s.Message(pod))
+	if !strings.HasPrefix(msg, "<134>1 ") || !strings.HasSuffix(msg, "] DELETE pods shop/web-0") {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+
+	for _, sdID := range []string{"k8s", "k8s swatch@1", `k8s"@1`} {
+		if err := new(Syslog).init(config.SyslogConf{Address: "rsyslog:514", SDID: sdID}); err == nil {
+			t.Errorf("sdID %q is accepted", sdID)
+		}
+	}
+	if got := paramEscaper.Replace(`a"b\c]`); got != `a\"b\\c\]` {
+		t.Errorf("escaped to %s", got)
+	}
+}
+
+func TestUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s := newSyslog(t, config.SyslogConf{Address: conn.LocalAddr().String()})
+	s.ObjectUpdated(backOff)
+	s.Close()
+
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(buf[:n]), "<131>1 ") {
+		t.Fatalf("unexpected datagram: %s", buf[:n])
+	}
+}
+
+func TestTCP(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// octet counting: MSG-LEN SP SYSLOG-MSG
+		r := bufio.NewReader(conn)
+		var msgs []string
+		for {
+			size, err := r.ReadString(' ')
+			if err != nil {
+				break
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(size))
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				break
+			}
+			msgs = append(msgs, string(msg))
+		}
+		received <- msgs
+	}()
+
+	s := newSyslog(t, config.SyslogConf{Address: lis.Addr().String(), Network: "tcp"})
+	s.ObjectUpdated(backOff)
+	s.ObjectCreated(event.Event{Kind: "pods", Namespace: "shop", Name: "web-0", Action: event.CreateEvent})
+	s.Close()
+
+	select {
+	case msgs := <-received:
+		if len(msgs) != 2 || !strings.HasSuffix(msgs[1], "CREATE pods shop/web-0") {
+			t.Fatalf("unexpected messages: %q", msgs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+// TestUnreachable 地址不可用时队列满后丢弃事件，add不阻塞
+func TestUnreachable(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	dropped := testutil.ToFloat64(messagesTotal.WithLabelValues("dropped"))
+	s := newSyslog(t, config.SyslogConf{Address: addr, Network: "tcp", QueueSize: 1})
+	defer s.Close()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			s.ObjectUpdated(backOff)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("add blocks while the address is unreachable")
+	}
+	// 最多一个事件在发送中，一个在队列中
+	if got := testutil.ToFloat64(messagesTotal.WithLabelValues("dropped")) - dropped; got < 8 {
+		t.Fatalf("dropped %v events, want at least 8", got)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/gok8s/k8swatch/pkg/cloudevents"
 	"github.com/gok8s/k8swatch/pkg/event"
 )
 
@@ -30,7 +31,8 @@ Command line flags will override environment variables
 // Webhook handler implements handler.Handlers interface,
 // Notify event to Webhook channel
 type Webhook struct {
-	Url string
+	Url         string
+	CloudEvents config.CloudEventsConf
 }
 
 type WebhookMessage struct {
@@ -46,6 +48,12 @@ func (m *Webhook) Init(c config.Config) error {
 	}
 
 	m.Url = url
+	m.CloudEvents = c.Handlers.Webhook.CloudEvents
+	if m.CloudEvents.Enable {
+		if err := cloudevents.ValidMode(m.CloudEvents.Mode); err != nil {
+			return err
+		}
+	}
 
 	return checkMissingWebhookVars(m)
 }
@@ -65,9 +73,12 @@ func (m *Webhook) ObjectUpdated(obj event.Event) {
 func notifyWebhook(m *Webhook, obj event.Event, action string) {
 	//e := kbEvent.New(obj, action)
 
-	webhookMessage := prepareWebhookMessage(obj, m)
-
-	err := postMessage(m.Url, webhookMessage)
+	var err error
+	if m.CloudEvents.Enable {
+		err = postCloudEvent(m.Url, obj, m.CloudEvents.Mode)
+	} else {
+		err = postMessage(m.Url, prepareWebhookMessage(obj, m))
+	}
 	if err != nil {
 		log.Printf("%s\n", err)
 		return
@@ -111,3 +122,20 @@ func postMessage(url string, webhookMessage *WebhookMessage) error {
 
 	return nil
 }
+
+// postCloudEvent sends obj with the CloudEvents HTTP binding
+func postCloudEvent(url string, obj event.Event, mode string) error {
+	req, err := cloudevents.NewHTTPRequest(url, obj, mode)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned %s", url, resp.Status)
+	}
+	return nil
+}
@@ -1,11 +1,17 @@
 package webhook
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 
+	"github.com/gok8s/k8swatch/pkg/cloudevents"
 	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
 )
 
 func TestWebhookInit(t *testing.T) {
@@ -28,3 +34,54 @@ func TestWebhookInit(t *testing.T) {
 		}
 	}
 }
+
+func TestWebhookCloudEvents(t *testing.T) {
+	var (
+		header http.Header
+		body   []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	e := event.Event{Cluster: "cluster-a", Namespace: "shop", Kind: "pods", Name: "web-0", Action: event.CreateEvent,
+		UID: "6f1d2c1e-8f6a-4c7e-9f4b-1f2e3d4c5b6a", ResourceVersion: "100"}
+
+	for _, mode := range []string{cloudevents.ModeStructured, cloudevents.ModeBinary} {
+		c := config.Config{}
+		c.Handlers.Webhook = config.Webhook{Enable: true, Url: server.URL, CloudEvents: config.CloudEventsConf{Enable: true, Mode: mode}}
+		s := &Webhook{}
+		if err := s.Init(c); err != nil {
+			t.Fatal(err)
+		}
+		s.ObjectCreated(e)
+		if mode == cloudevents.ModeBinary {
+			if header.Get("ce-specversion") != "1.0" || header.Get("ce-id") != e.ID() || header.Get("ce-source") != "cluster-a" ||
+				header.Get("ce-type") != "io.k8swatch.pods.create" {
+				t.Fatalf("unexpected binary headers: %v", header)
+			}
+			var data event.Event
+			if err := json.Unmarshal(body, &data); err != nil || data.Name != "web-0" {
+				t.Fatalf("unexpected binary body %s: %v", body, err)
+			}
+			continue
+		}
+		if header.Get("Content-Type") != cloudevents.StructuredContentType {
+			t.Fatalf("got content type %s", header.Get("Content-Type"))
+		}
+		var ce struct {
+			SpecVersion string      `json:"specversion"`
+			ID          string      `json:"id"`
+			Source      string      `json:"source"`
+			Subject     string      `json:"subject"`
+			Data        event.Event `json:"data"`
+		}
+		if err := json.Unmarshal(body, &ce); err != nil {
+			t.Fatal(err)
+		}
+		if ce.SpecVersion != "1.0" || ce.ID != e.ID() || ce.Source != "cluster-a" || ce.Subject != "shop/web-0" || ce.Data.Name != "web-0" {
+			t.Fatalf("unexpected structured body: %s", body)
+		}
+	}
+}
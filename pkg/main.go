@@ -14,14 +14,32 @@ import (
 
 	"github.com/gok8s/k8swatch/pkg/handlers/alert"
 
+	"github.com/gok8s/k8swatch/pkg/handlers/exporter"
+	"github.com/gok8s/k8swatch/pkg/handlers/file"
+	"github.com/gok8s/k8swatch/pkg/handlers/gelf"
 	"github.com/gok8s/k8swatch/pkg/handlers/influxdb"
+	"github.com/gok8s/k8swatch/pkg/handlers/kafka"
+	"github.com/gok8s/k8swatch/pkg/handlers/loki"
+	"github.com/gok8s/k8swatch/pkg/handlers/nats"
+	"github.com/gok8s/k8swatch/pkg/handlers/opensearch"
+	"github.com/gok8s/k8swatch/pkg/handlers/otlp"
 
 	"github.com/gok8s/k8swatch/pkg/handlers"
 	"github.com/gok8s/k8swatch/pkg/handlers/rabbitmq"
+	"github.com/gok8s/k8swatch/pkg/handlers/store"
+	"github.com/gok8s/k8swatch/pkg/handlers/stream"
+	"github.com/gok8s/k8swatch/pkg/handlers/syslog"
+	"github.com/gok8s/k8swatch/pkg/handlers/webhook"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"github.com/gok8s/k8swatch/pkg/audit"
+	"github.com/gok8s/k8swatch/pkg/auth"
 	"github.com/gok8s/k8swatch/pkg/config"
 	"github.com/gok8s/k8swatch/pkg/controller"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/pkg/trace"
+	"github.com/gok8s/k8swatch/pkg/tracker"
 
 	wapi "github.com/gok8s/k8swatch/pkg/api"
 	"github.com/gok8s/k8swatch/utils"
@@ -29,11 +47,12 @@ import (
 	"k8s.io/client-go/tools/cache"
 )
 
+// clusterCheckInterval is how often the connection of each cluster is checked
+const clusterCheckInterval = 30 * time.Second
+
 func Start(config config.Config) {
-	//设置系统环境变量-clusterName
-	err := os.Setenv("clusterName", config.K8s.ClusterName)
-	if err != nil {
-		zlog.Errorf("为clusterName设置系统环境变量失败,err:%s", err)
+	if err := event.SetSchema(config.Settings.EventSchema); err != nil {
+		zlog.Fatal(err.Error())
 	}
 	var eventHandlers []handlers.Handler
 	if config.Handlers.RabbitMq.Enable {
@@ -46,6 +65,26 @@ func Start(config config.Config) {
 			eventHandlers = append(eventHandlers, eventHandler)
 		}
 	}
+	if config.Handlers.Kafka.Enable {
+		zlog.Info("启用kafka handler")
+		eventHandler := new(kafka.Kafka)
+		if err := eventHandler.Init(config); err != nil {
+			zlog.Error(err.Error())
+		} else {
+			defer eventHandler.Close()
+			eventHandlers = append(eventHandlers, eventHandler)
+		}
+	}
+	if config.Handlers.NATS.Enable {
+		zlog.Info("启用nats handler")
+		eventHandler := new(nats.NATS)
+		if err := eventHandler.Init(config); err != nil {
+			zlog.Error(err.Error())
+		} else {
+			defer eventHandler.Close()
+			eventHandlers = append(eventHandlers, eventHandler)
+		}
+	}
 	if config.Handlers.Influxdb.Enable {
 		zlog.Info("启用influxdb handler")
 		eventHandler := new(influxdb.InfluxDB)
@@ -65,6 +104,15 @@ func Start(config config.Config) {
 			eventHandlers = append(eventHandlers, eventHandler)
 		}
 	}
+	if config.Handlers.Webhook.Enable {
+		zlog.Info("启用webhook handler")
+		eventHandler := new(webhook.Webhook)
+		if err := eventHandler.Init(config); err != nil {
+			zlog.Error(err.Error())
+		} else {
+			eventHandlers = append(eventHandlers, eventHandler)
+		}
+	}
 
 	if config.Handlers.Elasticsearch.Enable {
 		zlog.Info("启用elasticsearch handler")
@@ -72,21 +120,221 @@ func Start(config config.Config) {
 		if err := eventHandler.Init(config); err != nil {
 			zlog.Fatal(err.Error())
 		}
+		defer eventHandler.Close()
 		eventHandlers = append(eventHandlers, eventHandler)
 	}
 
-	utils.Init(config)
-	var kubeClient cache.Getter
+	if config.Handlers.OpenSearch.Enable {
+		zlog.Info("启用opensearch handler")
+		eventHandler := new(opensearch.OpenSearch)
+		if err := eventHandler.Init(config); err != nil {
+			zlog.Fatal(err.Error())
+		}
+		defer eventHandler.Close()
+		eventHandlers = append(eventHandlers, eventHandler)
+	}
+	if config.Handlers.Loki.Enable {
+		zlog.Info("启用loki handler")
+		eventHandler := new(loki.Loki)
+		if err := eventHandler.Init(config); err != nil {
+			zlog.Error(err.Error())
+		} else {
+			defer eventHandler.Close()
+			eventHandlers = append(eventHandlers, eventHandler)
+		}
+	}
+
+	if config.Handlers.Stdout.Enable {
+		zlog.Info("启用stdout handler")
+		eventHandlers = append(eventHandlers, new(handlers.Default))
+	}
+	if config.Handlers.File.Enable {
+		zlog.Info("启用file handler")
+		eventHandler := new(file.File)
+		if err := eventHandler.Init(config); err != nil {
+			zlog.Error(err.Error())
+		} else {
+			defer eventHandler.Close()
+			eventHandlers = append(eventHandlers, eventHandler)
+		}
+	}
+	if config.Handlers.Syslog.Enable {
+		zlog.Info("启用syslog handler")
+		eventHandler := new(syslog.Syslog)
+		if err := eventHandler.Init(config); err != nil {
+			zlog.Error(err.Error())
+		} else {
+			defer eventHandler.Close()
+			eventHandlers = append(eventHandlers, eventHandler)
+		}
+	}
+	if config.Handlers.GELF.Enable {
+		zlog.Info("启用gelf handler")
+		eventHandler := new(gelf.GELF)
+		if err := eventHandler.Init(config); err != nil {
+			zlog.Error(err.Error())
+		} else {
+			defer eventHandler.Close()
+			eventHandlers = append(eventHandlers, eventHandler)
+		}
+	}
+	//store同时作为/events的查询后端
+	var eventStore *store.Store
+	if config.Handlers.Store.Enable {
+		zlog.Info("启用store handler")
+		eventHandler := new(store.Store)
+		if err := eventHandler.Init(config); err != nil {
+			zlog.Error(err.Error())
+		} else {
+			defer eventHandler.Close()
+			eventHandlers = append(eventHandlers, eventHandler)
+			eventStore = eventHandler
+		}
+	}
+	//stream将事件推送给/api/v2/events/stream的订阅者
+	var eventStream *stream.Stream
+	if config.Handlers.Stream.Enable {
+		zlog.Info("启用stream handler")
+		eventHandler := new(stream.Stream)
+		if err := eventHandler.Init(config); err != nil {
+			zlog.Error(err.Error())
+		} else {
+			defer eventHandler.Close()
+			eventHandlers = append(eventHandlers, eventHandler)
+			eventStream = eventHandler
+		}
+	}
+	//启用otlp traces时记录每个对象的处理过程
+	var recorder trace.Recorder
+	if config.Handlers.OTLP.Enable {
+		zlog.Info("启用otlp handler")
+		eventHandler := new(otlp.OTLP)
+		if err := eventHandler.Init(config); err != nil {
+			zlog.Error(err.Error())
+		} else {
+			defer eventHandler.Close()
+			eventHandlers = append(eventHandlers, eventHandler)
+			if eventHandler.Tracing() {
+				recorder = eventHandler
+			}
+		}
+	}
+	if config.Handlers.Prometheus.Enable {
+		zlog.Info("启用prometheus handler")
+		eventHandler := new(exporter.Exporter)
+		if err := eventHandler.Init(config); err != nil {
+			zlog.Error(err.Error())
+		} else {
+			eventHandlers = append(eventHandlers, eventHandler)
+		}
+	}
+
+	clusters, err := utils.Init(config)
+	if err != nil {
+		zlog.Fatal(err.Error())
+	}
 
 	stopCh := make(chan struct{})
 	defer close(stopCh)
 
-	for _, resource := range config.Resources {
+	mux := http.NewServeMux()
+	mux.Handle("/api/v2/events/stream", wapi.NewStreamApi(eventStream))
+	for i, cluster := range clusters {
+		var auditor *audit.Correlator
+		if config.Audit.Enable {
+			auditor = audit.NewCorrelator(config.Audit)
+			go auditor.Run(stopCh)
+			if config.Audit.Webhook {
+				mux.Handle("/audit/"+cluster.Name, auditor)
+			}
+			//单集群时保持/audit，audit日志文件只能来自第一个集群
+			if i == 0 {
+				if config.Audit.Webhook {
+					mux.Handle("/audit", auditor)
+				}
+				if config.Audit.LogFile != "" {
+					go auditor.TailFile(config.Audit.LogFile, stopCh)
+				}
+			}
+		}
+		go cluster.RunHealthCheck(clusterCheckInterval, stopCh)
+		startCluster(config, cluster, eventHandlers, auditor, recorder, stopCh)
+	}
+	if config.Audit.Enable && !config.Audit.Webhook && config.Audit.LogFile == "" {
+		zlog.Warn("audit已启用但webhook和logFile均未配置，不会收到audit事件")
+	}
+
+	eapi := wapi.NewQueryApi(config, eventStore)
+
+	//认证配置错误时不启动，避免接口在未认证的情况下暴露
+	var httpAuth *auth.Auth
+	if config.Auth.Enable {
+		zlog.Info("启用HTTP API认证")
+		var err error
+		if httpAuth, err = auth.New(config.Auth, clusters); err != nil {
+			zlog.Fatal(err.Error())
+		}
+		//不需要认证的/audit可提交伪造的audit事件，将操作记在任意用户名下
+		if config.Audit.Enable && config.Audit.Webhook && config.Audit.WebhookToken == "" &&
+			(httpAuth.Public("/audit") || httpAuth.Public("/audit/")) {
+			zlog.Fatal("启用auth时/audit不需要认证，audit webhook需配置audit.webhookToken")
+		}
+	}
+
+	go registerHandlers(eapi, clusters, config.Settings.EnableProfiling, config.Settings.HttpPort, mux, httpAuth)
+
+	if config.GRPC.Enable {
+		zlog.Info("启用gRPC API")
+		var opts []grpc.ServerOption
+		if httpAuth != nil {
+			opts = append(opts, grpc.UnaryInterceptor(httpAuth.UnaryInterceptor()), grpc.StreamInterceptor(httpAuth.StreamInterceptor()))
+		}
+		server, err := wapi.NewGrpcServer(config.GRPC, wapi.NewGrpcApi(eapi, eventStream), opts...)
+		if err != nil {
+			zlog.Fatal(err.Error())
+		}
+		lis, err := wapi.ListenGrpc(config.GRPC)
+		if err != nil {
+			zlog.Fatal(err.Error())
+		}
+		defer server.Stop()
+		go func() {
+			if err := server.Serve(lis); err != nil {
+				zlog.Errorf("gRPC server退出: %v", err)
+			}
+		}()
+	}
+
+	sigterm := make(chan os.Signal, 1)
+	signal.Notify(sigterm, syscall.SIGTERM)
+	signal.Notify(sigterm, syscall.SIGINT)
+	<-sigterm
+}
+
+/*
+startCluster 为集群启用的每个资源创建informer和controller，并启动该集群的trackers
+*/
+func startCluster(config config.Config, cluster *utils.Cluster, eventHandlers []handlers.Handler, auditor *audit.Correlator, recorder trace.Recorder, stopCh chan struct{}) {
+	if cluster.KubeClient == nil {
+		zlog.Errorf("集群:%s 的client不可用，不启动控制器", cluster.Name)
+		return
+	}
+	var kubeClient cache.Getter
+
+	trackers := tracker.Enabled(config, cluster.KubeClient, func(e event.Event) {
+		e.SetCluster(cluster.Name, cluster.Meta)
+		for _, eventHandler := range eventHandlers {
+			eventHandler.ObjectCreated(e)
+		}
+	})
+	trackedResources := make(map[tracker.Tracker]bool)
+
+	for _, resource := range cluster.Resources {
 		if !resource.Enable {
 			continue
 		}
 		var ok bool
-		if kubeClient, ok = utils.ResourceGetterMap[resource.Name]; !ok {
+		if kubeClient, ok = cluster.ResourceGetterMap[resource.Name]; !ok {
 			zlog.Errorf("ResourceGetterMap 未找到%s对应的restclient", resource.Name)
 			continue
 		}
@@ -102,34 +350,62 @@ func Start(config config.Config) {
 			"",                  // 被监控命名空间
 			fields.Everything()) // 选择器，减少匹配的资源数量
 		informer := cache.NewSharedIndexInformer(lw, object, 0, cache.Indexers{})
-		c := controller.NewResourceController(eventHandlers, informer, config, resource.Name)
+		c := controller.NewResourceController(eventHandlers, informer, config, cluster, resource.Name)
+		if auditor != nil {
+			c.SetAuditor(auditor)
+		}
+		if recorder != nil {
+			c.SetTracer(recorder)
+		}
+		for _, t := range trackers {
+			if tracker.Watches(t, resource.Name) {
+				informer.AddEventHandler(t)
+				trackedResources[t] = true
+				if f, ok := t.(tracker.UpdateFilter); ok {
+					c.AddUpdateFilter(f.Significant)
+				}
+			}
+		}
 
 		go c.Run(config.Settings.Threadiness, stopCh)
-		zlog.Infof("resource:%s 的控制器已启动", resource.Name)
+		zlog.Infof("集群:%s resource:%s 的控制器已启动", cluster.Name, resource.Name)
+	}
+	for _, t := range trackers {
+		if !trackedResources[t] {
+			zlog.Warnf("集群:%s tracker所需的resource:%v 均未启用，tracker不会收到任何对象", cluster.Name, t.Resources())
+		}
+		go t.Run(stopCh)
 	}
-
-	mux := http.NewServeMux()
-	eapi := wapi.NewQueryApi(config)
-
-	go registerHandlers(eapi, config.Settings.EnableProfiling, config.Settings.HttpPort, mux)
-
-	sigterm := make(chan os.Signal, 1)
-	signal.Notify(sigterm, syscall.SIGTERM)
-	signal.Notify(sigterm, syscall.SIGINT)
-	<-sigterm
 }
 
 /*
  * 注册相关的api,profiling
  */
-func registerHandlers(eapi wapi.ElasticSearchApi, enableProfiling bool, port int, mux *http.ServeMux) {
+func registerHandlers(eapi wapi.QueryApi, clusters []*utils.Cluster, enableProfiling bool, port int, mux *http.ServeMux, httpAuth *auth.Auth) {
 	mux.HandleFunc("/events", eapi.GetPodEvt)
+	mux.HandleFunc("/api/v2/events", eapi.ListEvents)
 	mux.Handle("/metrics", promhttp.Handler())
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		b, _ := json.Marshal("{status: ok}")
 		w.Write(b)
 	})
+	//各集群的连接状态，任一集群不健康时返回503
+	mux.HandleFunc("/healthz/clusters", func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		var health []utils.ClusterHealth
+		for _, cluster := range clusters {
+			h := cluster.Health()
+			if !h.Healthy {
+				status = http.StatusServiceUnavailable
+			}
+			health = append(health, h)
+		}
+		b, _ := json.Marshal(health)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(b)
+	})
 
 	mux.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
 		err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
@@ -156,8 +432,20 @@ func registerHandlers(eapi wapi.ElasticSearchApi, enableProfiling bool, port int
 		Handler:           mux,
 		ReadTimeout:       10 * time.Second,
 		ReadHeaderTimeout: 10 * time.Second,
-		WriteTimeout:      300 * time.Second,
-		IdleTimeout:       120 * time.Second,
+		// 不设置WriteTimeout，否则/api/v2/events/stream在超时后被断开，SSE及WebSocket为每次写入单独设置超时
+		IdleTimeout: 120 * time.Second,
+		ConnContext: wapi.ConnContext,
+	}
+	if httpAuth != nil {
+		server.Handler = httpAuth.Handler(mux)
+		tlsConfig, err := httpAuth.TLSConfig()
+		if err != nil {
+			zlog.Fatal(err.Error())
+		}
+		if tlsConfig != nil {
+			server.TLSConfig = tlsConfig
+			zlog.Fatalf("%s", server.ListenAndServeTLS("", ""))
+		}
 	}
 	zlog.Fatalf("%s", server.ListenAndServe())
 }
@@ -0,0 +1,64 @@
+package trace
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/event"
+)
+
+// Span 是k8swatch处理一个对象的一个阶段
+type Span struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+/*
+Recorder 记录k8swatch处理一个对象的耗时，用于排查延迟
+root为从取出队列到分发给所有handler的整个过程，spans为其中的receive、enrich及每个handler的dispatch
+*/
+type Recorder interface {
+	Record(e event.Event, root Span, spans []Span)
+}
+
+// Process 记录一个对象的处理过程，recorder为nil时不做任何记录
+type Process struct {
+	recorder Recorder
+	start    time.Time
+	last     time.Time
+	spans    []Span
+}
+
+func Start(recorder Recorder) *Process {
+	now := time.Now()
+	return &Process{recorder: recorder, start: now, last: now}
+}
+
+// Step 结束从上一阶段结束到现在的阶段
+func (p *Process) Step(name string) {
+	if p.recorder == nil {
+		return
+	}
+	now := time.Now()
+	p.spans = append(p.spans, Span{Name: name, Start: p.last, End: now})
+	p.last = now
+}
+
+// Finish 将处理过程交给recorder
+func (p *Process) Finish(e event.Event, name string) {
+	if p.recorder == nil {
+		return
+	}
+	p.recorder.Record(e, Span{Name: name, Start: p.start, End: time.Now()}, p.spans)
+}
+
+// HandlerName 返回handler的名称，如*kafka.Kafka为kafka
+func HandlerName(h interface{}) string {
+	name := strings.TrimPrefix(fmt.Sprintf("%T", h), "*")
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
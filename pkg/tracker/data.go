@@ -0,0 +1,256 @@
+package tracker
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/utils/zlog"
+	api_v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const defaultCertExpiryDays = 30
+
+// certState is the leaf certificate of a TLS secret and what was reported about it
+type certState struct {
+	subject          string
+	notAfter         time.Time
+	expiringReported bool
+	expiredReported  bool
+}
+
+/*
+DataTracker 审计secrets和configmaps的data变化，只报告新增、删除、修改的key，任何情况下都不输出value；
+只有managedFields、annotation等元数据变化的UPDATE事件会被忽略
+TLS secret中的证书在CertExpiryDays天内过期或已过期时报警
+*/
+type DataTracker struct {
+	certExpiry time.Duration
+	emit       Emitter
+	now        func() time.Time
+
+	mu    sync.Mutex
+	certs map[string]*certState
+}
+
+func (d *DataTracker) Init(c config.Config, emit Emitter) error {
+	days := c.Trackers.Data.CertExpiryDays
+	if days <= 0 {
+		days = defaultCertExpiryDays
+	}
+	d.certExpiry = time.Duration(days) * 24 * time.Hour
+	d.emit = emit
+	d.now = time.Now
+	d.certs = make(map[string]*certState)
+	return nil
+}
+
+func (d *DataTracker) Resources() []string {
+	return []string{"secrets", "configmaps"}
+}
+
+func (d *DataTracker) OnAdd(obj interface{}) {
+	if secret, ok := obj.(*api_v1.Secret); ok {
+		d.emitAll(d.observeCert(secret))
+	}
+}
+
+func (d *DataTracker) OnUpdate(oldObj, newObj interface{}) {
+	var evts []event.Event
+	switch object := newObj.(type) {
+	case *api_v1.Secret:
+		if old, ok := oldObj.(*api_v1.Secret); ok {
+			if e, ok := keyChangeEvent("Secret", object.Namespace, object.Name, old.Data, object.Data); ok {
+				evts = append(evts, e)
+			}
+		}
+		evts = append(evts, d.observeCert(object)...)
+	case *api_v1.ConfigMap:
+		if old, ok := oldObj.(*api_v1.ConfigMap); ok {
+			if e, ok := keyChangeEvent("ConfigMap", object.Namespace, object.Name, configMapKeys(old), configMapKeys(object)); ok {
+				evts = append(evts, e)
+			}
+		}
+	}
+	d.emitAll(evts)
+}
+
+func (d *DataTracker) OnDelete(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		zlog.Errorf("dataTracker获取删除对象的key失败:%v", err)
+		return
+	}
+	d.mu.Lock()
+	delete(d.certs, key)
+	d.mu.Unlock()
+}
+
+// Significant drops updates which changed neither data nor labels, e.g. managedFields or annotation churn
+func (d *DataTracker) Significant(oldObj, newObj interface{}) bool {
+	switch object := newObj.(type) {
+	case *api_v1.Secret:
+		old, ok := oldObj.(*api_v1.Secret)
+		return !ok || old.Type != object.Type || !reflect.DeepEqual(old.Labels, object.Labels) ||
+			!reflect.DeepEqual(old.Data, object.Data)
+	case *api_v1.ConfigMap:
+		old, ok := oldObj.(*api_v1.ConfigMap)
+		return !ok || !reflect.DeepEqual(old.Labels, object.Labels) ||
+			!reflect.DeepEqual(configMapKeys(old), configMapKeys(object))
+	}
+	return true
+}
+
+func (d *DataTracker) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(evaluateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.emitAll(d.evaluateAll())
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// observeCert records the leaf certificate of a TLS secret
+func (d *DataTracker) observeCert(secret *api_v1.Secret) []event.Event {
+	key := secret.Namespace + "/" + secret.Name
+	crt, ok := secret.Data[api_v1.TLSCertKey]
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !ok {
+		delete(d.certs, key)
+		return nil
+	}
+	cert, err := parseLeafCert(crt)
+	if err != nil {
+		zlog.Warnf("secret:%s 的%s无法解析:%v", key, api_v1.TLSCertKey, err)
+		delete(d.certs, key)
+		return nil
+	}
+	st, ok := d.certs[key]
+	if !ok || !st.notAfter.Equal(cert.NotAfter) || st.subject != cert.Subject.String() {
+		st = &certState{subject: cert.Subject.String(), notAfter: cert.NotAfter}
+		d.certs[key] = st
+	}
+	if e, ok := d.checkCert(key, st, d.now()); ok {
+		return []event.Event{e}
+	}
+	return nil
+}
+
+func (d *DataTracker) evaluateAll() (evts []event.Event) {
+	now := d.now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, st := range d.certs {
+		if e, ok := d.checkCert(key, st, now); ok {
+			evts = append(evts, e)
+		}
+	}
+	return evts
+}
+
+// checkCert reports a certificate once when it is about to expire and once when it expired
+func (d *DataTracker) checkCert(key string, st *certState, now time.Time) (event.Event, bool) {
+	namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+	left := st.notAfter.Sub(now)
+	switch {
+	case left <= 0 && !st.expiredReported:
+		st.expiredReported = true
+		st.expiringReported = true
+		return certEvent(namespace, name, "CertificateExpired",
+			fmt.Sprintf("Certificate %s in TLS secret %s/%s expired at %s",
+				st.subject, namespace, name, st.notAfter.Format("2006-01-02 15:04:05"))), true
+	case left > 0 && left <= d.certExpiry && !st.expiringReported:
+		st.expiringReported = true
+		return certEvent(namespace, name, "CertificateExpiring",
+			fmt.Sprintf("Certificate %s in TLS secret %s/%s expires in %d days at %s",
+				st.subject, namespace, name, int(left.Hours()/24), st.notAfter.Format("2006-01-02 15:04:05"))), true
+	}
+	return event.Event{}, false
+}
+
+func (d *DataTracker) emitAll(evts []event.Event) {
+	for _, e := range evts {
+		zlog.Infof("dataTracker生成事件 %s:%s/%s reason:%s message:%s", e.InvolvedKind, e.Namespace, e.InvolvedName, e.Reason, e.Messages)
+		d.emit(e)
+	}
+}
+
+func certEvent(namespace, name, reason, message string) event.Event {
+	e := event.NewDerived("Secret", namespace, name, reason, event.WarningType, message)
+	e.ServiceName = name
+	return e
+}
+
+// keyChangeEvent describes the keys added, removed or changed between two versions, values are never included
+func keyChangeEvent(kind, namespace, name string, old, cur map[string][]byte) (event.Event, bool) {
+	var added, removed, changed []string
+	for k, v := range cur {
+		if ov, ok := old[k]; !ok {
+			added = append(added, k)
+		} else if !bytes.Equal(ov, v) {
+			changed = append(changed, k)
+		}
+	}
+	for k := range old {
+		if _, ok := cur[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	if len(added)+len(removed)+len(changed) == 0 {
+		return event.Event{}, false
+	}
+	var parts []string
+	for _, p := range []struct {
+		what string
+		keys []string
+	}{{"added", added}, {"removed", removed}, {"changed", changed}} {
+		if len(p.keys) > 0 {
+			sort.Strings(p.keys)
+			parts = append(parts, fmt.Sprintf("%s [%s]", p.what, strings.Join(p.keys, ", ")))
+		}
+	}
+	e := event.NewDerived(kind, namespace, name, kind+"DataChanged", event.NormalType,
+		fmt.Sprintf("%s %s/%s keys %s", kind, namespace, name, strings.Join(parts, ", ")))
+	e.ServiceName = name
+	return e, true
+}
+
+// configMapKeys merges data and binaryData, they can not share keys
+func configMapKeys(cm *api_v1.ConfigMap) map[string][]byte {
+	keys := make(map[string][]byte, len(cm.Data)+len(cm.BinaryData))
+	for k, v := range cm.Data {
+		keys[k] = []byte(v)
+	}
+	for k, v := range cm.BinaryData {
+		keys[k] = v
+	}
+	return keys
+}
+
+// parseLeafCert returns the first certificate of a PEM bundle, which is the leaf by convention
+func parseLeafCert(data []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package tracker
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	api_v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var dataTestConfig = config.Config{Trackers: config.Trackers{Data: config.DataTrackerConf{Enable: true, CertExpiryDays: 14}}}
+
+func testCertPEM(t *testing.T, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "shop.example.com"},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestDataTrackerKeyChanges(t *testing.T) {
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	d := new(DataTracker)
+	emitted := recordEmits(t, d, dataTestConfig)
+	d.now = func() time.Time { return now }
+
+	old := &api_v1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{Name: "db", Namespace: "shop", ResourceVersion: "1"},
+		Data: map[string][]byte{
+			"username": []byte("admin"),
+			"password": []byte("s3cret-old"),
+			"host":     []byte("db.local"),
+		},
+	}
+	cur := old.DeepCopy()
+	cur.ResourceVersion = "2"
+	cur.Data = map[string][]byte{
+		"username": []byte("admin"),
+		"password": []byte("s3cret-new"),
+		"port":     []byte("5432"),
+	}
+	d.OnUpdate(old, cur)
+
+	assertReasons(t, *emitted, []string{"SecretDataChanged"})
+	msg := (*emitted)[0].Messages
+	if msg != "Secret shop/db keys added [port], removed [host], changed [password]" {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+	for _, v := range []string{"s3cret", "admin", "5432", "db.local"} {
+		if strings.Contains(msg, v) {
+			t.Fatalf("secret value %q leaked: %s", v, msg)
+		}
+	}
+	if !d.Significant(old, cur) {
+		t.Fatal("data change should be significant")
+	}
+
+	churn := cur.DeepCopy()
+	churn.ResourceVersion = "3"
+	churn.Annotations = map[string]string{"kubectl.kubernetes.io/last-applied-configuration": "{}"}
+	churn.ManagedFields = []metaV1.ManagedFieldsEntry{{Manager: "kubectl"}}
+	d.OnUpdate(cur, churn)
+	assertReasons(t, *emitted, []string{"SecretDataChanged"})
+	if d.Significant(cur, churn) {
+		t.Fatal("annotation and managedFields churn should be ignored")
+	}
+
+	cm := &api_v1.ConfigMap{
+		ObjectMeta: metaV1.ObjectMeta{Name: "app", Namespace: "shop"},
+		Data:       map[string]string{"app.yaml": "a: 1"},
+	}
+	cm2 := cm.DeepCopy()
+	cm2.BinaryData = map[string][]byte{"logo.png": {0x89}}
+	d.OnUpdate(cm, cm2)
+	assertReasons(t, *emitted, []string{"SecretDataChanged", "ConfigMapDataChanged"})
+}
+
+func TestDataTrackerCertExpiry(t *testing.T) {
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	d := new(DataTracker)
+	emitted := recordEmits(t, d, dataTestConfig)
+	d.now = func() time.Time { return now }
+
+	secret := &api_v1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{Name: "shop-tls", Namespace: "shop"},
+		Type:       api_v1.SecretTypeTLS,
+		Data:       map[string][]byte{api_v1.TLSCertKey: testCertPEM(t, now.Add(20*24*time.Hour))},
+	}
+	d.OnAdd(secret)
+	assertReasons(t, *emitted, nil)
+
+	now = now.Add(7 * 24 * time.Hour)
+	d.emitAll(d.evaluateAll())
+	d.emitAll(d.evaluateAll())
+	assertReasons(t, *emitted, []string{"CertificateExpiring"})
+	if msg := (*emitted)[0].Messages; !strings.Contains(msg, "CN=shop.example.com") || !strings.Contains(msg, "expires in 13 days") {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+
+	now = now.Add(14 * 24 * time.Hour)
+	d.emitAll(d.evaluateAll())
+	assertReasons(t, *emitted, []string{"CertificateExpiring", "CertificateExpired"})
+
+	// 证书更新后重新跟踪
+	renewed := secret.DeepCopy()
+	renewed.Data[api_v1.TLSCertKey] = testCertPEM(t, now.Add(90*24*time.Hour))
+	d.OnUpdate(secret, renewed)
+	d.emitAll(d.evaluateAll())
+	assertReasons(t, *emitted, []string{"CertificateExpiring", "CertificateExpired", "SecretDataChanged"})
+}
@@ -0,0 +1,307 @@
+package tracker
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/utils/zlog"
+	"github.com/robfig/cron"
+	batch_v1 "k8s.io/api/batch/v1"
+	batch_v1beta1 "k8s.io/api/batch/v1beta1"
+	api_v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	defaultMissedScheduleGrace = 5 * time.Minute
+	// lastRunMaxAgeAnnotation 单独设置某个CronJob允许的最后一次运行距今时长，如 26h
+	lastRunMaxAgeAnnotation = "k8swatch/last-run-max-age"
+	// maxMissedSchedules 与cronjob controller一致，最多向前推算的计划次数
+	maxMissedSchedules = 100
+)
+
+// cronJobState remembers what was reported for a CronJob so that it is reported once
+type cronJobState struct {
+	cronJob        *batch_v1beta1.CronJob
+	reportedMissed time.Time
+	staleReported  bool
+	invalidLogged  bool
+}
+
+/*
+JobTracker 将Job的UPDATE转换为有意义的结果事件：完成(含耗时)、失败(含backoffLimit原因和失败的pod)、超过activeDeadlineSeconds，
+并检查CronJob是否错过计划时间或最后一次运行距今过久，事件带有所属CronJob，报警时归为batch类别
+*/
+type JobTracker struct {
+	missedScheduleGrace time.Duration
+	lastRunMaxAge       time.Duration
+	emit                Emitter
+	now                 func() time.Time
+	client              kubernetes.Interface
+	// failedPods returns the names of the failed pods of a job
+	failedPods func(namespace, job string) []string
+
+	mu       sync.Mutex
+	cronJobs map[string]*cronJobState
+}
+
+func (j *JobTracker) Init(c config.Config, emit Emitter) error {
+	conf := c.Trackers.Job
+	j.missedScheduleGrace = conf.MissedScheduleGrace
+	if j.missedScheduleGrace <= 0 {
+		j.missedScheduleGrace = defaultMissedScheduleGrace
+	}
+	j.lastRunMaxAge = conf.LastRunMaxAge
+	j.emit = emit
+	j.now = time.Now
+	j.failedPods = func(namespace, job string) []string {
+		return listFailedPods(j.client, namespace, job)
+	}
+	j.cronJobs = make(map[string]*cronJobState)
+	return nil
+}
+
+// SetClient sets the client used to look up the failed pods of a job
+func (j *JobTracker) SetClient(client kubernetes.Interface) {
+	j.client = client
+}
+
+func (j *JobTracker) Resources() []string {
+	return []string{"jobs", "cronjobs"}
+}
+
+// OnAdd 只记录CronJob，启动前已结束的Job不再报告
+func (j *JobTracker) OnAdd(obj interface{}) {
+	if cj, ok := obj.(*batch_v1beta1.CronJob); ok {
+		j.emitAll(j.observeCronJob(cj))
+	}
+}
+
+func (j *JobTracker) OnUpdate(oldObj, newObj interface{}) {
+	switch object := newObj.(type) {
+	case *batch_v1.Job:
+		if oldJob, ok := oldObj.(*batch_v1.Job); ok {
+			j.emitAll(j.jobEvents(oldJob, object))
+		}
+	case *batch_v1beta1.CronJob:
+		j.emitAll(j.observeCronJob(object))
+	}
+}
+
+func (j *JobTracker) OnDelete(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	if cj, ok := obj.(*batch_v1beta1.CronJob); ok {
+		j.mu.Lock()
+		delete(j.cronJobs, cj.Namespace+"/"+cj.Name)
+		j.mu.Unlock()
+	}
+}
+
+// Significant drops Job updates which changed neither the spec nor reached the Complete or Failed condition,
+// e.g. active/succeeded counts, startTime or managedFields churn; CronJob updates are always forwarded
+func (j *JobTracker) Significant(oldObj, newObj interface{}) bool {
+	job, ok := newObj.(*batch_v1.Job)
+	if !ok {
+		return true
+	}
+	old, ok := oldObj.(*batch_v1.Job)
+	return !ok || !reflect.DeepEqual(old.Spec, job.Spec) ||
+		(getJobCondition(old, batch_v1.JobComplete) == nil) != (getJobCondition(job, batch_v1.JobComplete) == nil) ||
+		(getJobCondition(old, batch_v1.JobFailed) == nil) != (getJobCondition(job, batch_v1.JobFailed) == nil)
+}
+
+func (j *JobTracker) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(evaluateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			j.emitAll(j.evaluateAll())
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// jobEvents reports the conditions a job reached with this update
+func (j *JobTracker) jobEvents(oldJob, job *batch_v1.Job) (evts []event.Event) {
+	if c := getJobCondition(job, batch_v1.JobComplete); c != nil && getJobCondition(oldJob, batch_v1.JobComplete) == nil {
+		msg := fmt.Sprintf("Job %s/%s completed", job.Namespace, job.Name)
+		if job.Status.StartTime != nil && job.Status.CompletionTime != nil {
+			msg += fmt.Sprintf(" in %s", job.Status.CompletionTime.Sub(job.Status.StartTime.Time).Round(time.Second))
+		}
+		msg += fmt.Sprintf(", %d pods succeeded", job.Status.Succeeded)
+		evts = append(evts, jobEvent(job, "JobCompleted", event.NormalType, msg))
+	}
+	if c := getJobCondition(job, batch_v1.JobFailed); c != nil && getJobCondition(oldJob, batch_v1.JobFailed) == nil {
+		if c.Reason == "DeadlineExceeded" {
+			var deadline int64
+			if job.Spec.ActiveDeadlineSeconds != nil {
+				deadline = *job.Spec.ActiveDeadlineSeconds
+			}
+			evts = append(evts, jobEvent(job, "JobDeadlineExceeded", event.WarningType,
+				fmt.Sprintf("Job %s/%s was active longer than activeDeadlineSeconds(%d): %s",
+					job.Namespace, job.Name, deadline, c.Message)))
+		} else {
+			var backoffLimit int32
+			if job.Spec.BackoffLimit != nil {
+				backoffLimit = *job.Spec.BackoffLimit
+			}
+			msg := fmt.Sprintf("Job %s/%s failed with %d failed pods (backoffLimit %d): %s: %s",
+				job.Namespace, job.Name, job.Status.Failed, backoffLimit, c.Reason, c.Message)
+			if pods := j.failedPods(job.Namespace, job.Name); len(pods) > 0 {
+				msg += fmt.Sprintf(", failed pods: %s", strings.Join(pods, ", "))
+			}
+			evts = append(evts, jobEvent(job, "JobFailed", event.WarningType, msg))
+		}
+	}
+	return evts
+}
+
+func (j *JobTracker) observeCronJob(cj *batch_v1beta1.CronJob) []event.Event {
+	now := j.now()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	key := cj.Namespace + "/" + cj.Name
+	st, ok := j.cronJobs[key]
+	if !ok {
+		st = &cronJobState{}
+		j.cronJobs[key] = st
+	}
+	if st.cronJob == nil || st.cronJob.Spec.Schedule != cj.Spec.Schedule {
+		st.invalidLogged = false
+	}
+	st.cronJob = cj
+	return j.evaluateCronJob(st, now)
+}
+
+func (j *JobTracker) evaluateAll() (evts []event.Event) {
+	now := j.now()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, st := range j.cronJobs {
+		evts = append(evts, j.evaluateCronJob(st, now)...)
+	}
+	return evts
+}
+
+// evaluateCronJob checks for missed schedules and a last run which is too old
+func (j *JobTracker) evaluateCronJob(st *cronJobState, now time.Time) (evts []event.Event) {
+	cj := st.cronJob
+	if cj.Spec.Suspend != nil && *cj.Spec.Suspend {
+		return nil
+	}
+	lastRun := cj.CreationTimestamp.Time
+	if cj.Status.LastScheduleTime != nil {
+		lastRun = cj.Status.LastScheduleTime.Time
+	}
+
+	sched, err := cron.ParseStandard(cj.Spec.Schedule)
+	if err != nil {
+		if !st.invalidLogged {
+			st.invalidLogged = true
+			zlog.Errorf("CronJob:%s/%s 的schedule:%q 无法解析:%v", cj.Namespace, cj.Name, cj.Spec.Schedule, err)
+		}
+	} else {
+		grace := j.missedScheduleGrace
+		if cj.Spec.StartingDeadlineSeconds != nil {
+			grace = time.Duration(*cj.Spec.StartingDeadlineSeconds) * time.Second
+		}
+		var missed time.Time
+		for t, i := sched.Next(lastRun), 0; !t.IsZero() && !t.Add(grace).After(now) && i < maxMissedSchedules; t, i = sched.Next(t), i+1 {
+			missed = t
+		}
+		if !missed.IsZero() && missed.After(st.reportedMissed) {
+			st.reportedMissed = missed
+			evts = append(evts, cronJobEvent(cj, "CronJobMissedSchedule",
+				fmt.Sprintf("CronJob %s/%s missed its schedule %q at %s, last run at %s",
+					cj.Namespace, cj.Name, cj.Spec.Schedule, missed.Format("2006-01-02 15:04:05"), lastRun.Format("2006-01-02 15:04:05"))))
+		}
+	}
+
+	maxAge := j.lastRunMaxAge
+	if v, ok := cj.Annotations[lastRunMaxAgeAnnotation]; ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			maxAge = d
+		} else if !st.invalidLogged {
+			st.invalidLogged = true
+			zlog.Errorf("CronJob:%s/%s 的annotation %s:%q 无法解析:%v", cj.Namespace, cj.Name, lastRunMaxAgeAnnotation, v, err)
+		}
+	}
+	if maxAge <= 0 {
+		return evts
+	}
+	if age := now.Sub(lastRun); age > maxAge {
+		if !st.staleReported {
+			st.staleReported = true
+			evts = append(evts, cronJobEvent(cj, "CronJobLastRunTooOld",
+				fmt.Sprintf("CronJob %s/%s last ran %s ago at %s, longer than %s",
+					cj.Namespace, cj.Name, age.Round(time.Second), lastRun.Format("2006-01-02 15:04:05"), maxAge)))
+		}
+	} else {
+		st.staleReported = false
+	}
+	return evts
+}
+
+func (j *JobTracker) emitAll(evts []event.Event) {
+	for _, e := range evts {
+		zlog.Infof("jobTracker生成事件 %s:%s/%s reason:%s message:%s", e.InvolvedKind, e.Namespace, e.InvolvedName, e.Reason, e.Messages)
+		j.emit(e)
+	}
+}
+
+func jobEvent(job *batch_v1.Job, reason, eventType, message string) event.Event {
+	e := event.NewDerived("Job", job.Namespace, job.Name, reason, eventType, message)
+	e.ServiceName = job.Name
+	if owner := metaV1.GetControllerOf(job); owner != nil && owner.Kind == "CronJob" {
+		e.OwnerKind = owner.Kind
+		e.OwnerName = owner.Name
+		e.ServiceName = owner.Name
+	}
+	return e
+}
+
+func cronJobEvent(cj *batch_v1beta1.CronJob, reason, message string) event.Event {
+	e := event.NewDerived("CronJob", cj.Namespace, cj.Name, reason, event.WarningType, message)
+	e.ServiceName = cj.Name
+	e.OwnerKind = "CronJob"
+	e.OwnerName = cj.Name
+	return e
+}
+
+func getJobCondition(job *batch_v1.Job, condType batch_v1.JobConditionType) *batch_v1.JobCondition {
+	for i := range job.Status.Conditions {
+		c := &job.Status.Conditions[i]
+		if c.Type == condType && c.Status == api_v1.ConditionTrue {
+			return c
+		}
+	}
+	return nil
+}
+
+func listFailedPods(client kubernetes.Interface, namespace, job string) (names []string) {
+	if client == nil {
+		return nil
+	}
+	pods, err := client.CoreV1().Pods(namespace).List(metaV1.ListOptions{LabelSelector: "job-name=" + job})
+	if err != nil {
+		zlog.Errorf("查询job:%s/%s 的pod失败:%v", namespace, job, err)
+		return nil
+	}
+	for _, p := range pods.Items {
+		if p.Status.Phase == api_v1.PodFailed {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,176 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	batch_v1 "k8s.io/api/batch/v1"
+	batch_v1beta1 "k8s.io/api/batch/v1beta1"
+	api_v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var jobTestConfig = config.Config{Trackers: config.Trackers{Job: config.JobTrackerConf{
+	Enable: true, MissedScheduleGrace: 5 * time.Minute, LastRunMaxAge: 2 * time.Hour,
+}}}
+
+func testFailedPods(namespace, job string) []string {
+	return []string{job + "-abcde", job + "-fghij"}
+}
+
+func testJob(conds ...batch_v1.JobCondition) *batch_v1.Job {
+	controller := true
+	backoffLimit := int32(1)
+	start := metaV1.NewTime(time.Date(2019, 10, 1, 1, 0, 0, 0, time.UTC))
+	return &batch_v1.Job{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      "report-1569891600",
+			Namespace: "data",
+			OwnerReferences: []metaV1.OwnerReference{
+				{Kind: "CronJob", Name: "report", Controller: &controller},
+			},
+		},
+		Spec: batch_v1.JobSpec{BackoffLimit: &backoffLimit},
+		Status: batch_v1.JobStatus{
+			Conditions: conds,
+			StartTime:  &start,
+		},
+	}
+}
+
+func TestJobTrackerCompleted(t *testing.T) {
+	now := time.Date(2019, 10, 1, 1, 5, 0, 0, time.UTC)
+	j := new(JobTracker)
+	emitted := recordEmits(t, j, jobTestConfig)
+	j.now = func() time.Time { return now }
+	j.failedPods = testFailedPods
+
+	running := testJob()
+	done := testJob(batch_v1.JobCondition{Type: batch_v1.JobComplete, Status: api_v1.ConditionTrue})
+	completion := metaV1.NewTime(time.Date(2019, 10, 1, 1, 2, 30, 0, time.UTC))
+	done.Status.CompletionTime = &completion
+	done.Status.Succeeded = 1
+	j.OnUpdate(running, done)
+	j.OnUpdate(done, done)
+
+	assertReasons(t, *emitted, []string{"JobCompleted"})
+	e := (*emitted)[0]
+	if e.Messages != "Job data/report-1569891600 completed in 2m30s, 1 pods succeeded" {
+		t.Fatalf("unexpected message: %s", e.Messages)
+	}
+	if e.OwnerKind != "CronJob" || e.OwnerName != "report" || e.ServiceName != "report" {
+		t.Fatalf("owning CronJob missing: %+v", e)
+	}
+}
+
+func TestJobTrackerSignificant(t *testing.T) {
+	now := time.Date(2019, 10, 1, 1, 5, 0, 0, time.UTC)
+	j := new(JobTracker)
+	recordEmits(t, j, jobTestConfig)
+	j.now = func() time.Time { return now }
+	j.failedPods = testFailedPods
+
+	running := testJob()
+	churn := running.DeepCopy()
+	churn.ResourceVersion = "2"
+	churn.Status.Active = 1
+	churn.ManagedFields = []metaV1.ManagedFieldsEntry{{Manager: "kube-controller-manager"}}
+	if j.Significant(running, churn) {
+		t.Fatal("status and managedFields churn should be ignored")
+	}
+	parallelism := int32(2)
+	scaled := churn.DeepCopy()
+	scaled.Spec.Parallelism = &parallelism
+	if !j.Significant(churn, scaled) {
+		t.Fatal("spec change should be significant")
+	}
+	for _, condType := range []batch_v1.JobConditionType{batch_v1.JobComplete, batch_v1.JobFailed} {
+		finished := testJob(batch_v1.JobCondition{Type: condType, Status: api_v1.ConditionTrue})
+		if !j.Significant(running, finished) {
+			t.Fatalf("%s should be significant", condType)
+		}
+		if j.Significant(finished, finished.DeepCopy()) {
+			t.Fatalf("%s job without changes should be ignored", condType)
+		}
+	}
+	if !j.Significant(&batch_v1beta1.CronJob{}, &batch_v1beta1.CronJob{}) {
+		t.Fatal("CronJob updates should be forwarded")
+	}
+}
+
+func TestJobTrackerFailed(t *testing.T) {
+	now := time.Date(2019, 10, 1, 1, 5, 0, 0, time.UTC)
+	j := new(JobTracker)
+	emitted := recordEmits(t, j, jobTestConfig)
+	j.now = func() time.Time { return now }
+	j.failedPods = testFailedPods
+
+	failed := testJob(batch_v1.JobCondition{
+		Type:    batch_v1.JobFailed,
+		Status:  api_v1.ConditionTrue,
+		Reason:  "BackoffLimitExceeded",
+		Message: "Job has reached the specified backoff limit",
+	})
+	failed.Status.Failed = 2
+	j.OnUpdate(testJob(), failed)
+
+	deadline := int64(600)
+	exceeded := testJob(batch_v1.JobCondition{
+		Type:    batch_v1.JobFailed,
+		Status:  api_v1.ConditionTrue,
+		Reason:  "DeadlineExceeded",
+		Message: "Job was active longer than specified deadline",
+	})
+	exceeded.Spec.ActiveDeadlineSeconds = &deadline
+	j.OnUpdate(testJob(), exceeded)
+
+	assertReasons(t, *emitted, []string{"JobFailed", "JobDeadlineExceeded"})
+	want := "Job data/report-1569891600 failed with 2 failed pods (backoffLimit 1): BackoffLimitExceeded: " +
+		"Job has reached the specified backoff limit, failed pods: report-1569891600-abcde, report-1569891600-fghij"
+	if msg := (*emitted)[0].Messages; msg != want {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+}
+
+func TestJobTrackerCronJob(t *testing.T) {
+	now := time.Date(2019, 10, 1, 1, 2, 0, 0, time.UTC)
+	j := new(JobTracker)
+	emitted := recordEmits(t, j, jobTestConfig)
+	j.now = func() time.Time { return now }
+	j.failedPods = testFailedPods
+
+	last := metaV1.NewTime(time.Date(2019, 10, 1, 1, 0, 0, 0, time.UTC))
+	cj := &batch_v1beta1.CronJob{
+		ObjectMeta: metaV1.ObjectMeta{Name: "report", Namespace: "data"},
+		Spec:       batch_v1beta1.CronJobSpec{Schedule: "0 * * * *"},
+		Status:     batch_v1beta1.CronJobStatus{LastScheduleTime: &last},
+	}
+	j.OnAdd(cj)
+	assertReasons(t, *emitted, nil)
+
+	// 02:00的计划在grace内，还不算错过
+	now = time.Date(2019, 10, 1, 2, 3, 0, 0, time.UTC)
+	j.emitAll(j.evaluateAll())
+	assertReasons(t, *emitted, nil)
+
+	now = time.Date(2019, 10, 1, 2, 6, 0, 0, time.UTC)
+	j.emitAll(j.evaluateAll())
+	j.emitAll(j.evaluateAll())
+	assertReasons(t, *emitted, []string{"CronJobMissedSchedule"})
+
+	now = time.Date(2019, 10, 1, 3, 6, 0, 0, time.UTC)
+	j.emitAll(j.evaluateAll())
+	assertReasons(t, *emitted, []string{"CronJobMissedSchedule", "CronJobMissedSchedule", "CronJobLastRunTooOld"})
+
+	// annotation覆盖全局设置
+	cj2 := cj.DeepCopy()
+	cj2.Annotations = map[string]string{lastRunMaxAgeAnnotation: "24h"}
+	cj2.Spec.Schedule = "0 0 * * *"
+	j2 := new(JobTracker)
+	emitted2 := recordEmits(t, j2, jobTestConfig)
+	j2.now = func() time.Time { return now }
+	j2.failedPods = testFailedPods
+	j2.OnAdd(cj2)
+	assertReasons(t, *emitted2, nil)
+}
@@ -0,0 +1,333 @@
+package tracker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/utils/zlog"
+	api_v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	defaultHoldDuration  = time.Minute
+	defaultFlapWindow    = 10 * time.Minute
+	defaultFlapThreshold = 4
+
+	// systemTaintPrefix 为node controller根据condition和cordon自动维护的污点，已有对应事件，不再重复报告
+	systemTaintPrefix = "node.kubernetes.io/"
+)
+
+// nodeCondition describes how a node condition is judged and which reasons it is reported with
+type nodeCondition struct {
+	condType      api_v1.NodeConditionType
+	badReason     string
+	recoverReason string
+	// badWhen is the status in which the condition is unhealthy,
+	// Ready is unhealthy in any status but True
+	badWhen api_v1.ConditionStatus
+}
+
+var nodeConditions = []nodeCondition{
+	{api_v1.NodeReady, "NodeNotReady", "NodeReady", ""},
+	{api_v1.NodeMemoryPressure, "NodeHasInsufficientMemory", "NodeHasSufficientMemory", api_v1.ConditionTrue},
+	{api_v1.NodeDiskPressure, "NodeHasDiskPressure", "NodeHasNoDiskPressure", api_v1.ConditionTrue},
+	{api_v1.NodePIDPressure, "NodeHasInsufficientPID", "NodeHasSufficientPID", api_v1.ConditionTrue},
+	{api_v1.NodeNetworkUnavailable, "NodeNetworkUnavailable", "NodeNetworkAvailable", api_v1.ConditionTrue},
+}
+
+func (nc nodeCondition) isBad(status api_v1.ConditionStatus) bool {
+	if nc.badWhen == "" {
+		return status != api_v1.ConditionTrue
+	}
+	return status == nc.badWhen
+}
+
+// conditionState is the state machine of one condition on one node
+type conditionState struct {
+	bad      bool
+	status   api_v1.ConditionStatus
+	message  string
+	since    time.Time // when the current state was observed first
+	badSince time.Time
+	// firing is set once the unhealthy state was reported and cleared on recovery
+	firing      bool
+	flapping    bool
+	transitions []time.Time
+}
+
+/*
+NodeTracker 跟踪node的Ready,MemoryPressure,DiskPressure,PIDPressure,NetworkUnavailable状态
+异常状态持续HoldDuration才报警，恢复时发送恢复事件；FlapWindow内切换达到FlapThreshold次视为抖动，
+抖动期间只发送一次NodeConditionFlapping，直到稳定后再按最终状态报警或恢复
+cordon/uncordon及污点变化会立即生成对应事件
+*/
+type NodeTracker struct {
+	holdDuration  time.Duration
+	flapWindow    time.Duration
+	flapThreshold int
+	emit          Emitter
+	now           func() time.Time
+
+	mu    sync.Mutex
+	nodes map[string]map[api_v1.NodeConditionType]*conditionState
+}
+
+func (n *NodeTracker) Init(c config.Config, emit Emitter) error {
+	conf := c.Trackers.Node
+	n.holdDuration = conf.HoldDuration
+	if n.holdDuration <= 0 {
+		n.holdDuration = defaultHoldDuration
+	}
+	n.flapWindow = conf.FlapWindow
+	if n.flapWindow <= 0 {
+		n.flapWindow = defaultFlapWindow
+	}
+	n.flapThreshold = conf.FlapThreshold
+	if n.flapThreshold <= 0 {
+		n.flapThreshold = defaultFlapThreshold
+	}
+	n.emit = emit
+	n.now = time.Now
+	n.nodes = make(map[string]map[api_v1.NodeConditionType]*conditionState)
+	return nil
+}
+
+func (n *NodeTracker) Resources() []string {
+	return []string{"nodes"}
+}
+
+// OnAdd 记录node的当前状态，informer启动时已处于异常的node不会重复报警
+func (n *NodeTracker) OnAdd(obj interface{}) {
+	node, ok := obj.(*api_v1.Node)
+	if !ok {
+		return
+	}
+	n.emitAll(n.observe(node))
+}
+
+func (n *NodeTracker) OnUpdate(oldObj, newObj interface{}) {
+	oldNode, ok := oldObj.(*api_v1.Node)
+	if !ok {
+		return
+	}
+	node, ok := newObj.(*api_v1.Node)
+	if !ok {
+		return
+	}
+	var evts []event.Event
+	if oldNode.Spec.Unschedulable != node.Spec.Unschedulable {
+		evts = append(evts, cordonEvent(node))
+	}
+	evts = append(evts, taintEvents(oldNode, node)...)
+	evts = append(evts, n.observe(node)...)
+	n.emitAll(evts)
+}
+
+func (n *NodeTracker) OnDelete(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		zlog.Errorf("nodeTracker获取删除对象的key失败:%v", err)
+		return
+	}
+	n.mu.Lock()
+	delete(n.nodes, key)
+	n.mu.Unlock()
+}
+
+func (n *NodeTracker) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(evaluateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.emitAll(n.evaluateAll())
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// observe feeds the conditions of node into their state machines
+func (n *NodeTracker) observe(node *api_v1.Node) (evts []event.Event) {
+	now := n.now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	states, seen := n.nodes[node.Name]
+	if !seen {
+		states = make(map[api_v1.NodeConditionType]*conditionState)
+		n.nodes[node.Name] = states
+	}
+	for _, nc := range nodeConditions {
+		cond := getNodeCondition(node, nc.condType)
+		if cond == nil {
+			continue
+		}
+		bad := nc.isBad(cond.Status)
+		st, ok := states[nc.condType]
+		if !ok {
+			since := cond.LastTransitionTime.Time
+			if since.IsZero() || since.After(now) {
+				since = now
+			}
+			st = &conditionState{bad: bad, status: cond.Status, message: cond.Message, since: since, badSince: since}
+			// 首次看到的node已持续异常超过HoldDuration，视为已报过警
+			st.firing = bad && !seen && now.Sub(since) >= n.holdDuration
+			states[nc.condType] = st
+		} else if st.bad != bad {
+			st.bad = bad
+			st.since = now
+			if bad {
+				st.badSince = now
+			}
+			st.transitions = append(n.recentTransitions(st, now), now)
+			if !st.flapping && len(st.transitions) >= n.flapThreshold {
+				st.flapping = true
+				zlog.Warnf("node:%s condition:%s 状态抖动，暂停报警", node.Name, nc.condType)
+				evts = append(evts, n.newEvent(node.Name, "NodeConditionFlapping", event.WarningType,
+					fmt.Sprintf("Node %s condition %s changed %d times within %s, alerts are suppressed until it is stable",
+						node.Name, nc.condType, len(st.transitions), n.flapWindow)))
+			}
+		}
+		st.status = cond.Status
+		st.message = cond.Message
+		if e, ok := n.evaluate(node.Name, nc, st, now); ok {
+			evts = append(evts, e)
+		}
+	}
+	return evts
+}
+
+func (n *NodeTracker) evaluateAll() (evts []event.Event) {
+	now := n.now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for name, states := range n.nodes {
+		for _, nc := range nodeConditions {
+			st, ok := states[nc.condType]
+			if !ok {
+				continue
+			}
+			if e, ok := n.evaluate(name, nc, st, now); ok {
+				evts = append(evts, e)
+			}
+		}
+	}
+	return evts
+}
+
+// evaluate moves the state machine forward, it returns the event to emit if any
+func (n *NodeTracker) evaluate(name string, nc nodeCondition, st *conditionState, now time.Time) (event.Event, bool) {
+	if st.flapping {
+		if len(n.recentTransitions(st, now)) > 0 {
+			return event.Event{}, false
+		}
+		zlog.Infof("node:%s condition:%s 已稳定，恢复报警", name, nc.condType)
+		st.flapping = false
+		st.transitions = nil
+	}
+	switch {
+	case st.bad && !st.firing && now.Sub(st.since) >= n.holdDuration:
+		st.firing = true
+		return n.newEvent(name, nc.badReason, event.WarningType,
+			fmt.Sprintf("Node %s condition %s has been %s for %s: %s",
+				name, nc.condType, st.status, now.Sub(st.badSince).Round(time.Second), st.message)), true
+	case !st.bad && st.firing:
+		st.firing = false
+		return n.newEvent(name, nc.recoverReason, event.NormalType,
+			fmt.Sprintf("Node %s condition %s recovered to %s after %s",
+				name, nc.condType, st.status, now.Sub(st.badSince).Round(time.Second))), true
+	}
+	return event.Event{}, false
+}
+
+// recentTransitions drops the transitions of st which are out of the flap window
+func (n *NodeTracker) recentTransitions(st *conditionState, now time.Time) []time.Time {
+	i := 0
+	for i < len(st.transitions) && now.Sub(st.transitions[i]) >= n.flapWindow {
+		i++
+	}
+	st.transitions = st.transitions[i:]
+	return st.transitions
+}
+
+func (n *NodeTracker) newEvent(name, reason, eventType, message string) event.Event {
+	e := event.NewDerived("Node", "", name, reason, eventType, message)
+	e.Host = name
+	return e
+}
+
+func (n *NodeTracker) emitAll(evts []event.Event) {
+	for _, e := range evts {
+		zlog.Infof("nodeTracker生成事件 node:%s reason:%s message:%s", e.InvolvedName, e.Reason, e.Messages)
+		n.emit(e)
+	}
+}
+
+func getNodeCondition(node *api_v1.Node, condType api_v1.NodeConditionType) *api_v1.NodeCondition {
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == condType {
+			return &node.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+func cordonEvent(node *api_v1.Node) event.Event {
+	var e event.Event
+	if node.Spec.Unschedulable {
+		e = event.NewDerived("Node", "", node.Name, "NodeNotSchedulable", event.WarningType,
+			fmt.Sprintf("Node %s has been cordoned, new pods will not be scheduled on it", node.Name))
+	} else {
+		e = event.NewDerived("Node", "", node.Name, "NodeSchedulable", event.NormalType,
+			fmt.Sprintf("Node %s has been uncordoned and is schedulable again", node.Name))
+	}
+	e.Host = node.Name
+	return e
+}
+
+// taintEvents reports the taints added to or removed from a node, a changed value counts as both
+func taintEvents(oldNode, node *api_v1.Node) (evts []event.Event) {
+	added := taintDiff(node.Spec.Taints, oldNode.Spec.Taints)
+	removed := taintDiff(oldNode.Spec.Taints, node.Spec.Taints)
+	if len(added) > 0 {
+		e := event.NewDerived("Node", "", node.Name, "NodeTaintAdded", event.WarningType,
+			fmt.Sprintf("Taints added to node %s: %s", node.Name, strings.Join(added, ", ")))
+		e.Host = node.Name
+		evts = append(evts, e)
+	}
+	if len(removed) > 0 {
+		e := event.NewDerived("Node", "", node.Name, "NodeTaintRemoved", event.NormalType,
+			fmt.Sprintf("Taints removed from node %s: %s", node.Name, strings.Join(removed, ", ")))
+		e.Host = node.Name
+		evts = append(evts, e)
+	}
+	return evts
+}
+
+// taintDiff returns the taints in a but not in b, formatted like kubectl does
+func taintDiff(a, b []api_v1.Taint) (diff []string) {
+	for _, t := range a {
+		if strings.HasPrefix(t.Key, systemTaintPrefix) {
+			continue
+		}
+		found := false
+		for _, o := range b {
+			if t.Key == o.Key && t.Value == o.Value && t.Effect == o.Effect {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, t.ToString())
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
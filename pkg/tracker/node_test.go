@@ -0,0 +1,160 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	api_v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var nodeTestConfig = config.Config{Trackers: config.Trackers{Node: config.NodeTrackerConf{
+	Enable:        true,
+	HoldDuration:  time.Minute,
+	FlapWindow:    10 * time.Minute,
+	FlapThreshold: 4,
+}}}
+
+func testNode(ready api_v1.ConditionStatus, since time.Time) *api_v1.Node {
+	return &api_v1.Node{
+		ObjectMeta: metaV1.ObjectMeta{Name: "node-1"},
+		Status: api_v1.NodeStatus{
+			Conditions: []api_v1.NodeCondition{
+				{Type: api_v1.NodeReady, Status: ready, LastTransitionTime: metaV1.NewTime(since)},
+				{Type: api_v1.NodeMemoryPressure, Status: api_v1.ConditionFalse, LastTransitionTime: metaV1.NewTime(since)},
+			},
+		},
+	}
+}
+
+func TestNodeTrackerHoldAndRecover(t *testing.T) {
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	n := new(NodeTracker)
+	emitted := recordEmits(t, n, nodeTestConfig)
+	n.now = func() time.Time { return now }
+
+	old := testNode(api_v1.ConditionTrue, now.Add(-time.Hour))
+	n.OnAdd(old)
+
+	notReady := testNode(api_v1.ConditionFalse, now)
+	n.OnUpdate(old, notReady)
+	if len(*emitted) != 0 {
+		t.Fatalf("alerted before hold duration: %v", reasons(*emitted))
+	}
+
+	now = now.Add(30 * time.Second)
+	n.emitAll(n.evaluateAll())
+	if len(*emitted) != 0 {
+		t.Fatalf("alerted before hold duration: %v", reasons(*emitted))
+	}
+
+	now = now.Add(31 * time.Second)
+	n.emitAll(n.evaluateAll())
+	if got := reasons(*emitted); len(got) != 1 || got[0] != "NodeNotReady" {
+		t.Fatalf("want NodeNotReady, got %v", got)
+	}
+	if e := (*emitted)[0]; e.Kind != "events" || e.InvolvedKind != "Node" || e.Host != "node-1" || e.Component != event.DerivedComponent {
+		t.Fatalf("unexpected derived event %+v", e)
+	}
+
+	n.emitAll(n.evaluateAll())
+	if len(*emitted) != 1 {
+		t.Fatalf("alert repeated: %v", reasons(*emitted))
+	}
+
+	now = now.Add(time.Minute)
+	n.OnUpdate(notReady, testNode(api_v1.ConditionTrue, now))
+	if got := reasons(*emitted); len(got) != 2 || got[1] != "NodeReady" {
+		t.Fatalf("want NodeReady recovery, got %v", got)
+	}
+}
+
+func TestNodeTrackerNoAlertForShortOutage(t *testing.T) {
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	n := new(NodeTracker)
+	emitted := recordEmits(t, n, nodeTestConfig)
+	n.now = func() time.Time { return now }
+
+	ready := testNode(api_v1.ConditionTrue, now.Add(-time.Hour))
+	n.OnAdd(ready)
+	notReady := testNode(api_v1.ConditionUnknown, now)
+	n.OnUpdate(ready, notReady)
+	now = now.Add(20 * time.Second)
+	n.OnUpdate(notReady, testNode(api_v1.ConditionTrue, now))
+	now = now.Add(5 * time.Minute)
+	n.emitAll(n.evaluateAll())
+
+	if len(*emitted) != 0 {
+		t.Fatalf("short outage should not be reported: %v", reasons(*emitted))
+	}
+}
+
+func TestNodeTrackerStartupDoesNotRealert(t *testing.T) {
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	n := new(NodeTracker)
+	emitted := recordEmits(t, n, nodeTestConfig)
+	n.now = func() time.Time { return now }
+
+	n.OnAdd(testNode(api_v1.ConditionFalse, now.Add(-time.Hour)))
+	n.emitAll(n.evaluateAll())
+	if len(*emitted) != 0 {
+		t.Fatalf("node already NotReady before start should not be reported: %v", reasons(*emitted))
+	}
+}
+
+func TestNodeTrackerFlapping(t *testing.T) {
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	n := new(NodeTracker)
+	emitted := recordEmits(t, n, nodeTestConfig)
+	n.now = func() time.Time { return now }
+
+	prev := testNode(api_v1.ConditionTrue, now.Add(-time.Hour))
+	n.OnAdd(prev)
+	status := api_v1.ConditionTrue
+	for i := 0; i < 6; i++ {
+		now = now.Add(90 * time.Second)
+		n.emitAll(n.evaluateAll())
+		if status == api_v1.ConditionTrue {
+			status = api_v1.ConditionFalse
+		} else {
+			status = api_v1.ConditionTrue
+		}
+		cur := testNode(status, now)
+		n.OnUpdate(prev, cur)
+		prev = cur
+	}
+	// 第4次切换时判定为抖动，此后报警被抑制
+	want := []string{"NodeNotReady", "NodeReady", "NodeNotReady", "NodeConditionFlapping"}
+	assertReasons(t, *emitted, want)
+
+	// 稳定超过FlapWindow后按最终状态(Ready)发送恢复
+	now = now.Add(11 * time.Minute)
+	n.emitAll(n.evaluateAll())
+	assertReasons(t, *emitted, append(want, "NodeReady"))
+}
+
+func TestNodeTrackerCordonAndTaints(t *testing.T) {
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	n := new(NodeTracker)
+	emitted := recordEmits(t, n, nodeTestConfig)
+	n.now = func() time.Time { return now }
+
+	old := testNode(api_v1.ConditionTrue, now.Add(-time.Hour))
+	old.Spec.Taints = []api_v1.Taint{{Key: "dedicated", Value: "db", Effect: api_v1.TaintEffectNoSchedule}}
+	n.OnAdd(old)
+
+	cur := old.DeepCopy()
+	cur.Spec.Unschedulable = true
+	cur.Spec.Taints = []api_v1.Taint{
+		{Key: "dedicated", Value: "web", Effect: api_v1.TaintEffectNoSchedule},
+		{Key: "node.kubernetes.io/unschedulable", Effect: api_v1.TaintEffectNoSchedule},
+	}
+	n.OnUpdate(old, cur)
+
+	assertReasons(t, *emitted, []string{"NodeNotSchedulable", "NodeTaintAdded", "NodeTaintRemoved"})
+	if msg := (*emitted)[1].Messages; msg != "Taints added to node node-1: dedicated=web:NoSchedule" {
+		t.Fatalf("unexpected taint message: %s", msg)
+	}
+}
@@ -0,0 +1,322 @@
+package tracker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/utils/zlog"
+	rbacV1 "k8s.io/api/rbac/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var defaultPrivilegedRoles = []string{"cluster-admin"}
+
+// escalatingVerbs allow to gain permissions which are not granted directly
+var escalatingVerbs = map[string]bool{"*": true, "escalate": true, "bind": true, "impersonate": true}
+
+// rbacRole is the audit relevant part of a Role or ClusterRole
+type rbacRole struct {
+	kind       string
+	namespace  string
+	name       string
+	rules      []rbacV1.PolicyRule
+	aggregated bool
+}
+
+// rbacBinding is the audit relevant part of a RoleBinding or ClusterRoleBinding
+type rbacBinding struct {
+	kind      string
+	namespace string
+	name      string
+	roleRef   rbacV1.RoleRef
+	subjects  []rbacV1.Subject
+}
+
+/*
+RBACTracker 审计roles,rolebindings,clusterroles,clusterrolebindings的变化，
+描述新增/删除的rule、绑定/解绑的subject，并识别提权操作(绑定到PrivilegedRoles、通配符verb/resource、escalate/bind/impersonate)，
+所有事件均按admin级别报警，便于安全团队接入SIEM
+由aggregationRule维护rule的ClusterRole不做rule对比，其变化来源于被聚合的ClusterRole
+*/
+type RBACTracker struct {
+	privilegedRoles map[string]bool
+	emit            Emitter
+	// startTime 之前已存在的对象在informer首次list时不做报告
+	startTime time.Time
+}
+
+func (r *RBACTracker) Init(c config.Config, emit Emitter) error {
+	roles := c.Trackers.RBAC.PrivilegedRoles
+	if len(roles) == 0 {
+		roles = defaultPrivilegedRoles
+	}
+	r.privilegedRoles = make(map[string]bool, len(roles))
+	for _, role := range roles {
+		r.privilegedRoles[role] = true
+	}
+	r.emit = emit
+	r.startTime = time.Now()
+	return nil
+}
+
+func (r *RBACTracker) Resources() []string {
+	return []string{"roles", "rolebindings", "clusterroles", "clusterrolebindings"}
+}
+
+func (r *RBACTracker) OnAdd(obj interface{}) {
+	if role, ok := getRBACRole(obj); ok && r.createdAfterStart(obj) {
+		r.emitAll(r.roleEvents(nil, &role))
+	} else if binding, ok := getRBACBinding(obj); ok && r.createdAfterStart(obj) {
+		r.emitAll(r.bindingEvents(nil, &binding))
+	}
+}
+
+func (r *RBACTracker) OnUpdate(oldObj, newObj interface{}) {
+	if role, ok := getRBACRole(newObj); ok {
+		if old, ok := getRBACRole(oldObj); ok {
+			r.emitAll(r.roleEvents(&old, &role))
+		}
+	} else if binding, ok := getRBACBinding(newObj); ok {
+		if old, ok := getRBACBinding(oldObj); ok {
+			r.emitAll(r.bindingEvents(&old, &binding))
+		}
+	}
+}
+
+func (r *RBACTracker) OnDelete(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	if role, ok := getRBACRole(obj); ok {
+		r.emitAll(r.roleEvents(&role, nil))
+	} else if binding, ok := getRBACBinding(obj); ok {
+		r.emitAll(r.bindingEvents(&binding, nil))
+	}
+}
+
+// Run does nothing, RBAC changes are reported as they are observed
+func (r *RBACTracker) Run(stopCh <-chan struct{}) {
+	<-stopCh
+}
+
+func (r *RBACTracker) createdAfterStart(obj interface{}) bool {
+	var created time.Time
+	switch object := obj.(type) {
+	case *rbacV1.Role:
+		created = object.CreationTimestamp.Time
+	case *rbacV1.ClusterRole:
+		created = object.CreationTimestamp.Time
+	case *rbacV1.RoleBinding:
+		created = object.CreationTimestamp.Time
+	case *rbacV1.ClusterRoleBinding:
+		created = object.CreationTimestamp.Time
+	}
+	return created.After(r.startTime)
+}
+
+// roleEvents describes the rules added and removed, old is nil on create and cur is nil on delete
+func (r *RBACTracker) roleEvents(old, cur *rbacRole) (evts []event.Event) {
+	switch {
+	case old == nil:
+		evts = append(evts, rbacEvent(cur.kind, cur.namespace, cur.name, "RBACRoleCreated", event.NormalType,
+			fmt.Sprintf("%s created with rules: %s", displayName(cur.kind, cur.namespace, cur.name), formatRules(cur.rules))))
+		old = &rbacRole{}
+	case cur == nil:
+		return append(evts, rbacEvent(old.kind, old.namespace, old.name, "RBACRoleDeleted", event.NormalType,
+			fmt.Sprintf("%s deleted, it had rules: %s", displayName(old.kind, old.namespace, old.name), formatRules(old.rules))))
+	case cur.aggregated:
+		return nil
+	default:
+		added, removed := ruleDiff(cur.rules, old.rules), ruleDiff(old.rules, cur.rules)
+		if len(added)+len(removed) == 0 {
+			return nil
+		}
+		var parts []string
+		if len(added) > 0 {
+			parts = append(parts, "added: "+formatRules(added))
+		}
+		if len(removed) > 0 {
+			parts = append(parts, "removed: "+formatRules(removed))
+		}
+		evts = append(evts, rbacEvent(cur.kind, cur.namespace, cur.name, "RBACRulesChanged", event.NormalType,
+			fmt.Sprintf("%s rules %s", displayName(cur.kind, cur.namespace, cur.name), strings.Join(parts, "; "))))
+	}
+
+	var escalating []rbacV1.PolicyRule
+	for _, rule := range ruleDiff(cur.rules, old.rules) {
+		if isEscalatingRule(rule) {
+			escalating = append(escalating, rule)
+		}
+	}
+	if len(escalating) > 0 {
+		evts = append(evts, rbacEvent(cur.kind, cur.namespace, cur.name, "RBACEscalation", event.WarningType,
+			fmt.Sprintf("%s was granted escalating rules: %s", displayName(cur.kind, cur.namespace, cur.name), formatRules(escalating))))
+	}
+	return evts
+}
+
+// bindingEvents describes the subjects bound and unbound, old is nil on create and cur is nil on delete
+func (r *RBACTracker) bindingEvents(old, cur *rbacBinding) (evts []event.Event) {
+	if cur == nil {
+		return []event.Event{rbacEvent(old.kind, old.namespace, old.name, "RBACSubjectsUnbound", event.NormalType,
+			fmt.Sprintf("%s unbound from %s/%s, %s deleted", formatSubjects(old.subjects), old.roleRef.Kind, old.roleRef.Name,
+				displayName(old.kind, old.namespace, old.name)))}
+	}
+	if old == nil {
+		old = &rbacBinding{}
+	}
+	via := displayName(cur.kind, cur.namespace, cur.name)
+	bound, unbound := subjectDiff(cur.subjects, old.subjects), subjectDiff(old.subjects, cur.subjects)
+	if len(bound) > 0 {
+		evts = append(evts, rbacEvent(cur.kind, cur.namespace, cur.name, "RBACSubjectsBound", event.NormalType,
+			fmt.Sprintf("%s bound to %s/%s via %s", formatSubjects(bound), cur.roleRef.Kind, cur.roleRef.Name, via)))
+		if cur.roleRef.Kind == "ClusterRole" && r.privilegedRoles[cur.roleRef.Name] {
+			evts = append(evts, rbacEvent(cur.kind, cur.namespace, cur.name, "RBACEscalation", event.WarningType,
+				fmt.Sprintf("%s bound to privileged ClusterRole %s via %s", formatSubjects(bound), cur.roleRef.Name, via)))
+		}
+	}
+	if len(unbound) > 0 {
+		evts = append(evts, rbacEvent(cur.kind, cur.namespace, cur.name, "RBACSubjectsUnbound", event.NormalType,
+			fmt.Sprintf("%s unbound from %s/%s via %s", formatSubjects(unbound), cur.roleRef.Kind, cur.roleRef.Name, via)))
+	}
+	return evts
+}
+
+func (r *RBACTracker) emitAll(evts []event.Event) {
+	for _, e := range evts {
+		zlog.Infof("rbacTracker生成事件 %s:%s/%s reason:%s message:%s", e.InvolvedKind, e.Namespace, e.InvolvedName, e.Reason, e.Messages)
+		r.emit(e)
+	}
+}
+
+func rbacEvent(kind, namespace, name, reason, eventType, message string) event.Event {
+	e := event.NewDerived(kind, namespace, name, reason, eventType, message)
+	e.ServiceName = name
+	return e
+}
+
+func getRBACRole(obj interface{}) (rbacRole, bool) {
+	switch object := obj.(type) {
+	case *rbacV1.Role:
+		return rbacRole{kind: "Role", namespace: object.Namespace, name: object.Name, rules: object.Rules}, true
+	case *rbacV1.ClusterRole:
+		return rbacRole{kind: "ClusterRole", name: object.Name, rules: object.Rules, aggregated: object.AggregationRule != nil}, true
+	}
+	return rbacRole{}, false
+}
+
+func getRBACBinding(obj interface{}) (rbacBinding, bool) {
+	switch object := obj.(type) {
+	case *rbacV1.RoleBinding:
+		return rbacBinding{kind: "RoleBinding", namespace: object.Namespace, name: object.Name, roleRef: object.RoleRef, subjects: object.Subjects}, true
+	case *rbacV1.ClusterRoleBinding:
+		return rbacBinding{kind: "ClusterRoleBinding", name: object.Name, roleRef: object.RoleRef, subjects: object.Subjects}, true
+	}
+	return rbacBinding{}, false
+}
+
+func displayName(kind, namespace, name string) string {
+	if namespace == "" {
+		return kind + " " + name
+	}
+	return kind + " " + namespace + "/" + name
+}
+
+// isEscalatingRule reports rules with wildcard verbs or resources, or verbs which allow to gain further permissions
+func isEscalatingRule(rule rbacV1.PolicyRule) bool {
+	for _, v := range rule.Verbs {
+		if escalatingVerbs[v] {
+			return true
+		}
+	}
+	for _, res := range rule.Resources {
+		if res == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func formatRule(rule rbacV1.PolicyRule) string {
+	var parts []string
+	if len(rule.APIGroups) > 0 {
+		groups := make([]string, len(rule.APIGroups))
+		for i, g := range rule.APIGroups {
+			groups[i] = fmt.Sprintf("%q", g)
+		}
+		parts = append(parts, fmt.Sprintf("apiGroups=[%s]", strings.Join(groups, " ")))
+	}
+	if len(rule.Resources) > 0 {
+		parts = append(parts, fmt.Sprintf("resources=[%s]", strings.Join(rule.Resources, " ")))
+	}
+	if len(rule.ResourceNames) > 0 {
+		parts = append(parts, fmt.Sprintf("resourceNames=[%s]", strings.Join(rule.ResourceNames, " ")))
+	}
+	if len(rule.NonResourceURLs) > 0 {
+		parts = append(parts, fmt.Sprintf("nonResourceURLs=[%s]", strings.Join(rule.NonResourceURLs, " ")))
+	}
+	parts = append(parts, fmt.Sprintf("verbs=[%s]", strings.Join(rule.Verbs, " ")))
+	return strings.Join(parts, " ")
+}
+
+func formatRules(rules []rbacV1.PolicyRule) string {
+	if len(rules) == 0 {
+		return "none"
+	}
+	formatted := make([]string, len(rules))
+	for i, rule := range rules {
+		formatted[i] = "{" + formatRule(rule) + "}"
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// ruleDiff returns the rules in a but not in b
+func ruleDiff(a, b []rbacV1.PolicyRule) (diff []rbacV1.PolicyRule) {
+	seen := make(map[string]bool, len(b))
+	for _, rule := range b {
+		seen[formatRule(rule)] = true
+	}
+	for _, rule := range a {
+		if !seen[formatRule(rule)] {
+			diff = append(diff, rule)
+		}
+	}
+	return diff
+}
+
+func formatSubject(s rbacV1.Subject) string {
+	if s.Namespace != "" {
+		return s.Kind + ":" + s.Namespace + "/" + s.Name
+	}
+	return s.Kind + ":" + s.Name
+}
+
+func formatSubjects(subjects []rbacV1.Subject) string {
+	if len(subjects) == 0 {
+		return "no subjects"
+	}
+	formatted := make([]string, len(subjects))
+	for i, s := range subjects {
+		formatted[i] = formatSubject(s)
+	}
+	sort.Strings(formatted)
+	return strings.Join(formatted, ", ")
+}
+
+// subjectDiff returns the subjects in a but not in b
+func subjectDiff(a, b []rbacV1.Subject) (diff []rbacV1.Subject) {
+	seen := make(map[string]bool, len(b))
+	for _, s := range b {
+		seen[formatSubject(s)] = true
+	}
+	for _, s := range a {
+		if !seen[formatSubject(s)] {
+			diff = append(diff, s)
+		}
+	}
+	return diff
+}
@@ -0,0 +1,79 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	rbacV1 "k8s.io/api/rbac/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var rbacTestConfig = config.Config{Trackers: config.Trackers{RBAC: config.RBACTrackerConf{Enable: true}}}
+
+func TestRBACTrackerRules(t *testing.T) {
+	r := new(RBACTracker)
+	emitted := recordEmits(t, r, rbacTestConfig)
+
+	existing := &rbacV1.Role{
+		ObjectMeta: metaV1.ObjectMeta{Name: "reader", Namespace: "shop", CreationTimestamp: metaV1.NewTime(r.startTime.Add(-time.Hour))},
+		Rules:      []rbacV1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list"}}},
+	}
+	r.OnAdd(existing)
+	assertReasons(t, *emitted, nil)
+
+	cur := existing.DeepCopy()
+	cur.Rules = []rbacV1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list"}},
+		{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"*"}},
+	}
+	r.OnUpdate(existing, cur)
+	assertReasons(t, *emitted, []string{"RBACRulesChanged", "RBACEscalation"})
+	want := `Role shop/reader rules added: {apiGroups=[""] resources=[secrets] verbs=[*]}`
+	if msg := (*emitted)[0].Messages; msg != want {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+
+	aggregated := &rbacV1.ClusterRole{
+		ObjectMeta:      metaV1.ObjectMeta{Name: "monitoring"},
+		AggregationRule: &rbacV1.AggregationRule{},
+	}
+	aggregated2 := aggregated.DeepCopy()
+	aggregated2.Rules = []rbacV1.PolicyRule{{Resources: []string{"*"}, Verbs: []string{"get"}}}
+	r.OnUpdate(aggregated, aggregated2)
+	r.OnDelete(cur)
+	assertReasons(t, *emitted, []string{"RBACRulesChanged", "RBACEscalation", "RBACRoleDeleted"})
+}
+
+func TestRBACTrackerBindings(t *testing.T) {
+	r := new(RBACTracker)
+	emitted := recordEmits(t, r, rbacTestConfig)
+
+	binding := &rbacV1.ClusterRoleBinding{
+		ObjectMeta: metaV1.ObjectMeta{Name: "ops-admin", CreationTimestamp: metaV1.NewTime(r.startTime.Add(time.Minute))},
+		RoleRef:    rbacV1.RoleRef{Kind: "ClusterRole", Name: "cluster-admin"},
+		Subjects: []rbacV1.Subject{
+			{Kind: "User", Name: "alice"},
+			{Kind: "ServiceAccount", Namespace: "ci", Name: "deployer"},
+		},
+	}
+	r.OnAdd(binding)
+	assertReasons(t, *emitted, []string{"RBACSubjectsBound", "RBACEscalation"})
+	want := "ServiceAccount:ci/deployer, User:alice bound to ClusterRole/cluster-admin via ClusterRoleBinding ops-admin"
+	if msg := (*emitted)[0].Messages; msg != want {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+
+	view := &rbacV1.RoleBinding{
+		ObjectMeta: metaV1.ObjectMeta{Name: "devs", Namespace: "shop"},
+		RoleRef:    rbacV1.RoleRef{Kind: "ClusterRole", Name: "view"},
+		Subjects:   []rbacV1.Subject{{Kind: "Group", Name: "devs"}},
+	}
+	view2 := view.DeepCopy()
+	view2.Subjects = []rbacV1.Subject{{Kind: "User", Name: "bob"}}
+	r.OnUpdate(view, view2)
+	assertReasons(t, *emitted, []string{"RBACSubjectsBound", "RBACEscalation", "RBACSubjectsBound", "RBACSubjectsUnbound"})
+	if msg := (*emitted)[3].Messages; msg != "Group:devs unbound from ClusterRole/view via RoleBinding shop/devs" {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+}
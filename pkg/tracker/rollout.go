@@ -0,0 +1,324 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/utils/zlog"
+	apps_v1 "k8s.io/api/apps/v1"
+	api_v1 "k8s.io/api/core/v1"
+	ext_v1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const defaultStuckTimeout = 10 * time.Minute
+
+// rolloutStatus is the rollout relevant part of a Deployment, StatefulSet or DaemonSet
+type rolloutStatus struct {
+	kind         string
+	namespace    string
+	name         string
+	templateHash string
+	images       []string
+	// observed is set when the workload controller has seen the latest spec
+	observed    bool
+	desired     int32
+	total       int32
+	updated     int32
+	available   int32
+	unavailable int32
+	// deadlineExceeded is set when the Deployment reports ProgressDeadlineExceeded
+	deadlineExceeded bool
+	deadlineMessage  string
+}
+
+func (s rolloutStatus) key() string {
+	return s.kind + "/" + s.namespace + "/" + s.name
+}
+
+func (s rolloutStatus) complete() bool {
+	return s.observed && s.updated == s.desired && s.total == s.updated && s.available == s.desired
+}
+
+func (s rolloutStatus) progress() string {
+	return fmt.Sprintf("%d/%d replicas updated, %d available, %d unavailable", s.updated, s.desired, s.available, s.unavailable)
+}
+
+// rolloutState follows one rollout of a workload
+type rolloutState struct {
+	templateHash string
+	inProgress   bool
+	startedAt    time.Time
+	// lastProgress is when updated or available replicas last changed during the rollout
+	lastProgress time.Time
+	stuck        bool
+	last         rolloutStatus
+}
+
+/*
+RolloutTracker 跟踪deployments,statefulsets,daemonsets的发布过程
+pod模板hash变化即视为新的发布，发布开始、进展、完成都会生成事件，便于与故障时间对照；
+Deployment报告ProgressDeadlineExceeded，或发布超过StuckTimeout没有进展且存在不可用副本时报警
+*/
+type RolloutTracker struct {
+	stuckTimeout time.Duration
+	emit         Emitter
+	now          func() time.Time
+
+	mu        sync.Mutex
+	workloads map[string]*rolloutState
+}
+
+func (r *RolloutTracker) Init(c config.Config, emit Emitter) error {
+	r.stuckTimeout = c.Trackers.Rollout.StuckTimeout
+	if r.stuckTimeout <= 0 {
+		r.stuckTimeout = defaultStuckTimeout
+	}
+	r.emit = emit
+	r.now = time.Now
+	r.workloads = make(map[string]*rolloutState)
+	return nil
+}
+
+func (r *RolloutTracker) Resources() []string {
+	return []string{"deployments", "statefulsets", "daemonsets"}
+}
+
+// OnAdd 记录workload的当前模板，启动时正在发布中的workload会继续跟踪直到完成
+func (r *RolloutTracker) OnAdd(obj interface{}) {
+	status, ok := getRolloutStatus(obj)
+	if !ok {
+		return
+	}
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.workloads[status.key()]; !ok {
+		r.seed(status, now)
+	}
+}
+
+func (r *RolloutTracker) seed(status rolloutStatus, now time.Time) {
+	r.workloads[status.key()] = &rolloutState{
+		templateHash: status.templateHash,
+		inProgress:   !status.complete(),
+		startedAt:    now,
+		lastProgress: now,
+		last:         status,
+	}
+}
+
+func (r *RolloutTracker) OnUpdate(oldObj, newObj interface{}) {
+	status, ok := getRolloutStatus(newObj)
+	if !ok {
+		return
+	}
+	r.emitAll(r.observe(status))
+}
+
+func (r *RolloutTracker) OnDelete(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	status, ok := getRolloutStatus(obj)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	delete(r.workloads, status.key())
+	r.mu.Unlock()
+}
+
+func (r *RolloutTracker) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(evaluateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.emitAll(r.evaluateAll())
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (r *RolloutTracker) observe(status rolloutStatus) (evts []event.Event) {
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	st, ok := r.workloads[status.key()]
+	if !ok {
+		r.seed(status, now)
+		return nil
+	}
+	if st.templateHash != status.templateHash {
+		if st.inProgress {
+			zlog.Infof("%s 上一次发布未完成即开始新的发布", status.key())
+		}
+		*st = rolloutState{
+			templateHash: status.templateHash,
+			inProgress:   true,
+			startedAt:    now,
+			lastProgress: now,
+			last:         status,
+		}
+		evts = append(evts, rolloutEvent(status, "RolloutStarted", event.NormalType,
+			fmt.Sprintf("Rollout of %s %s/%s started, images: %s",
+				strings.ToLower(status.kind), status.namespace, status.name, strings.Join(status.images, ", "))))
+	} else if st.inProgress && (st.last.updated != status.updated || st.last.available != status.available) {
+		st.lastProgress = now
+		evts = append(evts, rolloutEvent(status, "RolloutProgressing", event.NormalType,
+			fmt.Sprintf("Rollout of %s %s/%s is progressing: %s",
+				strings.ToLower(status.kind), status.namespace, status.name, status.progress())))
+	}
+	st.last = status
+	if !st.inProgress {
+		return evts
+	}
+
+	if status.complete() {
+		st.inProgress = false
+		evts = append(evts, rolloutEvent(status, "RolloutCompleted", event.NormalType,
+			fmt.Sprintf("Rollout of %s %s/%s completed in %s: %s",
+				strings.ToLower(status.kind), status.namespace, status.name, now.Sub(st.startedAt).Round(time.Second), status.progress())))
+		return evts
+	}
+	if status.deadlineExceeded && !st.stuck {
+		st.stuck = true
+		evts = append(evts, rolloutEvent(status, "ProgressDeadlineExceeded", event.WarningType,
+			fmt.Sprintf("Rollout of %s %s/%s exceeded its progress deadline: %s, %s",
+				strings.ToLower(status.kind), status.namespace, status.name, status.deadlineMessage, status.progress())))
+	}
+	if e, ok := r.checkStuck(st, now); ok {
+		evts = append(evts, e)
+	}
+	return evts
+}
+
+func (r *RolloutTracker) evaluateAll() (evts []event.Event) {
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, st := range r.workloads {
+		if e, ok := r.checkStuck(st, now); ok {
+			evts = append(evts, e)
+		}
+	}
+	return evts
+}
+
+// checkStuck reports a rollout which made no progress within stuckTimeout while replicas are unavailable
+func (r *RolloutTracker) checkStuck(st *rolloutState, now time.Time) (event.Event, bool) {
+	if !st.inProgress || st.stuck || st.last.unavailable == 0 || now.Sub(st.lastProgress) < r.stuckTimeout {
+		return event.Event{}, false
+	}
+	st.stuck = true
+	s := st.last
+	return rolloutEvent(s, "RolloutStuck", event.WarningType,
+		fmt.Sprintf("Rollout of %s %s/%s made no progress for %s: %s",
+			strings.ToLower(s.kind), s.namespace, s.name, now.Sub(st.lastProgress).Round(time.Second), s.progress())), true
+}
+
+func (r *RolloutTracker) emitAll(evts []event.Event) {
+	for _, e := range evts {
+		zlog.Infof("rolloutTracker生成事件 %s:%s/%s reason:%s message:%s", e.InvolvedKind, e.Namespace, e.InvolvedName, e.Reason, e.Messages)
+		r.emit(e)
+	}
+}
+
+func rolloutEvent(s rolloutStatus, reason, eventType, message string) event.Event {
+	e := event.NewDerived(s.kind, s.namespace, s.name, reason, eventType, message)
+	e.ServiceName = s.name
+	return e
+}
+
+func getRolloutStatus(obj interface{}) (rolloutStatus, bool) {
+	var s rolloutStatus
+	switch object := obj.(type) {
+	case *ext_v1beta1.Deployment:
+		s = rolloutStatus{
+			kind:         "Deployment",
+			namespace:    object.Namespace,
+			name:         object.Name,
+			templateHash: templateHash(object.Spec.Template),
+			images:       templateImages(object.Spec.Template),
+			observed:     object.Status.ObservedGeneration >= object.Generation,
+			desired:      1,
+			total:        object.Status.Replicas,
+			updated:      object.Status.UpdatedReplicas,
+			available:    object.Status.AvailableReplicas,
+			unavailable:  object.Status.UnavailableReplicas,
+		}
+		if object.Spec.Replicas != nil {
+			s.desired = *object.Spec.Replicas
+		}
+		for _, c := range object.Status.Conditions {
+			if c.Type == ext_v1beta1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+				s.deadlineExceeded = true
+				s.deadlineMessage = c.Message
+			}
+		}
+	case *apps_v1.StatefulSet:
+		s = rolloutStatus{
+			kind:         "StatefulSet",
+			namespace:    object.Namespace,
+			name:         object.Name,
+			templateHash: templateHash(object.Spec.Template),
+			images:       templateImages(object.Spec.Template),
+			observed:     object.Status.ObservedGeneration >= object.Generation,
+			desired:      1,
+			total:        object.Status.Replicas,
+			updated:      object.Status.UpdatedReplicas,
+			available:    object.Status.ReadyReplicas,
+		}
+		if object.Spec.Replicas != nil {
+			s.desired = *object.Spec.Replicas
+		}
+		if s.desired > s.available {
+			s.unavailable = s.desired - s.available
+		}
+	case *ext_v1beta1.DaemonSet:
+		s = rolloutStatus{
+			kind:         "DaemonSet",
+			namespace:    object.Namespace,
+			name:         object.Name,
+			templateHash: templateHash(object.Spec.Template),
+			images:       templateImages(object.Spec.Template),
+			observed:     object.Status.ObservedGeneration >= object.Generation,
+			desired:      object.Status.DesiredNumberScheduled,
+			total:        object.Status.UpdatedNumberScheduled,
+			updated:      object.Status.UpdatedNumberScheduled,
+			available:    object.Status.NumberAvailable,
+			unavailable:  object.Status.NumberUnavailable,
+		}
+	default:
+		return s, false
+	}
+	return s, true
+}
+
+// templateHash identifies a revision of the pod template, it changes on every new rollout
+func templateHash(template api_v1.PodTemplateSpec) string {
+	b, err := json.Marshal(template)
+	if err != nil {
+		zlog.Errorf("pod template序列化失败:%v", err)
+		return ""
+	}
+	h := fnv.New32a()
+	h.Write(b)
+	return fmt.Sprintf("%x", h.Sum32())
+}
+
+func templateImages(template api_v1.PodTemplateSpec) (images []string) {
+	for _, c := range template.Spec.Containers {
+		images = append(images, c.Image)
+	}
+	return images
+}
@@ -0,0 +1,115 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	api_v1 "k8s.io/api/core/v1"
+	ext_v1beta1 "k8s.io/api/extensions/v1beta1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var rolloutTestConfig = config.Config{Trackers: config.Trackers{Rollout: config.RolloutTrackerConf{Enable: true, StuckTimeout: 5 * time.Minute}}}
+
+func testDeployment(image string, generation int64, updated, available, unavailable int32) *ext_v1beta1.Deployment {
+	replicas := int32(3)
+	return &ext_v1beta1.Deployment{
+		ObjectMeta: metaV1.ObjectMeta{Name: "web", Namespace: "shop", Generation: generation},
+		Spec: ext_v1beta1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: api_v1.PodTemplateSpec{
+				Spec: api_v1.PodSpec{Containers: []api_v1.Container{{Name: "web", Image: image}}},
+			},
+		},
+		Status: ext_v1beta1.DeploymentStatus{
+			ObservedGeneration:  generation,
+			Replicas:            replicas,
+			UpdatedReplicas:     updated,
+			AvailableReplicas:   available,
+			UnavailableReplicas: unavailable,
+		},
+	}
+}
+
+func TestRolloutTrackerLifecycle(t *testing.T) {
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	r := new(RolloutTracker)
+	emitted := recordEmits(t, r, rolloutTestConfig)
+	r.now = func() time.Time { return now }
+
+	d := testDeployment("web:v1", 1, 3, 3, 0)
+	r.OnAdd(d)
+	// 仅副本状态变化，不是新的发布
+	r.OnUpdate(d, testDeployment("web:v1", 1, 3, 3, 0))
+	assertReasons(t, *emitted, nil)
+
+	d2 := testDeployment("web:v2", 2, 0, 3, 0)
+	r.OnUpdate(d, d2)
+	assertReasons(t, *emitted, []string{"RolloutStarted"})
+	if msg := (*emitted)[0].Messages; msg != "Rollout of deployment shop/web started, images: web:v2" {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+
+	d3 := testDeployment("web:v2", 2, 2, 2, 1)
+	r.OnUpdate(d2, d3)
+	now = now.Add(time.Minute)
+	d4 := testDeployment("web:v2", 2, 3, 3, 0)
+	r.OnUpdate(d3, d4)
+	assertReasons(t, *emitted, []string{"RolloutStarted", "RolloutProgressing", "RolloutProgressing", "RolloutCompleted"})
+	if e := (*emitted)[3]; e.InvolvedKind != "Deployment" || e.Namespace != "shop" || e.ServiceName != "web" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+}
+
+func TestRolloutTrackerStuck(t *testing.T) {
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	r := new(RolloutTracker)
+	emitted := recordEmits(t, r, rolloutTestConfig)
+	r.now = func() time.Time { return now }
+
+	d := testDeployment("web:v1", 1, 3, 3, 0)
+	r.OnAdd(d)
+	d2 := testDeployment("web:v2", 2, 1, 2, 1)
+	r.OnUpdate(d, d2)
+
+	now = now.Add(4 * time.Minute)
+	r.emitAll(r.evaluateAll())
+	assertReasons(t, *emitted, []string{"RolloutStarted"})
+
+	now = now.Add(2 * time.Minute)
+	r.emitAll(r.evaluateAll())
+	r.emitAll(r.evaluateAll())
+	assertReasons(t, *emitted, []string{"RolloutStarted", "RolloutStuck"})
+
+	d3 := d2.DeepCopy()
+	d3.Status.Conditions = []ext_v1beta1.DeploymentCondition{{
+		Type:    ext_v1beta1.DeploymentProgressing,
+		Status:  api_v1.ConditionFalse,
+		Reason:  "ProgressDeadlineExceeded",
+		Message: `ReplicaSet "web-5d4f" has timed out progressing.`,
+	}}
+	r.OnUpdate(d2, d3)
+	// 已报告过卡住，不重复报警
+	assertReasons(t, *emitted, []string{"RolloutStarted", "RolloutStuck"})
+}
+
+func TestRolloutTrackerDeadlineExceeded(t *testing.T) {
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	r := new(RolloutTracker)
+	emitted := recordEmits(t, r, rolloutTestConfig)
+	r.now = func() time.Time { return now }
+
+	d := testDeployment("web:v1", 1, 3, 3, 0)
+	r.OnAdd(d)
+	d2 := testDeployment("web:v2", 2, 1, 2, 1)
+	r.OnUpdate(d, d2)
+	d3 := d2.DeepCopy()
+	d3.Status.Conditions = []ext_v1beta1.DeploymentCondition{{
+		Type:   ext_v1beta1.DeploymentProgressing,
+		Status: api_v1.ConditionFalse,
+		Reason: "ProgressDeadlineExceeded",
+	}}
+	r.OnUpdate(d2, d3)
+	assertReasons(t, *emitted, []string{"RolloutStarted", "ProgressDeadlineExceeded"})
+}
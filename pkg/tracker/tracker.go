@@ -0,0 +1,90 @@
+package tracker
+
+import (
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+	"github.com/gok8s/k8swatch/utils/zlog"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+/*
+Tracker 挂在资源的informer上，对比对象前后状态得出更有意义的事件(如node持续NotReady)，
+通过Emitter交给各handler处理，原有的CREATE/UPDATE/DELETE事件不受影响
+*/
+type Tracker interface {
+	cache.ResourceEventHandler
+	// Init prepares tracker configuration, emit is used to deliver the derived events
+	Init(c config.Config, emit Emitter) error
+	// Resources returns the resources whose informers feed the tracker
+	Resources() []string
+	// Run evaluates time based transitions until stopCh is closed
+	Run(stopCh <-chan struct{})
+}
+
+// UpdateFilter is implemented by trackers which can tell that some UPDATE events of their resources are noise
+type UpdateFilter interface {
+	// Significant reports whether the update from old to new should still be forwarded to handlers
+	Significant(old, new interface{}) bool
+}
+
+// ClientUser is implemented by trackers which query the apiserver of the cluster they watch
+type ClientUser interface {
+	SetClient(client kubernetes.Interface)
+}
+
+// evaluateInterval is how often trackers check their time based transitions
+const evaluateInterval = 10 * time.Second
+
+// Emitter delivers an event derived by a tracker to the handlers
+type Emitter func(e event.Event)
+
+// Enabled returns the initialized trackers enabled in config, each watched cluster has its own trackers
+func Enabled(c config.Config, client kubernetes.Interface, emit Emitter) []Tracker {
+	var candidates []Tracker
+	if c.Trackers.Node.Enable {
+		zlog.Info("启用node tracker")
+		candidates = append(candidates, new(NodeTracker))
+	}
+	if c.Trackers.Rollout.Enable {
+		zlog.Info("启用rollout tracker")
+		candidates = append(candidates, new(RolloutTracker))
+	}
+	if c.Trackers.Job.Enable {
+		zlog.Info("启用job tracker")
+		candidates = append(candidates, new(JobTracker))
+	}
+	if c.Trackers.Data.Enable {
+		zlog.Info("启用data tracker")
+		candidates = append(candidates, new(DataTracker))
+	}
+	if c.Trackers.RBAC.Enable {
+		zlog.Info("启用rbac tracker")
+		candidates = append(candidates, new(RBACTracker))
+	}
+
+	var trackers []Tracker
+	for _, t := range candidates {
+		if err := t.Init(c, emit); err != nil {
+			zlog.Errorf("初始化tracker失败 resources:%v err:%v", t.Resources(), err)
+			continue
+		}
+		if u, ok := t.(ClientUser); ok {
+			u.SetClient(client)
+		}
+		trackers = append(trackers, t)
+	}
+	return trackers
+}
+
+// Watches reports whether t is fed by the informer of resource
+func Watches(t Tracker, resource string) bool {
+	for _, r := range t.Resources() {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package tracker
+
+import (
+	"testing"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/pkg/event"
+)
+
+// recordEmits 初始化tracker，返回其生成的事件
+func recordEmits(t *testing.T, tr Tracker, c config.Config) *[]event.Event {
+	t.Helper()
+	var emitted []event.Event
+	if err := tr.Init(c, func(e event.Event) { emitted = append(emitted, e) }); err != nil {
+		t.Fatalf("Init(): %v", err)
+	}
+	return &emitted
+}
+
+func reasons(evts []event.Event) (r []string) {
+	for _, e := range evts {
+		r = append(r, e.Reason)
+	}
+	return r
+}
+
+func assertReasons(t *testing.T, evts []event.Event, want []string) {
+	t.Helper()
+	got := reasons(evts)
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+}
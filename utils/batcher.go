@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Batcher 累积size个item或每interval调用一次flush，供批量发送的handler使用
+Close后flush剩余的item，flush在同一个goroutine中依次调用，不会并发
+*/
+type Batcher struct {
+	size  int
+	flush func(batch []interface{})
+
+	mu    sync.RWMutex
+	items chan interface{}
+	wg    sync.WaitGroup
+}
+
+func NewBatcher(size int, interval time.Duration, flush func(batch []interface{})) *Batcher {
+	b := &Batcher{
+		size:  size,
+		flush: flush,
+		items: make(chan interface{}, size),
+	}
+	b.wg.Add(1)
+	go b.run(b.items, interval)
+	return b
+}
+
+// Add 缓冲满时阻塞，Batcher为nil或已关闭时返回false
+func (b *Batcher) Add(item interface{}) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.items == nil {
+		return false
+	}
+	b.items <- item
+	return true
+}
+
+func (b *Batcher) run(items <-chan interface{}, interval time.Duration) {
+	defer b.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var batch []interface{}
+	flush := func() {
+		if len(batch) > 0 {
+			b.flush(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case item, ok := <-items:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, item)
+			if len(batch) >= b.size {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Close flushes the pending items and waits for it, it is safe to call more than once
+func (b *Batcher) Close() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	items := b.items
+	b.items = nil
+	b.mu.Unlock()
+	if items == nil {
+		return
+	}
+	close(items)
+	b.wg.Wait()
+}
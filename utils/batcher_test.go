@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type flushed struct {
+	mu      sync.Mutex
+	batches [][]interface{}
+}
+
+func (f *flushed) flush(batch []interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches = append(f.batches, batch)
+}
+
+func (f *flushed) get() [][]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]interface{}(nil), f.batches...)
+}
+
+func TestBatcherSize(t *testing.T) {
+	f := &flushed{}
+	b := NewBatcher(2, time.Hour, f.flush)
+	for i := 0; i < 5; i++ {
+		if !b.Add(i) {
+			t.Fatalf("Add(%d) = false", i)
+		}
+	}
+	// 满2个时flush，剩余的1个在Close时flush
+	b.Close()
+	batches := f.get()
+	if len(batches) != 3 || len(batches[0]) != 2 || batches[0][0] != 0 || len(batches[2]) != 1 || batches[2][0] != 4 {
+		t.Fatalf("unexpected batches: %v", batches)
+	}
+	if b.Add(5) {
+		t.Fatal("Add after Close = true")
+	}
+	// 可重复关闭，没有剩余时不调用flush
+	b.Close()
+	if len(f.get()) != 3 {
+		t.Fatalf("flush after second Close: %v", f.get())
+	}
+}
+
+func TestBatcherInterval(t *testing.T) {
+	f := &flushed{}
+	b := NewBatcher(100, 50*time.Millisecond, f.flush)
+	defer b.Close()
+	b.Add("a")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.get()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("batch is not flushed after interval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if batches := f.get(); len(batches) != 1 || len(batches[0]) != 1 || batches[0][0] != "a" {
+		t.Fatalf("unexpected batches: %v", batches)
+	}
+}
+
+func TestBatcherNil(t *testing.T) {
+	var b *Batcher
+	if b.Add(1) {
+		t.Fatal("Add on nil Batcher = true")
+	}
+	b.Close()
+}
@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"github.com/gok8s/k8swatch/utils/zlog"
+	"github.com/prometheus/client_golang/prometheus"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const kubeConfigSecretKey = "kubeconfig"
+
+var clusterHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "k8swatch_cluster_healthy",
+	Help: "Whether the apiserver of a watched cluster is reachable, 1 for healthy.",
+}, []string{"cluster"})
+
+func init() {
+	prometheus.MustRegister(clusterHealthy)
+}
+
+/*
+Cluster 是一个被监听的集群，每个集群有自己的client、informer和资源列表
+连接状态单独检查，某个集群不可用不影响其他集群
+*/
+type Cluster struct {
+	Name      string
+	Meta      config.ClusterMeta
+	Resources []config.Resource
+	// KubeClient is nil when the client could not be built
+	KubeClient kubernetes.Interface
+	// ResourceGetterMap is a map of resource name to resource Getter interface
+	ResourceGetterMap map[string]cache.Getter
+
+	mu     sync.RWMutex
+	health ClusterHealth
+}
+
+// ClusterHealth is the result of the last connection check of a cluster
+type ClusterHealth struct {
+	Cluster   string    `json:"cluster"`
+	Healthy   bool      `json:"healthy"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// Health returns the result of the last connection check
+func (c *Cluster) Health() ClusterHealth {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.health
+}
+
+func (c *Cluster) setHealth(err error) {
+	h := ClusterHealth{Cluster: c.Name, Healthy: err == nil, CheckedAt: time.Now()}
+	if err != nil {
+		h.Error = err.Error()
+	}
+	c.mu.Lock()
+	prev := c.health
+	c.health = h
+	c.mu.Unlock()
+	if h.Healthy {
+		clusterHealthy.WithLabelValues(c.Name).Set(1)
+		if !prev.Healthy && !prev.CheckedAt.IsZero() {
+			zlog.Infof("集群:%s 连接已恢复", c.Name)
+		}
+	} else {
+		clusterHealthy.WithLabelValues(c.Name).Set(0)
+		if prev.Healthy || prev.CheckedAt.IsZero() {
+			zlog.Errorf("集群:%s 连接异常:%v", c.Name, err)
+		}
+	}
+}
+
+// Check requests the apiserver version to tell whether the cluster is reachable
+func (c *Cluster) Check() {
+	if c.KubeClient == nil {
+		return
+	}
+	_, err := c.KubeClient.Discovery().ServerVersion()
+	c.setHealth(err)
+}
+
+// RunHealthCheck checks the cluster connection every interval until stopCh is closed
+func (c *Cluster) RunHealthCheck(interval time.Duration, stopCh <-chan struct{}) {
+	c.Check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.Check()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+/*
+NewClusters 根据配置创建各集群的client，k8s.clusters为空时沿用原有的单集群配置
+client创建失败的集群仍会返回，其状态为不健康，不会启动informer
+集群名称用于事件的cluster字段、/audit/<集群名称>及健康状态，不能为空或重复
+*/
+func NewClusters(c config.Config) ([]*Cluster, error) {
+	if len(c.K8s.Clusters) == 0 {
+		cluster := &Cluster{Name: c.K8s.ClusterName, Meta: c.K8s.Metadata, Resources: c.Resources}
+		if _, err := rest.InClusterConfig(); err != nil {
+			zlog.Error(err.Error())
+			cluster.KubeClient = GetClientOutOfCluster(c.K8s.APIServerHost, c.K8s.KubeConfigFile)
+		} else {
+			cluster.KubeClient = GetClient()
+		}
+		cluster.ResourceGetterMap = resourceGetters(cluster.KubeClient)
+		return []*Cluster{cluster}, nil
+	}
+
+	names := map[string]bool{}
+	for i, conf := range c.K8s.Clusters {
+		if conf.Name == "" {
+			return nil, fmt.Errorf("k8s.clusters[%d]的name不能为空", i)
+		}
+		if names[conf.Name] {
+			return nil, fmt.Errorf("k8s.clusters中的集群名称%q重复", conf.Name)
+		}
+		names[conf.Name] = true
+	}
+	var local kubernetes.Interface
+	var clusters []*Cluster
+	for _, conf := range c.K8s.Clusters {
+		cluster := &Cluster{Name: conf.Name, Meta: c.K8s.Metadata.Merge(conf.Metadata), Resources: conf.Resources}
+		if len(cluster.Resources) == 0 {
+			cluster.Resources = c.Resources
+		}
+		var restConfig *rest.Config
+		var err error
+		switch {
+		case conf.InCluster:
+			restConfig, err = rest.InClusterConfig()
+		case conf.KubeConfigSecret != "":
+			if local == nil {
+				if local, err = localClient(); err != nil {
+					local = nil
+				}
+			}
+			if err == nil {
+				restConfig, err = configFromSecret(local, conf.KubeConfigSecret, conf.Context)
+			}
+		default:
+			restConfig, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+				&clientcmd.ClientConfigLoadingRules{ExplicitPath: conf.KubeConfigFile},
+				&clientcmd.ConfigOverrides{CurrentContext: conf.Context}).ClientConfig()
+		}
+		var clientset *kubernetes.Clientset
+		if err == nil {
+			restConfig.Burst = defaultBurst
+			restConfig.QPS = defaultQPS
+			clientset, err = kubernetes.NewForConfig(restConfig)
+		}
+		if err != nil {
+			zlog.Errorf("创建集群:%s 的client失败:%v", conf.Name, err)
+			cluster.setHealth(err)
+		} else {
+			cluster.KubeClient = clientset
+			cluster.ResourceGetterMap = resourceGetters(clientset)
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters, nil
+}
+
+func localClient() (kubernetes.Interface, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("读取kubeConfigSecret需要在集群内运行:%v", err)
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
+
+// configFromSecret builds the client config from a kubeconfig stored in the secret namespace/name
+func configFromSecret(client kubernetes.Interface, ref, context string) (*rest.Config, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(ref)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := client.CoreV1().Secrets(namespace).Get(name, metaV1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	data, ok := secret.Data[kubeConfigSecretKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s 中没有%s", ref, kubeConfigSecretKey)
+	}
+	kubeConfig, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, err
+	}
+	return clientcmd.NewNonInteractiveClientConfig(*kubeConfig, context, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gok8s/k8swatch/pkg/config"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: a
+  cluster:
+    server: https://a.example.com:6443
+- name: b
+  cluster:
+    server: https://b.example.com:6443
+users:
+- name: watcher
+  user:
+    token: t0ken
+contexts:
+- name: a
+  context: {cluster: a, user: watcher}
+- name: b
+  context: {cluster: b, user: watcher}
+current-context: a
+`
+
+func TestNewClusters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(path, []byte(testKubeConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.Config{Resources: []config.Resource{{Name: "pods", Enable: true}}}
+	c.K8s.Metadata = config.ClusterMeta{Environment: "prod", Region: "cn-east", Labels: map[string]string{"team": "infra"}}
+	c.K8s.Clusters = []config.Cluster{
+		{Name: "a", KubeConfigFile: path},
+		{Name: "b", KubeConfigFile: path, Context: "b", Resources: []config.Resource{{Name: "nodes", Enable: true}},
+			Metadata: config.ClusterMeta{Region: "cn-north", Labels: map[string]string{"tier": "edge"}}},
+		{Name: "broken", KubeConfigFile: filepath.Join(dir, "missing")},
+	}
+	clusters, err := NewClusters(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 3 {
+		t.Fatalf("NewClusters() returned %d clusters", len(clusters))
+	}
+	if clusters[0].KubeClient == nil || clusters[0].ResourceGetterMap["pods"] == nil || clusters[0].Resources[0].Name != "pods" {
+		t.Fatalf("cluster a not initialized: %+v", clusters[0])
+	}
+	if clusters[1].Resources[0].Name != "nodes" {
+		t.Fatalf("cluster b should use its own resources: %+v", clusters[1].Resources)
+	}
+	if m := clusters[1].Meta; m.Environment != "prod" || m.Region != "cn-north" || m.Labels["team"] != "infra" || m.Labels["tier"] != "edge" {
+		t.Fatalf("cluster b metadata not merged: %+v", m)
+	}
+	if len(c.K8s.Metadata.Labels) != 1 {
+		t.Fatalf("merge modified the default labels: %v", c.K8s.Metadata.Labels)
+	}
+	if clusters[2].KubeClient != nil || clusters[2].Health().Healthy || clusters[2].Health().Error == "" {
+		t.Fatalf("broken cluster should be unhealthy: %+v", clusters[2].Health())
+	}
+
+	for _, invalid := range [][]config.Cluster{
+		{{Name: "a", KubeConfigFile: path}, {Name: "a", KubeConfigFile: path, Context: "b"}},
+		{{KubeConfigFile: path}, {KubeConfigFile: path, Context: "b"}},
+		{{Name: "a", KubeConfigFile: path}, {KubeConfigFile: path}},
+	} {
+		c.K8s.Clusters = invalid
+		if _, err := NewClusters(c); err == nil {
+			t.Errorf("NewClusters(%+v) expected an error for an empty or duplicate name", invalid)
+		}
+	}
+
+	healthy := &Cluster{Name: "fake", KubeClient: fake.NewSimpleClientset()}
+	healthy.Check()
+	if h := healthy.Health(); !h.Healthy || h.Cluster != "fake" {
+		t.Fatalf("fake cluster should be healthy: %+v", h)
+	}
+}
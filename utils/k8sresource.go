@@ -2,13 +2,13 @@ package utils
 
 import (
 	"github.com/gok8s/k8swatch/pkg/config"
-	"github.com/gok8s/k8swatch/utils/zlog"
+	appsV1 "k8s.io/api/apps/v1"
 	batchV1 "k8s.io/api/batch/v1"
+	batchV1beta1 "k8s.io/api/batch/v1beta1"
 	apiV1 "k8s.io/api/core/v1"
 	extV1beta1 "k8s.io/api/extensions/v1beta1" //deployment-->appsV1beta;@1.14
 	rbacV1 "k8s.io/api/rbac/v1"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/rest"
 
 	"k8s.io/client-go/kubernetes"
 
@@ -19,23 +19,14 @@ import (
 var (
 	// RtObjectMap is a map of resource name to respective runtime object
 	RtObjectMap map[string]runtime.Object
-	// ResourceGetterMap is a map of resource name to resource Getter interface
-	ResourceGetterMap map[string]cache.Getter
 	// AllowedEventKindsMap is a map to filter valid event kinds
 	AllowedEventKindsMap map[EventKind]bool
-	// KubeClient is a global kubernetes client to communicate to apiserver
-	KubeClient kubernetes.Interface
 )
 
-func Init(config config.Config) {
-	_, err := rest.InClusterConfig()
-	if err != nil {
-		zlog.Error(err.Error())
-		KubeClient = GetClientOutOfCluster(config.K8s.APIServerHost, config.K8s.KubeConfigFile)
-	} else {
-		KubeClient = GetClient()
-	}
+// Init creates the resource maps and the clients of the watched clusters
+func Init(config config.Config) ([]*Cluster, error) {
 	createMaps()
+	return NewClusters(config)
 }
 
 // EventKind used in AllowedEventKindsMap to filter event kinds
@@ -46,7 +37,6 @@ type EventKind struct {
 
 func createMaps() {
 	RtObjectMap = make(map[string]runtime.Object)
-	ResourceGetterMap = make(map[string]cache.Getter)
 	AllowedEventKindsMap = make(map[EventKind]bool)
 
 	// Runtime object map
@@ -65,34 +55,42 @@ func createMaps() {
 	RtObjectMap["deployments"] = &extV1beta1.Deployment{}
 	RtObjectMap["daemonsets"] = &extV1beta1.DaemonSet{}
 	RtObjectMap["replicasets"] = &extV1beta1.ReplicaSet{}
+	RtObjectMap["statefulsets"] = &appsV1.StatefulSet{}
 	RtObjectMap["ingresses"] = &extV1beta1.Ingress{}
 	RtObjectMap["jobs"] = &batchV1.Job{}
+	RtObjectMap["cronjobs"] = &batchV1beta1.CronJob{}
 	RtObjectMap["roles"] = &rbacV1.Role{}
 	RtObjectMap["rolebindings"] = &rbacV1.RoleBinding{}
 	RtObjectMap["clusterroles"] = &rbacV1.ClusterRole{}
 	RtObjectMap["clusterrolebindings"] = &rbacV1.ClusterRoleBinding{}
+}
 
-	// Getter map
-	ResourceGetterMap["events"] = KubeClient.CoreV1().RESTClient()
-	ResourceGetterMap["endpoints"] = KubeClient.CoreV1().RESTClient()
-	ResourceGetterMap["pods"] = KubeClient.CoreV1().RESTClient()
-	ResourceGetterMap["nodes"] = KubeClient.CoreV1().RESTClient()
-	ResourceGetterMap["services"] = KubeClient.CoreV1().RESTClient()
-	ResourceGetterMap["namespaces"] = KubeClient.CoreV1().RESTClient()
-	ResourceGetterMap["replicationcontrollers"] = KubeClient.CoreV1().RESTClient()
-	ResourceGetterMap["persistentvolumes"] = KubeClient.CoreV1().RESTClient()
-	ResourceGetterMap["persistentvolumeClaim"] = KubeClient.CoreV1().RESTClient()
-	ResourceGetterMap["secrets"] = KubeClient.CoreV1().RESTClient()
-	ResourceGetterMap["configmaps"] = KubeClient.CoreV1().RESTClient()
-	ResourceGetterMap["deployments"] = KubeClient.ExtensionsV1beta1().RESTClient()
-	ResourceGetterMap["daemonsets"] = KubeClient.ExtensionsV1beta1().RESTClient()
-	ResourceGetterMap["replicasets"] = KubeClient.ExtensionsV1beta1().RESTClient()
-	ResourceGetterMap["ingresses"] = KubeClient.ExtensionsV1beta1().RESTClient()
-	ResourceGetterMap["jobs"] = KubeClient.BatchV1().RESTClient()
-	ResourceGetterMap["roles"] = KubeClient.RbacV1().RESTClient()
-	ResourceGetterMap["rolebindings"] = KubeClient.RbacV1().RESTClient()
-	ResourceGetterMap["clusterroles"] = KubeClient.RbacV1().RESTClient()
-	ResourceGetterMap["clusterrolebindings"] = KubeClient.RbacV1().RESTClient()
+// resourceGetters maps resource names to the rest client of their API group
+func resourceGetters(client kubernetes.Interface) map[string]cache.Getter {
+	getters := make(map[string]cache.Getter)
+	getters["events"] = client.CoreV1().RESTClient()
+	getters["endpoints"] = client.CoreV1().RESTClient()
+	getters["pods"] = client.CoreV1().RESTClient()
+	getters["nodes"] = client.CoreV1().RESTClient()
+	getters["services"] = client.CoreV1().RESTClient()
+	getters["namespaces"] = client.CoreV1().RESTClient()
+	getters["replicationcontrollers"] = client.CoreV1().RESTClient()
+	getters["persistentvolumes"] = client.CoreV1().RESTClient()
+	getters["persistentvolumeClaim"] = client.CoreV1().RESTClient()
+	getters["secrets"] = client.CoreV1().RESTClient()
+	getters["configmaps"] = client.CoreV1().RESTClient()
+	getters["deployments"] = client.ExtensionsV1beta1().RESTClient()
+	getters["daemonsets"] = client.ExtensionsV1beta1().RESTClient()
+	getters["replicasets"] = client.ExtensionsV1beta1().RESTClient()
+	getters["statefulsets"] = client.AppsV1().RESTClient()
+	getters["ingresses"] = client.ExtensionsV1beta1().RESTClient()
+	getters["jobs"] = client.BatchV1().RESTClient()
+	getters["cronjobs"] = client.BatchV1beta1().RESTClient()
+	getters["roles"] = client.RbacV1().RESTClient()
+	getters["rolebindings"] = client.RbacV1().RESTClient()
+	getters["clusterroles"] = client.RbacV1().RESTClient()
+	getters["clusterrolebindings"] = client.RbacV1().RESTClient()
+	return getters
 }
 
 // GetObjectMetaData returns metadata of the given object
@@ -127,11 +125,15 @@ func GetObjectMetaData(obj interface{}) metaV1.ObjectMeta {
 		objectMeta = object.ObjectMeta
 	case *extV1beta1.ReplicaSet:
 		objectMeta = object.ObjectMeta
+	case *appsV1.StatefulSet:
+		objectMeta = object.ObjectMeta
 		//case *appsV1beta1.Deployment:
 	case *extV1beta1.Deployment:
 		objectMeta = object.ObjectMeta
 	case *batchV1.Job:
 		objectMeta = object.ObjectMeta
+	case *batchV1beta1.CronJob:
+		objectMeta = object.ObjectMeta
 	case *rbacV1.Role:
 		objectMeta = object.ObjectMeta
 	case *rbacV1.RoleBinding:
@@ -179,11 +181,15 @@ func GetObjectTypeMetaData(obj interface{}) metaV1.TypeMeta {
 		typeMeta = object.TypeMeta
 	case *extV1beta1.ReplicaSet:
 		typeMeta = object.TypeMeta
+	case *appsV1.StatefulSet:
+		typeMeta = object.TypeMeta
 		//	case *appsV1beta1.Deployment:
 	case *extV1beta1.Deployment:
 		typeMeta = object.TypeMeta
 	case *batchV1.Job:
 		typeMeta = object.TypeMeta
+	case *batchV1beta1.CronJob:
+		typeMeta = object.TypeMeta
 	case *rbacV1.Role:
 		typeMeta = object.TypeMeta
 	case *rbacV1.RoleBinding:
@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"fmt"
 	"math/rand"
 	"time"
 
@@ -20,7 +19,6 @@ func Retry(f func() error, describe string, attempts int, sleep int) error {
 	if attempts--; attempts > 0 {
 		// Add some randomness to prevent creating a Thundering Herd
 		jitter := rand.Int63n(int64(sleep))
-		fmt.Println(int(jitter))
 		sleep = sleep + int(jitter/2)
 		zlog.Errorf("执行:%s失败，错误为:%v，将在%d秒后重试，剩余%d次", describe, err.Error(), sleep, attempts)
 		time.Sleep(time.Duration(sleep) * time.Second)
@@ -32,3 +30,8 @@ func Retry(f func() error, describe string, attempts int, sleep int) error {
 type stop struct {
 	error
 }
+
+// Stop 使Retry不再重试，直接返回err
+func Stop(err error) error {
+	return stop{err}
+}